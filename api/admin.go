@@ -0,0 +1,437 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/yukimochi/Activity-Relay/models"
+)
+
+type adminRequest struct {
+	Type    string   `json:"type,omitempty"`
+	Domains []string `json:"domains,omitempty"`
+	Configs []string `json:"configs,omitempty"`
+}
+
+type adminResult struct {
+	Target  string `json:"target"`
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+type adminResponse struct {
+	Results []adminResult `json:"results"`
+}
+
+type adminDomainsResponse struct {
+	Subscribers []models.Subscriber `json:"subscribers,omitempty"`
+	Followers   []models.Follower   `json:"followers,omitempty"`
+	Domains     []string            `json:"domains,omitempty"`
+	Total       int                 `json:"total"`
+}
+
+type adminConfigResponse struct {
+	PersonOnly           bool `json:"person_only"`
+	ManuallyAccept       bool `json:"manually_accept"`
+	InstanceActorSigning bool `json:"instance_actor_signing"`
+	AllowlistOnly        bool `json:"allowlist_only"`
+	CollectionItems      bool `json:"collection_items"`
+	StatusDomains        bool `json:"status_domains"`
+	StatusConfig         bool `json:"status_config"`
+}
+
+type adminErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func adminHandlersRegister() {
+	http.HandleFunc("/api/admin/domains", requireAdminToken(models.ReadOnlyScope, "GET", handleAdminListDomains))
+	http.HandleFunc("/api/admin/domains/set", requireAdminToken(models.ModerationScope, "POST", handleAdminSetDomains))
+	http.HandleFunc("/api/admin/domains/unset", requireAdminToken(models.ModerationScope, "POST", handleAdminUnsetDomains))
+	http.HandleFunc("/api/admin/domains/unfollow", requireAdminToken(models.ModerationScope, "POST", handleAdminUnfollowDomains))
+	http.HandleFunc("/api/admin/follows", requireAdminToken(models.ReadOnlyScope, "GET", handleAdminListFollows))
+	http.HandleFunc("/api/admin/follows/accept", requireAdminToken(models.ModerationScope, "POST", handleAdminAcceptFollows))
+	http.HandleFunc("/api/admin/follows/reject", requireAdminToken(models.ModerationScope, "POST", handleAdminRejectFollows))
+	http.HandleFunc("/api/admin/follows/update", requireAdminToken(models.AdminScope, "POST", handleAdminUpdateActor))
+	http.HandleFunc("/api/admin/config", requireAdminToken(models.ReadOnlyScope, "GET", handleAdminListConfig))
+	http.HandleFunc("/api/admin/config/enable", requireAdminToken(models.AdminScope, "POST", handleAdminEnableConfig))
+	http.HandleFunc("/api/admin/config/disable", requireAdminToken(models.AdminScope, "POST", handleAdminDisableConfig))
+	http.HandleFunc("/api/admin/config/export", requireAdminToken(models.ReadOnlyScope, "GET", handleAdminExportConfig))
+	http.HandleFunc("/api/admin/config/import", requireAdminToken(models.AdminScope, "POST", handleAdminImportConfig))
+}
+
+func requireAdminToken(scope models.TokenScope, method string, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != method {
+			writeAdminError(writer, 405, errors.New("method not allowed"))
+			return
+		}
+		authorization := request.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Bearer ") {
+			writeAdminError(writer, 401, errors.New("bearer token is required"))
+			return
+		}
+		token := RelayState.SelectAdminToken(strings.TrimPrefix(authorization, "Bearer "))
+		if token == nil {
+			writeAdminError(writer, 401, errors.New("token is invalid"))
+			return
+		}
+		if !token.Scope.Allows(scope) {
+			writeAdminError(writer, 403, errors.New("token scope "+string(token.Scope)+" is insufficient, "+string(scope)+" is required"))
+			return
+		}
+		logrus.Debug("Admin API Request : ", token.Name, " ", request.Method, " ", request.URL.Path)
+		handler(writer, request.WithContext(context.WithValue(request.Context(), adminTokenNameKey{}, token.Name)))
+	}
+}
+
+type adminTokenNameKey struct{}
+
+// auditedState : RelayState recording changes with admin token name and reason query parameter
+func auditedState(request *http.Request) *models.RelayState {
+	name, _ := request.Context().Value(adminTokenNameKey{}).(string)
+	return RelayState.WithAudit("token:"+name, request.URL.Query().Get("reason"))
+}
+
+func writeAdminJSON(writer http.ResponseWriter, status int, data interface{}) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		logrus.Error("Failed to marshal admin response : ", err.Error())
+		writer.WriteHeader(500)
+		writer.Write(nil)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	writer.Write(jsonData)
+}
+
+func writeAdminError(writer http.ResponseWriter, status int, err error) {
+	writeAdminJSON(writer, status, adminErrorResponse{err.Error()})
+}
+
+func decodeAdminRequest(request *http.Request) (*adminRequest, error) {
+	var data adminRequest
+	err := json.NewDecoder(request.Body).Decode(&data)
+	if err != nil {
+		return nil, errors.New("request body is invalid: " + err.Error())
+	}
+	return &data, nil
+}
+
+func handleAdminListDomains(writer http.ResponseWriter, request *http.Request) {
+	switch request.URL.Query().Get("type") {
+	case "limited":
+		writeAdminJSON(writer, 200, adminDomainsResponse{Domains: RelayState.LimitedDomains, Total: len(RelayState.LimitedDomains)})
+	case "blocked":
+		writeAdminJSON(writer, 200, adminDomainsResponse{Domains: RelayState.BlockedDomains, Total: len(RelayState.BlockedDomains)})
+	case "allowed":
+		writeAdminJSON(writer, 200, adminDomainsResponse{Domains: RelayState.AllowedDomains, Total: len(RelayState.AllowedDomains)})
+	case "", "subscriber":
+		writeAdminJSON(writer, 200, adminDomainsResponse{
+			Subscribers: RelayState.Subscribers,
+			Followers:   RelayState.Followers,
+			Total:       len(RelayState.Subscribers) + len(RelayState.Followers),
+		})
+	default:
+		writeAdminError(writer, 400, errors.New("invalid type provided: "+request.URL.Query().Get("type")))
+	}
+}
+
+func editAdminDomains(writer http.ResponseWriter, request *http.Request, value bool) {
+	data, err := decodeAdminRequest(request)
+	if err != nil {
+		writeAdminError(writer, 400, err)
+		return
+	}
+	statement := "Set"
+	if !value {
+		statement = "Unset"
+	}
+	state := auditedState(request)
+
+	var results []adminResult
+	switch data.Type {
+	case "limited":
+		for _, domain := range data.Domains {
+			if value {
+				err := models.ValidateDomainPattern(domain)
+				if err != nil {
+					results = append(results, adminResult{domain, false, "Invalid domain provided: " + domain + " (" + err.Error() + ")"})
+					continue
+				}
+			}
+			state.SetLimitedDomain(domain, value)
+			results = append(results, adminResult{domain, true, statement + " [" + domain + "] as limited domain"})
+		}
+	case "blocked":
+		for _, domain := range data.Domains {
+			if value {
+				err := models.ValidateDomainPattern(domain)
+				if err != nil {
+					results = append(results, adminResult{domain, false, "Invalid domain provided: " + domain + " (" + err.Error() + ")"})
+					continue
+				}
+			}
+			if !value {
+				state.SetBlockedDomain(domain, false)
+				results = append(results, adminResult{domain, true, statement + " [" + domain + "] as blocked domain"})
+				continue
+			}
+			unfollowed := state.BlockDomain(RelayActor.Load(), domain, enqueueRegisterActivity)
+			results = append(results, adminResult{domain, true, statement + " [" + domain + "] as blocked domain"})
+			for _, unfollowedDomain := range unfollowed {
+				results = append(results, adminResult{unfollowedDomain, true, "Unfollow [" + unfollowedDomain + "]"})
+			}
+		}
+	case "allowed":
+		for _, domain := range data.Domains {
+			if value {
+				err := models.ValidateDomainPattern(domain)
+				if err != nil {
+					results = append(results, adminResult{domain, false, "Invalid domain provided: " + domain + " (" + err.Error() + ")"})
+					continue
+				}
+			}
+			state.SetAllowedDomain(domain, value)
+			results = append(results, adminResult{domain, true, statement + " [" + domain + "] as allowed domain"})
+		}
+	default:
+		writeAdminError(writer, 400, errors.New("invalid type provided: "+data.Type))
+		return
+	}
+	writeAdminJSON(writer, 200, adminResponse{results})
+}
+
+func handleAdminSetDomains(writer http.ResponseWriter, request *http.Request) {
+	editAdminDomains(writer, request, true)
+}
+
+func handleAdminUnsetDomains(writer http.ResponseWriter, request *http.Request) {
+	editAdminDomains(writer, request, false)
+}
+
+func handleAdminUnfollowDomains(writer http.ResponseWriter, request *http.Request) {
+	data, err := decodeAdminRequest(request)
+	if err != nil {
+		writeAdminError(writer, 400, err)
+		return
+	}
+
+	state := auditedState(request)
+	var results []adminResult
+	for _, domain := range data.Domains {
+		switch {
+		case contains(RelayState.Subscribers, domain):
+			err = state.UnfollowSubscriber(RelayActor.Load(), *RelayState.SelectSubscriber(domain), enqueueRegisterActivity)
+		case contains(RelayState.Followers, domain):
+			err = state.UnfollowFollower(RelayActor.Load(), *RelayState.SelectFollower(domain), enqueueRegisterActivity)
+		default:
+			results = append(results, adminResult{domain, false, "Invalid domain provided: " + domain})
+			continue
+		}
+		if err != nil {
+			results = append(results, adminResult{domain, false, "Failed to unfollow [" + domain + "]: " + err.Error()})
+			continue
+		}
+		results = append(results, adminResult{domain, true, "Unfollow [" + domain + "]"})
+	}
+	writeAdminJSON(writer, 200, adminResponse{results})
+}
+
+func pendingFollowDomains() ([]string, error) {
+	var domains []string
+	requests, err := RelayState.PendingRequests()
+	if err != nil {
+		return nil, err
+	}
+	for _, request := range requests {
+		domains = append(domains, request.Domain)
+	}
+	return domains, nil
+}
+
+func handleAdminListFollows(writer http.ResponseWriter, _ *http.Request) {
+	domains, err := pendingFollowDomains()
+	if err != nil {
+		writeAdminError(writer, 500, err)
+		return
+	}
+	writeAdminJSON(writer, 200, adminDomainsResponse{Domains: domains, Total: len(domains)})
+}
+
+func respondAdminFollows(writer http.ResponseWriter, request *http.Request, response string) {
+	data, err := decodeAdminRequest(request)
+	if err != nil {
+		writeAdminError(writer, 400, err)
+		return
+	}
+	domains, err := pendingFollowDomains()
+	if err != nil {
+		writeAdminError(writer, 500, err)
+		return
+	}
+
+	state := auditedState(request)
+	var results []adminResult
+	for _, domain := range data.Domains {
+		if !contains(domains, domain) {
+			results = append(results, adminResult{domain, false, "Invalid domain provided: " + domain})
+			continue
+		}
+		err = state.RespondFollowRequest(RelayActor.Load(), domain, response, enqueueRegisterActivity)
+		if err != nil {
+			results = append(results, adminResult{domain, false, "Failed to " + strings.ToLower(response) + " [" + domain + "] follow request: " + err.Error()})
+			continue
+		}
+		results = append(results, adminResult{domain, true, response + " [" + domain + "] follow request"})
+	}
+	writeAdminJSON(writer, 200, adminResponse{results})
+}
+
+func handleAdminAcceptFollows(writer http.ResponseWriter, request *http.Request) {
+	respondAdminFollows(writer, request, "Accept")
+}
+
+func handleAdminRejectFollows(writer http.ResponseWriter, request *http.Request) {
+	respondAdminFollows(writer, request, "Reject")
+}
+
+func handleAdminUpdateActor(writer http.ResponseWriter, _ *http.Request) {
+	var results []adminResult
+	relayActor := RelayActor.Load()
+	for _, subscription := range RelayState.SubscribersAndFollowers {
+		activity := models.Activity{
+			Context: []string{"https://www.w3.org/ns/activitystreams"},
+			ID:      GlobalConfig.ServerHostname().String() + "/activities/" + uuid.New().String(),
+			Actor:   relayActor.ID,
+			Type:    "Update",
+			To:      []string{"https://www.w3.org/ns/activitystreams#Public"},
+			Object:  relayActor,
+		}
+		jsonData, err := json.Marshal(&activity)
+		if err != nil {
+			results = append(results, adminResult{subscription.Domain, false, "Failed to update RelayActor for [" + subscription.Domain + "]"})
+			continue
+		}
+		err = enqueueRegisterActivity(subscription.InboxURL, jsonData)
+		if err != nil {
+			results = append(results, adminResult{subscription.Domain, false, "Failed to update RelayActor for [" + subscription.Domain + "]: " + err.Error()})
+			continue
+		}
+		results = append(results, adminResult{subscription.Domain, true, "Update RelayActor for [" + subscription.Domain + "]"})
+	}
+	writeAdminJSON(writer, 200, adminResponse{results})
+}
+
+func handleAdminListConfig(writer http.ResponseWriter, _ *http.Request) {
+	writeAdminJSON(writer, 200, adminConfigResponse{
+		PersonOnly:           RelayState.RelayConfig.PersonOnly,
+		ManuallyAccept:       RelayState.RelayConfig.ManuallyAccept,
+		InstanceActorSigning: RelayState.RelayConfig.InstanceActorSigning,
+		AllowlistOnly:        RelayState.RelayConfig.AllowlistOnly,
+		CollectionItems:      RelayState.RelayConfig.CollectionItems,
+		StatusDomains:        RelayState.RelayConfig.StatusDomains,
+		StatusConfig:         RelayState.RelayConfig.StatusConfig,
+	})
+}
+
+func editAdminConfig(writer http.ResponseWriter, request *http.Request, value bool) {
+	data, err := decodeAdminRequest(request)
+	if err != nil {
+		writeAdminError(writer, 400, err)
+		return
+	}
+	statement := "enabled"
+	if !value {
+		statement = "disabled"
+	}
+	state := auditedState(request)
+
+	var results []adminResult
+	for _, key := range data.Configs {
+		switch key {
+		case "person-only":
+			state.SetConfig(models.PersonOnly, value)
+			results = append(results, adminResult{key, true, "Person-Type Actor limitation is " + statement + "."})
+		case "manually-accept":
+			state.SetConfig(models.ManuallyAccept, value)
+			results = append(results, adminResult{key, true, "Manual follow request acceptance is " + statement + "."})
+		case "instance-actor-signing":
+			state.SetConfig(models.InstanceActorSigning, value)
+			results = append(results, adminResult{key, true, "Same-origin instance actor signing is " + statement + "."})
+		case "allowlist-only":
+			state.SetConfig(models.AllowlistOnly, value)
+			results = append(results, adminResult{key, true, "Allowlist-only federation is " + statement + "."})
+		case "collection-items":
+			state.SetConfig(models.CollectionItems, value)
+			results = append(results, adminResult{key, true, "Listing items of followers/following collections is " + statement + "."})
+		case "status-domains":
+			state.SetConfig(models.StatusDomains, value)
+			results = append(results, adminResult{key, true, "Listing domains on status page is " + statement + "."})
+		case "status-config":
+			state.SetConfig(models.StatusConfig, value)
+			results = append(results, adminResult{key, true, "Showing configuration on status page is " + statement + "."})
+		default:
+			results = append(results, adminResult{key, false, "Invalid configuration provided: " + key})
+		}
+	}
+	writeAdminJSON(writer, 200, adminResponse{results})
+}
+
+func handleAdminEnableConfig(writer http.ResponseWriter, request *http.Request) {
+	editAdminConfig(writer, request, true)
+}
+
+func handleAdminDisableConfig(writer http.ResponseWriter, request *http.Request) {
+	editAdminConfig(writer, request, false)
+}
+
+func handleAdminExportConfig(writer http.ResponseWriter, _ *http.Request) {
+	snapshot, err := RelayState.Export()
+	if err != nil {
+		writeAdminError(writer, 500, err)
+		return
+	}
+	writeAdminJSON(writer, 200, snapshot)
+}
+
+func handleAdminImportConfig(writer http.ResponseWriter, request *http.Request) {
+	snapshot, err := models.ParseSnapshot(request.Body)
+	if err != nil {
+		writeAdminError(writer, 400, errors.New("request body is invalid: "+err.Error()))
+		return
+	}
+	mode := models.MergeImport
+	if request.URL.Query().Get("mode") != "" {
+		mode = models.ImportMode(request.URL.Query().Get("mode"))
+	}
+	if mode != models.MergeImport && mode != models.ReplaceImport {
+		writeAdminError(writer, 400, errors.New("invalid mode provided: "+string(mode)))
+		return
+	}
+
+	var changes []models.SnapshotChange
+	var unfollowed []string
+	if request.URL.Query().Get("dry_run") == "true" {
+		changes, err = RelayState.DiffSnapshot(snapshot, mode)
+	} else {
+		changes, unfollowed, err = auditedState(request).ImportSnapshot(snapshot, mode, RelayActor.Load(), enqueueRegisterActivity)
+	}
+	results := []adminResult{}
+	for _, change := range changes {
+		results = append(results, adminResult{change.Target, true, change.String()})
+	}
+	for _, unfollowedDomain := range unfollowed {
+		results = append(results, adminResult{unfollowedDomain, true, "Unfollow [" + unfollowedDomain + "]"})
+	}
+	if err != nil {
+		results = append(results, adminResult{string(mode), false, "Failed to import snapshot: " + err.Error()})
+	}
+	writeAdminJSON(writer, 200, adminResponse{results})
+}
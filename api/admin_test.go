@@ -0,0 +1,235 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yukimochi/Activity-Relay/models"
+)
+
+func adminRequestWithToken(t *testing.T, handler func(http.ResponseWriter, *http.Request), method string, target string, token string, body interface{}) *http.Response {
+	s := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(s.Close)
+
+	var reader io.Reader
+	if body != nil {
+		jsonData, _ := json.Marshal(body)
+		reader = bytes.NewReader(jsonData)
+	}
+	req, _ := http.NewRequest(method, s.URL+target, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	t.Cleanup(func() { r.Body.Close() })
+	return r
+}
+
+func TestAdminTokenAuthorization(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	readOnlyToken, _ := RelayState.AddAdminToken("dashboard", models.ReadOnlyScope)
+	moderationToken, _ := RelayState.AddAdminToken("chatops", models.ModerationScope)
+	handler := requireAdminToken(models.ModerationScope, "POST", handleAdminSetDomains)
+
+	t.Run("Reject request without token", func(t *testing.T) {
+		r := adminRequestWithToken(t, handler, "POST", "", "", adminRequest{Type: "blocked", Domains: []string{"example.com"}})
+		if r.StatusCode != 401 {
+			t.Fatalf("Expected StatusCode to be 401, but got %d", r.StatusCode)
+		}
+	})
+
+	t.Run("Reject request with unknown token", func(t *testing.T) {
+		r := adminRequestWithToken(t, handler, "POST", "", "unknown", adminRequest{Type: "blocked", Domains: []string{"example.com"}})
+		if r.StatusCode != 401 {
+			t.Fatalf("Expected StatusCode to be 401, but got %d", r.StatusCode)
+		}
+	})
+
+	t.Run("Reject request with insufficient scope", func(t *testing.T) {
+		r := adminRequestWithToken(t, handler, "POST", "", readOnlyToken, adminRequest{Type: "blocked", Domains: []string{"example.com"}})
+		if r.StatusCode != 403 {
+			t.Fatalf("Expected StatusCode to be 403, but got %d", r.StatusCode)
+		}
+	})
+
+	t.Run("Reject request with invalid method", func(t *testing.T) {
+		r := adminRequestWithToken(t, handler, "GET", "", moderationToken, nil)
+		if r.StatusCode != 405 {
+			t.Fatalf("Expected StatusCode to be 405, but got %d", r.StatusCode)
+		}
+	})
+
+	t.Run("Accept request with sufficient scope", func(t *testing.T) {
+		r := adminRequestWithToken(t, handler, "POST", "", moderationToken, adminRequest{Type: "blocked", Domains: []string{"example.com"}})
+		if r.StatusCode != 200 {
+			t.Fatalf("Expected StatusCode to be 200, but got %d", r.StatusCode)
+		}
+		if !contains(RelayState.BlockedDomains, "example.com") {
+			t.Fatalf("Expected 'example.com' to be blocked, but it was not")
+		}
+	})
+}
+
+func TestHandleAdminListDomains(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	token, _ := RelayState.AddAdminToken("dashboard", models.ReadOnlyScope)
+	handler := requireAdminToken(models.ReadOnlyScope, "GET", handleAdminListDomains)
+	RelayState.AddSubscriber(models.Subscriber{
+		Domain:   "example.com",
+		InboxURL: "https://example.com/inbox",
+	})
+	RelayState.SetLimitedDomain("limited.example.com", true)
+
+	t.Run("List subscribers", func(t *testing.T) {
+		r := adminRequestWithToken(t, handler, "GET", "", token, nil)
+		if r.StatusCode != 200 {
+			t.Fatalf("Expected StatusCode to be 200, but got %d", r.StatusCode)
+		}
+		var response adminDomainsResponse
+		json.NewDecoder(r.Body).Decode(&response)
+		if response.Total != 1 || response.Subscribers[0].Domain != "example.com" {
+			t.Fatalf("Expected subscriber 'example.com' to be listed, but got %+v", response)
+		}
+	})
+
+	t.Run("List limited domains", func(t *testing.T) {
+		r := adminRequestWithToken(t, handler, "GET", "?type=limited", token, nil)
+		var response adminDomainsResponse
+		json.NewDecoder(r.Body).Decode(&response)
+		if response.Total != 1 || response.Domains[0] != "limited.example.com" {
+			t.Fatalf("Expected limited domain 'limited.example.com' to be listed, but got %+v", response)
+		}
+	})
+
+	t.Run("Reject invalid type", func(t *testing.T) {
+		r := adminRequestWithToken(t, handler, "GET", "?type=unknown", token, nil)
+		if r.StatusCode != 400 {
+			t.Fatalf("Expected StatusCode to be 400, but got %d", r.StatusCode)
+		}
+	})
+}
+
+func TestHandleAdminUnfollowDomains(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	token, _ := RelayState.AddAdminToken("chatops", models.ModerationScope)
+	handler := requireAdminToken(models.ModerationScope, "POST", handleAdminUnfollowDomains)
+	RelayState.AddSubscriber(models.Subscriber{
+		Domain:   "example.com",
+		InboxURL: "https://example.com/inbox",
+	})
+
+	r := adminRequestWithToken(t, handler, "POST", "", token, adminRequest{Domains: []string{"example.com", "unknown.tld"}})
+	var response adminResponse
+	json.NewDecoder(r.Body).Decode(&response)
+	if len(response.Results) != 2 || !response.Results[0].Success || response.Results[1].Success {
+		t.Fatalf("Expected only 'example.com' to be unfollowed, but got %+v", response)
+	}
+	if contains(RelayState.Subscribers, "example.com") {
+		t.Fatalf("Expected 'example.com' to be removed from subscribers, but still found")
+	}
+}
+
+func TestHandleAdminAcceptFollows(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	token, _ := RelayState.AddAdminToken("chatops", models.ModerationScope)
+	handler := requireAdminToken(models.ModerationScope, "POST", handleAdminAcceptFollows)
+	RelayState.RedisClient.HMSet(context.TODO(), "relay:pending:example.com", map[string]interface{}{
+		"inbox_url":   "https://example.com/inbox",
+		"activity_id": "https://example.com/UUID",
+		"type":        "Follow",
+		"actor":       "https://example.com/user/example",
+		"object":      "https://www.w3.org/ns/activitystreams#Public",
+	})
+
+	adminRequestWithToken(t, handler, "POST", "", token, adminRequest{Domains: []string{"example.com"}})
+
+	valid, _ := RelayState.RedisClient.Exists(context.TODO(), "relay:pending:example.com").Result()
+	if valid != 0 {
+		t.Fatalf("Expected relay:pending:example.com to be removed, but still exists (value: %d)", valid)
+	}
+	valid, _ = RelayState.RedisClient.Exists(context.TODO(), "relay:subscription:example.com").Result()
+	if valid != 1 {
+		t.Fatalf("Expected relay:subscription:example.com to be created, but not found (value: %d)", valid)
+	}
+}
+
+func TestHandleAdminEnableConfig(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	token, _ := RelayState.AddAdminToken("operator", models.AdminScope)
+	handler := requireAdminToken(models.AdminScope, "POST", handleAdminEnableConfig)
+
+	r := adminRequestWithToken(t, handler, "POST", "", token, adminRequest{Configs: []string{"manually-accept", "hoge"}})
+	var response adminResponse
+	json.NewDecoder(r.Body).Decode(&response)
+	if len(response.Results) != 2 || response.Results[1].Message != "Invalid configuration provided: hoge" {
+		t.Fatalf("Expected invalid configuration to be reported, but got %+v", response)
+	}
+	if !RelayState.RelayConfig.ManuallyAccept {
+		t.Fatalf("Expected ManuallyAccept to be enabled, but it was not")
+	}
+	RelayState.SetConfig(models.ManuallyAccept, false)
+}
+
+func TestHandleAdminAuditActor(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	token, _ := RelayState.AddAdminToken("operator", models.AdminScope)
+	handler := requireAdminToken(models.AdminScope, "POST", handleAdminEnableConfig)
+
+	adminRequestWithToken(t, handler, "POST", "?reason=maintenance", token, adminRequest{Configs: []string{"manually-accept"}})
+	events, _ := RelayState.AuditEvents(models.AuditFilter{Action: models.AuditConfigEnable}, 0)
+	if len(events) != 1 || events[0].Actor != "token:operator" || events[0].Reason != "maintenance" {
+		t.Fatalf("Expected change to be recorded with token name and reason, but got %v", events)
+	}
+	RelayState.SetConfig(models.ManuallyAccept, false)
+}
+
+func TestHandleAdminImportConfig(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	token, _ := RelayState.AddAdminToken("operator", models.AdminScope)
+	handler := requireAdminToken(models.AdminScope, "POST", handleAdminImportConfig)
+	snapshot := models.Snapshot{
+		Version: models.SnapshotVersion,
+		Config:  map[string]bool{"manually_accept": true},
+		Followers: []models.Follower{
+			{Domain: "example.com", InboxURL: "https://example.com/inbox", ActivityID: "https://example.com/UUID", ActorID: "https://example.com/actor", MutuallyFollow: true},
+		},
+	}
+
+	r := adminRequestWithToken(t, handler, "POST", "?dry_run=true", token, snapshot)
+	var response adminResponse
+	json.NewDecoder(r.Body).Decode(&response)
+	if len(response.Results) != 2 || response.Results[1].Message != "+ follower example.com" {
+		t.Fatalf("Expected changes to be reported, but got %+v", response)
+	}
+	if RelayState.SelectFollower("example.com") != nil {
+		t.Fatalf("Expected dry run not to import follower, but imported")
+	}
+
+	adminRequestWithToken(t, handler, "POST", "?mode=replace", token, snapshot)
+	follower := RelayState.SelectFollower("example.com")
+	if follower == nil || !follower.MutuallyFollow || !RelayState.RelayConfig.ManuallyAccept {
+		t.Fatalf("Expected snapshot to be imported, but got follower %v and config %+v", follower, RelayState.RelayConfig)
+	}
+
+	r = adminRequestWithToken(t, handler, "POST", "?mode=hoge", token, snapshot)
+	if r.StatusCode != 400 {
+		t.Fatalf("Expected StatusCode to be 400, but got %d", r.StatusCode)
+	}
+	RelayState.DelFollower("example.com")
+	RelayState.SetConfig(models.ManuallyAccept, false)
+}
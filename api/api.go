@@ -1,13 +1,20 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/yukimochi/Activity-Relay/models"
-	"github.com/yukimochi/machinery-v1/v1"
 )
 
 var (
@@ -15,18 +22,38 @@ var (
 	GlobalConfig *models.RelayConfig
 
 	// RelayActor : Relay's Actor
-	RelayActor models.Actor
+	RelayActor atomic.Pointer[models.Actor]
 	// Nodeinfo : Relay's Nodeinfo
 	Nodeinfo models.NodeinfoResources
 	// WebfingerResources : Relay's Webfinger Resources
 	WebfingerResources []models.WebfingerResource
+	// HostMeta : Relay's host-meta Resource
+	HostMeta models.HostMeta
 
-	ActorCache      *cache.Cache
-	MachineryServer *machinery.Server
-	RelayState      models.RelayState
+	ActorCache *cache.Cache
+	// Queue : Queue of delivery tasks
+	Queue models.JobQueue
+	// Activities : Bodies of activities relayed to inboxes
+	Activities models.ActivityStore
+	RelayState models.RelayState
+
+	pendingEnqueues sync.WaitGroup
 )
 
 func Entrypoint(g *models.RelayConfig, v string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return start(ctx, g, v, nil)
+}
+
+// StandaloneEntrypoint : Run API Server enqueueing tasks to in-process queue until ctx is done
+func StandaloneEntrypoint(ctx context.Context, g *models.RelayConfig, v string, queue models.JobQueue) error {
+	g.SetStandalone()
+	return start(ctx, g, v, queue)
+}
+
+func start(ctx context.Context, g *models.RelayConfig, v string, queue models.JobQueue) error {
 	var err error
 
 	version = v
@@ -36,44 +63,113 @@ func Entrypoint(g *models.RelayConfig, v string) error {
 	if err != nil {
 		return err
 	}
+	if queue != nil {
+		Queue = queue
+	} else if Queue == nil {
+		return errors.New("REDIS_URL IS EMPTY. API SERVER REQUIRES REDIS, USE STANDALONE INSTEAD")
+	}
 
 	handlersRegister()
+	adminHandlersRegister()
 
 	logrus.Info("Starting API Server at ", GlobalConfig.ServerBind())
-	err = http.ListenAndServe(GlobalConfig.ServerBind(), nil)
+	server := &http.Server{Addr: GlobalConfig.ServerBind()}
+	return serve(ctx, server, GlobalConfig.ShutdownTimeout())
+}
+
+// serve : Serve until ctx is done, then stop accepting requests and wait for in-flight requests and pending enqueues within timeout.
+func serve(ctx context.Context, server *http.Server, timeout time.Duration) error {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	logrus.Info("Shutting down API Server, waiting for in-flight requests and pending enqueues")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	enqueued := make(chan struct{})
+	go func() {
+		pendingEnqueues.Wait()
+		close(enqueued)
+	}()
+	select {
+	case <-enqueued:
+		logrus.Info("API Server stopped")
+		return nil
+	case <-shutdownCtx.Done():
+		return errors.New("shutdown deadline exceeded with pending enqueues")
+	}
 }
 
 func initialize(globalConfig *models.RelayConfig) error {
 	var err error
 
 	redisClient := globalConfig.RedisClient()
-	RelayState = models.NewState(redisClient, true)
+	storage, err := globalConfig.NewStorage()
+	if err != nil {
+		return err
+	}
+	RelayState = models.NewStateWithStorage(storage, redisClient, true)
 	RelayState.ListenNotify(nil)
 
-	MachineryServer, err = models.NewMachineryServer(globalConfig)
+	err = models.RegisterStateMetrics(&RelayState)
+	if err != nil {
+		return err
+	}
+	err = models.RegisterQueueMetrics(redisClient)
 	if err != nil {
 		return err
 	}
 
-	RelayActor = models.NewActivityPubActorFromRelayConfig(globalConfig)
+	// Without Redis, queue is given by standalone
+	if redisClient != nil {
+		machineryServer, err := models.NewMachineryServer(globalConfig)
+		if err != nil {
+			return err
+		}
+		Queue = models.NewMachineryQueue(machineryServer)
+	}
+	Activities = globalConfig.ActivityStore()
+
+	relayActor := models.NewActivityPubActorFromRelayConfig(globalConfig)
+	RelayActor.Store(&relayActor)
+	globalConfig.ListenActorKey(func() {
+		relayActor := models.NewActivityPubActorFromRelayConfig(globalConfig)
+		RelayActor.Store(&relayActor)
+		logrus.Info("Switched actor key to ", globalConfig.ActorKeyID())
+	})
 	ActorCache = cache.New(5*time.Minute, 10*time.Minute)
 
 	Nodeinfo = models.GenerateNodeinfoResources(globalConfig.ServerHostname(), version)
-	WebfingerResources = append(WebfingerResources, RelayActor.GenerateWebfingerResource(globalConfig.ServerHostname()))
+	WebfingerResources = append(WebfingerResources, RelayActor.Load().GenerateWebfingerResource(globalConfig.ServerHostname()))
+	HostMeta = models.GenerateHostMeta(globalConfig.ServerHostname())
 
 	return nil
 }
 
 func handlersRegister() {
+	http.HandleFunc("/", handleStatusPage)
+	http.HandleFunc("/api/v1/relay", handleRelayStatus)
 	http.HandleFunc("/.well-known/nodeinfo", handleNodeinfoLink)
 	http.HandleFunc("/.well-known/webfinger", handleWebfinger)
+	http.HandleFunc("/.well-known/host-meta", handleHostMeta)
+	http.HandleFunc("/.well-known/host-meta.json", handleHostMeta)
 	http.HandleFunc("/nodeinfo/2.1", handleNodeinfo)
 	http.HandleFunc("/actor", handleRelayActor)
+	http.HandleFunc("/actor/followers", handleRelayFollowers)
+	http.HandleFunc("/actor/following", handleRelayFollowing)
+	http.Handle("/metrics", promhttp.Handler())
 	http.HandleFunc("/inbox", func(w http.ResponseWriter, r *http.Request) {
 		handleInbox(w, r, decodeActivity)
 	})
@@ -3,8 +3,10 @@ package api
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/yukimochi/Activity-Relay/models"
@@ -33,8 +35,122 @@ func TestMain(m *testing.M) {
 		fmt.Println(err.Error())
 		os.Exit(1)
 	}
-	RelayState = models.NewState(RelayState.RedisClient, false)
 	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	RelayState = models.NewState(RelayState.RedisClient, false)
 	code := m.Run()
 	os.Exit(code)
 }
+
+func TestServeWaitsPendingEnqueues(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := &http.Server{Addr: "127.0.0.1:0"}
+
+	enqueued := false
+	enqueueAsync(func() {
+		time.Sleep(100 * time.Millisecond)
+		enqueued = true
+	})
+	cancel()
+
+	err := serve(ctx, server, time.Second)
+	if err != nil {
+		t.Fatalf("Expected graceful shutdown, but got error: %v", err)
+	}
+	if !enqueued {
+		t.Fatalf("Expected pending enqueue to be finished before shutdown, but it was not")
+	}
+}
+
+func TestServeShutdownDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := &http.Server{Addr: "127.0.0.1:0"}
+
+	release := make(chan struct{})
+	enqueueAsync(func() {
+		<-release
+	})
+	defer close(release)
+	cancel()
+
+	err := serve(ctx, server, 50*time.Millisecond)
+	if err == nil || err.Error() != "shutdown deadline exceeded with pending enqueues" {
+		t.Fatalf("Expected error 'shutdown deadline exceeded with pending enqueues', but got '%v'", err)
+	}
+}
+
+func TestEnqueueRegisterActivityToQueue(t *testing.T) {
+	queue := models.NewMemoryQueue()
+	received := make(chan []string, 1)
+	queue.RegisterTask("register", func(args ...string) error {
+		received <- args
+		return nil
+	})
+	queue.Launch(1)
+	defer queue.Shutdown(context.Background())
+
+	machineryQueue := Queue
+	Queue = queue
+	defer func() { Queue = machineryQueue }()
+
+	enqueueRegisterActivity("https://example.com/inbox", []byte("ExampleData"))
+	select {
+	case args := <-received:
+		if len(args) != 2 || args[0] != "https://example.com/inbox" || args[1] != "ExampleData" {
+			t.Fatalf("Expected args to be [https://example.com/inbox ExampleData], but got %v", args)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected register task to be processed by queue, but timed out")
+	}
+}
+
+func TestEnqueueActivityForSharedInbox(t *testing.T) {
+	queue := models.NewMemoryQueue()
+	received := make(chan []string, 10)
+	queue.RegisterTask("relay-v2", func(args ...string) error {
+		received <- args
+		return nil
+	})
+	queue.Launch(1)
+	defer queue.Shutdown(context.Background())
+
+	machineryQueue := Queue
+	Queue = queue
+	defer func() { Queue = machineryQueue }()
+
+	subscriptions := []models.Subscriber{
+		{Domain: "source.example.com", InboxURL: "https://source.example.com/inbox"},
+		{Domain: "alias.source.example.com", InboxURL: "https://source.example.com/inbox"},
+		{Domain: "a.hosted.example.com", InboxURL: "https://hosted.example.com/inbox"},
+		{Domain: "b.hosted.example.com", InboxURL: "https://hosted.example.com/inbox"},
+		{Domain: "example.org", InboxURL: "https://example.org/inbox"},
+	}
+	enqueueActivityForInboxes("source.example.com", subscriptions, []byte("ExampleData"))
+
+	inboxes := make(map[string]string)
+	var activityID string
+	for i := 0; i < 2; i++ {
+		select {
+		case args := <-received:
+			inboxes[args[0]] = args[3]
+			activityID = args[1]
+		case <-time.After(time.Second):
+			t.Fatalf("Expected 2 deliveries, but got %d", i)
+		}
+	}
+	select {
+	case args := <-received:
+		t.Fatalf("Expected no more deliveries, but got %v", args)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if inboxes["https://hosted.example.com/inbox"] != "a.hosted.example.com,b.hosted.example.com" {
+		t.Fatalf("Expected shared inbox to carry both domains, but got '%s'", inboxes["https://hosted.example.com/inbox"])
+	}
+	if inboxes["https://example.org/inbox"] != "example.org" {
+		t.Fatalf("Expected inbox to carry example.org, but got '%s'", inboxes["https://example.org/inbox"])
+	}
+	remainCount, _ := RelayState.RedisClient.HGet(context.TODO(), "relay:activity:"+activityID, "remain_count").Result()
+	if remainCount != "2" {
+		t.Fatalf("Expected remain_count to be '2', but got '%s'", remainCount)
+	}
+}
@@ -1,47 +1,257 @@
 package api
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-fed/httpsig"
 	"github.com/yukimochi/Activity-Relay/models"
 )
 
+// rejectionError : Inbox rejection with response status and reason
+type rejectionError struct {
+	status int
+	reason string
+}
+
+func (e *rejectionError) Error() string {
+	return e.reason
+}
+
 func decodeActivity(request *http.Request) (*models.Activity, *models.Actor, []byte, error) {
 	request.Header.Set("Host", request.Host)
 	body, err := io.ReadAll(request.Body)
 
+	relayKeyID, relayPrivateKey := GlobalConfig.ActorSigningKey()
+	uaString := fmt.Sprintf("%s (golang net/http; Activity-Relay %s; %s)", GlobalConfig.ServerServiceName(), version, GlobalConfig.ServerHostname().Host)
+
 	// Verify HTTPSignature
-	verifier, err := httpsig.NewVerifier(request)
+	var keyOwnerActor *models.Actor
+	if request.Header.Get("Signature-Input") != "" {
+		keyOwnerActor, err = verifyRFC9421Signature(request, body, uaString)
+	} else {
+		keyOwnerActor, err = verifyCavageSignature(request, body, uaString)
+	}
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	KeyID := verifier.KeyId()
-	relayKeyID := RelayActor.PublicKey.ID
-	relayPrivateKey := GlobalConfig.ActorKey()
-	uaString := fmt.Sprintf("%s (golang net/http; Activity-Relay %s; %s)", GlobalConfig.ServerServiceName(), version, GlobalConfig.ServerHostname().Host)
-	keyOwnerActor, err := models.NewActivityPubActorFromRemoteActor(KeyID, uaString, ActorCache, relayKeyID, relayPrivateKey)
+
+	// Parse Activity
+	var activity models.Activity
+	err = json.Unmarshal(body, &activity)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	PubKey, err := models.ReadPublicKeyRSAFromString(keyOwnerActor.PublicKey.PublicKeyPem)
-	if PubKey == nil {
-		return nil, nil, nil, errors.New("failed parse PublicKey from string")
+	err = verifyKeyOwner(keyOwnerActor, activity.Actor)
+	if err != nil {
+		models.SignatureFailures.WithLabelValues("actor_mismatch").Inc()
+		return nil, nil, nil, err
 	}
+	err = registerSignatureNonce(request)
 	if err != nil {
+		models.SignatureFailures.WithLabelValues("replayed").Inc()
 		return nil, nil, nil, err
 	}
-	err = verifier.Verify(PubKey, httpsig.RSA_SHA256)
+	if keyOwnerActor.ID == activity.Actor {
+		return &activity, keyOwnerActor, body, nil
+	}
+	remoteActor, err := models.NewActivityPubActorFromRemoteActor(activity.Actor, uaString, ActorCache, relayKeyID, relayPrivateKey)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
+	return &activity, &remoteActor, body, nil
+}
+
+// remoteAddress : Remote IP address of request, without port.
+func remoteAddress(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// verifySignedTime : Check signed time is within allowed clock skew.
+func verifySignedTime(signed time.Time) error {
+	now := time.Now()
+	clockSkew := GlobalConfig.SignatureClockSkew()
+	if signed.After(now.Add(clockSkew)) {
+		return &rejectionError{401, "signature is created in the future"}
+	}
+	if signed.Before(now.Add(-clockSkew)) {
+		return &rejectionError{401, "signature is too old"}
+	}
+	return nil
+}
+
+func parseDateHeader(request *http.Request) (time.Time, error) {
+	date := request.Header.Get("Date")
+	if date == "" {
+		return time.Time{}, &rejectionError{401, "date header is missing"}
+	}
+	signed, err := http.ParseTime(date)
+	if err != nil {
+		return time.Time{}, &rejectionError{400, "date header is invalid"}
+	}
+	return signed, nil
+}
+
+// registerSignatureNonce : Remember signature while it is fresh to refuse replayed request.
+func registerSignatureNonce(request *http.Request) error {
+	registered, err := RelayState.MarkNonce(request.Header.Get("Signature"), 2*GlobalConfig.SignatureClockSkew())
+	if err != nil {
+		return err
+	}
+	if !registered {
+		return &rejectionError{401, "replayed request is refused"}
+	}
+	return nil
+}
+
+// verifyKeyOwner : Bind signing key owner to activity actor.
+func verifyKeyOwner(keyOwnerActor *models.Actor, activityActor string) error {
+	if keyOwnerActor.ID == activityActor {
+		return nil
+	}
+	keyOwnerID, err := url.Parse(keyOwnerActor.ID)
+	if err != nil || keyOwnerActor.ID == "" {
+		return &rejectionError{401, "signing key owner is unknown"}
+	}
+	activityActorID, err := url.Parse(activityActor)
+	if err != nil || activityActor == "" {
+		return &rejectionError{400, "activity actor is invalid"}
+	}
+	if !strings.EqualFold(keyOwnerID.Host, activityActorID.Host) {
+		return &rejectionError{401, "signed by " + keyOwnerActor.ID + ", which is on a different host from activity actor " + activityActor}
+	}
+	if !RelayState.RelayConfig.InstanceActorSigning {
+		return &rejectionError{401, "signed by " + keyOwnerActor.ID + ", which is not activity actor " + activityActor + " (same-origin instance actor signing is disabled)"}
+	}
+	return nil
+}
+
+func fetchKeyOwnerPublicKey(keyID string, uaString string) (*models.Actor, crypto.PublicKey, error) {
+	relayKeyID, relayPrivateKey := GlobalConfig.ActorSigningKey()
+	keyOwnerActor, err := models.NewActivityPubActorFromRemoteActor(keyID, uaString, ActorCache, relayKeyID, relayPrivateKey)
+	if err != nil {
+		models.SignatureFailures.WithLabelValues("key_fetch").Inc()
+		return nil, nil, err
+	}
+	// Key document must be served by the host of its owner
+	keyURL, err := url.Parse(keyID)
+	if err != nil {
+		models.SignatureFailures.WithLabelValues("invalid_key").Inc()
+		return nil, nil, err
+	}
+	ownerURL, err := url.Parse(keyOwnerActor.ID)
+	if err != nil || !strings.EqualFold(keyURL.Host, ownerURL.Host) {
+		models.SignatureFailures.WithLabelValues("invalid_key").Inc()
+		return nil, nil, &rejectionError{401, "key " + keyID + " is not hosted by its owner " + keyOwnerActor.ID}
+	}
+	if keyOwnerActor.PublicKey.ID == keyID && keyOwnerActor.PublicKey.Owner != "" && keyOwnerActor.PublicKey.Owner != keyOwnerActor.ID {
+		models.SignatureFailures.WithLabelValues("invalid_key").Inc()
+		return nil, nil, &rejectionError{401, "key " + keyID + " is owned by " + keyOwnerActor.PublicKey.Owner + ", not " + keyOwnerActor.ID}
+	}
+	publicKey, err := keyOwnerActor.PublicKeyByID(keyID)
+	if err != nil {
+		models.SignatureFailures.WithLabelValues("invalid_key").Inc()
+		return nil, nil, err
+	}
+	return &keyOwnerActor, publicKey, nil
+}
+
+var cavageSignatureParam = regexp.MustCompile(`(\w+)=(?:"([^"]*)"|([^,\s]*))`)
+
+// cavageSignatureParams : Parameters of draft-cavage Signature header, or of Authorization header with Signature scheme.
+func cavageSignatureParams(request *http.Request) map[string]string {
+	header := request.Header.Get("Signature")
+	if header == "" {
+		header = strings.TrimPrefix(request.Header.Get("Authorization"), "Signature ")
+	}
+	params := make(map[string]string)
+	for _, match := range cavageSignatureParam.FindAllStringSubmatch(header, -1) {
+		params[match[1]] = match[2] + match[3]
+	}
+	return params
+}
+
+// verifyCavageSignature : Verify draft-cavage-http-signatures Signature and Digest headers.
+func verifyCavageSignature(request *http.Request, body []byte, uaString string) (*models.Actor, error) {
+	verifier, err := httpsig.NewVerifier(request)
+	if err != nil {
+		models.SignatureFailures.WithLabelValues("missing").Inc()
+		return nil, err
+	}
+	params := cavageSignatureParams(request)
+	headers := strings.Fields(strings.ToLower(params["headers"]))
+	if len(headers) == 0 {
+		headers = []string{"date"}
+	}
+	if !slices.Contains(headers, "host") || !(slices.Contains(headers, "date") || slices.Contains(headers, "(created)")) {
+		models.SignatureFailures.WithLabelValues("missing").Inc()
+		return nil, &rejectionError{401, "signature must cover host and date or (created)"}
+	}
+	var signed time.Time
+	if slices.Contains(headers, "date") {
+		signed, err = parseDateHeader(request)
+	} else {
+		var created int64
+		created, err = strconv.ParseInt(params["created"], 10, 64)
+		if err != nil {
+			err = &rejectionError{401, "created parameter is invalid"}
+		}
+		signed = time.Unix(created, 0)
+	}
+	if err == nil {
+		err = verifySignedTime(signed)
+	}
+	if err != nil {
+		models.SignatureFailures.WithLabelValues("expired").Inc()
+		return nil, err
+	}
+	keyOwnerActor, publicKey, err := fetchKeyOwnerPublicKey(verifier.KeyId(), uaString)
+	if err != nil {
+		return nil, err
+	}
+	var algorithm httpsig.Algorithm
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		algorithm = httpsig.RSA_SHA256
+	case ed25519.PublicKey:
+		algorithm = httpsig.ED25519
+	case *ecdsa.PublicKey:
+		algorithm = httpsig.ECDSA_SHA256
+		if key.Curve == elliptic.P384() {
+			algorithm = httpsig.ECDSA_SHA384
+		}
+	default:
+		models.SignatureFailures.WithLabelValues("invalid_key").Inc()
+		return nil, errors.New("unsupported public key type")
+	}
+	err = verifier.Verify(publicKey, algorithm)
+	if err != nil {
+		models.SignatureFailures.WithLabelValues("signature").Inc()
+		return nil, err
+	}
+
 	// Verify Digest
 	givenDigest := request.Header.Get("Digest")
 	hash := sha256.New()
@@ -50,31 +260,66 @@ func decodeActivity(request *http.Request) (*models.Activity, *models.Actor, []b
 	calculatedDigest := "SHA-256=" + base64.StdEncoding.EncodeToString(b)
 
 	if givenDigest != calculatedDigest {
-		return nil, nil, nil, errors.New("digest header is mismatch")
+		models.SignatureFailures.WithLabelValues("digest").Inc()
+		return nil, errors.New("digest header is mismatch")
 	}
+	return keyOwnerActor, nil
+}
 
-	// Parse Activity
-	var activity models.Activity
-	err = json.Unmarshal(body, &activity)
+// verifyRFC9421Signature : Verify RFC 9421 Signature-Input, Signature and Content-Digest headers.
+func verifyRFC9421Signature(request *http.Request, body []byte, uaString string) (*models.Actor, error) {
+	verifier, err := models.NewRFC9421Verifier(request)
 	if err != nil {
-		return nil, nil, nil, err
+		models.SignatureFailures.WithLabelValues("missing").Inc()
+		return nil, err
+	}
+	// @path alone does not bind signature to this relay, so @authority is required with it
+	coversTarget := verifier.Covers("@target-uri") || (verifier.Covers("@path") && verifier.Covers("@authority"))
+	if !verifier.Covers("@method") || !coversTarget || !verifier.Covers("content-digest") {
+		models.SignatureFailures.WithLabelValues("missing").Inc()
+		return nil, errors.New("signature must cover @method, @target-uri (or @authority and @path) and content-digest")
+	}
+	if !verifier.Expires().IsZero() && time.Now().Add(-GlobalConfig.SignatureClockSkew()).After(verifier.Expires()) {
+		models.SignatureFailures.WithLabelValues("expired").Inc()
+		return nil, &rejectionError{401, "signature is expired"}
+	}
+	signed := verifier.Created()
+	if signed.IsZero() {
+		signed, err = parseDateHeader(request)
+	}
+	if err == nil {
+		err = verifySignedTime(signed)
 	}
-	remoteActor, err := models.NewActivityPubActorFromRemoteActor(activity.Actor, uaString, ActorCache, relayKeyID, relayPrivateKey)
 	if err != nil {
-		return nil, nil, nil, err
+		models.SignatureFailures.WithLabelValues("expired").Inc()
+		return nil, err
 	}
-
-	return &activity, &remoteActor, body, nil
+	keyOwnerActor, publicKey, err := fetchKeyOwnerPublicKey(verifier.KeyId(), uaString)
+	if err != nil {
+		return nil, err
+	}
+	err = verifier.Verify(publicKey)
+	if err != nil {
+		models.SignatureFailures.WithLabelValues("signature").Inc()
+		return nil, err
+	}
+	err = models.VerifyContentDigest(request.Header.Get("Content-Digest"), body)
+	if err != nil {
+		models.SignatureFailures.WithLabelValues("digest").Inc()
+		return nil, err
+	}
+	return keyOwnerActor, nil
 }
 
-func fetchOriginalActivityFromURL(url string) (*models.Activity, *models.Actor, error) {
-	remoteActivity, err := models.NewActivityPubActivityFromRemoteActivity(url, fmt.Sprintf("%s (golang net/http; Activity-Relay %s; %s)", GlobalConfig.ServerServiceName(), version, GlobalConfig.ServerHostname().Host))
+func fetchOriginalActivityFromURL(url string) (*models.Activity, map[string]interface{}, *models.Actor, error) {
+	remoteActivity, document, err := models.NewActivityPubActivityFromRemoteActivity(url, fmt.Sprintf("%s (golang net/http; Activity-Relay %s; %s)", GlobalConfig.ServerServiceName(), version, GlobalConfig.ServerHostname().Host))
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
-	remoteActor, err := models.NewActivityPubActorFromRemoteActor(remoteActivity.Actor, fmt.Sprintf("%s (golang net/http; Activity-Relay %s; %s)", GlobalConfig.ServerServiceName(), version, GlobalConfig.ServerHostname().Host), ActorCache, RelayActor.PublicKey.ID, GlobalConfig.ActorKey())
+	relayKeyID, relayPrivateKey := GlobalConfig.ActorSigningKey()
+	remoteActor, err := models.NewActivityPubActorFromRemoteActor(remoteActivity.Actor, fmt.Sprintf("%s (golang net/http; Activity-Relay %s; %s)", GlobalConfig.ServerServiceName(), version, GlobalConfig.ServerHostname().Host), ActorCache, relayKeyID, relayPrivateKey)
 	if err != nil {
-		return &remoteActivity, nil, err
+		return &remoteActivity, document, nil, err
 	}
-	return &remoteActivity, &remoteActor, err
+	return &remoteActivity, document, &remoteActor, err
 }
@@ -3,12 +3,23 @@ package api
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Songmu/go-httpdate"
+	"github.com/go-fed/httpsig"
 	"github.com/yukimochi/Activity-Relay/models"
 )
 
@@ -117,3 +128,226 @@ func TestDecodeActivityWithInvalidDigest(t *testing.T) {
 		t.Fatalf("Expected error 'crypto/rsa: verification error', but got '%v'", err)
 	}
 }
+
+func cacheMockRemoteActor(actorID string, keyID string) {
+	actor := *RelayActor.Load()
+	actor.ID = actorID
+	actor.PublicKey.ID = keyID
+	actor.PublicKey.Owner = actorID
+	actorData, _ := json.Marshal(actor)
+	ActorCache.Set(actorID, actorData, time.Minute)
+	ActorCache.Set(keyID, actorData, time.Minute)
+}
+
+func mockRFC9421SignedRequest(body []byte, signedBody []byte, keyID string, lifetime time.Duration) *http.Request {
+	req, _ := http.NewRequest("POST", "https://relay.01.cloudgarage.yukimochi.io/inbox", bytes.NewReader(body))
+	req.Header.Add("content-type", "application/activity+json")
+	models.SignRequestRFC9421(req, signedBody, keyID, GlobalConfig.ActorKey(), lifetime)
+	return req
+}
+
+func TestDecodeActivityRFC9421(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	cacheMockRemoteActor("https://innocent.yukimochi.io/users/YUKIMOCHI", "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key")
+
+	file, _ := os.Open("../misc/test/create.json")
+	body, _ := io.ReadAll(file)
+	req := mockRFC9421SignedRequest(body, body, "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key", time.Hour)
+
+	activity, remoteActor, _, err := decodeActivity(req)
+	if err != nil {
+		t.Fatalf("Expected decodeActivity to succeed, but got error: %v", err)
+	}
+	if activity.Actor != remoteActor.ID {
+		t.Fatalf("Expected activity.Actor to be '%s', but got '%s'", remoteActor.ID, activity.Actor)
+	}
+}
+
+func TestDecodeActivityRFC9421WithInvalidContentDigest(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	cacheMockRemoteActor("https://innocent.yukimochi.io/users/YUKIMOCHI", "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key")
+
+	file, _ := os.Open("../misc/test/create.json")
+	body, _ := io.ReadAll(file)
+	req := mockRFC9421SignedRequest(body, []byte("{}"), "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key", time.Hour)
+
+	_, _, _, err := decodeActivity(req)
+	if err == nil || err.Error() != "content-digest header is mismatch" {
+		t.Fatalf("Expected error 'content-digest header is mismatch', but got '%v'", err)
+	}
+}
+
+func TestDecodeActivityRFC9421Expired(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	file, _ := os.Open("../misc/test/create.json")
+	body, _ := io.ReadAll(file)
+	req := mockRFC9421SignedRequest(body, body, "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key", -time.Hour)
+
+	_, _, _, err := decodeActivity(req)
+	if err == nil || err.Error() != "signature is expired" {
+		t.Fatalf("Expected error 'signature is expired', but got '%v'", err)
+	}
+}
+
+func TestDecodeActivityWithOtherActorKey(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	cacheMockRemoteActor("https://innocent.yukimochi.io/users/YUKIMOCHI", "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key")
+	cacheMockRemoteActor("https://innocent.yukimochi.io/actor", "https://innocent.yukimochi.io/actor#main-key")
+
+	file, _ := os.Open("../misc/test/create.json")
+	body, _ := io.ReadAll(file)
+	req := mockRFC9421SignedRequest(body, body, "https://innocent.yukimochi.io/actor#main-key", time.Hour)
+
+	_, _, _, err := decodeActivity(req)
+	var rejection *rejectionError
+	if !errors.As(err, &rejection) || rejection.status != 401 {
+		t.Fatalf("Expected activity signed by other actor to be rejected with 401, but got '%v'", err)
+	}
+
+	RelayState.SetConfig(InstanceActorSigning, true)
+	req = mockRFC9421SignedRequest(body, body, "https://innocent.yukimochi.io/actor#main-key", time.Hour)
+	activity, remoteActor, _, err := decodeActivity(req)
+	if err != nil {
+		t.Fatalf("Expected activity signed by same-origin instance actor to be accepted, but got error: %v", err)
+	}
+	if remoteActor.ID != activity.Actor {
+		t.Fatalf("Expected actor to be activity actor '%s', but got '%s'", activity.Actor, remoteActor.ID)
+	}
+	RelayState.SetConfig(InstanceActorSigning, false)
+}
+
+func TestDecodeActivityWithOtherHostKey(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	RelayState.SetConfig(InstanceActorSigning, true)
+	cacheMockRemoteActor("https://innocent.yukimochi.io/users/YUKIMOCHI", "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key")
+	cacheMockRemoteActor("https://evil.example.com/actor", "https://evil.example.com/actor#main-key")
+
+	file, _ := os.Open("../misc/test/create.json")
+	body, _ := io.ReadAll(file)
+	req := mockRFC9421SignedRequest(body, body, "https://evil.example.com/actor#main-key", time.Hour)
+
+	_, _, _, err := decodeActivity(req)
+	if err == nil || err.Error() != "signed by https://evil.example.com/actor, which is on a different host from activity actor https://innocent.yukimochi.io/users/YUKIMOCHI" {
+		t.Fatalf("Expected activity signed by other host to be rejected, but got '%v'", err)
+	}
+	RelayState.SetConfig(InstanceActorSigning, false)
+}
+
+func TestDecodeActivityReplayed(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	cacheMockRemoteActor("https://innocent.yukimochi.io/users/YUKIMOCHI", "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key")
+
+	file, _ := os.Open("../misc/test/create.json")
+	body, _ := io.ReadAll(file)
+	req := mockRFC9421SignedRequest(body, body, "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key", time.Hour)
+	replayed, _ := http.NewRequest("POST", req.URL.String(), bytes.NewReader(body))
+	replayed.Header = req.Header.Clone()
+
+	_, _, _, err := decodeActivity(req)
+	if err != nil {
+		t.Fatalf("Expected decodeActivity to succeed, but got error: %v", err)
+	}
+	_, _, _, err = decodeActivity(replayed)
+	if err == nil || err.Error() != "replayed request is refused" {
+		t.Fatalf("Expected error 'replayed request is refused', but got '%v'", err)
+	}
+}
+
+func TestDecodeActivityWithStaleDate(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	file, _ := os.Open("../misc/test/create.json")
+	body, _ := io.ReadAll(file)
+	req, _ := http.NewRequest("POST", "https://relay.01.cloudgarage.yukimochi.io/inbox", bytes.NewReader(body))
+	req.Header.Add("content-type", "application/activity+json")
+	req.Header.Add("date", httpdate.Time2Str(time.Now().Add(-time.Hour)))
+	req.Header.Add("host", req.Host)
+	signer, _, _ := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, httpsig.DigestSha256, []string{httpsig.RequestTarget, "Host", "Date", "Digest"}, httpsig.Signature, 60*60)
+	signer.SignRequest(GlobalConfig.ActorKey(), "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key", req, body)
+
+	_, _, _, err := decodeActivity(req)
+	if err == nil || err.Error() != "signature is too old" {
+		t.Fatalf("Expected error 'signature is too old', but got '%v'", err)
+	}
+}
+
+func TestDecodeActivityWithUnsignedDateOrHost(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	cacheMockRemoteActor("https://innocent.yukimochi.io/users/YUKIMOCHI", "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key")
+
+	file, _ := os.Open("../misc/test/create.json")
+	body, _ := io.ReadAll(file)
+	for _, headers := range [][]string{
+		{httpsig.RequestTarget, "Host", "Digest"},
+		{httpsig.RequestTarget, "Date", "Digest"},
+	} {
+		req, _ := http.NewRequest("POST", "https://relay.01.cloudgarage.yukimochi.io/inbox", bytes.NewReader(body))
+		req.Header.Add("content-type", "application/activity+json")
+		req.Header.Add("date", httpdate.Time2Str(time.Now()))
+		req.Header.Add("host", req.Host)
+		signer, _, _ := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, httpsig.DigestSha256, headers, httpsig.Signature, 60*60)
+		signer.SignRequest(GlobalConfig.ActorKey(), "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key", req, body)
+
+		_, _, _, err := decodeActivity(req)
+		if err == nil || err.Error() != "signature must cover host and date or (created)" {
+			t.Fatalf("Expected signature with headers %v to be rejected, but got '%v'", headers, err)
+		}
+	}
+
+	req, _ := http.NewRequest("POST", "https://relay.01.cloudgarage.yukimochi.io/inbox", bytes.NewReader(body))
+	req.Header.Add("content-type", "application/activity+json")
+	req.Header.Add("date", httpdate.Time2Str(time.Now()))
+	req.Header.Add("host", req.Host)
+	signer, _, _ := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, httpsig.DigestSha256, []string{httpsig.RequestTarget, "Host", "Date", "Digest"}, httpsig.Signature, 60*60)
+	signer.SignRequest(GlobalConfig.ActorKey(), "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key", req, body)
+	_, _, _, err := decodeActivity(req)
+	if err != nil {
+		t.Fatalf("Expected signature covering host and date to be accepted, but got error: %v", err)
+	}
+}
+
+func signRFC9421Components(req *http.Request, body []byte, keyID string, components []string) {
+	req.Header.Set("Content-Digest", models.GenerateContentDigest(body))
+	values := map[string]string{
+		"@method":        req.Method,
+		"@path":          req.URL.EscapedPath(),
+		"@authority":     req.URL.Host,
+		"content-digest": req.Header.Get("Content-Digest"),
+	}
+	var identifiers []string
+	var base strings.Builder
+	for _, component := range components {
+		identifiers = append(identifiers, `"`+component+`"`)
+		base.WriteString(`"` + component + `": ` + values[component] + "\n")
+	}
+	signatureParams := "(" + strings.Join(identifiers, " ") + ");created=" + strconv.FormatInt(time.Now().Unix(), 10) + `;keyid="` + keyID + `"`
+	base.WriteString(`"@signature-params": ` + signatureParams)
+
+	hash := sha256.Sum256([]byte(base.String()))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, GlobalConfig.ActorKey().(*rsa.PrivateKey), crypto.SHA256, hash[:])
+	req.Header.Set("Signature-Input", "sig1="+signatureParams)
+	req.Header.Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(signature)+":")
+}
+
+func TestDecodeActivityRFC9421WithoutAuthority(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	cacheMockRemoteActor("https://innocent.yukimochi.io/users/YUKIMOCHI", "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key")
+
+	file, _ := os.Open("../misc/test/create.json")
+	body, _ := io.ReadAll(file)
+	req, _ := http.NewRequest("POST", "https://relay.01.cloudgarage.yukimochi.io/inbox", bytes.NewReader(body))
+	signRFC9421Components(req, body, "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key", []string{"@method", "@path", "content-digest"})
+
+	_, _, _, err := decodeActivity(req)
+	if err == nil || err.Error() != "signature must cover @method, @target-uri (or @authority and @path) and content-digest" {
+		t.Fatalf("Expected signature without @authority to be rejected, but got '%v'", err)
+	}
+
+	req, _ = http.NewRequest("POST", "https://relay.01.cloudgarage.yukimochi.io/inbox", bytes.NewReader(body))
+	signRFC9421Components(req, body, "https://innocent.yukimochi.io/users/YUKIMOCHI#main-key", []string{"@method", "@authority", "@path", "content-digest"})
+	_, _, _, err = decodeActivity(req)
+	if err != nil {
+		t.Fatalf("Expected signature covering @authority and @path to be accepted, but got error: %v", err)
+	}
+}
@@ -2,14 +2,20 @@ package api
 
 import (
 	"encoding/json"
+	"encoding/xml"
 	"errors"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/yukimochi/Activity-Relay/models"
 )
 
+// handleWebfinger : Serve Webfinger resource queried by acct or actor URL, with links filtered by rel.
 func handleWebfinger(writer http.ResponseWriter, request *http.Request) {
 	queriedResource := request.URL.Query()["resource"]
 	if request.Method != "GET" || len(queriedResource) == 0 {
@@ -18,14 +24,15 @@ func handleWebfinger(writer http.ResponseWriter, request *http.Request) {
 	} else {
 		queriedSubject := queriedResource[0]
 		for _, webfingerResource := range WebfingerResources {
-			if queriedSubject == webfingerResource.Subject {
-				webfinger, err := json.Marshal(&webfingerResource)
+			if webfingerResource.Matches(queriedSubject) {
+				webfinger, err := json.Marshal(webfingerResource.FilterLinks(request.URL.Query()["rel"]))
 				if err != nil {
 					logrus.Fatal("Failed to marshal webfinger resource : ", err.Error())
 					writer.WriteHeader(500)
 					writer.Write(nil)
 					return
 				}
+				writer.Header().Add("Access-Control-Allow-Origin", "*")
 				writer.Header().Add("Content-Type", "application/json")
 				writer.WriteHeader(200)
 				writer.Write(webfinger)
@@ -37,6 +44,42 @@ func handleWebfinger(writer http.ResponseWriter, request *http.Request) {
 	}
 }
 
+// handleHostMeta : Serve host-meta as XRD, or JSON for host-meta.json and clients preferring JSON.
+func handleHostMeta(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		writer.WriteHeader(400)
+		writer.Write(nil)
+		return
+	}
+
+	contentType := "application/json"
+	if !strings.HasSuffix(request.URL.Path, ".json") {
+		writer.Header().Add("Vary", "Accept")
+		contentType = negotiateContentType(request.Header.Get("Accept"), []string{"application/xrd+xml", "application/json", "application/jrd+json"})
+	}
+	var hostMeta []byte
+	var err error
+	switch contentType {
+	case "":
+		writer.WriteHeader(406)
+		writer.Write(nil)
+		return
+	case "application/xrd+xml":
+		hostMeta, err = xml.Marshal(&HostMeta)
+		hostMeta = append([]byte(xml.Header), hostMeta...)
+	default:
+		hostMeta, err = json.Marshal(&HostMeta)
+	}
+	if err != nil {
+		logrus.Error("Failed to marshal host-meta : ", err.Error())
+		writer.WriteHeader(500)
+		writer.Write(nil)
+		return
+	}
+	writer.Header().Add("Access-Control-Allow-Origin", "*")
+	writeDocument(writer, request, contentType, hostMeta)
+}
+
 func handleNodeinfoLink(writer http.ResponseWriter, request *http.Request) {
 	if request.Method != "GET" {
 		writer.WriteHeader(400)
@@ -49,9 +92,7 @@ func handleNodeinfoLink(writer http.ResponseWriter, request *http.Request) {
 			writer.Write(nil)
 			return
 		}
-		writer.Header().Add("Content-Type", "application/json")
-		writer.WriteHeader(200)
-		writer.Write(nodeinfoLinks)
+		writeDocument(writer, request, "application/json", nodeinfoLinks)
 	}
 }
 
@@ -71,39 +112,143 @@ func handleNodeinfo(writer http.ResponseWriter, request *http.Request) {
 			writer.Write(nil)
 			return
 		}
-		writer.Header().Add("Content-Type", "application/json")
-		writer.WriteHeader(200)
-		writer.Write(nodeinfo)
+		writeDocument(writer, request, "application/json", nodeinfo)
 	}
 }
 
+// handleRelayActor : Serve relay actor in negotiated representation. Browsers are redirected to status page.
 func handleRelayActor(writer http.ResponseWriter, request *http.Request) {
-	if request.Method == "GET" {
-		relayActor, err := json.Marshal(&RelayActor)
+	if request.Method != "GET" {
+		writer.WriteHeader(400)
+		writer.Write(nil)
+		return
+	}
+
+	writer.Header().Add("Vary", "Accept")
+	switch contentType := negotiateContentType(request.Header.Get("Accept"), activityStreamsOffers); contentType {
+	case "":
+		writer.WriteHeader(406)
+		writer.Write(nil)
+	case htmlType:
+		http.Redirect(writer, request, "/", http.StatusSeeOther)
+	default:
+		relayActor, err := json.Marshal(RelayActor.Load())
 		if err != nil {
 			logrus.Fatal("Failed to marshal relay actor : ", err.Error())
 			writer.WriteHeader(500)
 			writer.Write(nil)
 			return
 		}
-		writer.Header().Add("Content-Type", "application/activity+json")
-		writer.WriteHeader(200)
-		writer.Write(relayActor)
-	} else {
+		writeDocument(writer, request, contentType, relayActor)
+	}
+}
+
+func handleRelayFollowers(writer http.ResponseWriter, request *http.Request) {
+	handleRelayCollection(writer, request, RelayActor.Load().FollowersURL, RelayState.FollowersOfRelay())
+}
+
+func handleRelayFollowing(writer http.ResponseWriter, request *http.Request) {
+	handleRelayCollection(writer, request, RelayActor.Load().FollowingURL, RelayState.FollowingOfRelay())
+}
+
+// handleRelayCollection : Serve OrderedCollection of actors. Pages are served only when collection-items config is enabled.
+func handleRelayCollection(writer http.ResponseWriter, request *http.Request, id string, items []string) {
+	if request.Method != "GET" {
 		writer.WriteHeader(400)
 		writer.Write(nil)
+		return
+	}
+	writer.Header().Add("Vary", "Accept")
+	contentType := negotiateContentType(request.Header.Get("Accept"), activityStreamsOffers)
+	switch contentType {
+	case "":
+		writer.WriteHeader(406)
+		writer.Write(nil)
+		return
+	case htmlType:
+		http.Redirect(writer, request, "/", http.StatusSeeOther)
+		return
+	}
+
+	var collection interface{} = models.NewOrderedCollection(id, len(items), RelayState.RelayConfig.CollectionItems)
+	if request.URL.Query().Has("page") {
+		page, err := strconv.Atoi(request.URL.Query().Get("page"))
+		collectionPage, ok := models.NewOrderedCollectionPage(id, items, page)
+		if err != nil || !ok || !RelayState.RelayConfig.CollectionItems {
+			writer.WriteHeader(404)
+			writer.Write(nil)
+			return
+		}
+		collection = collectionPage
+	}
+	jsonData, err := json.Marshal(collection)
+	if err != nil {
+		logrus.Error("Failed to marshal collection : ", err.Error())
+		writer.WriteHeader(500)
+		writer.Write(nil)
+		return
 	}
+	writer.Header().Add("Content-Type", contentType)
+	writer.WriteHeader(200)
+	writer.Write(jsonData)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func writeTooManyRequests(writer http.ResponseWriter, source string, retryAfter time.Duration) {
+	writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writer.WriteHeader(429)
+	writer.Write([]byte("too many requests from " + source))
 }
 
 func handleInbox(writer http.ResponseWriter, request *http.Request, activityDecoder func(*http.Request) (*models.Activity, *models.Actor, []byte, error)) {
+	recorder := &statusRecorder{writer, 200}
+	writer = recorder
+	activityType := "unknown"
+	defer func() {
+		models.InboxRequests.WithLabelValues(activityType, strconv.Itoa(recorder.status)).Inc()
+	}()
+
 	switch request.Method {
 	case "POST":
+		// Rate limit by remote address before fetching key and verifying signature
+		address := remoteAddress(request)
+		if allowed, retryAfter := RelayState.TakeAddressRateLimitToken(address); !allowed {
+			writeTooManyRequests(writer, address, retryAfter)
+
+			return
+		}
 		activity, actor, body, err := activityDecoder(request)
 		if err != nil {
-			writer.WriteHeader(400)
-			writer.Write(nil)
+			status := 400
+			var rejection *rejectionError
+			if errors.As(err, &rejection) {
+				status = rejection.status
+			}
+			writer.WriteHeader(status)
+			writer.Write([]byte(err.Error()))
 		} else {
+			switch activity.Type {
+			case "Create", "Update", "Delete", "Move", "Follow", "Undo", "Accept", "Reject", "Announce":
+				activityType = activity.Type
+			default:
+				activityType = "other"
+			}
 			actorID, _ := url.Parse(activity.Actor)
+			// Rate limit by domain of actor, which is bound to signing key owner
+			if allowed, retryAfter := RelayState.TakeRateLimitToken(actorID.Host); !allowed {
+				writeTooManyRequests(writer, actorID.Host, retryAfter)
+
+				return
+			}
 			switch {
 			case contains(activity.To, "https://www.w3.org/ns/activitystreams#Public"), contains(activity.Cc, "https://www.w3.org/ns/activitystreams#Public"):
 				// Mastodon Traditional Style (Activity Transfer)
@@ -122,7 +267,7 @@ func handleInbox(writer http.ResponseWriter, request *http.Request, activityDeco
 					writer.WriteHeader(202)
 					writer.Write(nil)
 				}
-			case contains(activity.To, RelayActor.ID), contains(activity.Cc, RelayActor.ID):
+			case contains(activity.To, RelayActor.Load().ID), contains(activity.Cc, RelayActor.Load().ID):
 				// LitePub Relay Style
 				fallthrough
 			case isToMyFollower(activity.To), isToMyFollower(activity.Cc):
@@ -197,9 +342,16 @@ func handleInbox(writer http.ResponseWriter, request *http.Request, activityDeco
 
 						return
 					}
+					if !isActorAllowed(actorID) {
+						logrus.Debug("Dropped Announce Activity from Non-Allowlisted Domain : ", activity.Actor)
+						writer.WriteHeader(202)
+						writer.Write(nil)
+
+						return
+					}
 					switch innerObject := activity.Object.(type) {
 					case string:
-						origActivity, origActor, err := fetchOriginalActivityFromURL(innerObject)
+						origActivity, origDocument, origActor, err := fetchOriginalActivityFromURL(innerObject)
 						if err != nil {
 							logrus.Debug("Failed Announce Activity : ", activity.Actor)
 							writer.WriteHeader(400)
@@ -207,7 +359,7 @@ func handleInbox(writer http.ResponseWriter, request *http.Request, activityDeco
 
 							return
 						}
-						executeAnnounceActivity(origActivity, origActor)
+						executeAnnounceActivity(origActivity, origDocument, origActor)
 					default:
 						logrus.Debug("Skipped Announce Activity : ", activity.Actor)
 					}
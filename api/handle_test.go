@@ -3,20 +3,32 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/yukimochi/Activity-Relay/models"
 )
 
 const (
 	PersonOnly models.Config = iota
 	ManuallyAccept
+	InstanceActorSigning
+	AllowlistOnly
+	CollectionItems
+	StatusDomains
+	StatusConfig
 )
 
 func TestHandleWebfingerGet(t *testing.T) {
@@ -79,6 +91,85 @@ func TestHandleWebfingerGetBadResource(t *testing.T) {
 	}
 }
 
+func TestHandleWebfingerGetByActor(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(handleWebfinger))
+	defer s.Close()
+
+	req, _ := http.NewRequest("GET", s.URL, nil)
+	q := req.URL.Query()
+	q.Add("resource", strings.ToUpper(GlobalConfig.ServerHostname().Scheme)+"://"+strings.ToUpper(GlobalConfig.ServerHostname().Host)+"/actor")
+	q.Add("rel", "self")
+	req.URL.RawQuery = q.Encode()
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		t.Fatalf("Expected StatusCode to be 200, but got %d", r.StatusCode)
+	}
+
+	var webfinger models.WebfingerResource
+	err = json.NewDecoder(r.Body).Decode(&webfinger)
+	if err != nil {
+		t.Fatalf("Expected valid JSON response, but got error: %v", err)
+	}
+	if webfinger.Subject != "acct:relay@"+GlobalConfig.ServerHostname().Host {
+		t.Fatalf("Expected subject to be acct of relay, but got '%s'", webfinger.Subject)
+	}
+	if len(webfinger.Links) != 1 || webfinger.Links[0].Rel != "self" {
+		t.Fatalf("Expected only self link, but got %+v", webfinger.Links)
+	}
+}
+
+func TestHandleHostMeta(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/host-meta", handleHostMeta)
+	mux.HandleFunc("/.well-known/host-meta.json", handleHostMeta)
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	template := "https://" + GlobalConfig.ServerHostname().Host + "/.well-known/webfinger?resource={uri}"
+
+	t.Run("XRD", func(t *testing.T) {
+		r, err := http.Get(s.URL + "/.well-known/host-meta")
+		if err != nil {
+			t.Fatalf("Expected request to succeed, but got error: %v", err)
+		}
+		defer r.Body.Close()
+		if r.StatusCode != 200 || r.Header.Get("Content-Type") != "application/xrd+xml" {
+			t.Fatalf("Expected 200 with XRD, but got %d with '%s'", r.StatusCode, r.Header.Get("Content-Type"))
+		}
+		var hostMeta models.HostMeta
+		err = xml.NewDecoder(r.Body).Decode(&hostMeta)
+		if err != nil {
+			t.Fatalf("Expected valid XRD response, but got error: %v", err)
+		}
+		if len(hostMeta.Links) != 1 || hostMeta.Links[0].Rel != "lrdd" || hostMeta.Links[0].Template != template {
+			t.Fatalf("Expected lrdd link to Webfinger, but got %+v", hostMeta.Links)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		for _, path := range []string{"/.well-known/host-meta.json", "/.well-known/host-meta"} {
+			req, _ := http.NewRequest("GET", s.URL+path, nil)
+			req.Header.Set("Accept", "application/json")
+			r, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Expected request to succeed, but got error: %v", err)
+			}
+			var hostMeta models.HostMeta
+			err = json.NewDecoder(r.Body).Decode(&hostMeta)
+			r.Body.Close()
+			if err != nil || r.Header.Get("Content-Type") != "application/json" {
+				t.Fatalf("Expected JSON response for %s, but got '%s': %v", path, r.Header.Get("Content-Type"), err)
+			}
+			if len(hostMeta.Links) != 1 || hostMeta.Links[0].Template != template {
+				t.Fatalf("Expected lrdd link to Webfinger, but got %+v", hostMeta.Links)
+			}
+		}
+	})
+}
+
 func TestHandleNodeinfoLinkGet(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(handleNodeinfoLink))
 	defer s.Close()
@@ -205,6 +296,260 @@ func TestHandleActorGet(t *testing.T) {
 	}
 }
 
+func TestHandleActorDuringKeyRotation(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	defer RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	servedActor := func() models.Actor {
+		req := httptest.NewRequest("GET", "/actor", nil)
+		recorder := httptest.NewRecorder()
+		handleRelayActor(recorder, req)
+		var actor models.Actor
+		json.Unmarshal(recorder.Body.Bytes(), &actor)
+		return actor
+	}
+
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					servedActor()
+					newRelayStatus()
+				}
+			}
+		}()
+	}
+
+	keyID, err := GlobalConfig.RotateActorKey(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for RelayActor.Load().PublicKey.ID != keyID && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	readers.Wait()
+
+	if actor := servedActor(); actor.PublicKey.ID != keyID {
+		t.Fatalf("Expected actor to serve rotated key %s, but got %s", keyID, actor.PublicKey.ID)
+	}
+}
+
+func TestHandleActorNegotiation(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(handleRelayActor))
+	defer s.Close()
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, _ := http.NewRequest("GET", s.URL, nil)
+	req.Header.Set("Accept", `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`)
+	r, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	r.Body.Close()
+	if r.StatusCode != 200 || r.Header.Get("Content-Type") != ldJSONType {
+		t.Fatalf("Expected 200 with Content-Type '%s', but got %d with '%s'", ldJSONType, r.StatusCode, r.Header.Get("Content-Type"))
+	}
+	if r.Header.Get("Vary") != "Accept" {
+		t.Fatalf("Expected Vary to be 'Accept', but got '%s'", r.Header.Get("Vary"))
+	}
+
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	r, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	r.Body.Close()
+	if r.StatusCode != 303 || r.Header.Get("Location") != "/" {
+		t.Fatalf("Expected redirect to status page, but got %d to '%s'", r.StatusCode, r.Header.Get("Location"))
+	}
+
+	req.Header.Set("Accept", "image/png")
+	r, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	r.Body.Close()
+	if r.StatusCode != 406 {
+		t.Fatalf("Expected StatusCode to be 406, but got %d", r.StatusCode)
+	}
+}
+
+func TestHandleActorConditionalGet(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(handleRelayActor))
+	defer s.Close()
+
+	r, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	r.Body.Close()
+	etag := r.Header.Get("ETag")
+	lastModified := r.Header.Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("Expected ETag and Last-Modified, but got '%s' and '%s'", etag, lastModified)
+	}
+
+	req, _ := http.NewRequest("GET", s.URL, nil)
+	req.Header.Set("If-None-Match", etag)
+	r, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	r.Body.Close()
+	if r.StatusCode != 304 {
+		t.Fatalf("Expected StatusCode to be 304, but got %d", r.StatusCode)
+	}
+
+	req, _ = http.NewRequest("GET", s.URL, nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	r, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	r.Body.Close()
+	if r.StatusCode != 304 {
+		t.Fatalf("Expected StatusCode to be 304, but got %d", r.StatusCode)
+	}
+
+	req, _ = http.NewRequest("GET", s.URL, nil)
+	req.Header.Set("Accept", "application/ld+json")
+	req.Header.Set("If-None-Match", etag)
+	r, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	r.Body.Close()
+	if r.StatusCode != 200 {
+		t.Fatalf("Expected StatusCode to be 200 for other representation, but got %d", r.StatusCode)
+	}
+}
+
+func TestHandleNodeinfoConditionalGet(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	RelayState.Load()
+	s := httptest.NewServer(http.HandlerFunc(handleNodeinfo))
+	defer s.Close()
+
+	r, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	r.Body.Close()
+	etag := r.Header.Get("ETag")
+
+	req, _ := http.NewRequest("GET", s.URL, nil)
+	req.Header.Set("If-None-Match", etag)
+	r, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	r.Body.Close()
+	if r.StatusCode != 304 {
+		t.Fatalf("Expected StatusCode to be 304, but got %d", r.StatusCode)
+	}
+
+	RelayState.AddSubscriber(models.Subscriber{
+		Domain:     "example.com",
+		InboxURL:   "https://example.com/inbox",
+		ActivityID: "https://example.com/UUID",
+		ActorID:    "https://example.com/user/example",
+	})
+	r, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	r.Body.Close()
+	if r.StatusCode != 200 || r.Header.Get("ETag") == etag {
+		t.Fatalf("Expected 200 with new ETag after subscribers changed, but got %d with '%s'", r.StatusCode, r.Header.Get("ETag"))
+	}
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	RelayState.Load()
+}
+
+func TestHandleRelayCollections(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	RelayState.AddSubscriber(models.Subscriber{
+		Domain:   "subscription.example.jp",
+		InboxURL: "https://subscription.example.jp/inbox",
+		ActorID:  "https://subscription.example.jp/actor",
+	})
+	RelayState.AddFollower(models.Follower{
+		Domain:         "follower.example.jp",
+		InboxURL:       "https://follower.example.jp/inbox",
+		ActorID:        "https://follower.example.jp/relay",
+		MutuallyFollow: true,
+	})
+	defer RelayState.SetConfig(CollectionItems, false)
+
+	s := httptest.NewServer(http.HandlerFunc(handleRelayFollowers))
+	defer s.Close()
+
+	t.Run("Serve totalItems only", func(t *testing.T) {
+		r, err := http.Get(s.URL)
+		if err != nil {
+			t.Fatalf("Expected request to succeed, but got error: %v", err)
+		}
+		defer r.Body.Close()
+		var collection models.OrderedCollection
+		json.NewDecoder(r.Body).Decode(&collection)
+		if collection.ID != RelayActor.Load().FollowersURL || collection.TotalItems != 2 || collection.First != "" {
+			t.Fatalf("Expected collection of 2 followers without first page, but got %+v", collection)
+		}
+
+		r, _ = http.Get(s.URL + "?page=1")
+		if r.StatusCode != 404 {
+			t.Fatalf("Expected StatusCode to be 404, but got %d", r.StatusCode)
+		}
+	})
+
+	t.Run("Serve items", func(t *testing.T) {
+		RelayState.SetConfig(CollectionItems, true)
+		r, err := http.Get(s.URL + "?page=1")
+		if err != nil {
+			t.Fatalf("Expected request to succeed, but got error: %v", err)
+		}
+		defer r.Body.Close()
+		var page models.OrderedCollectionPage
+		json.NewDecoder(r.Body).Decode(&page)
+		if len(page.OrderedItems) != 2 || page.OrderedItems[0] != "https://follower.example.jp/relay" || page.PartOf != RelayActor.Load().FollowersURL {
+			t.Fatalf("Expected page of 2 followers, but got %+v", page)
+		}
+
+		r, _ = http.Get(s.URL + "?page=2")
+		if r.StatusCode != 404 {
+			t.Fatalf("Expected StatusCode to be 404, but got %d", r.StatusCode)
+		}
+	})
+
+	t.Run("Serve following", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(handleRelayFollowing))
+		defer s.Close()
+		r, err := http.Get(s.URL + "?page=1")
+		if err != nil {
+			t.Fatalf("Expected request to succeed, but got error: %v", err)
+		}
+		defer r.Body.Close()
+		var page models.OrderedCollectionPage
+		json.NewDecoder(r.Body).Decode(&page)
+		if len(page.OrderedItems) != 1 || page.OrderedItems[0] != "https://follower.example.jp/relay" {
+			t.Fatalf("Expected page of mutually followed actor, but got %+v", page)
+		}
+	})
+}
+
 func TestHandleActorInvalidMethod(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(handleRelayActor))
 	defer s.Close()
@@ -404,6 +749,29 @@ func TestHandleInboxNoSignature(t *testing.T) {
 	}
 }
 
+func TestHandleInboxRejectionReason(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleInbox(w, r, func(*http.Request) (*models.Activity, *models.Actor, []byte, error) {
+			return nil, nil, nil, &rejectionError{401, "signed by https://example.com/actor, which is not activity actor https://example.com/users/alice (same-origin instance actor signing is disabled)"}
+		})
+	}))
+	defer s.Close()
+
+	req, _ := http.NewRequest("POST", s.URL, nil)
+	client := new(http.Client)
+	r, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	if r.StatusCode != 401 {
+		t.Fatalf("Expected StatusCode to be 401, but got %d", r.StatusCode)
+	}
+	reason, _ := io.ReadAll(r.Body)
+	if string(reason) != "signed by https://example.com/actor, which is not activity actor https://example.com/users/alice (same-origin instance actor signing is disabled)" {
+		t.Fatalf("Expected rejection reason in response body, but got '%s'", string(reason))
+	}
+}
+
 func TestHandleInboxInvalidMethod(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		handleInbox(w, r, decodeActivity)
@@ -507,6 +875,76 @@ func TestHandleInboxValidFollowBlocked(t *testing.T) {
 	RelayState.SetBlockedDomain(domain.Host, false)
 }
 
+func TestHandleInboxValidFollowWildcardBlocked(t *testing.T) {
+	activity := mockActivity("Follow")
+	actor := mockActor("Person")
+	domain, _ := url.Parse(activity.Actor)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	pattern := "." + domain.Hostname()
+	RelayState.SetBlockedDomain(pattern, true)
+
+	req, _ := http.NewRequest("POST", s.URL, nil)
+	client := new(http.Client)
+	r, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	if r.StatusCode != 202 {
+		t.Fatalf("Expected StatusCode to be 202, but got %d", r.StatusCode)
+	}
+	res, _ := RelayState.RedisClient.Exists(context.TODO(), "relay:subscription:"+domain.Host).Result()
+	if res != 0 {
+		t.Fatalf("Expected Redis key 'relay:subscription:%s' to not exist (value=0), but got %d", domain.Host, res)
+	}
+	RelayState.DelSubscriber(domain.Host)
+	RelayState.SetBlockedDomain(pattern, false)
+}
+
+func TestHandleInboxValidFollowNotAllowlisted(t *testing.T) {
+	activity := mockActivity("Follow")
+	actor := mockActor("Person")
+	domain, _ := url.Parse(activity.Actor)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	RelayState.SetConfig(AllowlistOnly, true)
+	RelayState.SetAllowedDomain("allowed.example.com", true)
+
+	req, _ := http.NewRequest("POST", s.URL, nil)
+	client := new(http.Client)
+	r, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	if r.StatusCode != 202 {
+		t.Fatalf("Expected StatusCode to be 202, but got %d", r.StatusCode)
+	}
+	res, _ := RelayState.RedisClient.Exists(context.TODO(), "relay:subscription:"+domain.Host).Result()
+	if res != 0 {
+		t.Fatalf("Expected Redis key 'relay:subscription:%s' to not exist (value=0), but got %d", domain.Host, res)
+	}
+
+	RelayState.SetAllowedDomain(domain.Hostname(), true)
+	r, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	res, _ = RelayState.RedisClient.Exists(context.TODO(), "relay:subscription:"+domain.Host).Result()
+	if res != 1 {
+		t.Fatalf("Expected Redis key 'relay:subscription:%s' to exist (value=1), but got %d", domain.Host, res)
+	}
+	RelayState.DelSubscriber(domain.Host)
+	RelayState.SetAllowedDomain(domain.Hostname(), false)
+	RelayState.SetAllowedDomain("allowed.example.com", false)
+	RelayState.SetConfig(AllowlistOnly, false)
+}
+
 func TestHandleInboxFollowLitePub(t *testing.T) {
 	activity := mockActivity("Follow-LP")
 	actor := mockActor("Person")
@@ -715,6 +1153,273 @@ func TestHandleInboxValidCreate(t *testing.T) {
 	RelayState.RedisClient.Del(context.TODO(), "relay:subscription:example.org").Result()
 }
 
+func TestHandleInboxDuplicatedCreate(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	activity := mockActivity("Create")
+	actor := mockActor("Person")
+	domain, _ := url.Parse(activity.Actor)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	RelayState.AddSubscriber(models.Subscriber{
+		Domain:   domain.Host,
+		InboxURL: "https://mastodon.test.yukimochi.io/inbox",
+	})
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", s.URL, nil)
+		client := new(http.Client)
+		r, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Expected request to succeed, but got error: %v", err)
+		}
+		if r.StatusCode != 202 {
+			t.Fatalf("Expected StatusCode to be 202, but got %d", r.StatusCode)
+		}
+	}
+	statistics := RelayState.DuplicateStatistics()
+	if statistics[models.SeenActivity] != 1 || statistics[models.SeenObject] != 1 {
+		t.Fatalf("Expected duplicated Create to be counted once for activity and object, but got %v", statistics)
+	}
+	RelayState.DelSubscriber(domain.Host)
+}
+
+func TestHandleInboxRepeatedUpdate(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	activity := mockActivity("Create")
+	activity.Type = "Update"
+	actor := mockActor("Person")
+	domain, _ := url.Parse(activity.Actor)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	RelayState.AddSubscriber(models.Subscriber{
+		Domain:   domain.Host,
+		InboxURL: "https://mastodon.test.yukimochi.io/inbox",
+	})
+
+	baseID := activity.ID
+	for _, id := range []string{baseID + "#updates/1", baseID + "#updates/2", baseID + "#updates/2"} {
+		activity.ID = id
+		req, _ := http.NewRequest("POST", s.URL, nil)
+		client := new(http.Client)
+		r, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Expected request to succeed, but got error: %v", err)
+		}
+		if r.StatusCode != 202 {
+			t.Fatalf("Expected StatusCode to be 202, but got %d", r.StatusCode)
+		}
+	}
+	statistics := RelayState.DuplicateStatistics()
+	if statistics[models.SeenActivity] != 1 || statistics[models.SeenObject] != 0 {
+		t.Fatalf("Expected only repeated Update activity to be duplicated, but got %v", statistics)
+	}
+	RelayState.DelSubscriber(domain.Host)
+}
+
+func TestHandleInboxFilteredCreate(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	activity := mockActivity("Create")
+	actor := mockActor("Person")
+	domain, _ := url.Parse(activity.Actor)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	RelayState.AddSubscriber(models.Subscriber{
+		Domain:   domain.Host,
+		InboxURL: "https://mastodon.test.yukimochi.io/inbox",
+	})
+	RelayState.AddFilter(models.FilterRule{Action: models.DropAction, Keyword: "てすてす"})
+	RelayState.Load()
+
+	req, _ := http.NewRequest("POST", s.URL, nil)
+	client := new(http.Client)
+	r, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	if r.StatusCode != 202 {
+		t.Fatalf("Expected StatusCode to be 202, but got %d", r.StatusCode)
+	}
+	seen, _ := RelayState.RedisClient.Keys(context.TODO(), "relay:seen:*").Result()
+	if len(seen) != 0 {
+		t.Fatalf("Expected filtered Create not to be relayed, but got %v", seen)
+	}
+	RelayState.DelFilter("1")
+	RelayState.DelSubscriber(domain.Host)
+	RelayState.Load()
+}
+
+func TestExecuteAnnounceActivityFiltered(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	queue := models.NewMemoryQueue()
+	received := make(chan string, 10)
+	queue.RegisterTask("relay-v2", func(args ...string) error {
+		received <- args[0]
+		return nil
+	})
+	queue.Launch(1)
+	defer queue.Shutdown(context.Background())
+	machineryQueue := Queue
+	Queue = queue
+	defer func() { Queue = machineryQueue }()
+
+	actor := mockActor("Person")
+	RelayState.AddSubscriber(models.Subscriber{
+		Domain:   "example.org",
+		InboxURL: "https://example.org/inbox",
+	})
+	RelayState.AddFollower(models.Follower{
+		Domain:   "example.net",
+		InboxURL: "https://example.net/inbox",
+	})
+	RelayState.AddFilter(models.FilterRule{Action: models.DropAction, Keyword: "spam"})
+	RelayState.AddFilter(models.FilterRule{Action: models.DropForFollowersAction, Keyword: "nsfw"})
+	RelayState.Load()
+
+	deliveries := func(objectID string, content string) []string {
+		activity := models.Activity{ID: objectID, Actor: actor.ID, Type: "Note"}
+		document := map[string]interface{}{
+			"id":     objectID,
+			"type":   "Create",
+			"actor":  actor.ID,
+			"object": map[string]interface{}{"type": "Note", "content": content},
+		}
+		executeAnnounceActivity(&activity, document, &actor)
+		pendingEnqueues.Wait()
+		var inboxes []string
+		for {
+			select {
+			case inbox := <-received:
+				inboxes = append(inboxes, inbox)
+			case <-time.After(100 * time.Millisecond):
+				slices.Sort(inboxes)
+				return inboxes
+			}
+		}
+	}
+
+	t.Run("Drop announced object", func(t *testing.T) {
+		inboxes := deliveries("https://example.com/objects/1", "<p>buy spam now</p>")
+		if len(inboxes) != 0 {
+			t.Fatalf("Expected filtered Announce not to be relayed, but got %v", inboxes)
+		}
+	})
+	t.Run("Drop announced object for followers", func(t *testing.T) {
+		inboxes := deliveries("https://example.com/objects/2", "<p>nsfw</p>")
+		if len(inboxes) != 1 || inboxes[0] != "https://example.org/inbox" {
+			t.Fatalf("Expected Announce to be relayed to subscriber only, but got %v", inboxes)
+		}
+	})
+	t.Run("Relay announced object", func(t *testing.T) {
+		inboxes := deliveries("https://example.com/objects/3", "<p>hello</p>")
+		if len(inboxes) != 2 {
+			t.Fatalf("Expected Announce to be relayed to subscriber and follower, but got %v", inboxes)
+		}
+	})
+
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	RelayState.Load()
+}
+
+func TestHandleInboxRateLimited(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	activity := mockActivity("Create")
+	actor := mockActor("Person")
+	domain, _ := url.Parse(activity.Actor)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	RelayState.AddSubscriber(models.Subscriber{
+		Domain:   domain.Host,
+		InboxURL: "https://mastodon.test.yukimochi.io/inbox",
+	})
+	RelayState.SetRateLimit(domain.Host, models.RateLimit{Rate: 0.1, Burst: 1})
+
+	client := new(http.Client)
+	req, _ := http.NewRequest("POST", s.URL, nil)
+	r, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	if r.StatusCode != 202 {
+		t.Fatalf("Expected StatusCode to be 202, but got %d", r.StatusCode)
+	}
+	req, _ = http.NewRequest("POST", s.URL, nil)
+	r, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	if r.StatusCode != 429 {
+		t.Fatalf("Expected StatusCode to be 429, but got %d", r.StatusCode)
+	}
+	if r.Header.Get("Retry-After") != "10" {
+		t.Fatalf("Expected Retry-After to be 10, but got '%s'", r.Header.Get("Retry-After"))
+	}
+	if RelayState.RateLimitStatistics()[domain.Host] != 1 {
+		t.Fatalf("Expected rate limited request to be recorded, but got %v", RelayState.RateLimitStatistics())
+	}
+	pendingEnqueues.Wait()
+	RelayState.DelRateLimit(domain.Host)
+	RelayState.DelSubscriber(domain.Host)
+}
+
+func TestHandleInboxRateLimitedBeforeVerification(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	decoded := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleInbox(w, r, func(r *http.Request) (*models.Activity, *models.Actor, []byte, error) {
+			decoded++
+			return nil, nil, nil, errors.New("signature is invalid")
+		})
+	}))
+	defer s.Close()
+
+	RelayState.SetRateLimit("127.0.0.1", models.RateLimit{Rate: 0.1, Burst: 1})
+	RelayState.SetRateLimit("victim.example.com", models.RateLimit{Rate: 0.1, Burst: 1})
+
+	client := new(http.Client)
+	statuses := make([]int, 2)
+	for i := range statuses {
+		req, _ := http.NewRequest("POST", s.URL, strings.NewReader("{}"))
+		req.Header.Set("Signature", `keyId="https://victim.example.com/actor#main-key",algorithm="rsa-sha256",headers="(request-target) host date",signature="c2lnbmF0dXJl"`)
+		r, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Expected request to succeed, but got error: %v", err)
+		}
+		statuses[i] = r.StatusCode
+	}
+	if statuses[0] == 429 || statuses[1] != 429 {
+		t.Fatalf("Expected unverified request to be rate limited by remote address, but got %v", statuses)
+	}
+	if decoded != 1 {
+		t.Fatalf("Expected rate limited request not to be decoded, but decoded %d requests", decoded)
+	}
+	if allowed, _ := RelayState.TakeRateLimitToken("victim.example.com"); !allowed {
+		t.Fatalf("Expected bucket of spoofed keyId host not to be charged, but it was empty")
+	}
+	if len(RelayState.RateLimitStatistics()) != 0 {
+		t.Fatalf("Expected unverified request not to be recorded, but got %v", RelayState.RateLimitStatistics())
+	}
+	RelayState.DelRateLimit("127.0.0.1")
+	RelayState.DelRateLimit("victim.example.com")
+}
+
 func TestHandleInboxLimitedCreate(t *testing.T) {
 	activity := mockActivity("Create")
 	actor := mockActor("Person")
@@ -743,6 +1448,39 @@ func TestHandleInboxLimitedCreate(t *testing.T) {
 	RelayState.SetLimitedDomain(domain.Host, false)
 }
 
+func TestHandleInboxNotAllowlistedCreate(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	activity := mockActivity("Create")
+	actor := mockActor("Person")
+	domain, _ := url.Parse(activity.Actor)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	RelayState.AddSubscriber(models.Subscriber{
+		Domain:   domain.Host,
+		InboxURL: "https://mastodon.test.yukimochi.io/inbox",
+	})
+	RelayState.SetConfig(AllowlistOnly, true)
+
+	req, _ := http.NewRequest("POST", s.URL, nil)
+	client := new(http.Client)
+	r, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	if r.StatusCode != 202 {
+		t.Fatalf("Expected StatusCode to be 202, but got %d", r.StatusCode)
+	}
+	if !RelayState.MarkSeen(models.SeenActivity, activity.ID, GlobalConfig.DedupeTTL()) {
+		t.Fatalf("Expected activity from non-allowlisted domain to be dropped, but it was relayed")
+	}
+	RelayState.DelSubscriber(domain.Host)
+	RelayState.SetConfig(AllowlistOnly, false)
+}
+
 func TestHandleInboxUnsubscriptionCreate(t *testing.T) {
 	activity := mockActivity("Create")
 	actor := mockActor("Person")
@@ -794,3 +1532,29 @@ func TestHandleInboxAnnounceLitePub(t *testing.T) {
 	RelayState.RedisClient.Del(context.TODO(), "relay:subscription:"+domain.Host).Result()
 	RelayState.RedisClient.Del(context.TODO(), "relay:subscription:example.org").Result()
 }
+
+func TestHandleInboxMetrics(t *testing.T) {
+	activity := mockActivity("Create")
+	actor := mockActor("Person")
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	before := testutil.ToFloat64(models.InboxRequests.WithLabelValues("Create", "401"))
+
+	req, _ := http.NewRequest("POST", s.URL, nil)
+	client := new(http.Client)
+	r, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, but got error: %v", err)
+	}
+	if r.StatusCode != 401 {
+		t.Fatalf("Expected StatusCode to be 401, but got %d", r.StatusCode)
+	}
+
+	after := testutil.ToFloat64(models.InboxRequests.WithLabelValues("Create", "401"))
+	if after != before+1 {
+		t.Fatalf("Expected inbox request metric to be incremented, but got %v -> %v", before, after)
+	}
+}
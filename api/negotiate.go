@@ -0,0 +1,148 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	activityJSONType = "application/activity+json"
+	ldJSONType       = `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+	jsonType         = "application/json"
+	htmlType         = "text/html"
+)
+
+// activityStreamsOffers : Representations of ActivityPub documents, in order of preference
+var activityStreamsOffers = []string{activityJSONType, ldJSONType, jsonType, htmlType}
+
+// negotiateContentType : Offer most preferred by Accept header, or "" when none is acceptable. Earlier offer wins a tie.
+func negotiateContentType(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	type mediaRange struct {
+		mediaType string
+		params    map[string]string
+		quality   float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			delete(params, "q")
+		}
+		ranges = append(ranges, mediaRange{mediaType, params, quality})
+	}
+
+	best, bestQuality := "", 0.0
+	for _, offer := range offers {
+		offerType, offerParams, _ := mime.ParseMediaType(offer)
+		// Quality of offer is given by most specific matching range
+		quality, specificity := 0.0, -1
+		for _, r := range ranges {
+			var rangeSpecificity int
+			switch {
+			case r.mediaType == offerType:
+				rangeSpecificity = 2
+			case r.mediaType == "*/*":
+				rangeSpecificity = 0
+			case strings.HasSuffix(r.mediaType, "/*") && strings.HasPrefix(offerType, strings.TrimSuffix(r.mediaType, "*")):
+				rangeSpecificity = 1
+			default:
+				continue
+			}
+			matched := true
+			for key, value := range r.params {
+				if offerParams[key] != value {
+					matched = false
+				}
+			}
+			if !matched {
+				continue
+			}
+			if len(r.params) > 0 {
+				rangeSpecificity++
+			}
+			if rangeSpecificity > specificity {
+				quality, specificity = r.quality, rangeSpecificity
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+	return best
+}
+
+// documentVersion : ETag and Last-Modified of generated document
+type documentVersion struct {
+	etag     string
+	modified time.Time
+}
+
+var (
+	documentVersionsMutex sync.Mutex
+	// documentVersions : Version by path and content type. Last-Modified is updated when content changes.
+	documentVersions = map[string]documentVersion{}
+)
+
+func versionOfDocument(key string, body []byte) documentVersion {
+	hash := sha256.Sum256(append([]byte(key+"\n"), body...))
+	etag := `"` + hex.EncodeToString(hash[:16]) + `"`
+
+	documentVersionsMutex.Lock()
+	defer documentVersionsMutex.Unlock()
+	version, ok := documentVersions[key]
+	if !ok || version.etag != etag {
+		version = documentVersion{etag, time.Now().UTC().Truncate(time.Second)}
+		documentVersions[key] = version
+	}
+	return version
+}
+
+// writeDocument : Write document with ETag and Last-Modified, or 304 when client has same version.
+func writeDocument(writer http.ResponseWriter, request *http.Request, contentType string, body []byte) {
+	version := versionOfDocument(request.URL.Path+" "+contentType, body)
+	writer.Header().Set("ETag", version.etag)
+	writer.Header().Set("Last-Modified", version.modified.Format(http.TimeFormat))
+
+	if isNotModified(request, version) {
+		writer.WriteHeader(304)
+		return
+	}
+	writer.Header().Add("Content-Type", contentType)
+	writer.WriteHeader(200)
+	writer.Write(body)
+}
+
+func isNotModified(request *http.Request, version documentVersion) bool {
+	if ifNoneMatch := request.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, etag := range strings.Split(ifNoneMatch, ",") {
+			etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+			if etag == version.etag || etag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if ifModifiedSince := request.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		return err == nil && !version.modified.After(since)
+	}
+	return false
+}
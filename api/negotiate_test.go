@@ -0,0 +1,29 @@
+package api
+
+import "testing"
+
+func TestNegotiateContentType(t *testing.T) {
+	testCases := []struct {
+		accept   string
+		expected string
+	}{
+		{"", activityJSONType},
+		{"*/*", activityJSONType},
+		{"application/activity+json", activityJSONType},
+		{`application/ld+json; profile="https://www.w3.org/ns/activitystreams"`, ldJSONType},
+		{"application/ld+json", ldJSONType},
+		{`application/ld+json; profile="https://example.com/other"`, ""},
+		{"application/json", jsonType},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", htmlType},
+		{"text/*", htmlType},
+		{`application/activity+json;q=0.5, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`, ldJSONType},
+		{"text/html;q=0, */*", activityJSONType},
+		{"image/png", ""},
+	}
+	for _, testCase := range testCases {
+		contentType := negotiateContentType(testCase.accept, activityStreamsOffers)
+		if contentType != testCase.expected {
+			t.Fatalf("Expected '%s' for Accept '%s', but got '%s'", testCase.expected, testCase.accept, contentType)
+		}
+	}
+}
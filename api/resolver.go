@@ -1,11 +1,12 @@
 package api
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"net/url"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
@@ -42,7 +43,16 @@ func contains(entries interface{}, key string) bool {
 	return false
 }
 
-func enqueueRegisterActivity(inboxURL string, body []byte) {
+// enqueueAsync : Run enqueue in background. Shutdown waits for pending enqueues.
+func enqueueAsync(enqueue func()) {
+	pendingEnqueues.Add(1)
+	go func() {
+		defer pendingEnqueues.Done()
+		enqueue()
+	}()
+}
+
+func enqueueRegisterActivity(inboxURL string, body []byte) error {
 	job := &tasks.Signature{
 		Name:       "register",
 		RetryCount: 2,
@@ -59,13 +69,16 @@ func enqueueRegisterActivity(inboxURL string, body []byte) {
 			},
 		},
 	}
-	_, err := MachineryServer.SendTask(job)
+	err := Queue.SendTask(job)
 	if err != nil {
 		logrus.Error(err)
+		return err
 	}
+	models.EnqueuedJobs.WithLabelValues(job.Name).Inc()
+	return nil
 }
 
-func enqueueRelayActivity(inboxURL string, activityID string) {
+func enqueueRelayActivity(inboxURL string, activityID string, domains []string) {
 	job := &tasks.Signature{
 		Name:       "relay-v2",
 		RetryCount: 0,
@@ -80,87 +93,106 @@ func enqueueRelayActivity(inboxURL string, activityID string) {
 				Type:  "string",
 				Value: activityID,
 			},
+			{
+				Name:  "attempt",
+				Type:  "string",
+				Value: "0",
+			},
+			{
+				Name:  "domains",
+				Type:  "string",
+				Value: strings.Join(domains, ","),
+			},
 		},
 	}
-	_, err := MachineryServer.SendTask(job)
+	err := Queue.SendTask(job)
 	if err != nil {
 		logrus.Error(err)
+		return
 	}
+	models.EnqueuedJobs.WithLabelValues(job.Name).Inc()
 }
 
-func enqueueActivityForAll(sourceDomain string, body []byte) {
-	activityID := uuid.New()
-	remainCount := len(RelayState.SubscribersAndFollowers) - 1
+// deliveryTarget : Inbox to deliver and domains sharing it
+type deliveryTarget struct {
+	inboxURL string
+	domains  []string
+}
 
-	if remainCount < 1 {
-		return
+// groupByInbox : Group destinations sharing InboxURL in order of appearance. Inboxes used by source domain and domains not allowed to federate are excluded.
+func groupByInbox(subscriptions []models.Subscriber, sourceDomain string) []deliveryTarget {
+	sourceInboxes := make(map[string]bool)
+	for _, subscription := range subscriptions {
+		if subscription.Domain == sourceDomain {
+			sourceInboxes[subscription.InboxURL] = true
+		}
 	}
 
-	pushActivityScript := "redis.call('HSET',KEYS[1], 'body', ARGV[1], 'remain_count', ARGV[2]); redis.call('EXPIRE', KEYS[1], ARGV[3]);"
-	RelayState.RedisClient.Eval(context.TODO(), pushActivityScript, []string{"relay:activity:" + activityID.String()}, body, remainCount, 2*60).Result()
-
-	for _, subscription := range RelayState.SubscribersAndFollowers {
-		if sourceDomain == subscription.Domain {
+	var targets []deliveryTarget
+	indexes := make(map[string]int)
+	for _, subscription := range subscriptions {
+		if sourceInboxes[subscription.InboxURL] || !RelayState.IsFederationAllowed(subscription.Domain) {
 			continue
 		}
-		enqueueRelayActivity(subscription.InboxURL, activityID.String())
+		index, ok := indexes[subscription.InboxURL]
+		if !ok {
+			index = len(targets)
+			indexes[subscription.InboxURL] = index
+			targets = append(targets, deliveryTarget{inboxURL: subscription.InboxURL})
+		}
+		if !contains(targets[index].domains, subscription.Domain) {
+			targets[index].domains = append(targets[index].domains, subscription.Domain)
+		}
 	}
+	return targets
 }
 
-func enqueueActivityForSubscriber(sourceDomain string, body []byte) {
-	activityID := uuid.New()
-	remainCount := len(RelayState.Subscribers)
-	if contains(RelayState.Subscribers, sourceDomain) {
-		remainCount = remainCount - 1
-	}
+// enqueueActivityForInboxes : Store activity body and enqueue delivery once per unique inbox
+func enqueueActivityForInboxes(sourceDomain string, subscriptions []models.Subscriber, body []byte) {
+	targets := groupByInbox(subscriptions, sourceDomain)
+	remainCount := len(targets)
 	if remainCount < 1 {
 		return
 	}
+	activityID := uuid.New()
 
-	pushActivityScript := "redis.call('HSET',KEYS[1], 'body', ARGV[1], 'remain_count', ARGV[2]); redis.call('EXPIRE', KEYS[1], ARGV[3]);"
-	RelayState.RedisClient.Eval(context.TODO(), pushActivityScript, []string{"relay:activity:" + activityID.String()}, body, remainCount, 2*60).Result()
+	err := Activities.Push(activityID.String(), body, remainCount, 2*time.Minute)
+	if err != nil {
+		logrus.Error("Failed to store activity : ", err)
+		return
+	}
 
-	for _, subscription := range RelayState.Subscribers {
-		if sourceDomain == subscription.Domain {
-			continue
-		}
-		enqueueRelayActivity(subscription.InboxURL, activityID.String())
+	for _, target := range targets {
+		enqueueRelayActivity(target.inboxURL, activityID.String(), target.domains)
 	}
 }
 
-func enqueueActivityForFollower(sourceDomain string, body []byte) {
-	activityID := uuid.New()
-	remainCount := len(RelayState.Followers)
-	if contains(RelayState.Followers, sourceDomain) {
-		remainCount = remainCount - 1
-	}
-	if remainCount < 1 {
-		return
-	}
+func enqueueActivityForAll(sourceDomain string, body []byte) {
+	enqueueActivityForInboxes(sourceDomain, RelayState.SubscribersAndFollowers, body)
+}
 
-	pushActivityScript := "redis.call('HSET',KEYS[1], 'body', ARGV[1], 'remain_count', ARGV[2]); redis.call('EXPIRE', KEYS[1], ARGV[3]);"
-	RelayState.RedisClient.Eval(context.TODO(), pushActivityScript, []string{"relay:activity:" + activityID.String()}, body, remainCount, 2*60).Result()
+func enqueueActivityForSubscriber(sourceDomain string, body []byte) {
+	enqueueActivityForInboxes(sourceDomain, RelayState.Subscribers, body)
+}
 
-	for _, subscription := range RelayState.Followers {
-		if sourceDomain == subscription.Domain {
-			continue
-		}
-		enqueueRelayActivity(subscription.InboxURL, activityID.String())
+func enqueueActivityForFollower(sourceDomain string, body []byte) {
+	var followers []models.Subscriber
+	for _, follower := range RelayState.Followers {
+		followers = append(followers, models.Subscriber{Domain: follower.Domain, InboxURL: follower.InboxURL})
 	}
+	enqueueActivityForInboxes(sourceDomain, followers, body)
 }
 
 func isActorLimited(actorID *url.URL) bool {
-	if contains(RelayState.LimitedDomains, actorID.Host) {
-		return true
-	}
-	return false
+	return RelayState.IsLimitedDomain(actorID.Host)
 }
 
 func isActorBlocked(actorID *url.URL) bool {
-	if contains(RelayState.BlockedDomains, actorID.Host) {
-		return true
-	}
-	return false
+	return RelayState.IsBlockedDomain(actorID.Host)
+}
+
+func isActorAllowed(actorID *url.URL) bool {
+	return RelayState.IsFederationAllowed(actorID.Host)
 }
 
 func isActorSubscribed(actorID *url.URL) bool {
@@ -195,7 +227,7 @@ func isActorAbleToBeFollower(actor *models.Actor) bool {
 
 func isActorAbleToRelay(actor *models.Actor) bool {
 	domain, _ := url.Parse(actor.ID)
-	if contains(RelayState.LimitedDomains, domain.Host) {
+	if RelayState.IsLimitedDomain(domain.Host) {
 		return false
 	}
 	if RelayState.RelayConfig.PersonOnly && actor.Type != "Person" {
@@ -223,22 +255,28 @@ func executeFollowing(activity *models.Activity, actor *models.Actor) error {
 	if isActorBlocked(actorID) {
 		return errors.New(actorID.Host + " is blocked")
 	}
+	if !isActorAllowed(actorID) {
+		return errors.New(actorID.Host + " is not in allowlist")
+	}
+	state := RelayState.WithAudit(actor.ID, "")
 	switch {
 	case contains(activity.Object, "https://www.w3.org/ns/activitystreams#Public"):
 		if RelayState.RelayConfig.ManuallyAccept {
-			RelayState.RedisClient.HMSet(context.TODO(), "relay:pending:"+actorID.Host, map[string]interface{}{
-				"inbox_url":   actor.Endpoints.SharedInbox,
-				"activity_id": activity.ID,
-				"type":        "Follow",
-				"actor":       actor.ID,
-				"object":      activity.Object.(string),
+			state.AddPendingRequest(models.PendingRequest{
+				Domain:     actorID.Host,
+				InboxURL:   actor.Endpoints.SharedInbox,
+				ActivityID: activity.ID,
+				Type:       "Follow",
+				Actor:      actor.ID,
+				Object:     activity.Object.(string),
 			})
 			logrus.Info("Pending Follow Request : ", activity.Actor)
 		} else {
-			resp := activity.GenerateReply(RelayActor, activity, "Accept")
+			resp := activity.GenerateReply(*RelayActor.Load(), activity, "Accept")
 			jsonData, _ := json.Marshal(&resp)
-			go enqueueRegisterActivity(actor.Inbox, jsonData)
-			RelayState.AddSubscriber(models.Subscriber{
+			enqueueAsync(func() { enqueueRegisterActivity(actor.Inbox, jsonData) })
+			state.RecordAudit(models.AuditFollowAccept, actorID.Host)
+			state.AddSubscriber(models.Subscriber{
 				Domain:     actorID.Host,
 				InboxURL:   actor.Endpoints.SharedInbox,
 				ActivityID: activity.ID,
@@ -246,21 +284,22 @@ func executeFollowing(activity *models.Activity, actor *models.Actor) error {
 			})
 			logrus.Info("Accepted Follow Request : ", activity.Actor)
 		}
-	case contains(activity.Object, RelayActor.ID):
+	case contains(activity.Object, RelayActor.Load().ID):
 		if isActorAbleToBeFollower(actor) {
 			if RelayState.RelayConfig.ManuallyAccept {
-				RelayState.RedisClient.HMSet(context.TODO(), "relay:pending:"+actorID.Host, map[string]interface{}{
-					"inbox_url":   actor.Inbox,
-					"activity_id": activity.ID,
-					"type":        "Follow",
-					"actor":       actor.ID,
-					"object":      activity.Object.(string),
+				state.AddPendingRequest(models.PendingRequest{
+					Domain:     actorID.Host,
+					InboxURL:   actor.Inbox,
+					ActivityID: activity.ID,
+					Type:       "Follow",
+					Actor:      actor.ID,
+					Object:     activity.Object.(string),
 				})
 				logrus.Info("Pending Follow Request : ", activity.Actor)
 			} else {
-				resp := activity.GenerateReply(RelayActor, activity, "Accept")
+				resp := activity.GenerateReply(*RelayActor.Load(), activity, "Accept")
 				jsonData, _ := json.Marshal(&resp)
-				go enqueueRegisterActivity(actor.Inbox, jsonData)
+				enqueueAsync(func() { enqueueRegisterActivity(actor.Inbox, jsonData) })
 				follower := models.Follower{
 					Domain:         actorID.Host,
 					InboxURL:       actor.Inbox,
@@ -268,7 +307,8 @@ func executeFollowing(activity *models.Activity, actor *models.Actor) error {
 					ActorID:        actor.ID,
 					MutuallyFollow: false,
 				}
-				RelayState.AddFollower(follower)
+				state.RecordAudit(models.AuditFollowAccept, actorID.Host)
+				state.AddFollower(follower)
 				logrus.Info("Accepted Follow Request : ", activity.Actor)
 
 				executeMutuallyFollow(follower)
@@ -285,14 +325,15 @@ func executeFollowing(activity *models.Activity, actor *models.Actor) error {
 
 func executeUnfollowing(activity *models.Activity, actor *models.Actor) error {
 	actorID, _ := url.Parse(actor.ID)
+	state := RelayState.WithAudit(actor.ID, "")
 	switch {
 	case contains(activity.Object, "https://www.w3.org/ns/activitystreams#Public"):
-		RelayState.DelSubscriber(actorID.Host)
+		state.DelSubscriber(actorID.Host)
 		logrus.Info("Accepted Unfollow Request : ", activity.Actor)
 		return nil
-	case contains(activity.Object, RelayActor.ID):
+	case contains(activity.Object, RelayActor.Load().ID):
 		if isActorAbleToBeFollower(actor) {
-			RelayState.DelFollower(actorID.Host)
+			state.DelFollower(actorID.Host)
 			logrus.Info("Accepted Unfollow Request : ", activity.Actor)
 			return nil
 		}
@@ -306,9 +347,9 @@ func executeUnfollowing(activity *models.Activity, actor *models.Actor) error {
 func executeMutuallyFollow(follower models.Follower) error {
 	actorID, _ := url.Parse(follower.ActorID)
 	if !isActorLimited(actorID) {
-		followRequest := models.NewActivityPubActivity(RelayActor, []string{follower.ActorID}, follower.ActorID, "Follow")
+		followRequest := models.NewActivityPubActivity(*RelayActor.Load(), []string{follower.ActorID}, follower.ActorID, "Follow")
 		jsonData, _ := json.Marshal(&followRequest)
-		go enqueueRegisterActivity(follower.InboxURL, jsonData)
+		enqueueAsync(func() { enqueueRegisterActivity(follower.InboxURL, jsonData) })
 		logrus.Info("Sent MutuallyFollow Request : ", follower.ActorID)
 	}
 	return nil
@@ -316,16 +357,18 @@ func executeMutuallyFollow(follower models.Follower) error {
 
 func finalizeMutuallyFollow(activity *models.Activity, actor *models.Actor, activityType string) {
 	actorID, _ := url.Parse(actor.ID)
-	if contains(activity.Actor, RelayActor.ID) && contains(activity.Object, actor.ID) && isActorFollowers(actorID) {
-		RelayState.UpdateFollowerStatus(actorID.Host, activityType == "Accept")
+	if contains(activity.Actor, RelayActor.Load().ID) && contains(activity.Object, actor.ID) && isActorFollowers(actorID) {
+		RelayState.WithAudit(actor.ID, "").UpdateFollowerStatus(actorID.Host, activityType == "Accept")
 		logrus.Info("Confirmed MutuallyFollow "+activityType+"ed : ", actor.ID)
 	}
 }
 
 func executeRejectRequest(activity *models.Activity, actor *models.Actor, err error) {
-	reject := activity.GenerateReply(RelayActor, activity, "Reject")
+	reject := activity.GenerateReply(*RelayActor.Load(), activity, "Reject")
 	jsonData, _ := json.Marshal(&reject)
-	go enqueueRegisterActivity(actor.Inbox, jsonData)
+	enqueueAsync(func() { enqueueRegisterActivity(actor.Inbox, jsonData) })
+	actorID, _ := url.Parse(actor.ID)
+	RelayState.WithAudit(actor.ID, err.Error()).RecordAudit(models.AuditFollowReject, actorID.Host)
 	logrus.Error("Rejected Follow, Unfollow Request : ", activity.Actor, " ", err.Error())
 }
 
@@ -335,16 +378,35 @@ func executeRelayActivity(activity *models.Activity, actor *models.Actor, body [
 		err := errors.New("to use the relay service, please follow in advance")
 		return err
 	}
+	if !isActorAllowed(actorID) {
+		logrus.Debug("Dropped Relay Activity from Non-Allowlisted Domain : ", activity.Actor)
+		return nil
+	}
 	if isActorAbleToRelay(actor) {
-		go enqueueActivityForSubscriber(actorID.Host, body)
+		action, rule := RelayState.FilterActivity(activity)
+		if action == models.DropAction {
+			logrus.Debug("Filtered Relay Activity by Filter [", rule.ID, "] : ", activity.ID)
+			return nil
+		}
+		firstSeen := activity.ID == "" || RelayState.MarkSeen(models.SeenActivity, activity.ID, GlobalConfig.DedupeTTL())
+		if firstSeen {
+			enqueueAsync(func() { enqueueActivityForSubscriber(actorID.Host, body) })
+		} else {
+			logrus.Debug("Skipped Duplicated Relay Activity : ", activity.ID)
+		}
 
 		var innnerObjectId, err = activity.UnwrapInnerObjectId()
 		if err != nil {
 			logrus.Debug("Accepted Relay Activity (Announce Failed) : ", activity.Actor)
+		} else if action == models.DropForFollowersAction {
+			logrus.Debug("Filtered Announce Activity by Filter [", rule.ID, "] : ", innnerObjectId)
+		} else if !isFirstAnnounce(activity, innnerObjectId, firstSeen) {
+			logrus.Debug("Skipped Duplicated Announce Activity : ", innnerObjectId)
 		} else {
-			announce := models.NewActivityPubActivity(RelayActor, []string{RelayActor.Followers()}, innnerObjectId, "Announce")
+			relayActor := RelayActor.Load()
+			announce := models.NewActivityPubActivity(*relayActor, []string{relayActor.Followers()}, innnerObjectId, "Announce")
 			jsonData, _ := json.Marshal(&announce)
-			go enqueueActivityForFollower(actorID.Host, jsonData)
+			enqueueAsync(func() { enqueueActivityForFollower(actorID.Host, jsonData) })
 			logrus.Debug("Accepted Relay Activity : ", activity.Actor)
 		}
 	} else {
@@ -353,12 +415,38 @@ func executeRelayActivity(activity *models.Activity, actor *models.Actor, body [
 	return nil
 }
 
-func executeAnnounceActivity(activity *models.Activity, actor *models.Actor) error {
+// isFirstAnnounce : Whether inner object of activity is not announced yet. Object may be updated many times, so Update and Delete are deduplicated by activity ID only.
+func isFirstAnnounce(activity *models.Activity, innerObjectId string, firstSeen bool) bool {
+	switch activity.Type {
+	case "Update", "Delete":
+		return firstSeen
+	default:
+		return RelayState.MarkSeen(models.SeenObject, activity.Type+" "+innerObjectId, GlobalConfig.DedupeTTL())
+	}
+}
+
+// executeAnnounceActivity : Announce activity announced by LitePub relay to subscribers and followers. Filters apply to fetched document.
+func executeAnnounceActivity(activity *models.Activity, document map[string]interface{}, actor *models.Actor) error {
 	actorID, _ := url.Parse(actor.ID)
 	if isActorAbleToRelay(actor) {
-		announce := models.NewActivityPubActivity(RelayActor, []string{RelayActor.Followers()}, activity.ID, "Announce")
+		action, rule := RelayState.FilterAnnouncedObject(document)
+		if action == models.DropAction {
+			logrus.Debug("Filtered Announce Activity by Filter [", rule.ID, "] : ", activity.ID)
+			return nil
+		}
+		if !RelayState.MarkSeen(models.SeenObject, "Announce "+activity.ID, GlobalConfig.DedupeTTL()) {
+			logrus.Debug("Skipped Duplicated Announce Activity : ", activity.ID)
+			return nil
+		}
+		relayActor := RelayActor.Load()
+		announce := models.NewActivityPubActivity(*relayActor, []string{relayActor.Followers()}, activity.ID, "Announce")
 		jsonData, _ := json.Marshal(&announce)
-		go enqueueActivityForAll(actorID.Host, jsonData)
+		if action == models.DropForFollowersAction {
+			logrus.Debug("Filtered Announce Activity for Followers by Filter [", rule.ID, "] : ", activity.ID)
+			enqueueAsync(func() { enqueueActivityForSubscriber(actorID.Host, jsonData) })
+			return nil
+		}
+		enqueueAsync(func() { enqueueActivityForAll(actorID.Host, jsonData) })
 		logrus.Debug("Accepted Announce Activity : ", activity.Actor)
 	} else {
 		logrus.Debug("Skipped Announce Activity : ", activity.Actor)
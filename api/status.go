@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+)
+
+// relayStatus : Public information of relay. Domains and Config are included only when enabled by admin.
+type relayStatus struct {
+	Name        string          `json:"name"`
+	Summary     string          `json:"summary,omitempty"`
+	Icon        string          `json:"icon,omitempty"`
+	Actor       string          `json:"actor"`
+	Inbox       string          `json:"inbox"`
+	Version     string          `json:"version"`
+	Subscribers int             `json:"subscribers"`
+	Followers   int             `json:"followers"`
+	Domains     []string        `json:"domains,omitempty"`
+	Config      map[string]bool `json:"config,omitempty"`
+}
+
+// statusConfigLabels : Description of config flags on status page
+var statusConfigLabels = map[string]string{
+	"allowlist_only":         "Only allowed domains can join",
+	"block_service":          "Activities of service-type actors are not relayed",
+	"collection_items":       "Followers and following collections are public",
+	"instance_actor_signing": "Activities signed by instance actor are accepted",
+	"manually_accept":        "Follow requests are reviewed by admin",
+	"status_config":          "Configuration is public",
+	"status_domains":         "Participating domains are public",
+}
+
+type statusConfigRow struct {
+	Label   string
+	Enabled bool
+}
+
+var statusPageTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Status.Name}}</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; line-height: 1.5; }
+header { display: flex; align-items: center; gap: 1em; }
+header img { width: 64px; height: 64px; border-radius: 8px; }
+.summary { white-space: pre-line; }
+code { background: #eee; padding: 0.1em 0.3em; word-break: break-all; }
+</style>
+</head>
+<body>
+<header>
+{{if .Status.Icon}}<img src="{{.Status.Icon}}" alt="">{{end}}
+<h1>{{.Status.Name}}</h1>
+</header>
+{{if .Status.Summary}}<p class="summary">{{.Status.Summary}}</p>{{end}}
+<h2>Statistics</h2>
+<ul>
+<li>Subscribers: {{.Status.Subscribers}}</li>
+<li>Followers: {{.Status.Followers}}</li>
+</ul>
+<h2>How to join</h2>
+<ul>
+<li>Mastodon, Misskey and their forks: subscribe inbox <code>{{.Status.Inbox}}</code></li>
+<li>Pleroma and their forks: follow actor <code>{{.Status.Actor}}</code></li>
+</ul>
+{{if .Config}}<h2>Configuration</h2>
+<ul>
+{{range .Config}}<li>{{.Label}}: {{if .Enabled}}yes{{else}}no{{end}}</li>
+{{end}}</ul>
+{{end}}{{if .Status.Domains}}<h2>Participating domains</h2>
+<ul>
+{{range .Status.Domains}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}<footer><p>Activity-Relay {{.Status.Version}} &middot; <a href="/api/v1/relay">JSON</a></p></footer>
+</body>
+</html>
+`))
+
+func newRelayStatus() relayStatus {
+	relayActor := RelayActor.Load()
+	status := relayStatus{
+		Name:        relayActor.Name,
+		Summary:     relayActor.Summary,
+		Actor:       relayActor.ID,
+		Inbox:       relayActor.Inbox,
+		Version:     version,
+		Subscribers: len(RelayState.Subscribers),
+		Followers:   len(RelayState.Followers),
+	}
+	if relayActor.Icon != nil {
+		status.Icon = relayActor.Icon.URL
+	}
+	if RelayState.RelayConfig.StatusDomains {
+		status.Domains = RelayState.MemberDomains()
+	}
+	if RelayState.RelayConfig.StatusConfig {
+		status.Config = RelayState.ConfigFlags()
+	}
+	return status
+}
+
+func handleRelayStatus(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		writer.WriteHeader(400)
+		writer.Write(nil)
+		return
+	}
+	jsonData, err := json.Marshal(newRelayStatus())
+	if err != nil {
+		logrus.Error("Failed to marshal relay status : ", err.Error())
+		writer.WriteHeader(500)
+		writer.Write(nil)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(jsonData)
+}
+
+func handleStatusPage(writer http.ResponseWriter, request *http.Request) {
+	// "/" pattern matches every unregistered path
+	if request.URL.Path != "/" {
+		writer.WriteHeader(404)
+		writer.Write(nil)
+		return
+	}
+	if request.Method != "GET" {
+		writer.WriteHeader(400)
+		writer.Write(nil)
+		return
+	}
+
+	status := newRelayStatus()
+	var rows []statusConfigRow
+	var names []string
+	for name := range status.Config {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		label, ok := statusConfigLabels[name]
+		if !ok {
+			label = name
+		}
+		rows = append(rows, statusConfigRow{label, status.Config[name]})
+	}
+
+	writer.Header().Add("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(200)
+	err := statusPageTemplate.Execute(writer, struct {
+		Status relayStatus
+		Config []statusConfigRow
+	}{status, rows})
+	if err != nil {
+		logrus.Error("Failed to render status page : ", err.Error())
+	}
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yukimochi/Activity-Relay/models"
+)
+
+func TestHandleRelayStatus(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	RelayState.AddSubscriber(models.Subscriber{
+		Domain:   "subscription.example.jp",
+		InboxURL: "https://subscription.example.jp/inbox",
+	})
+	RelayState.SetConfig(ManuallyAccept, true)
+	defer RelayState.SetConfig(ManuallyAccept, false)
+
+	s := httptest.NewServer(http.HandlerFunc(handleRelayStatus))
+	defer s.Close()
+
+	t.Run("Hide domains and config by default", func(t *testing.T) {
+		r, err := http.Get(s.URL)
+		if err != nil {
+			t.Fatalf("Expected request to succeed, but got error: %v", err)
+		}
+		defer r.Body.Close()
+		var status relayStatus
+		json.NewDecoder(r.Body).Decode(&status)
+		if status.Name != RelayActor.Load().Name || status.Subscribers != 1 || status.Domains != nil || status.Config != nil {
+			t.Fatalf("Expected counts without domains and config, but got %+v", status)
+		}
+	})
+
+	t.Run("Show domains and config when enabled", func(t *testing.T) {
+		RelayState.SetConfig(StatusDomains, true)
+		RelayState.SetConfig(StatusConfig, true)
+		defer RelayState.SetConfig(StatusDomains, false)
+		defer RelayState.SetConfig(StatusConfig, false)
+
+		r, err := http.Get(s.URL)
+		if err != nil {
+			t.Fatalf("Expected request to succeed, but got error: %v", err)
+		}
+		defer r.Body.Close()
+		var status relayStatus
+		json.NewDecoder(r.Body).Decode(&status)
+		if len(status.Domains) != 1 || status.Domains[0] != "subscription.example.jp" || !status.Config["manually_accept"] {
+			t.Fatalf("Expected domains and config to be listed, but got %+v", status)
+		}
+	})
+}
+
+func TestHandleStatusPage(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	RelayState.AddSubscriber(models.Subscriber{
+		Domain:   "subscription.example.jp",
+		InboxURL: "https://subscription.example.jp/inbox",
+	})
+	RelayState.SetConfig(StatusDomains, true)
+	defer RelayState.SetConfig(StatusDomains, false)
+
+	s := httptest.NewServer(http.HandlerFunc(handleStatusPage))
+	defer s.Close()
+
+	t.Run("Render status page", func(t *testing.T) {
+		r, err := http.Get(s.URL)
+		if err != nil {
+			t.Fatalf("Expected request to succeed, but got error: %v", err)
+		}
+		defer r.Body.Close()
+		if r.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+			t.Fatalf("Expected Content-Type to be 'text/html; charset=utf-8', but got '%s'", r.Header.Get("Content-Type"))
+		}
+		data, _ := io.ReadAll(r.Body)
+		page := string(data)
+		if !strings.Contains(page, "<li>subscription.example.jp</li>") || !strings.Contains(page, "<code>"+RelayActor.Load().Inbox+"</code>") {
+			t.Fatalf("Expected page to list domains and inbox, but got '%s'", page)
+		}
+		if strings.Contains(page, "<script") || strings.Contains(page, "Configuration") {
+			t.Fatalf("Expected page without script and configuration, but got '%s'", page)
+		}
+	})
+
+	t.Run("Return 404 for other paths", func(t *testing.T) {
+		r, err := http.Get(s.URL + "/unknown")
+		if err != nil {
+			t.Fatalf("Expected request to succeed, but got error: %v", err)
+		}
+		defer r.Body.Close()
+		if r.StatusCode != 404 {
+			t.Fatalf("Expected StatusCode to be 404, but got %d", r.StatusCode)
+		}
+	})
+}
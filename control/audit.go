@@ -0,0 +1,88 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yukimochi/Activity-Relay/models"
+)
+
+func auditCmdInit() *cobra.Command {
+	var audit = &cobra.Command{
+		Use:   "audit [flags]",
+		Short: "Show audit log",
+		Long:  "Show latest audit log events of moderation and subscription changes. Use --follow to wait for new events.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(listAuditEvents, cmd, args)
+		},
+	}
+	audit.Flags().String("actor", "", "Show events by actor (e.g. cli:root, token:dashboard, https://example.com/actor)")
+	audit.Flags().String("action", "", "Show events of action, or actions starting with prefix ending with dot (e.g. domain.)")
+	audit.Flags().String("target", "", "Show events whose target contains text")
+	audit.Flags().Duration("since", 0, "Show events within duration (e.g. 24h)")
+	audit.Flags().IntP("limit", "n", 20, "Number of latest events to show (0 for all)")
+	audit.Flags().BoolP("follow", "f", false, "Wait for new events until interrupted")
+
+	return audit
+}
+
+func auditFilterFromFlags(cmd *cobra.Command) models.AuditFilter {
+	filter := models.AuditFilter{
+		Actor:  cmd.Flag("actor").Value.String(),
+		Action: cmd.Flag("action").Value.String(),
+		Target: cmd.Flag("target").Value.String(),
+	}
+	since, _ := time.ParseDuration(cmd.Flag("since").Value.String())
+	if since > 0 {
+		filter.Since = time.Now().Add(-since)
+	}
+	return filter
+}
+
+func listAuditEvents(cmd *cobra.Command, _ []string) error {
+	filter := auditFilterFromFlags(cmd)
+	limit, _ := strconv.Atoi(cmd.Flag("limit").Value.String())
+	events, err := RelayState.AuditEvents(filter, limit)
+	if err != nil {
+		cmd.Println("Failed to read audit log: " + err.Error())
+		return nil
+	}
+	for _, event := range events {
+		cmd.Println(event.String())
+	}
+
+	follow, _ := strconv.ParseBool(cmd.Flag("follow").Value.String())
+	if !follow {
+		cmd.Println(fmt.Sprintf("Total: %d", len(events)))
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	lastID := "$"
+	if len(events) > 0 {
+		lastID = events[len(events)-1].ID
+	}
+	for ctx.Err() == nil {
+		events, err = RelayState.ReadAuditEvents(ctx, lastID, 5*time.Second)
+		if err != nil {
+			if ctx.Err() == nil {
+				cmd.Println("Failed to read audit log: " + err.Error())
+			}
+			return nil
+		}
+		for _, event := range events {
+			lastID = event.ID
+			if filter.Match(event) {
+				cmd.Println(event.String())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestListAuditEvents(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := domainCmdInit()
+	app.SetArgs([]string{"set", "-t", "blocked", "spam.example.com"})
+	app.Execute()
+	app = configCmdInit()
+	app.SetArgs([]string{"enable", "manually-accept"})
+	app.Execute()
+
+	buffer := new(bytes.Buffer)
+
+	app = auditCmdInit()
+	app.SetOut(buffer)
+	app.SetArgs([]string{"--action", "domain."})
+	app.Execute()
+
+	output := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(output) != 2 {
+		t.Fatalf("Expected 1 event and total, but got '%s'", buffer.String())
+	}
+	if !strings.HasSuffix(output[0], "domain.set blocked spam.example.com") {
+		t.Fatalf("Expected blocked domain event, but got '%s'", output[0])
+	}
+	if output[1] != "Total: 1" {
+		t.Fatalf("Expected total to be 'Total: 1', but got '%s'", output[1])
+	}
+}
+
+func TestListAuditEventsLimit(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	for _, domain := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		app := domainCmdInit()
+		app.SetArgs([]string{"set", "-t", "limited", domain})
+		app.Execute()
+	}
+
+	buffer := new(bytes.Buffer)
+
+	app := auditCmdInit()
+	app.SetOut(buffer)
+	app.SetArgs([]string{"-n", "2"})
+	app.Execute()
+
+	output := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(output) != 3 || !strings.HasSuffix(output[0], "b.example.com") || !strings.HasSuffix(output[1], "c.example.com") {
+		t.Fatalf("Expected latest 2 events in chronological order, but got '%s'", buffer.String())
+	}
+}
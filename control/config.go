@@ -2,8 +2,12 @@ package control
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
 
-	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/yukimochi/Activity-Relay/models"
 )
@@ -11,6 +15,11 @@ import (
 const (
 	PersonOnly models.Config = iota
 	ManuallyAccept
+	InstanceActorSigning
+	AllowlistOnly
+	CollectionItems
+	StatusDomains
+	StatusConfig
 )
 
 func configCmdInit() *cobra.Command {
@@ -31,25 +40,32 @@ func configCmdInit() *cobra.Command {
 	config.AddCommand(configList)
 
 	var configExport = &cobra.Command{
-		Use:   "export",
+		Use:   "export [flags]",
 		Short: "Export all relay information",
-		Long:  "Export all relay information by JSON format.",
-		Run: func(cmd *cobra.Command, args []string) {
-			InitProxy(exportConfig, cmd, args)
+		Long:  "Export versioned snapshot of config flags, domain lists, filters, rate limits, subscribers, followers and pending follow requests by JSON format.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(exportConfig, cmd, args)
 		},
 	}
+	configExport.Flags().StringP("file", "f", "", "Write snapshot to file instead of stdout")
 	config.AddCommand(configExport)
 
 	var configImport = &cobra.Command{
 		Use:   "import [flags]",
 		Short: "Import all relay information",
-		Long:  "Import all relay information from JSON.",
-		Run: func(cmd *cobra.Command, args []string) {
-			InitProxy(importConfig, cmd, args)
+		Long: `Import snapshot exported by config export from file or stdin.
+ - merge
+	Add and update entries in snapshot, keep other entries.
+ - replace
+	Make relay information identical to snapshot. Entries not in snapshot are removed without notifying them.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(importConfig, cmd, args)
 		},
 	}
-	configImport.Flags().String("data", "", "JSON String")
-	configImport.MarkFlagRequired("data")
+	configImport.Flags().StringP("file", "f", "", "Read snapshot from file (default: stdin)")
+	configImport.Flags().String("data", "", "Snapshot JSON String")
+	configImport.Flags().StringP("mode", "m", string(models.MergeImport), "Import mode [merge,replace]")
+	configImport.Flags().Bool("dry-run", false, "Show changes without applying")
 	config.AddCommand(configImport)
 
 	var configEnable = &cobra.Command{
@@ -59,7 +75,17 @@ func configCmdInit() *cobra.Command {
  - person-only
 	Blocking feature for service-type actor.
  - manually-accept
-	Enable manually accept follow request.`,
+	Enable manually accept follow request.
+ - instance-actor-signing
+	Accept activities signed by another actor on the same host (e.g. instance actor).
+ - allowlist-only
+	Accept follow requests and activities only from allowed domains.
+ - collection-items
+	List actors in followers/following collections of relay actor, instead of totalItems only.
+ - status-domains
+	List subscribed domains on public status page and /api/v1/relay.
+ - status-config
+	Show relay configuration on public status page and /api/v1/relay.`,
 		Args: cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return InitProxyE(configEnable, cmd, args)
@@ -74,7 +100,17 @@ func configCmdInit() *cobra.Command {
  - person-only
 	Blocking feature for service-type actor.
  - manually-accept
-	Enable manually accept follow request.`,
+	Enable manually accept follow request.
+ - instance-actor-signing
+	Accept activities signed by another actor on the same host (e.g. instance actor).
+ - allowlist-only
+	Accept follow requests and activities only from allowed domains.
+ - collection-items
+	List actors in followers/following collections of relay actor, instead of totalItems only.
+ - status-domains
+	List subscribed domains on public status page and /api/v1/relay.
+ - status-config
+	Show relay configuration on public status page and /api/v1/relay.`,
 		Args: cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return InitProxyE(configDisable, cmd, args)
@@ -99,6 +135,21 @@ func editConfig(key string, value bool) string {
 	case "manually-accept":
 		RelayState.SetConfig(ManuallyAccept, value)
 		return "Manual follow request acceptance is " + statement + "."
+	case "instance-actor-signing":
+		RelayState.SetConfig(InstanceActorSigning, value)
+		return "Same-origin instance actor signing is " + statement + "."
+	case "allowlist-only":
+		RelayState.SetConfig(AllowlistOnly, value)
+		return "Allowlist-only federation is " + statement + "."
+	case "collection-items":
+		RelayState.SetConfig(CollectionItems, value)
+		return "Listing items of followers/following collections is " + statement + "."
+	case "status-domains":
+		RelayState.SetConfig(StatusDomains, value)
+		return "Listing domains on status page is " + statement + "."
+	case "status-config":
+		RelayState.SetConfig(StatusConfig, value)
+		return "Showing configuration on status page is " + statement + "."
 	}
 	return "Invalid configuration provided: " + key
 }
@@ -122,45 +173,79 @@ func configDisable(cmd *cobra.Command, args []string) error {
 func listConfig(cmd *cobra.Command, _ []string) {
 	cmd.Println("Person-Type Actor limitation:", RelayState.RelayConfig.PersonOnly)
 	cmd.Println("Manual follow request acceptance:", RelayState.RelayConfig.ManuallyAccept)
+	cmd.Println("Same-origin instance actor signing:", RelayState.RelayConfig.InstanceActorSigning)
+	cmd.Println("Allowlist-only federation:", RelayState.RelayConfig.AllowlistOnly)
+	cmd.Println("Followers/following collection items:", RelayState.RelayConfig.CollectionItems)
+	cmd.Println("Domains on status page:", RelayState.RelayConfig.StatusDomains)
+	cmd.Println("Configuration on status page:", RelayState.RelayConfig.StatusConfig)
 }
 
-func exportConfig(cmd *cobra.Command, _ []string) {
-	jsonData, _ := json.Marshal(&RelayState)
-	cmd.Println(string(jsonData))
-}
-
-func importConfig(cmd *cobra.Command, _ []string) {
-	jsonData := cmd.Flag("data").Value.String()
-	var data models.RelayState
-	err := json.Unmarshal([]byte(jsonData), &data)
+func exportConfig(cmd *cobra.Command, _ []string) error {
+	snapshot, err := RelayState.Export()
 	if err != nil {
-		logrus.Error(err)
-		return
+		cmd.Println("Failed to export config: " + err.Error())
+		return nil
 	}
+	jsonData, _ := json.MarshalIndent(snapshot, "", "  ")
 
-	if data.RelayConfig.PersonOnly {
-		RelayState.SetConfig(PersonOnly, true)
-		cmd.Println("Person-Type Actor limitation is enabled.")
+	path := cmd.Flag("file").Value.String()
+	if path == "" {
+		cmd.Println(string(jsonData))
+		return nil
 	}
-	if data.RelayConfig.ManuallyAccept {
-		RelayState.SetConfig(ManuallyAccept, true)
-		cmd.Println("Manual follow request acceptance is enabled.")
-	}
-	for _, LimitedDomain := range data.LimitedDomains {
-		RelayState.SetLimitedDomain(LimitedDomain, true)
-		cmd.Println("Set [" + LimitedDomain + "] as limited domain")
+	err = os.WriteFile(path, append(jsonData, '\n'), 0600)
+	if err != nil {
+		cmd.Println("Failed to write snapshot: " + err.Error())
+		return nil
 	}
-	for _, BlockedDomain := range data.BlockedDomains {
-		RelayState.SetBlockedDomain(BlockedDomain, true)
-		cmd.Println("Set [" + BlockedDomain + "] as blocked domain")
+	cmd.Println("Exported to " + path)
+	return nil
+}
+
+func importConfig(cmd *cobra.Command, _ []string) error {
+	var reader io.Reader = cmd.InOrStdin()
+	if jsonData := cmd.Flag("data").Value.String(); jsonData != "" {
+		reader = strings.NewReader(jsonData)
+	} else if path := cmd.Flag("file").Value.String(); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			cmd.Println("Failed to open snapshot: " + err.Error())
+			return nil
+		}
+		defer file.Close()
+		reader = file
+	}
+	snapshot, err := models.ParseSnapshot(reader)
+	if err != nil {
+		cmd.Println("Invalid snapshot provided: " + err.Error())
+		return nil
+	}
+	mode := models.ImportMode(cmd.Flag("mode").Value.String())
+
+	dryRun, _ := strconv.ParseBool(cmd.Flag("dry-run").Value.String())
+	if dryRun {
+		changes, err := RelayState.DiffSnapshot(snapshot, mode)
+		if err != nil {
+			cmd.Println("Failed to import snapshot: " + err.Error())
+			return nil
+		}
+		cmd.Println(" - Changes to be applied:")
+		printSnapshotChanges(cmd, changes)
+		return nil
+	}
+
+	changes, unfollowed, err := RelayState.ImportSnapshot(snapshot, mode, &RelayActor, enqueueRegisterActivity)
+	printSnapshotChanges(cmd, changes)
+	printUnfollowed(cmd, unfollowed)
+	if err != nil {
+		cmd.Println("Failed to import snapshot: " + err.Error())
 	}
-	for _, Subscription := range data.Subscribers {
-		RelayState.AddSubscriber(models.Subscriber{
-			Domain:     Subscription.Domain,
-			InboxURL:   Subscription.InboxURL,
-			ActivityID: Subscription.ActivityID,
-			ActorID:    Subscription.ActorID,
-		})
-		cmd.Println("Register [" + Subscription.Domain + "] as subscriber")
+	return nil
+}
+
+func printSnapshotChanges(cmd *cobra.Command, changes []models.SnapshotChange) {
+	for _, change := range changes {
+		cmd.Println(change.String())
 	}
+	cmd.Println(fmt.Sprintf("Total: %d", len(changes)))
 }
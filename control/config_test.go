@@ -7,6 +7,8 @@ import (
 	"os"
 	"strings"
 	"testing"
+
+	"github.com/yukimochi/Activity-Relay/models"
 )
 
 func TestPersonOnlyConfiguration(t *testing.T) {
@@ -57,6 +59,73 @@ func TestManuallyAcceptConfiguration(t *testing.T) {
 	})
 }
 
+func TestInstanceActorSigningConfiguration(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := configCmdInit()
+
+	t.Run("Enable instance-actor-signing configuration", func(t *testing.T) {
+		app.SetArgs([]string{"enable", "instance-actor-signing"})
+		app.Execute()
+		RelayState.Load()
+		if !RelayState.RelayConfig.InstanceActorSigning {
+			t.Fatalf("Expected InstanceActorSigning to be enabled, but it was not")
+		}
+	})
+
+	t.Run("Disable instance-actor-signing configuration", func(t *testing.T) {
+		app.SetArgs([]string{"disable", "instance-actor-signing"})
+		app.Execute()
+		RelayState.Load()
+		if RelayState.RelayConfig.InstanceActorSigning {
+			t.Fatalf("Expected InstanceActorSigning to be disabled, but it was not")
+		}
+	})
+}
+
+func TestAllowlistOnlyConfiguration(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := configCmdInit()
+
+	t.Run("Enable allowlist-only configuration", func(t *testing.T) {
+		app.SetArgs([]string{"enable", "allowlist-only"})
+		app.Execute()
+		RelayState.Load()
+		if !RelayState.RelayConfig.AllowlistOnly {
+			t.Fatalf("Expected AllowlistOnly to be enabled, but it was not")
+		}
+	})
+
+	t.Run("Disable allowlist-only configuration", func(t *testing.T) {
+		app.SetArgs([]string{"disable", "allowlist-only"})
+		app.Execute()
+		RelayState.Load()
+		if RelayState.RelayConfig.AllowlistOnly {
+			t.Fatalf("Expected AllowlistOnly to be disabled, but it was not")
+		}
+	})
+}
+
+func TestCollectionItemsConfiguration(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := configCmdInit()
+	buffer := new(bytes.Buffer)
+	app.SetOut(buffer)
+
+	app.SetArgs([]string{"enable", "collection-items"})
+	app.Execute()
+	RelayState.Load()
+	if !RelayState.RelayConfig.CollectionItems {
+		t.Fatalf("Expected CollectionItems to be enabled, but it was not")
+	}
+	if buffer.String() != "Listing items of followers/following collections is enabled.\n" {
+		t.Fatalf("Expected output to report enabled config, but got '%s'", buffer.String())
+	}
+	RelayState.SetConfig(CollectionItems, false)
+}
+
 func TestInvalidConfig(t *testing.T) {
 	RelayState.RedisClient.FlushAll(context.TODO()).Result()
 
@@ -114,14 +183,38 @@ func TestExportConfig(t *testing.T) {
 	}
 	jsonData, _ := io.ReadAll(file)
 	output := buffer.String()
-	if strings.Split(output, "\n")[0] != string(jsonData) {
-		t.Fatalf("Expected exported config to be '%s', but got '%s'", string(jsonData), strings.Split(output, "\n")[0])
+	if strings.TrimSpace(output) != strings.TrimSpace(string(jsonData)) {
+		t.Fatalf("Expected exported config to be '%s', but got '%s'", string(jsonData), output)
 	}
 }
 
 func TestImportConfig(t *testing.T) {
 	RelayState.RedisClient.FlushAll(context.TODO()).Result()
 
+	app := configCmdInit()
+	app.SetArgs([]string{"import", "--file", "../misc/test/exampleSnapshot.json"})
+	app.Execute()
+	RelayState.Load()
+
+	jsonData, err := os.ReadFile("../misc/test/exampleSnapshot.json")
+	if err != nil {
+		t.Fatalf("Failed to open test resource file: %v", err)
+	}
+	path := t.TempDir() + "/snapshot.json"
+	app = configCmdInit()
+	app.SetArgs([]string{"export", "--file", path})
+	app.Execute()
+
+	output, _ := os.ReadFile(path)
+	if string(output) != string(jsonData) {
+		t.Fatalf("Expected exported config to be '%s', but got '%s'", string(jsonData), string(output))
+	}
+}
+
+func TestImportLegacyConfig(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	RelayState.SetConfig(InstanceActorSigning, true)
+
 	app := configCmdInit()
 	file, err := os.Open("../misc/test/exampleConfig.json")
 	if err != nil {
@@ -133,14 +226,60 @@ func TestImportConfig(t *testing.T) {
 	app.Execute()
 	RelayState.Load()
 
+	if !RelayState.RelayConfig.PersonOnly || !RelayState.RelayConfig.ManuallyAccept {
+		t.Fatalf("Expected enabled flags to be imported, but got %+v", RelayState.RelayConfig)
+	}
+	if !RelayState.RelayConfig.InstanceActorSigning {
+		t.Fatalf("Expected flags not listed in legacy config to be kept, but InstanceActorSigning was disabled")
+	}
+	if len(RelayState.Subscribers) != 1 || len(RelayState.LimitedDomains) != 1 || len(RelayState.BlockedDomains) != 1 {
+		t.Fatalf("Expected subscribers and domains to be imported, but got %+v", RelayState)
+	}
+}
+
+func TestImportConfigReplace(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	RelayState.AddSubscriber(models.Subscriber{
+		Domain:     "old.example.jp",
+		InboxURL:   "https://old.example.jp/inbox",
+		ActivityID: "https://old.example.jp/UUID",
+		ActorID:    "https://old.example.jp/actor",
+	})
+	RelayState.SetBlockedDomain("old.example.jp", true)
+	RelayState.SetConfig(AllowlistOnly, true)
+
+	app := configCmdInit()
 	buffer := new(bytes.Buffer)
 	app.SetOut(buffer)
-
-	app.SetArgs([]string{"export"})
+	app.SetArgs([]string{"import", "--file", "../misc/test/exampleSnapshot.json", "--mode", "replace", "--dry-run"})
 	app.Execute()
+	RelayState.Load()
 
 	output := buffer.String()
-	if strings.Split(output, "\n")[0] != string(jsonData) {
-		t.Fatalf("Expected exported config to be '%s', but got '%s'", string(jsonData), strings.Split(output, "\n")[0])
+	for _, expected := range []string{"~ config allowlist_only=false", "- blocked old.example.jp", "- subscriber old.example.jp", "+ follower follower.example.jp", "+ pending pending.example.jp"} {
+		if !strings.Contains(output, expected+"\n") {
+			t.Fatalf("Expected dry-run output to contain '%s', but got '%s'", expected, output)
+		}
+	}
+	if RelayState.SelectSubscriber("old.example.jp") == nil || !RelayState.RelayConfig.AllowlistOnly {
+		t.Fatalf("Expected dry-run not to change state, but changed")
+	}
+
+	app = configCmdInit()
+	app.SetArgs([]string{"import", "--file", "../misc/test/exampleSnapshot.json", "--mode", "replace"})
+	app.Execute()
+	RelayState.Load()
+
+	if RelayState.SelectSubscriber("old.example.jp") != nil || len(RelayState.BlockedDomains) != 1 || RelayState.RelayConfig.AllowlistOnly {
+		t.Fatalf("Expected entries not in snapshot to be removed, but got %+v", RelayState)
+	}
+	follower := RelayState.SelectFollower("follower.example.jp")
+	if follower == nil || !follower.MutuallyFollow {
+		t.Fatalf("Expected follower to be imported with MutuallyFollow, but got %v", follower)
+	}
+	requests, _ := RelayState.PendingRequests()
+	if len(requests) != 1 || requests[0].Domain != "pending.example.jp" {
+		t.Fatalf("Expected pending request to be imported, but got %v", requests)
 	}
 }
@@ -2,12 +2,12 @@ package control
 
 import (
 	"os"
+	"os/user"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/yukimochi/Activity-Relay/models"
-	"github.com/yukimochi/machinery-v1/v1"
 )
 
 var (
@@ -19,14 +19,21 @@ var (
 	// RelayActor : Relay's Actor
 	RelayActor models.Actor
 
-	MachineryServer *machinery.Server
-	RelayState      models.RelayState
+	// Queue : Queue of delivery tasks
+	Queue      models.JobQueue
+	RelayState models.RelayState
 )
 
 func BuildCommand(command *cobra.Command) {
 	command.AddCommand(configCmdInit())
 	command.AddCommand(domainCmdInit())
 	command.AddCommand(followCmdInit())
+	command.AddCommand(filterCmdInit())
+	command.AddCommand(rateLimitCmdInit())
+	command.AddCommand(tokenCmdInit())
+	command.AddCommand(auditCmdInit())
+	command.AddCommand(keyCmdInit())
+	command.PersistentFlags().String("reason", "", "Reason recorded in audit log")
 }
 
 func initializeProxy(function func(cmd *cobra.Command, args []string), cmd *cobra.Command, args []string) {
@@ -53,6 +60,7 @@ func initConfig(cmd *cobra.Command) error {
 		logrus.Warn("Config file not found. Using environment variables.")
 
 		viper.BindEnv("ACTOR_PEM")
+		viper.BindEnv("ACTOR_KEY")
 		viper.BindEnv("REDIS_URL")
 		viper.BindEnv("RELAY_BIND")
 		viper.BindEnv("RELAY_DOMAIN")
@@ -61,6 +69,16 @@ func initConfig(cmd *cobra.Command) error {
 		viper.BindEnv("RELAY_SUMMARY")
 		viper.BindEnv("RELAY_ICON")
 		viper.BindEnv("RELAY_IMAGE")
+		viper.BindEnv("JOB_RETRY_COUNT")
+		viper.BindEnv("JOB_RETRY_INTERVAL")
+		viper.BindEnv("JOB_RETRY_MAX_INTERVAL")
+		viper.BindEnv("METRICS_BIND")
+		viper.BindEnv("SIGNATURE_CLOCK_SKEW")
+		viper.BindEnv("ACTIVITY_DEDUPE_TTL")
+		viper.BindEnv("SHUTDOWN_TIMEOUT")
+		viper.BindEnv("STATE_STORAGE")
+		viper.BindEnv("SQLITE_PATH")
+		viper.BindEnv("BLOCKLIST_SYNC_INTERVAL")
 	}
 
 	GlobalConfig, err = models.NewRelayConfig()
@@ -69,21 +87,43 @@ func initConfig(cmd *cobra.Command) error {
 	}
 
 	initialize()
+	RelayState.AuditActor = "cli:" + currentUsername()
+	if flag := cmd.Flag("reason"); flag != nil {
+		RelayState.AuditReason = flag.Value.String()
+	}
 
 	return nil
 }
 
+func currentUsername() string {
+	current, err := user.Current()
+	if err != nil {
+		return os.Getenv("USER")
+	}
+	return current.Username
+}
+
 func initialize() error {
 	var err error
 
 	redisClient := GlobalConfig.RedisClient()
-	RelayState = models.NewState(redisClient, true)
-	RelayState.ListenNotify(nil)
-
-	MachineryServer, err = models.NewMachineryServer(GlobalConfig)
+	storage, err := GlobalConfig.NewStorage()
 	if err != nil {
 		return err
 	}
+	RelayState = models.NewStateWithStorage(storage, redisClient, true)
+	RelayState.ListenNotify(nil)
+
+	if redisClient == nil {
+		logrus.Warn("REDIS_URL: EMPTY. COMMANDS SENDING ACTIVITIES FAIL AND RUNNING RELAY LOADS CHANGES ON RESTART.")
+		Queue = models.DisabledQueue{}
+	} else {
+		machineryServer, err := models.NewMachineryServer(GlobalConfig)
+		if err != nil {
+			return err
+		}
+		Queue = models.NewMachineryQueue(machineryServer)
+	}
 
 	RelayActor = models.NewActivityPubActorFromRelayConfig(GlobalConfig)
 
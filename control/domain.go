@@ -1,8 +1,12 @@
 package control
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/yukimochi/Activity-Relay/models"
@@ -12,7 +16,7 @@ func domainCmdInit() *cobra.Command {
 	var domain = &cobra.Command{
 		Use:   "domain",
 		Short: "Manage subscriber domains",
-		Long:  "List all subscribers, set/unset domains as limited or blocked and unfollow domains.",
+		Long:  "List all subscribers, set/unset domains as limited, blocked or allowed and unfollow domains.",
 	}
 
 	var domainList = &cobra.Command{
@@ -23,32 +27,32 @@ func domainCmdInit() *cobra.Command {
 			return InitProxyE(listDomains, cmd, args)
 		},
 	}
-	domainList.Flags().StringP("type", "t", "subscriber", "domain type [subscriber,limited,blocked]")
+	domainList.Flags().StringP("type", "t", "subscriber", "domain type [subscriber,limited,blocked,allowed]")
 	domain.AddCommand(domainList)
 
 	var domainSet = &cobra.Command{
 		Use:   "set [flags]",
-		Short: "Set domains as limited or blocked",
-		Long:  "Set domains as limited or blocked.",
+		Short: "Set domains as limited, blocked or allowed",
+		Long:  "Set domains as limited, blocked or allowed. Use *.example.com for subdomains, or .example.com for domain and subdomains. Existing subscribers matching blocked domains are unfollowed. Allowed domains take effect when allowlist-only config is enabled.",
 		Args:  cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return InitProxyE(setDomainType, cmd, args)
 		},
 	}
-	domainSet.Flags().StringP("type", "t", "", "Apply domain type [limited,blocked]")
+	domainSet.Flags().StringP("type", "t", "", "Apply domain type [limited,blocked,allowed]")
 	domainSet.MarkFlagRequired("type")
 	domain.AddCommand(domainSet)
 
 	var domainUnset = &cobra.Command{
 		Use:   "unset [flags]",
-		Short: "Unset domains as limited or blocked",
-		Long:  "Unset domains as limited or blocked.",
+		Short: "Unset domains as limited, blocked or allowed",
+		Long:  "Unset domains as limited, blocked or allowed.",
 		Args:  cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return InitProxyE(unsetDomainType, cmd, args)
 		},
 	}
-	domainUnset.Flags().StringP("type", "t", "", "Apply domain type [limited,blocked]")
+	domainUnset.Flags().StringP("type", "t", "", "Apply domain type [limited,blocked,allowed]")
 	domainUnset.MarkFlagRequired("type")
 	domain.AddCommand(domainUnset)
 
@@ -62,39 +66,75 @@ func domainCmdInit() *cobra.Command {
 	}
 	domain.AddCommand(domainUnfollow)
 
+	var domainImport = &cobra.Command{
+		Use:   "import [flags] FILE",
+		Short: "Import blocked and limited domains",
+		Long:  "Import blocked and limited domains from blocklist file. Use - to read from stdin. Only domains not yet listed are added.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(importDomains, cmd, args)
+		},
+	}
+	domainImport.Flags().StringP("format", "f", models.MastodonCSVFormat, "Blocklist format [mastodon-csv,plain]")
+	domainImport.Flags().Bool("dry-run", false, "Show domains to be added without applying")
+	domain.AddCommand(domainImport)
+
+	domain.AddCommand(domainSourceCmdInit())
+
 	return domain
 }
 
-func createUnfollowToSubscriberRequest(subscriber models.Subscriber) error {
-	activity := models.Activity{
-		Context: []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
-		ID:      subscriber.ActivityID,
-		Actor:   subscriber.ActorID,
-		Type:    "Follow",
-		Object:  "https://www.w3.org/ns/activitystreams#Public",
+func domainSourceCmdInit() *cobra.Command {
+	var source = &cobra.Command{
+		Use:   "source",
+		Short: "Manage blocklist sources",
+		Long:  "List, add, remove and sync blocklist sources. Job Worker syncs them every BLOCKLIST_SYNC_INTERVAL seconds.",
 	}
 
-	resp := activity.GenerateReply(RelayActor, activity, "Reject")
-	jsonData, _ := json.Marshal(&resp)
-	enqueueRegisterActivity(subscriber.InboxURL, jsonData)
+	var sourceList = &cobra.Command{
+		Use:   "list",
+		Short: "List blocklist sources",
+		Long:  "List blocklist sources with last sync result.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(listBlocklistSources, cmd, args)
+		},
+	}
+	source.AddCommand(sourceList)
 
-	return nil
-}
+	var sourceAdd = &cobra.Command{
+		Use:   "add [flags] NAME LOCATION",
+		Short: "Add blocklist source",
+		Long:  "Add blocklist source from local file or URL. Entries are added on next sync.",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(addBlocklistSource, cmd, args)
+		},
+	}
+	sourceAdd.Flags().StringP("format", "f", models.MastodonCSVFormat, "Blocklist format [mastodon-csv,plain]")
+	source.AddCommand(sourceAdd)
 
-func createUnfollowToFollowerRequest(follower models.Follower) error {
-	activity := models.Activity{
-		Context: []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
-		ID:      follower.ActivityID,
-		Actor:   follower.ActorID,
-		Type:    "Follow",
-		Object:  RelayActor.ID,
+	var sourceRemove = &cobra.Command{
+		Use:   "remove NAME",
+		Short: "Remove blocklist sources",
+		Long:  "Remove blocklist sources and domains provided only by them.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(removeBlocklistSources, cmd, args)
+		},
 	}
+	source.AddCommand(sourceRemove)
 
-	resp := activity.GenerateReply(RelayActor, activity, "Reject")
-	jsonData, _ := json.Marshal(&resp)
-	enqueueRegisterActivity(follower.InboxURL, jsonData)
+	var sourceSync = &cobra.Command{
+		Use:   "sync [NAME...]",
+		Short: "Sync blocklist sources now",
+		Long:  "Sync provided blocklist sources, or all blocklist sources without arguments.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(syncBlocklistSources, cmd, args)
+		},
+	}
+	source.AddCommand(sourceSync)
 
-	return nil
+	return source
 }
 
 func listDomains(cmd *cobra.Command, _ []string) error {
@@ -104,13 +144,19 @@ func listDomains(cmd *cobra.Command, _ []string) error {
 		cmd.Println(" - Limited domains:")
 		for _, domain := range RelayState.LimitedDomains {
 			count = count + 1
-			cmd.Println(domain)
+			cmd.Println(formatDomainPattern(domain))
 		}
 	case "blocked":
 		cmd.Println(" - Blocked domains:")
 		for _, domain := range RelayState.BlockedDomains {
 			count = count + 1
-			cmd.Println(domain)
+			cmd.Println(formatDomainPattern(domain))
+		}
+	case "allowed":
+		cmd.Println(" - Allowed domains:")
+		for _, domain := range RelayState.AllowedDomains {
+			count = count + 1
+			cmd.Println(formatDomainPattern(domain))
 		}
 	default:
 		cmd.Println(" - Subscriber list:")
@@ -135,25 +181,50 @@ func listDomains(cmd *cobra.Command, _ []string) error {
 	return nil
 }
 
+func formatDomainPattern(pattern string) string {
+	kind := models.DomainPatternKind(pattern)
+	if kind == "exact" {
+		return pattern
+	}
+	return pattern + " (" + kind + ")"
+}
+
 func setDomainType(cmd *cobra.Command, args []string) error {
-	switch cmd.Flag("type").Value.String() {
-	case "limited":
-		for _, domain := range args {
+	domainType := cmd.Flag("type").Value.String()
+	if domainType != "limited" && domainType != "blocked" && domainType != "allowed" {
+		cmd.Println("Invalid type provided: " + domainType)
+		return nil
+	}
+
+	for _, domain := range args {
+		err := models.ValidateDomainPattern(domain)
+		if err != nil {
+			cmd.Println("Invalid domain provided: " + domain + " (" + err.Error() + ")")
+			continue
+		}
+		switch domainType {
+		case "limited":
 			RelayState.SetLimitedDomain(domain, true)
 			cmd.Println("Set [" + domain + "] as limited domain")
-		}
-	case "blocked":
-		for _, domain := range args {
-			RelayState.SetBlockedDomain(domain, true)
+		case "blocked":
+			unfollowed := RelayState.BlockDomain(&RelayActor, domain, enqueueRegisterActivity)
 			cmd.Println("Set [" + domain + "] as blocked domain")
+			printUnfollowed(cmd, unfollowed)
+		case "allowed":
+			RelayState.SetAllowedDomain(domain, true)
+			cmd.Println("Set [" + domain + "] as allowed domain")
 		}
-	default:
-		cmd.Println("Invalid type provided: " + cmd.Flag("type").Value.String())
 	}
 
 	return nil
 }
 
+func printUnfollowed(cmd *cobra.Command, domains []string) {
+	for _, domain := range domains {
+		cmd.Println("Unfollow [" + domain + "]")
+	}
+}
+
 func unsetDomainType(cmd *cobra.Command, args []string) error {
 	switch cmd.Flag("type").Value.String() {
 	case "limited":
@@ -166,6 +237,11 @@ func unsetDomainType(cmd *cobra.Command, args []string) error {
 			RelayState.SetBlockedDomain(domain, false)
 			cmd.Println("Unset [" + domain + "] as blocked domain")
 		}
+	case "allowed":
+		for _, domain := range args {
+			RelayState.SetAllowedDomain(domain, false)
+			cmd.Println("Unset [" + domain + "] as allowed domain")
+		}
 	default:
 		cmd.Println("Invalid type provided: " + cmd.Flag("type").Value.String())
 	}
@@ -177,20 +253,142 @@ func unfollowDomains(cmd *cobra.Command, args []string) error {
 	subscriptions := RelayState.Subscribers
 	followers := RelayState.Followers
 	for _, domain := range args {
+		var err error
 		switch {
 		case contains(subscriptions, domain):
-			subscription := *RelayState.SelectSubscriber(domain)
-			createUnfollowToSubscriberRequest(subscription)
-			RelayState.DelSubscriber(subscription.Domain)
-			cmd.Println("Unfollow [" + subscription.Domain + "]")
+			err = RelayState.UnfollowSubscriber(&RelayActor, *RelayState.SelectSubscriber(domain), enqueueRegisterActivity)
 		case contains(followers, domain):
-			follower := *RelayState.SelectFollower(domain)
-			createUnfollowToFollowerRequest(follower)
-			RelayState.DelFollower(follower.Domain)
-			cmd.Println("Unfollow [" + follower.Domain + "]")
+			err = RelayState.UnfollowFollower(&RelayActor, *RelayState.SelectFollower(domain), enqueueRegisterActivity)
 		default:
 			cmd.Println("Invalid domain provided: " + domain)
+			continue
+		}
+		if err != nil {
+			cmd.Println("Failed to unfollow [" + domain + "]: " + err.Error())
+			continue
 		}
+		cmd.Println("Unfollow [" + domain + "]")
 	}
 	return nil
 }
+
+func printBlocklistEntries(cmd *cobra.Command, prefix string, entries []models.BlocklistEntry) {
+	for _, entry := range entries {
+		cmd.Println(prefix + " " + string(entry.List) + " " + entry.Domain)
+	}
+}
+
+func importDomains(cmd *cobra.Command, args []string) error {
+	var reader io.Reader = cmd.InOrStdin()
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			cmd.Println("Failed to open blocklist: " + err.Error())
+			return nil
+		}
+		defer file.Close()
+		reader = file
+	}
+	entries, err := models.ParseBlocklist(cmd.Flag("format").Value.String(), reader)
+	if err != nil {
+		cmd.Println("Invalid blocklist provided: " + err.Error())
+		return nil
+	}
+
+	dryRun, _ := strconv.ParseBool(cmd.Flag("dry-run").Value.String())
+	if dryRun {
+		added := RelayState.DiffBlocklist(entries)
+		cmd.Println(" - Domains to be added:")
+		printBlocklistEntries(cmd, "+", added)
+		cmd.Println(fmt.Sprintf("Total: %d (unchanged: %d)", len(added), len(entries)-len(added)))
+		return nil
+	}
+
+	added, unfollowed, err := RelayState.ImportBlocklist(entries, &RelayActor, enqueueRegisterActivity)
+	if err != nil {
+		return err
+	}
+	RelayState.Load()
+	cmd.Println(" - Added domains:")
+	printBlocklistEntries(cmd, "+", added)
+	cmd.Println(fmt.Sprintf("Total: %d (unchanged: %d)", len(added), len(entries)-len(added)))
+	printUnfollowed(cmd, unfollowed)
+
+	return nil
+}
+
+func listBlocklistSources(cmd *cobra.Command, _ []string) error {
+	sources := RelayState.BlocklistSources()
+	cmd.Println(" - Blocklist sources:")
+	for _, source := range sources {
+		status := "never synced"
+		if !source.SyncedAt.IsZero() {
+			status = "synced at " + source.SyncedAt.Format(time.RFC3339)
+		}
+		if source.LastError != "" {
+			status = status + ", last error: " + source.LastError
+		}
+		cmd.Println(source.Name + " : " + source.Location + " (" + source.Format + ", " + status + ")")
+	}
+	cmd.Println(fmt.Sprintf("Total: %d", len(sources)))
+
+	return nil
+}
+
+func addBlocklistSource(cmd *cobra.Command, args []string) error {
+	if RelayState.SelectBlocklistSource(args[0]) != nil {
+		cmd.Println("Blocklist source already exists: " + args[0])
+		return nil
+	}
+	err := RelayState.SetBlocklistSource(models.BlocklistSource{
+		Name:     args[0],
+		Location: args[1],
+		Format:   cmd.Flag("format").Value.String(),
+	})
+	if err != nil {
+		cmd.Println("Invalid blocklist source provided: " + err.Error())
+		return nil
+	}
+	cmd.Println("Added [" + args[0] + "] blocklist source")
+
+	return nil
+}
+
+func removeBlocklistSources(cmd *cobra.Command, args []string) error {
+	for _, name := range args {
+		removed, err := RelayState.DelBlocklistSource(name)
+		if err != nil {
+			cmd.Println("Invalid blocklist source provided: " + name)
+			continue
+		}
+		cmd.Println("Removed [" + name + "] blocklist source")
+		printBlocklistEntries(cmd, "-", removed)
+	}
+	RelayState.Load()
+
+	return nil
+}
+
+func syncBlocklistSources(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		for _, source := range RelayState.BlocklistSources() {
+			args = append(args, source.Name)
+		}
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	for _, name := range args {
+		added, removed, unfollowed, err := RelayState.SyncBlocklistSource(name, client, &RelayActor, enqueueRegisterActivity)
+		if err != nil {
+			cmd.Println("Failed to sync [" + name + "] blocklist source: " + err.Error())
+			continue
+		}
+		cmd.Println("Synced [" + name + "] blocklist source")
+		printBlocklistEntries(cmd, "+", added)
+		printBlocklistEntries(cmd, "-", removed)
+		printUnfollowed(cmd, unfollowed)
+		RelayState.Load()
+	}
+
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 	"os"
 	"strings"
 	"testing"
+
+	"github.com/yukimochi/Activity-Relay/models"
 )
 
 func TestListDomainSubscriber(t *testing.T) {
@@ -145,6 +147,31 @@ func TestSetDomainLimited(t *testing.T) {
 	}
 }
 
+func TestSetDomainAllowed(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := domainCmdInit()
+
+	app.SetArgs([]string{"set", "-t", "allowed", ".example.jp"})
+	app.Execute()
+	RelayState.Load()
+
+	if len(RelayState.AllowedDomains) != 1 || RelayState.AllowedDomains[0] != ".example.jp" {
+		t.Fatalf("Expected allowed domains to be [.example.jp], but got %v", RelayState.AllowedDomains)
+	}
+	if !RelayState.IsAllowedDomain("testdomain.example.jp") {
+		t.Fatalf("Expected 'testdomain.example.jp' to be allowed, but it was not")
+	}
+
+	app.SetArgs([]string{"unset", "-t", "allowed", ".example.jp"})
+	app.Execute()
+	RelayState.Load()
+
+	if len(RelayState.AllowedDomains) != 0 {
+		t.Fatalf("Expected allowed domains to be empty, but got %v", RelayState.AllowedDomains)
+	}
+}
+
 func TestUnsetDomainBlocked(t *testing.T) {
 	RelayState.RedisClient.FlushAll(context.TODO()).Result()
 
@@ -288,3 +315,98 @@ func TestInvalidUnfollowDomain(t *testing.T) {
 		t.Fatalf("Expected output to be 'Invalid domain provided: unknown.tld', but got '%s'", strings.Split(output, "\n")[0])
 	}
 }
+
+func TestSetDomainBlockedWildcard(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	RelayState.Load()
+
+	RelayState.AddSubscriber(models.Subscriber{
+		Domain:     "spam.example.jp",
+		InboxURL:   "https://spam.example.jp/inbox",
+		ActivityID: "https://spam.example.jp/UUID",
+		ActorID:    "https://spam.example.jp/users/example",
+	})
+	RelayState.AddSubscriber(models.Subscriber{
+		Domain:     "example.jp",
+		InboxURL:   "https://example.jp/inbox",
+		ActivityID: "https://example.jp/UUID",
+		ActorID:    "https://example.jp/users/example",
+	})
+
+	buffer := new(bytes.Buffer)
+
+	app := domainCmdInit()
+	app.SetOut(buffer)
+	app.SetArgs([]string{"set", "-t", "blocked", "*.example.jp", "*.*.example.jp"})
+	app.Execute()
+
+	output := buffer.String()
+	valid := `Set [*.example.jp] as blocked domain
+Unfollow [spam.example.jp]
+Invalid domain provided: *.*.example.jp (wildcard is allowed only as leading label)
+`
+	if output != valid {
+		t.Fatalf("Expected output to be '%s', but got '%s'", valid, output)
+	}
+	if RelayState.SelectSubscriber("spam.example.jp") != nil {
+		t.Fatalf("Expected subscriber 'spam.example.jp' to be unfollowed, but still found")
+	}
+	if RelayState.SelectSubscriber("example.jp") == nil {
+		t.Fatalf("Expected subscriber 'example.jp' to remain, but not found")
+	}
+
+	buffer.Reset()
+	app.SetArgs([]string{"list", "-t", "blocked"})
+	app.Execute()
+
+	output = buffer.String()
+	valid = ` - Blocked domains:
+*.example.jp (wildcard)
+Total: 1
+`
+	if output != valid {
+		t.Fatalf("Expected output to be '%s', but got '%s'", valid, output)
+	}
+}
+
+func TestImportDomainsMastodonCSV(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+	RelayState.Load()
+	RelayState.SetBlockedDomain("spam.example.jp", true)
+
+	csv := `#domain,#severity,#reject_media,#reject_reports,#public_comment,#obfuscate
+spam.example.jp,suspend,false,false,,false
+another.example.jp,suspend,false,false,,false
+noisy.example.jp,silence,false,false,,false
+`
+	buffer := new(bytes.Buffer)
+
+	app := domainCmdInit()
+	app.SetOut(buffer)
+	app.SetIn(strings.NewReader(csv))
+	app.SetArgs([]string{"import", "--dry-run", "-"})
+	app.Execute()
+
+	output := buffer.String()
+	valid := ` - Domains to be added:
++ blocked another.example.jp
++ limited noisy.example.jp
+Total: 2 (unchanged: 1)
+`
+	if output != valid {
+		t.Fatalf("Expected output to be '%s', but got '%s'", valid, output)
+	}
+	if len(RelayState.BlockedDomains) != 1 {
+		t.Fatalf("Expected dry run not to change blocked domains, but got %v", RelayState.BlockedDomains)
+	}
+
+	app = domainCmdInit()
+	app.SetOut(buffer)
+	app.SetIn(strings.NewReader(csv))
+	app.SetArgs([]string{"import", "-"})
+	app.Execute()
+
+	if !RelayState.IsBlockedDomain("another.example.jp") || !RelayState.IsLimitedDomain("noisy.example.jp") {
+		t.Fatalf("Expected imported domains to be applied, but got blocked %v and limited %v", RelayState.BlockedDomains, RelayState.LimitedDomains)
+	}
+}
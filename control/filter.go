@@ -0,0 +1,105 @@
+package control
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/yukimochi/Activity-Relay/models"
+)
+
+func filterCmdInit() *cobra.Command {
+	var filter = &cobra.Command{
+		Use:   "filter",
+		Short: "Manage content filters",
+		Long:  "List, add and remove content filters evaluated before relaying activities.",
+	}
+
+	var filterList = &cobra.Command{
+		Use:   "list",
+		Short: "List content filters",
+		Long:  "List content filters.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(listFilters, cmd, args)
+		},
+	}
+	filter.AddCommand(filterList)
+
+	var filterAdd = &cobra.Command{
+		Use:   "add [flags]",
+		Short: "Add content filter",
+		Long: `Add content filter. All provided conditions must match.
+ - drop
+	Drop activity for all subscribers and followers.
+ - drop-followers
+	Drop activity for LitePub followers only.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(addFilter, cmd, args)
+		},
+	}
+	filterAdd.Flags().StringP("action", "a", "drop", "Filter action [drop,drop-followers]")
+	filterAdd.Flags().StringP("keyword", "k", "", "Match keyword in content or content warning (case-insensitive)")
+	filterAdd.Flags().StringP("regex", "r", "", "Match regular expression in content or content warning")
+	filterAdd.Flags().String("hashtag", "", "Match hashtag")
+	filterAdd.Flags().Bool("sensitive", false, "Match sensitive object")
+	filterAdd.Flags().Bool("content-warning", false, "Match object with content warning")
+	filterAdd.Flags().Int("min-attachments", 0, "Match object with at least provided number of attachments")
+	filter.AddCommand(filterAdd)
+
+	var filterRemove = &cobra.Command{
+		Use:   "remove",
+		Short: "Remove content filters",
+		Long:  "Remove content filters by ID.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(removeFilters, cmd, args)
+		},
+	}
+	filter.AddCommand(filterRemove)
+
+	return filter
+}
+
+func listFilters(cmd *cobra.Command, _ []string) error {
+	cmd.Println(" - Content filters:")
+	for _, filter := range RelayState.Filters {
+		cmd.Println("[" + filter.ID + "] " + filter.String())
+	}
+	cmd.Println(fmt.Sprintf("Total: %d", len(RelayState.Filters)))
+
+	return nil
+}
+
+func addFilter(cmd *cobra.Command, _ []string) error {
+	minAttachments, _ := strconv.Atoi(cmd.Flag("min-attachments").Value.String())
+	filter := models.FilterRule{
+		Action:         models.FilterAction(cmd.Flag("action").Value.String()),
+		Keyword:        cmd.Flag("keyword").Value.String(),
+		Regex:          cmd.Flag("regex").Value.String(),
+		Hashtag:        cmd.Flag("hashtag").Value.String(),
+		Sensitive:      cmd.Flag("sensitive").Value.String() == "true",
+		ContentWarning: cmd.Flag("content-warning").Value.String() == "true",
+		MinAttachments: minAttachments,
+	}
+	id, err := RelayState.AddFilter(filter)
+	if err != nil {
+		cmd.Println("Failed to add filter: " + err.Error())
+		return nil
+	}
+	cmd.Println("Added [" + id + "] filter")
+
+	return nil
+}
+
+func removeFilters(cmd *cobra.Command, args []string) error {
+	for _, id := range args {
+		if RelayState.DelFilter(id) {
+			cmd.Println("Removed [" + id + "] filter")
+		} else {
+			cmd.Println("Invalid filter provided: " + id)
+		}
+	}
+
+	return nil
+}
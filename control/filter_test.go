@@ -0,0 +1,88 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestAddFilter(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := filterCmdInit()
+	buffer := new(bytes.Buffer)
+	app.SetOut(buffer)
+
+	app.SetArgs([]string{"add", "--keyword", "spam", "--sensitive"})
+	app.Execute()
+	RelayState.Load()
+
+	output := strings.Split(buffer.String(), "\n")[0]
+	if output != "Added [1] filter" {
+		t.Fatalf("Expected output to be 'Added [1] filter', but got '%s'", output)
+	}
+	if len(RelayState.Filters) != 1 || RelayState.Filters[0].Keyword != "spam" || !RelayState.Filters[0].Sensitive {
+		t.Fatalf("Expected filter to be stored, but got %v", RelayState.Filters)
+	}
+}
+
+func TestAddInvalidFilter(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := filterCmdInit()
+	buffer := new(bytes.Buffer)
+	app.SetOut(buffer)
+
+	app.SetArgs([]string{"add", "--action", "drop-followers"})
+	app.Execute()
+
+	output := strings.Split(buffer.String(), "\n")[0]
+	if output != "Failed to add filter: filter has no condition" {
+		t.Fatalf("Expected output to be 'Failed to add filter: filter has no condition', but got '%s'", output)
+	}
+}
+
+func TestListFilters(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := filterCmdInit()
+	app.SetArgs([]string{"add", "--action", "drop-followers", "--hashtag", "nsfw", "--min-attachments", "1"})
+	app.Execute()
+	RelayState.Load()
+
+	buffer := new(bytes.Buffer)
+	app.SetOut(buffer)
+	app.SetArgs([]string{"list"})
+	app.Execute()
+
+	output := buffer.String()
+	valid := ` - Content filters:
+[1] drop-followers hashtag=#nsfw attachments>=1
+Total: 1
+`
+	if output != valid {
+		t.Fatalf("Expected output to be '%s', but got '%s'", valid, output)
+	}
+}
+
+func TestRemoveFilters(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := filterCmdInit()
+	app.SetArgs([]string{"add", "--keyword", "spam"})
+	app.Execute()
+
+	buffer := new(bytes.Buffer)
+	app.SetOut(buffer)
+	app.SetArgs([]string{"remove", "1", "2"})
+	app.Execute()
+
+	output := buffer.String()
+	valid := `Removed [1] filter
+Invalid filter provided: 2
+`
+	if output != valid {
+		t.Fatalf("Expected output to be '%s', but got '%s'", valid, output)
+	}
+}
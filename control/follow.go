@@ -1,14 +1,10 @@
 package control
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
-	"net/url"
-	"strings"
 
 	"github.com/google/uuid"
-	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/yukimochi/Activity-Relay/models"
 	"github.com/yukimochi/machinery-v1/v1/tasks"
@@ -66,7 +62,7 @@ func followCmdInit() *cobra.Command {
 	return follow
 }
 
-func enqueueRegisterActivity(inboxURL string, body []byte) {
+func enqueueRegisterActivity(inboxURL string, body []byte) error {
 	job := &tasks.Signature{
 		Name:       "register",
 		RetryCount: 25,
@@ -83,60 +79,7 @@ func enqueueRegisterActivity(inboxURL string, body []byte) {
 			},
 		},
 	}
-	_, err := MachineryServer.SendTask(job)
-	if err != nil {
-		logrus.Error(err)
-	}
-}
-
-func createFollowRequestResponse(domain string, response string) error {
-	data, err := RelayState.RedisClient.HGetAll(context.TODO(), "relay:pending:"+domain).Result()
-	if err != nil {
-		return err
-	}
-	activity := models.Activity{
-		Context: []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
-		ID:      data["activity_id"],
-		Actor:   data["actor"],
-		Type:    data["type"],
-		Object:  data["object"],
-	}
-
-	resp := activity.GenerateReply(RelayActor, activity, response)
-	jsonData, err := json.Marshal(&resp)
-	if err != nil {
-		return err
-	}
-	enqueueRegisterActivity(data["inbox_url"], jsonData)
-	RelayState.RedisClient.Del(context.TODO(), "relay:pending:"+domain)
-
-	switch {
-	case contains(activity.Object, "https://www.w3.org/ns/activitystreams#Public"):
-		if response == "Accept" {
-			RelayState.AddSubscriber(models.Subscriber{
-				Domain:     domain,
-				InboxURL:   data["inbox_url"],
-				ActivityID: data["activity_id"],
-				ActorID:    data["actor"],
-			})
-		}
-	case contains(activity.Object, RelayActor.ID):
-		if response == "Accept" {
-			RelayState.AddFollower(models.Follower{
-				Domain:     domain,
-				InboxURL:   data["inbox_url"],
-				ActivityID: data["activity_id"],
-				ActorID:    data["actor"],
-			})
-			actorID, _ := url.Parse(data["actor"])
-			if !contains(RelayState.LimitedDomains, actorID.Host) {
-				followRequest := models.NewActivityPubActivity(RelayActor, []string{data["actor"]}, data["actor"], "Follow")
-				jsonData, _ := json.Marshal(&followRequest)
-				enqueueRegisterActivity(data["inbox_url"], jsonData)
-			}
-		}
-	}
-	return nil
+	return Queue.SendTask(job)
 }
 
 func createUpdateActorActivity(subscription models.Subscriber) error {
@@ -153,20 +96,18 @@ func createUpdateActorActivity(subscription models.Subscriber) error {
 	if err != nil {
 		return err
 	}
-	enqueueRegisterActivity(subscription.InboxURL, jsonData)
-
-	return nil
+	return enqueueRegisterActivity(subscription.InboxURL, jsonData)
 }
 
 func listFollows(cmd *cobra.Command, _ []string) error {
 	var domains []string
 	cmd.Println(" - Follow requests:")
-	follows, err := RelayState.RedisClient.Keys(context.TODO(), "relay:pending:*").Result()
+	requests, err := RelayState.PendingRequests()
 	if err != nil {
 		return err
 	}
-	for _, follow := range follows {
-		domains = append(domains, strings.Replace(follow, "relay:pending:", "", 1))
+	for _, request := range requests {
+		domains = append(domains, request.Domain)
 	}
 	for _, domain := range domains {
 		cmd.Println(domain)
@@ -179,18 +120,22 @@ func listFollows(cmd *cobra.Command, _ []string) error {
 func acceptFollow(cmd *cobra.Command, args []string) error {
 	var err error
 	var domains []string
-	follows, err := RelayState.RedisClient.Keys(context.TODO(), "relay:pending:*").Result()
+	requests, err := RelayState.PendingRequests()
 	if err != nil {
 		return err
 	}
-	for _, follow := range follows {
-		domains = append(domains, strings.Replace(follow, "relay:pending:", "", 1))
+	for _, request := range requests {
+		domains = append(domains, request.Domain)
 	}
 
 	for _, domain := range args {
 		if contains(domains, domain) {
+			err = RelayState.RespondFollowRequest(&RelayActor, domain, "Accept", enqueueRegisterActivity)
+			if err != nil {
+				cmd.Println("Failed to accept [" + domain + "] follow request: " + err.Error())
+				continue
+			}
 			cmd.Println("Accept [" + domain + "] follow request")
-			createFollowRequestResponse(domain, "Accept")
 		} else {
 			cmd.Println("Invalid domain provided: " + domain)
 		}
@@ -202,18 +147,22 @@ func acceptFollow(cmd *cobra.Command, args []string) error {
 func rejectFollow(cmd *cobra.Command, args []string) error {
 	var err error
 	var domains []string
-	follows, err := RelayState.RedisClient.Keys(context.TODO(), "relay:pending:*").Result()
+	requests, err := RelayState.PendingRequests()
 	if err != nil {
 		return err
 	}
-	for _, follow := range follows {
-		domains = append(domains, strings.Replace(follow, "relay:pending:", "", 1))
+	for _, request := range requests {
+		domains = append(domains, request.Domain)
 	}
 
 	for _, domain := range args {
 		if contains(domains, domain) {
+			err = RelayState.RespondFollowRequest(&RelayActor, domain, "Reject", enqueueRegisterActivity)
+			if err != nil {
+				cmd.Println("Failed to reject [" + domain + "] follow request: " + err.Error())
+				continue
+			}
 			cmd.Println("Reject [" + domain + "] follow request")
-			createFollowRequestResponse(domain, "Reject")
 		} else {
 			cmd.Println("Invalid domain provided: " + domain)
 		}
@@ -226,7 +175,7 @@ func updateActor(cmd *cobra.Command, _ []string) error {
 	for _, subscription := range RelayState.SubscribersAndFollowers {
 		err := createUpdateActorActivity(subscription)
 		if err != nil {
-			cmd.Println("Failed to update RelayActor for [" + subscription.Domain + "]")
+			cmd.Println("Failed to update RelayActor for [" + subscription.Domain + "]: " + err.Error())
 		}
 	}
 	return nil
@@ -0,0 +1,114 @@
+package control
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yukimochi/Activity-Relay/models"
+)
+
+func keyCmdInit() *cobra.Command {
+	var key = &cobra.Command{
+		Use:   "key",
+		Short: "Manage relay actor key",
+		Long:  "Generate and rotate key used to sign requests of relay actor.",
+	}
+
+	var keyGenerate = &cobra.Command{
+		Use:   "generate [flags] <path>",
+		Short: "Generate relay actor key",
+		Long: `Generate relay actor key in PKCS#8 PEM, readable only by owner. Use it as ACTOR_PEM.
+ - rsa
+	RSA 2048 bit key, supported by most of servers.
+ - ed25519
+	Ed25519 key, published as Multikey. Not supported by some servers (e.g. Mastodon).`,
+		Args: cobra.ExactArgs(1),
+		RunE: generateKey,
+	}
+	keyGenerate.Flags().StringP("type", "t", models.RSAKey, "Key type [rsa,ed25519]")
+	keyGenerate.Flags().Bool("force", false, "Overwrite existing file")
+	key.AddCommand(keyGenerate)
+
+	var keyRotate = &cobra.Command{
+		Use:   "rotate [flags]",
+		Short: "Rotate relay actor key",
+		Long: `Generate new relay actor key and switch signing to it, then send Update of actor to all subscribers/followers.
+Previous key is still published in actor for grace period so that remote servers can expire cached key.
+New private key is stored unencrypted in Redis (relay:actorKey) and takes precedence over ACTOR_KEY, ACTOR_PEM and Docker secret,
+including keys configured after rotation. Protect access to Redis accordingly.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(rotateKey, cmd, args)
+		},
+	}
+	keyRotate.Flags().Duration("grace", models.ActorKeyGracePeriod, "Period to keep publishing previous key")
+	key.AddCommand(keyRotate)
+
+	return key
+}
+
+func generateKey(cmd *cobra.Command, args []string) error {
+	keyType := cmd.Flag("type").Value.String()
+	force, _ := strconv.ParseBool(cmd.Flag("force").Value.String())
+
+	privateKey, err := models.GeneratePrivateKey(keyType)
+	if err != nil {
+		cmd.Println("Invalid key type provided: " + keyType)
+		return nil
+	}
+	privateKeyPem, err := models.GeneratePrivateKeyPEMString(privateKey)
+	if err != nil {
+		cmd.Println("Failed to generate actor key: " + err.Error())
+		return nil
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(args[0], flag, 0600)
+	if err != nil {
+		cmd.Println("Failed to write actor key: " + err.Error())
+		return nil
+	}
+	defer file.Close()
+	// Permission of existing file is kept by OpenFile
+	err = file.Chmod(0600)
+	if err == nil {
+		_, err = file.WriteString(privateKeyPem)
+	}
+	if err != nil {
+		cmd.Println("Failed to write actor key: " + err.Error())
+		return nil
+	}
+	cmd.Println("Generated " + keyType + " actor key at [" + args[0] + "]")
+
+	return nil
+}
+
+func rotateKey(cmd *cobra.Command, _ []string) error {
+	grace, _ := time.ParseDuration(cmd.Flag("grace").Value.String())
+	keyID, err := GlobalConfig.RotateActorKey(grace)
+	if err != nil {
+		cmd.Println("Failed to rotate actor key: " + err.Error())
+		return nil
+	}
+	RelayState.RecordAudit(models.AuditKeyRotate, keyID)
+	RelayActor = models.NewActivityPubActorFromRelayConfig(GlobalConfig)
+	cmd.Println("Rotated actor key to [" + keyID + "]")
+
+	updated := 0
+	for _, subscription := range RelayState.SubscribersAndFollowers {
+		err := createUpdateActorActivity(subscription)
+		if err != nil {
+			cmd.Println("Failed to update RelayActor for [" + subscription.Domain + "]")
+			continue
+		}
+		updated++
+	}
+	cmd.Println(fmt.Sprintf("Sent Update of actor to %d subscribers/followers", updated))
+
+	return nil
+}
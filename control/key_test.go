@@ -0,0 +1,84 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yukimochi/Activity-Relay/models"
+)
+
+func TestRotateKey(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := configCmdInit()
+	file, err := os.Open("../misc/test/exampleConfig.json")
+	if err != nil {
+		t.Fatalf("Failed to open test resource file: %v", err)
+	}
+	jsonData, _ := io.ReadAll(file)
+
+	app.SetArgs([]string{"import", "--data", string(jsonData)})
+	app.Execute()
+	RelayState.Load()
+
+	previousKeyID := GlobalConfig.ActorKeyID()
+	buffer := new(bytes.Buffer)
+
+	app = keyCmdInit()
+	app.SetOut(buffer)
+	app.SetArgs([]string{"rotate", "--grace", "1h"})
+	app.Execute()
+
+	output := buffer.String()
+	valid := "Rotated actor key to [" + GlobalConfig.ActorKeyID() + "]\nSent Update of actor to 1 subscribers/followers\n"
+	if GlobalConfig.ActorKeyID() == previousKeyID || output != valid {
+		t.Fatalf("Expected output to be '%s', but got '%s'", valid, output)
+	}
+	if RelayActor.PublicKey.ID != GlobalConfig.ActorKeyID() || len(RelayActor.AssertionMethod) != 2 {
+		t.Fatalf("Expected actor to publish new and previous key, but got %+v", RelayActor)
+	}
+	events, _ := RelayState.AuditEvents(models.AuditFilter{Action: models.AuditKeyRotate}, 0)
+	if len(events) != 1 {
+		t.Fatalf("Expected rotation to be recorded in audit log, but got %v", events)
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "actor.pem")
+	buffer := new(bytes.Buffer)
+
+	app := keyCmdInit()
+	app.SetOut(buffer)
+	app.SetArgs([]string{"generate", "--type", "ed25519", keyPath})
+	app.Execute()
+
+	output := buffer.String()
+	valid := "Generated ed25519 actor key at [" + keyPath + "]\n"
+	if output != valid {
+		t.Fatalf("Expected output to be '%s', but got '%s'", valid, output)
+	}
+	info, _ := os.Stat(keyPath)
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected key file permission to be 0600, but got %v", info.Mode().Perm())
+	}
+	keyData, _ := os.ReadFile(keyPath)
+	privateKey, err := models.ReadPrivateKeyFromString(string(keyData))
+	if err != nil || models.PrivateKeyType(privateKey) != models.Ed25519Key {
+		t.Fatalf("Expected generated key to be Ed25519 key, but got %v", err)
+	}
+
+	buffer.Reset()
+	app = keyCmdInit()
+	app.SetOut(buffer)
+	app.SetArgs([]string{"generate", keyPath})
+	app.Execute()
+
+	if !strings.HasPrefix(buffer.String(), "Failed to write actor key: ") {
+		t.Fatalf("Expected existing key not to be overwritten, but got '%s'", buffer.String())
+	}
+}
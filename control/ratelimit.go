@@ -0,0 +1,142 @@
+package control
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/yukimochi/Activity-Relay/models"
+)
+
+func rateLimitCmdInit() *cobra.Command {
+	var rateLimit = &cobra.Command{
+		Use:   "ratelimit",
+		Short: "Manage inbound rate limits",
+		Long:  "List, set and unset per-domain token bucket rate limits for inbox requests.",
+	}
+
+	var rateLimitList = &cobra.Command{
+		Use:   "list",
+		Short: "List rate limits",
+		Long:  "List default rate limit, per-domain overrides and number of rate limited requests.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(listRateLimits, cmd, args)
+		},
+	}
+	rateLimit.AddCommand(rateLimitList)
+
+	var rateLimitDefault = &cobra.Command{
+		Use:   "default [flags]",
+		Short: "Set default rate limit",
+		Long:  "Set rate limit applied to domains without override. Rate 0 means unlimited.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(setDefaultRateLimit, cmd, args)
+		},
+	}
+	rateLimitDefault.Flags().Float64P("rate", "r", 0, "Allowed requests per second")
+	rateLimitDefault.Flags().IntP("burst", "b", 0, "Allowed burst of requests (default: rounded up rate)")
+	rateLimitDefault.MarkFlagRequired("rate")
+	rateLimit.AddCommand(rateLimitDefault)
+
+	var rateLimitSet = &cobra.Command{
+		Use:   "set [flags]",
+		Short: "Set rate limit for domains",
+		Long:  "Set rate limit override for domains. Rate 0 means unlimited.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(setRateLimit, cmd, args)
+		},
+	}
+	rateLimitSet.Flags().Float64P("rate", "r", 0, "Allowed requests per second")
+	rateLimitSet.Flags().IntP("burst", "b", 0, "Allowed burst of requests (default: rounded up rate)")
+	rateLimitSet.MarkFlagRequired("rate")
+	rateLimit.AddCommand(rateLimitSet)
+
+	var rateLimitUnset = &cobra.Command{
+		Use:   "unset",
+		Short: "Unset rate limit for domains",
+		Long:  "Unset rate limit override for domains. Default rate limit is applied to them.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(unsetRateLimit, cmd, args)
+		},
+	}
+	rateLimit.AddCommand(rateLimitUnset)
+
+	return rateLimit
+}
+
+func rateLimitFromFlags(cmd *cobra.Command) models.RateLimit {
+	rate, _ := strconv.ParseFloat(cmd.Flag("rate").Value.String(), 64)
+	burst, _ := strconv.Atoi(cmd.Flag("burst").Value.String())
+	return models.RateLimit{Rate: rate, Burst: burst}
+}
+
+func listRateLimits(cmd *cobra.Command, _ []string) error {
+	cmd.Println(" - Default rate limit: " + RelayState.SelectRateLimit("").String())
+
+	cmd.Println(" - Rate limit overrides:")
+	var domains []string
+	for domain := range RelayState.RateLimits {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		cmd.Println(domain + " : " + RelayState.RateLimits[domain].String())
+	}
+	cmd.Println(fmt.Sprintf("Total: %d", len(domains)))
+
+	cmd.Println(" - Rate limited requests:")
+	statistics := RelayState.RateLimitStatistics()
+	domains = nil
+	for domain := range statistics {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		cmd.Println(fmt.Sprintf("%s : %d", domain, statistics[domain]))
+	}
+
+	return nil
+}
+
+func setDefaultRateLimit(cmd *cobra.Command, _ []string) error {
+	err := RelayState.SetDefaultRateLimit(rateLimitFromFlags(cmd))
+	if err != nil {
+		cmd.Println("Invalid rate limit provided: " + err.Error())
+		return nil
+	}
+	RelayState.Load()
+	cmd.Println("Set default rate limit as " + RelayState.SelectRateLimit("").String())
+
+	return nil
+}
+
+func setRateLimit(cmd *cobra.Command, args []string) error {
+	limit := rateLimitFromFlags(cmd)
+	for _, domain := range args {
+		err := RelayState.SetRateLimit(domain, limit)
+		if err != nil {
+			cmd.Println("Invalid rate limit provided: " + err.Error())
+			return nil
+		}
+		RelayState.Load()
+		cmd.Println("Set [" + domain + "] rate limit as " + RelayState.SelectRateLimit(domain).String())
+	}
+
+	return nil
+}
+
+func unsetRateLimit(cmd *cobra.Command, args []string) error {
+	for _, domain := range args {
+		if RelayState.DelRateLimit(domain) {
+			cmd.Println("Unset [" + domain + "] rate limit")
+		} else {
+			cmd.Println("Invalid domain provided: " + domain)
+		}
+	}
+
+	return nil
+}
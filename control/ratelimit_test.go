@@ -0,0 +1,92 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestSetRateLimit(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := rateLimitCmdInit()
+	buffer := new(bytes.Buffer)
+	app.SetOut(buffer)
+
+	app.SetArgs([]string{"set", "--rate", "0.5", "--burst", "10", "example.com"})
+	app.Execute()
+
+	output := buffer.String()
+	if output != "Set [example.com] rate limit as 0.5 req/s (burst 10)\n" {
+		t.Fatalf("Expected output to be 'Set [example.com] rate limit as 0.5 req/s (burst 10)', but got '%s'", output)
+	}
+	limit := RelayState.SelectRateLimit("example.com")
+	if limit.Rate != 0.5 || limit.Burst != 10 {
+		t.Fatalf("Expected rate limit to be stored, but got %v", limit)
+	}
+}
+
+func TestSetInvalidRateLimit(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := rateLimitCmdInit()
+	buffer := new(bytes.Buffer)
+	app.SetOut(buffer)
+
+	app.SetArgs([]string{"default", "--rate", "-1"})
+	app.Execute()
+
+	output := buffer.String()
+	if output != "Invalid rate limit provided: rate must be zero or positive number\n" {
+		t.Fatalf("Expected output to be 'Invalid rate limit provided: rate must be zero or positive number', but got '%s'", output)
+	}
+}
+
+func TestListRateLimits(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := rateLimitCmdInit()
+	app.SetArgs([]string{"default", "--rate", "5"})
+	app.Execute()
+	app.SetArgs([]string{"set", "--rate", "0", "example.com"})
+	app.Execute()
+	RelayState.RedisClient.HSet(context.TODO(), "relay:statistics:ratelimited", "spam.example.com", 3).Result()
+
+	buffer := new(bytes.Buffer)
+	app.SetOut(buffer)
+	app.SetArgs([]string{"list"})
+	app.Execute()
+
+	output := buffer.String()
+	valid := ` - Default rate limit: 5 req/s (burst 5)
+ - Rate limit overrides:
+example.com : unlimited
+Total: 1
+ - Rate limited requests:
+spam.example.com : 3
+`
+	if output != valid {
+		t.Fatalf("Expected output to be '%s', but got '%s'", valid, output)
+	}
+}
+
+func TestUnsetRateLimit(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := rateLimitCmdInit()
+	app.SetArgs([]string{"set", "--rate", "1", "example.com"})
+	app.Execute()
+
+	buffer := new(bytes.Buffer)
+	app.SetOut(buffer)
+	app.SetArgs([]string{"unset", "example.com", "other.example.com"})
+	app.Execute()
+
+	output := buffer.String()
+	valid := `Unset [example.com] rate limit
+Invalid domain provided: other.example.com
+`
+	if output != valid {
+		t.Fatalf("Expected output to be '%s', but got '%s'", valid, output)
+	}
+}
@@ -0,0 +1,93 @@
+package control
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/yukimochi/Activity-Relay/models"
+)
+
+func tokenCmdInit() *cobra.Command {
+	var token = &cobra.Command{
+		Use:   "token",
+		Short: "Manage admin API tokens",
+		Long:  "List, create and revoke tokens for relay admin API.",
+	}
+
+	var tokenList = &cobra.Command{
+		Use:   "list",
+		Short: "List admin API tokens",
+		Long:  "List admin API tokens.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(listTokens, cmd, args)
+		},
+	}
+	token.AddCommand(tokenList)
+
+	var tokenCreate = &cobra.Command{
+		Use:   "create [flags]",
+		Short: "Create admin API token",
+		Long: `Create admin API token with provided scope.
+ - read-only
+	List and export relay information.
+ - moderation
+	read-only, and manage domains and follow requests.
+ - admin
+	moderation, and change relay configuration.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(createToken, cmd, args)
+		},
+	}
+	tokenCreate.Flags().StringP("scope", "s", "read-only", "Token scope [read-only,moderation,admin]")
+	token.AddCommand(tokenCreate)
+
+	var tokenRevoke = &cobra.Command{
+		Use:   "revoke",
+		Short: "Revoke admin API tokens",
+		Long:  "Revoke admin API tokens by name.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return InitProxyE(revokeTokens, cmd, args)
+		},
+	}
+	token.AddCommand(tokenRevoke)
+
+	return token
+}
+
+func listTokens(cmd *cobra.Command, _ []string) error {
+	tokens := RelayState.AdminTokens()
+	cmd.Println(" - Admin API tokens:")
+	for _, token := range tokens {
+		cmd.Println(token.Name + " (" + string(token.Scope) + ")")
+	}
+	cmd.Println(fmt.Sprintf("Total: %d", len(tokens)))
+
+	return nil
+}
+
+func createToken(cmd *cobra.Command, args []string) error {
+	scope := models.TokenScope(cmd.Flag("scope").Value.String())
+	token, err := RelayState.AddAdminToken(args[0], scope)
+	if err != nil {
+		cmd.Println("Failed to create token: " + err.Error())
+		return nil
+	}
+	cmd.Println("Created [" + args[0] + "] token with " + string(scope) + " scope")
+	cmd.Println(token)
+
+	return nil
+}
+
+func revokeTokens(cmd *cobra.Command, args []string) error {
+	for _, name := range args {
+		if RelayState.DelAdminToken(name) {
+			cmd.Println("Revoke [" + name + "] token")
+		} else {
+			cmd.Println("Invalid token provided: " + name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCreateToken(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := tokenCmdInit()
+	buffer := new(bytes.Buffer)
+	app.SetOut(buffer)
+
+	app.SetArgs([]string{"create", "dashboard", "--scope", "moderation"})
+	app.Execute()
+
+	output := strings.Split(buffer.String(), "\n")
+	if output[0] != "Created [dashboard] token with moderation scope" {
+		t.Fatalf("Expected output to be 'Created [dashboard] token with moderation scope', but got '%s'", output[0])
+	}
+	token := RelayState.SelectAdminToken(output[1])
+	if token == nil || token.Name != "dashboard" {
+		t.Fatalf("Expected printed token to be valid, but it was not")
+	}
+}
+
+func TestCreateTokenInvalidScope(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	app := tokenCmdInit()
+	buffer := new(bytes.Buffer)
+	app.SetOut(buffer)
+
+	app.SetArgs([]string{"create", "dashboard", "--scope", "root"})
+	app.Execute()
+
+	output := buffer.String()
+	if strings.Split(output, "\n")[0] != "Failed to create token: invalid token scope: root" {
+		t.Fatalf("Expected output to be 'Failed to create token: invalid token scope: root', but got '%s'", strings.Split(output, "\n")[0])
+	}
+}
+
+func TestListTokens(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	RelayState.AddAdminToken("dashboard", "read-only")
+
+	app := tokenCmdInit()
+	buffer := new(bytes.Buffer)
+	app.SetOut(buffer)
+
+	app.SetArgs([]string{"list"})
+	app.Execute()
+
+	output := buffer.String()
+	valid := ` - Admin API tokens:
+dashboard (read-only)
+Total: 1
+`
+	if output != valid {
+		t.Fatalf("Expected output to be '%s', but got '%s'", valid, output)
+	}
+}
+
+func TestRevokeTokens(t *testing.T) {
+	RelayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	RelayState.AddAdminToken("dashboard", "read-only")
+
+	app := tokenCmdInit()
+	buffer := new(bytes.Buffer)
+	app.SetOut(buffer)
+
+	app.SetArgs([]string{"revoke", "dashboard", "unknown"})
+	app.Execute()
+
+	output := buffer.String()
+	valid := `Revoke [dashboard] token
+Invalid token provided: unknown
+`
+	if output != valid {
+		t.Fatalf("Expected output to be '%s', but got '%s'", valid, output)
+	}
+}
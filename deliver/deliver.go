@@ -5,14 +5,23 @@ import (
 	"errors"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"github.com/yukimochi/Activity-Relay/models"
 	"github.com/yukimochi/machinery-v1/v1"
 	"github.com/yukimochi/machinery-v1/v1/log"
+	"github.com/yukimochi/machinery-v1/v1/tasks"
 )
 
 var (
@@ -20,36 +29,120 @@ var (
 	GlobalConfig *models.RelayConfig
 
 	// RelayActor : Relay's Actor
-	RelayActor models.Actor
+	RelayActor atomic.Pointer[models.Actor]
 
 	HttpClient      *http.Client
 	MachineryServer *machinery.Server
-	RedisClient     *redis.Client
+	// Queue : Queue of retried delivery tasks
+	Queue models.JobQueue
+	// Activities : Bodies of activities relayed to inboxes
+	Activities  models.ActivityStore
+	RedisClient *redis.Client
 )
 
+// taskHandlers : Delivery tasks by name
+var taskHandlers = map[string]models.TaskHandler{
+	"register": registerActivity,
+	"relay-v2": relayActivityV2,
+}
+
 func relayActivityV2(args ...string) error {
 	inboxURL := args[0]
 	activityID := args[1]
-	body, err := RedisClient.HGet(context.TODO(), "relay:activity:"+activityID, "body").Result()
+	attempt := 0
+	if len(args) > 2 {
+		attempt, _ = strconv.Atoi(args[2])
+	}
+	// Domains sharing inbox. Tasks enqueued by older versions have no domains.
+	var domains []string
+	if len(args) > 3 && args[3] != "" {
+		domains = strings.Split(args[3], ",")
+	}
+	body, done, err := Activities.Body(activityID, inboxURL)
 	if err != nil {
-		return errors.New("activity ttl expired")
+		return err
+	}
+	// Task requeued on shutdown may already be processed by interrupted worker
+	if done {
+		logrus.Debug("Skipped Relay already done : ", inboxURL, " ", activityID)
+		return nil
 	}
 
-	err = sendActivity(inboxURL, RelayActor.PublicKey.ID, []byte(body), GlobalConfig.ActorKey())
+	keyID, privateKey := GlobalConfig.ActorSigningKey()
+	err = sendActivity(inboxURL, keyID, body, privateKey)
 	if err != nil {
-		domain, _ := url.Parse(inboxURL)
-		pushErrorLogScript := "local change = redis.call('HSETNX', KEYS[1], 'last_error', ARGV[1]); if change == 1 then redis.call('EXPIRE', KEYS[1], ARGV[2]) end;"
-		RedisClient.Eval(context.TODO(), pushErrorLogScript, []string{"relay:statistics:" + domain.Host}, err.Error(), 60).Result()
+		if domains == nil {
+			domain, _ := url.Parse(inboxURL)
+			domains = []string{domain.Host}
+		}
+		// Last error is kept in Redis for statistics
+		if RedisClient != nil {
+			pushErrorLogScript := "local change = redis.call('HSETNX', KEYS[1], 'last_error', ARGV[1]); if change == 1 then redis.call('EXPIRE', KEYS[1], ARGV[2]) end;"
+			for _, domain := range domains {
+				RedisClient.Eval(context.TODO(), pushErrorLogScript, []string{"relay:statistics:" + domain}, err.Error(), 60).Result()
+			}
+		}
+
+		if isTemporaryError(err) && attempt < GlobalConfig.RetryPolicy().Count {
+			retryErr := retryRelayActivity(inboxURL, activityID, attempt+1, domains)
+			if retryErr == nil {
+				return err
+			}
+			logrus.Error(retryErr)
+		}
 	}
-	reductionRemainCountScript := "local remain_count = redis.call('HINCRBY', KEYS[1], 'remain_count', -1); if remain_count < 1 then redis.call('DEL', KEYS[1]) end;"
-	RedisClient.Eval(context.TODO(), reductionRemainCountScript, []string{"relay:activity:" + activityID}).Result()
+	// Remaining count is reduced once per inbox, even if task is processed twice
+	Activities.Done(activityID, inboxURL)
 	return err
 }
 
+func retryRelayActivity(inboxURL string, activityID string, attempt int, domains []string) error {
+	backoff := GlobalConfig.RetryPolicy().Backoff(attempt)
+
+	// Keep activity body until the retry is processed. Remaining count is not reduced for pending retry.
+	Activities.Extend(activityID, backoff+2*time.Minute)
+
+	eta := time.Now().Add(backoff)
+	job := &tasks.Signature{
+		Name:       "relay-v2",
+		RetryCount: 0,
+		ETA:        &eta,
+		Args: []tasks.Arg{
+			{
+				Name:  "inboxURL",
+				Type:  "string",
+				Value: inboxURL,
+			},
+			{
+				Name:  "activityID",
+				Type:  "string",
+				Value: activityID,
+			},
+			{
+				Name:  "attempt",
+				Type:  "string",
+				Value: strconv.Itoa(attempt),
+			},
+			{
+				Name:  "domains",
+				Type:  "string",
+				Value: strings.Join(domains, ","),
+			},
+		},
+	}
+	err := Queue.SendTask(job)
+	if err != nil {
+		return err
+	}
+	logrus.Debug("Scheduled Relay Retry : ", inboxURL, " ", attempt, " in ", backoff)
+	return nil
+}
+
 func registerActivity(args ...string) error {
 	inboxURL := args[0]
 	body := args[1]
-	err := sendActivity(inboxURL, RelayActor.PublicKey.ID, []byte(body), GlobalConfig.ActorKey())
+	keyID, privateKey := GlobalConfig.ActorSigningKey()
+	err := sendActivity(inboxURL, keyID, []byte(body), privateKey)
 	return err
 }
 
@@ -63,19 +156,39 @@ func Entrypoint(g *models.RelayConfig, v string) error {
 	if err != nil {
 		return err
 	}
+	if MachineryServer == nil {
+		return errors.New("REDIS_URL IS EMPTY. JOB WORKER REQUIRES REDIS, USE STANDALONE INSTEAD")
+	}
 
-	err = MachineryServer.RegisterTask("register", registerActivity)
+	err = registerTasks()
 	if err != nil {
 		return err
 	}
-	err = MachineryServer.RegisterTask("relay-v2", relayActivityV2)
+
+	if GlobalConfig.MetricsBind() != "" {
+		go func() {
+			logrus.Info("Starting Metrics Listener at ", GlobalConfig.MetricsBind())
+			err := http.ListenAndServe(GlobalConfig.MetricsBind(), promhttp.Handler())
+			if err != nil {
+				logrus.Error(err)
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = startBlocklistSync(ctx, GlobalConfig.BlocklistSyncInterval())
 	if err != nil {
 		return err
 	}
 
 	workerID := uuid.New()
 	worker := MachineryServer.NewWorker(workerID.String(), GlobalConfig.JobConcurrency())
-	err = worker.Launch()
+	running := newRunningTasks()
+	worker.SetPreTaskHandler(running.add)
+	worker.SetPostTaskHandler(running.remove)
+	err = launchWorker(ctx, worker, running, GlobalConfig.ShutdownTimeout())
 	if err != nil {
 		logrus.Error(err)
 	}
@@ -83,18 +196,239 @@ func Entrypoint(g *models.RelayConfig, v string) error {
 	return nil
 }
 
+// StandaloneEntrypoint : Process tasks of in-process queue until ctx is done. With Redis, tasks enqueued by CLI to Machinery are processed as well.
+func StandaloneEntrypoint(ctx context.Context, g *models.RelayConfig, v string, queue *models.MemoryQueue) error {
+	var err error
+
+	version = v
+	GlobalConfig = g
+	GlobalConfig.SetStandalone()
+
+	err = initialize(GlobalConfig)
+	if err != nil {
+		return err
+	}
+	Queue = queue
+	err = models.RegisterMemoryQueueMetrics(queue)
+	if err != nil {
+		return err
+	}
+
+	for name, handler := range taskHandlers {
+		queue.RegisterTask(name, handler)
+	}
+	queue.Launch(GlobalConfig.JobConcurrency())
+
+	err = startBlocklistSync(ctx, GlobalConfig.BlocklistSyncInterval())
+	if err != nil {
+		return err
+	}
+
+	if MachineryServer != nil {
+		err = registerTasks()
+		if err != nil {
+			return err
+		}
+		workerID := uuid.New()
+		worker := MachineryServer.NewWorker(workerID.String(), 1)
+		running := newRunningTasks()
+		worker.SetPreTaskHandler(running.add)
+		worker.SetPostTaskHandler(running.remove)
+		err = launchWorker(ctx, worker, running, GlobalConfig.ShutdownTimeout())
+		if err != nil {
+			logrus.Error(err)
+		}
+	} else {
+		<-ctx.Done()
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), GlobalConfig.ShutdownTimeout())
+	defer cancel()
+	dropped, err := queue.Shutdown(shutdownCtx)
+	if dropped > 0 {
+		logrus.Warn("Dropped ", dropped, " queued tasks")
+	}
+	return err
+}
+
+// startBlocklistSync : Sync blocklist sources every interval until ctx is done. Only one worker syncs at a time. Blocklist sources are kept in Redis only.
+func startBlocklistSync(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 || RedisClient == nil {
+		return nil
+	}
+	storage, err := GlobalConfig.NewStorage()
+	if err != nil {
+		return err
+	}
+	relayState := models.NewStateWithStorage(storage, RedisClient, true)
+	relayState.AuditActor = "worker:" + workerHostname()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			syncBlocklists(&relayState, interval)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+func syncBlocklists(relayState *models.RelayState, interval time.Duration) {
+	locked, err := RedisClient.SetNX(context.TODO(), "relay:lock:blocklistSync", workerHostname(), interval/2).Result()
+	if err != nil || !locked {
+		return
+	}
+	for name, err := range relayState.SyncBlocklistSources(HttpClient, RelayActor.Load(), enqueueRegisterActivity) {
+		if err != nil {
+			logrus.Error("Failed to sync blocklist source ", name, " : ", err)
+		} else {
+			logrus.Debug("Synced blocklist source ", name)
+		}
+	}
+}
+
+// enqueueRegisterActivity : Enqueue activity sent by relay itself, such as Reject to unfollowed member
+func enqueueRegisterActivity(inboxURL string, body []byte) error {
+	job := &tasks.Signature{
+		Name:       "register",
+		RetryCount: 25,
+		Args: []tasks.Arg{
+			{
+				Name:  "inboxURL",
+				Type:  "string",
+				Value: inboxURL,
+			},
+			{
+				Name:  "body",
+				Type:  "string",
+				Value: string(body),
+			},
+		},
+	}
+	err := Queue.SendTask(job)
+	if err != nil {
+		logrus.Error("Failed to enqueue activity to ", inboxURL, " : ", err)
+	}
+	return err
+}
+
+func workerHostname() string {
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+func registerTasks() error {
+	for name, handler := range taskHandlers {
+		err := MachineryServer.RegisterTask(name, handler)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// launchWorker : Run worker until ctx is done, then wait for running tasks within timeout and requeue unfinished ones.
+func launchWorker(ctx context.Context, worker *machinery.Worker, running *runningTasks, timeout time.Duration) error {
+	workerErr := make(chan error, 1)
+	worker.LaunchAsync(workerErr)
+
+	select {
+	case err := <-workerErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	logrus.Info("Shutting down Job Worker, waiting for running tasks to finish")
+	stopped := make(chan struct{})
+	go func() {
+		worker.Quit()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		logrus.Info("Job Worker stopped")
+	case <-time.After(timeout):
+		requeued := running.requeue()
+		logrus.Warn("Shutdown deadline exceeded, requeued ", requeued, " running tasks")
+	}
+	return nil
+}
+
+// runningTasks : Tasks being processed by worker
+type runningTasks struct {
+	mutex      sync.Mutex
+	signatures map[string]*tasks.Signature
+}
+
+func newRunningTasks() *runningTasks {
+	return &runningTasks{signatures: make(map[string]*tasks.Signature)}
+}
+
+func (running *runningTasks) add(signature *tasks.Signature) {
+	running.mutex.Lock()
+	defer running.mutex.Unlock()
+	running.signatures[signature.UUID] = signature
+}
+
+func (running *runningTasks) remove(signature *tasks.Signature) {
+	running.mutex.Lock()
+	defer running.mutex.Unlock()
+	delete(running.signatures, signature.UUID)
+}
+
+// requeue : Send running tasks to queue again with same UUID, ETA and retry state. Return number of requeued tasks.
+// Relay tasks already done by interrupted worker are skipped when processed again.
+func (running *runningTasks) requeue() int {
+	running.mutex.Lock()
+	defer running.mutex.Unlock()
+
+	requeued := 0
+	for id, signature := range running.signatures {
+		job := *signature
+		_, err := MachineryServer.SendTask(&job)
+		if err != nil {
+			logrus.Error("Failed to requeue task ", signature.Name, " : ", err)
+			continue
+		}
+		delete(running.signatures, id)
+		requeued++
+	}
+	return requeued
+}
+
 func initialize(globalConfig *models.RelayConfig) error {
 	var err error
 
 	RedisClient = globalConfig.RedisClient()
-
-	MachineryServer, err = models.NewMachineryServer(globalConfig)
+	Activities = globalConfig.ActivityStore()
+	err = models.RegisterQueueMetrics(RedisClient)
 	if err != nil {
 		return err
 	}
+
+	// Without Redis, queue is given by standalone
+	MachineryServer = nil
+	if RedisClient != nil {
+		MachineryServer, err = models.NewMachineryServer(globalConfig)
+		if err != nil {
+			return err
+		}
+		Queue = models.NewMachineryQueue(MachineryServer)
+	}
 	HttpClient = &http.Client{Timeout: time.Duration(5) * time.Second}
 
-	RelayActor = models.NewActivityPubActorFromRelayConfig(globalConfig)
+	relayActor := models.NewActivityPubActorFromRelayConfig(globalConfig)
+	RelayActor.Store(&relayActor)
+	globalConfig.ListenActorKey(func() {
+		relayActor := models.NewActivityPubActorFromRelayConfig(globalConfig)
+		RelayActor.Store(&relayActor)
+		logrus.Info("Switched actor key to ", globalConfig.ActorKeyID())
+	})
 	newNullLogger := NewNullLogger()
 	log.DEBUG = newNullLogger
 
@@ -8,11 +8,15 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/spf13/viper"
 	"github.com/yukimochi/Activity-Relay/models"
+	"github.com/yukimochi/machinery-v1/v1/tasks"
 )
 
 func TestMain(m *testing.M) {
@@ -115,6 +119,152 @@ func TestRelayActivityResp500(t *testing.T) {
 	}
 }
 
+func TestRelayActivitySharedInboxStatistics(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		w.Write(nil)
+	}))
+	defer s.Close()
+
+	activityID := uuid.New()
+	remainCount := 1
+
+	pushActivityScript := "redis.call('HSET',KEYS[1], 'body', ARGV[1], 'remain_count', ARGV[2]); redis.call('EXPIRE', KEYS[1], ARGV[3]);"
+	RedisClient.Eval(context.TODO(), pushActivityScript, []string{"relay:activity:" + activityID.String()}, "ExampleData", remainCount, 10).Result()
+
+	err := relayActivityV2(s.URL, activityID.String(), "0", "a.example.com,b.example.com")
+	if err == nil {
+		t.Fatal("Expected error to be reported for 404 response, but got nil")
+	}
+	for _, domain := range []string{"a.example.com", "b.example.com"} {
+		data, _ := RedisClient.HGet(context.TODO(), "relay:statistics:"+domain, "last_error").Result()
+		if data == "" {
+			t.Fatalf("Expected last_error to be saved for domain %s, but got empty string", domain)
+		}
+	}
+	exist, _ := RedisClient.Exists(context.TODO(), "relay:activity:"+activityID.String()).Result()
+	if exist != 0 {
+		t.Fatalf("Expected activity to be deleted after delivery to shared inbox, but still exists")
+	}
+}
+
+func TestRelayActivityRetryResp500(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+		w.Write(nil)
+	}))
+	defer s.Close()
+
+	activityID := uuid.New()
+	remainCount := 1
+
+	pushActivityScript := "redis.call('HSET',KEYS[1], 'body', ARGV[1], 'remain_count', ARGV[2]); redis.call('EXPIRE', KEYS[1], ARGV[3]);"
+	RedisClient.Eval(context.TODO(), pushActivityScript, []string{"relay:activity:" + activityID.String()}, "ExampleData", remainCount, 10).Result()
+	delayed, _ := RedisClient.ZCard(context.TODO(), "delayed_tasks").Result()
+
+	err := relayActivityV2(s.URL, activityID.String())
+	if err == nil {
+		t.Fatal("Expected error to be reported for 500 response, but got nil")
+	}
+
+	t.Run("Keep remain_count for pending retry", func(t *testing.T) {
+		data, _ := RedisClient.HGet(context.TODO(), "relay:activity:"+activityID.String(), "remain_count").Result()
+		if data != "1" {
+			t.Fatalf("Expected remain_count to be '1', but got '%s'", data)
+		}
+	})
+
+	t.Run("Extend activity ttl for pending retry", func(t *testing.T) {
+		ttl, _ := RedisClient.TTL(context.TODO(), "relay:activity:"+activityID.String()).Result()
+		if ttl <= 10*time.Second {
+			t.Fatalf("Expected activity ttl to be extended, but got %v", ttl)
+		}
+	})
+
+	t.Run("Enqueue delayed retry", func(t *testing.T) {
+		data, _ := RedisClient.ZCard(context.TODO(), "delayed_tasks").Result()
+		if data != delayed+1 {
+			t.Fatalf("Expected delayed tasks to be %d, but got %d", delayed+1, data)
+		}
+	})
+}
+
+func TestRelayActivityRetryExhausted(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+		w.Write(nil)
+	}))
+	defer s.Close()
+
+	activityID := uuid.New()
+	remainCount := 1
+
+	pushActivityScript := "redis.call('HSET',KEYS[1], 'body', ARGV[1], 'remain_count', ARGV[2]); redis.call('EXPIRE', KEYS[1], ARGV[3]);"
+	RedisClient.Eval(context.TODO(), pushActivityScript, []string{"relay:activity:" + activityID.String()}, "ExampleData", remainCount, 10).Result()
+
+	err := relayActivityV2(s.URL, activityID.String(), strconv.Itoa(GlobalConfig.RetryPolicy().Count))
+	if err == nil {
+		t.Fatal("Expected error to be reported for 500 response, but got nil")
+	}
+	exist, _ := RedisClient.Exists(context.TODO(), "relay:activity:"+activityID.String()).Result()
+	if exist != 0 {
+		t.Fatalf("Expected activity to be deleted after last retry, but still exists")
+	}
+}
+
+func TestRelayActivityNoRetryResp404(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		w.Write(nil)
+	}))
+	defer s.Close()
+
+	activityID := uuid.New()
+	remainCount := 1
+
+	pushActivityScript := "redis.call('HSET',KEYS[1], 'body', ARGV[1], 'remain_count', ARGV[2]); redis.call('EXPIRE', KEYS[1], ARGV[3]);"
+	RedisClient.Eval(context.TODO(), pushActivityScript, []string{"relay:activity:" + activityID.String()}, "ExampleData", remainCount, 10).Result()
+
+	err := relayActivityV2(s.URL, activityID.String())
+	if err == nil {
+		t.Fatal("Expected error to be reported for 404 response, but got nil")
+	}
+	exist, _ := RedisClient.Exists(context.TODO(), "relay:activity:"+activityID.String()).Result()
+	if exist != 0 {
+		t.Fatalf("Expected activity to be deleted without retry, but still exists")
+	}
+}
+
+func TestRelayActivityProcessedTwice(t *testing.T) {
+	delivered := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered++
+		w.WriteHeader(202)
+		w.Write(nil)
+	}))
+	defer s.Close()
+
+	activityID := uuid.New()
+	remainCount := 2
+
+	pushActivityScript := "redis.call('HSET',KEYS[1], 'body', ARGV[1], 'remain_count', ARGV[2]); redis.call('EXPIRE', KEYS[1], ARGV[3]);"
+	RedisClient.Eval(context.TODO(), pushActivityScript, []string{"relay:activity:" + activityID.String()}, "ExampleData", remainCount, 10).Result()
+
+	for i := 0; i < 2; i++ {
+		err := relayActivityV2(s.URL, activityID.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if delivered != 1 {
+		t.Fatalf("Expected activity to be delivered once, but got %d", delivered)
+	}
+	data, err := RedisClient.HGet(context.TODO(), "relay:activity:"+activityID.String(), "remain_count").Result()
+	if err != nil || data != "1" {
+		t.Fatalf("Expected remain_count to be '1', but got '%s' (%v)", data, err)
+	}
+}
+
 func TestRegisterActivity(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		data, _ := io.ReadAll(r.Body)
@@ -158,3 +308,120 @@ func TestRegisterActivityResp500(t *testing.T) {
 		t.Fatal("Expected error to be reported for 500 response, but got nil")
 	}
 }
+
+func TestRequeueRunningTasks(t *testing.T) {
+	RedisClient.Del(context.TODO(), "relay", "delayed_tasks").Result()
+
+	running := newRunningTasks()
+	finished := &tasks.Signature{UUID: "finished", Name: "register"}
+	eta := time.Now().Add(time.Hour)
+	unfinished := &tasks.Signature{
+		UUID:       "unfinished",
+		Name:       "register",
+		RetryCount: 2,
+		ETA:        &eta,
+		Args: []tasks.Arg{
+			{Name: "inboxURL", Type: "string", Value: "https://example.com/inbox"},
+			{Name: "body", Type: "string", Value: "{}"},
+		},
+	}
+	running.add(finished)
+	running.add(unfinished)
+	running.remove(finished)
+
+	requeued := running.requeue()
+	if requeued != 1 {
+		t.Fatalf("Expected 1 task to be requeued, but got %d", requeued)
+	}
+	delayed, _ := RedisClient.ZRange(context.TODO(), "delayed_tasks", 0, -1).Result()
+	if len(delayed) != 1 || !strings.Contains(delayed[0], `"UUID":"unfinished"`) || !strings.Contains(delayed[0], `"RetryCount":2`) {
+		t.Fatalf("Expected task to be delayed with same UUID and RetryCount, but got %v", delayed)
+	}
+	if len(running.signatures) != 0 {
+		t.Fatalf("Expected requeued task to be forgotten, but got %v", running.signatures)
+	}
+}
+
+func TestSyncBlocklistsUnfollowMembers(t *testing.T) {
+	RedisClient.FlushAll(context.TODO()).Result()
+	defer RedisClient.FlushAll(context.TODO()).Result()
+	relayState := models.NewState(RedisClient, false)
+	relayState.AddSubscriber(models.Subscriber{
+		Domain:     "suspended.example.com",
+		InboxURL:   "https://suspended.example.com/inbox",
+		ActivityID: "https://suspended.example.com/UUID",
+		ActorID:    "https://suspended.example.com/actor",
+	})
+	path := t.TempDir() + "/blocklist.txt"
+	os.WriteFile(path, []byte("suspended.example.com\n"), 0644)
+	relayState.SetBlocklistSource(models.BlocklistSource{Name: "source", Location: path, Format: models.PlainFormat})
+
+	syncBlocklists(&relayState, time.Minute)
+
+	relayState.Load()
+	if relayState.SelectSubscriber("suspended.example.com") != nil {
+		t.Fatalf("Expected subscriber of blocked domain to be unfollowed, but got %v", relayState.Subscribers)
+	}
+	queued, _ := RedisClient.LRange(context.TODO(), "relay", 0, -1).Result()
+	if len(queued) != 1 || !strings.Contains(queued[0], "Reject") || !strings.Contains(queued[0], "https://suspended.example.com/inbox") {
+		t.Fatalf("Expected Reject to be enqueued, but got %v", queued)
+	}
+}
+
+func TestStandaloneEntrypointWithoutRedis(t *testing.T) {
+	globalConfig, redisClient, machineryServer, queue, activities, httpClient := GlobalConfig, RedisClient, MachineryServer, Queue, Activities, HttpClient
+	redisURL, stateStorage := viper.GetString("REDIS_URL"), viper.GetString("STATE_STORAGE")
+	defer func() {
+		GlobalConfig, RedisClient, MachineryServer, Queue, Activities, HttpClient = globalConfig, redisClient, machineryServer, queue, activities, httpClient
+		viper.Set("REDIS_URL", redisURL)
+		viper.Set("STATE_STORAGE", stateStorage)
+	}()
+	viper.Set("REDIS_URL", "")
+	viper.Set("STATE_STORAGE", "memory")
+	config, err := models.NewRelayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delivered := make(chan string, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		delivered <- string(body)
+		w.WriteHeader(202)
+	}))
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	memoryQueue := models.NewMemoryQueue()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- StandaloneEntrypoint(ctx, config, "test", memoryQueue)
+	}()
+
+	config.ActivityStore().Push("activity", []byte("ExampleData"), 1, time.Minute)
+	memoryQueue.SendTask(&tasks.Signature{
+		Name: "relay-v2",
+		Args: []tasks.Arg{
+			{Name: "inboxURL", Type: "string", Value: s.URL},
+			{Name: "activityID", Type: "string", Value: "activity"},
+		},
+	})
+	select {
+	case body := <-delivered:
+		if body != "ExampleData" {
+			t.Fatalf("Expected body 'ExampleData', but got '%s'", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected activity to be delivered without Redis, but timed out")
+	}
+
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("Expected standalone to stop without error, but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected standalone to stop, but timed out")
+	}
+}
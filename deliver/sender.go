@@ -2,17 +2,21 @@ package deliver
 
 import (
 	"bytes"
-	"crypto/rsa"
+	"context"
+	"crypto"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/Songmu/go-httpdate"
 	"github.com/go-fed/httpsig"
+	"github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
+	"github.com/yukimochi/Activity-Relay/models"
 )
 
 func compatibilityForHTTPSignature11(request *http.Request, algorithm httpsig.Algorithm) {
@@ -22,10 +26,11 @@ func compatibilityForHTTPSignature11(request *http.Request, algorithm httpsig.Al
 	request.Header.Set("Signature", signature)
 }
 
-func appendSignature(request *http.Request, body *[]byte, KeyID string, privateKey *rsa.PrivateKey) error {
+func appendSignature(request *http.Request, body *[]byte, KeyID string, privateKey crypto.PrivateKey) error {
 	request.Header.Set("Host", request.Host)
 
-	signer, _, err := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, httpsig.DigestSha256, []string{httpsig.RequestTarget, "Host", "Date", "Digest", "Content-Type"}, httpsig.Signature, 60*60)
+	algorithm := models.HTTPSignatureAlgorithm(privateKey)
+	signer, _, err := httpsig.NewSigner([]httpsig.Algorithm{algorithm}, httpsig.DigestSha256, []string{httpsig.RequestTarget, "Host", "Date", "Digest", "Content-Type"}, httpsig.Signature, 60*60)
 	if err != nil {
 		return err
 	}
@@ -33,16 +38,146 @@ func appendSignature(request *http.Request, body *[]byte, KeyID string, privateK
 	if err != nil {
 		return err
 	}
-	compatibilityForHTTPSignature11(request, httpsig.RSA_SHA256) // Compatibility for Misskey <12.111.0
+	compatibilityForHTTPSignature11(request, algorithm) // Compatibility for Misskey <12.111.0
 	return nil
 }
 
-func sendActivity(inboxURL string, KeyID string, body []byte, privateKey *rsa.PrivateKey) error {
+func appendSignatureRFC9421(request *http.Request, body *[]byte, KeyID string, privateKey crypto.PrivateKey) error {
+	return models.SignRequestRFC9421(request, *body, KeyID, privateKey, 60*60*time.Second)
+}
+
+const (
+	signatureSchemeRFC9421 = "rfc9421"
+	signatureSchemeCavage  = "cavage"
+)
+
+// signatureSchemeTTL : Remember accepted scheme. Cavage is remembered for host which rejected RFC 9421 to stop knocking twice.
+var signatureSchemeTTL = map[string]time.Duration{
+	signatureSchemeRFC9421: 30 * 24 * time.Hour,
+	signatureSchemeCavage:  7 * 24 * time.Hour,
+}
+
+// localSignatureSchemes : Accepted schemes remembered in process without Redis
+var localSignatureSchemes = cache.New(24*time.Hour, time.Hour)
+
+// rememberedSignatureScheme : Scheme remembered for host, or empty when host is unknown
+func rememberedSignatureScheme(host string) string {
+	if RedisClient == nil {
+		if value, ok := localSignatureSchemes.Get(host); ok {
+			return value.(string)
+		}
+		return ""
+	}
+	scheme, _ := RedisClient.Get(context.TODO(), "relay:signature:"+host).Result()
+	return scheme
+}
+
+// selectSignatureScheme : RFC 9421 for host known to accept it, otherwise draft-cavage which most servers accept
+func selectSignatureScheme(host string) string {
+	if rememberedSignatureScheme(host) == signatureSchemeRFC9421 {
+		return signatureSchemeRFC9421
+	}
+	return signatureSchemeCavage
+}
+
+func rememberSignatureScheme(host string, scheme string) {
+	if RedisClient == nil {
+		localSignatureSchemes.Set(host, scheme, signatureSchemeTTL[scheme])
+		return
+	}
+	RedisClient.Set(context.TODO(), "relay:signature:"+host, scheme, signatureSchemeTTL[scheme]).Result()
+}
+
+// deliveryError : Failed delivery with response status (0 for no response, -1 for request not sent)
+type deliveryError struct {
+	message    string
+	statusCode int
+}
+
+func (e *deliveryError) Error() string {
+	return e.message
+}
+
+// Temporary : Failure may be resolved by retrying later
+func (e *deliveryError) Temporary() bool {
+	switch {
+	case e.statusCode == 0, e.statusCode >= 500:
+		return true
+	case e.statusCode == http.StatusRequestTimeout, e.statusCode == http.StatusTooManyRequests:
+		return true
+	}
+	return false
+}
+
+// SignatureRejected : Failure may be caused by unsupported signature scheme
+func (e *deliveryError) SignatureRejected() bool {
+	switch e.statusCode {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	}
+	return false
+}
+
+func isTemporaryError(err error) bool {
+	var deliveryErr *deliveryError
+	if errors.As(err, &deliveryErr) {
+		return deliveryErr.Temporary()
+	}
+	return false
+}
+
+func sendActivity(inboxURL string, KeyID string, body []byte, privateKey crypto.PrivateKey) error {
+	host := inboxURL
+	if parsedURL, err := url.Parse(inboxURL); err == nil {
+		host = parsedURL.Host
+	}
+
+	// Double-knocking : Retry with another signature scheme when signature seems to be rejected. Both attempts are counted as one delivery.
+	start := time.Now()
+	scheme := selectSignatureScheme(host)
+	response, err := sendSignedActivity(inboxURL, KeyID, body, privateKey, scheme)
+	var deliveryErr *deliveryError
+	if errors.As(err, &deliveryErr) && deliveryErr.SignatureRejected() {
+		fallbackScheme := signatureSchemeCavage
+		if scheme == signatureSchemeCavage {
+			fallbackScheme = signatureSchemeRFC9421
+		}
+		response, err = sendSignedActivity(inboxURL, KeyID, body, privateKey, fallbackScheme)
+		if err == nil {
+			logrus.Debug(inboxURL, " accepts ", fallbackScheme, " signature")
+			rememberSignatureScheme(host, fallbackScheme)
+		}
+	} else if err == nil && scheme == signatureSchemeCavage && response.Header.Get("Accept-Signature") != "" && rememberedSignatureScheme(host) == "" {
+		// Inbox advertises RFC 9421 by Accept-Signature header
+		logrus.Debug(inboxURL, " accepts ", signatureSchemeRFC9421, " signature")
+		rememberSignatureScheme(host, signatureSchemeRFC9421)
+	}
+
+	if response != nil {
+		models.DeliveryDuration.WithLabelValues(host).Observe(time.Since(start).Seconds())
+		models.Deliveries.WithLabelValues(host, strconv.Itoa(response.StatusCode/100)+"xx").Inc()
+	} else {
+		models.Deliveries.WithLabelValues(host, "error").Inc()
+	}
+	return err
+}
+
+// sendSignedActivity : Send activity signed with scheme. Response is nil when request is not sent or no response is received.
+func sendSignedActivity(inboxURL string, KeyID string, body []byte, privateKey crypto.PrivateKey, scheme string) (*http.Response, error) {
 	req, _ := http.NewRequest("POST", inboxURL, bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/activity+json")
 	req.Header.Set("User-Agent", fmt.Sprintf("%s (golang net/http; Activity-Relay %s; %s)", GlobalConfig.ServerServiceName(), version, GlobalConfig.ServerHostname().Host))
 	req.Header.Set("Date", httpdate.Time2Str(time.Now()))
-	appendSignature(req, &body, KeyID, privateKey)
+	var err error
+	if scheme == signatureSchemeRFC9421 {
+		err = appendSignatureRFC9421(req, &body, KeyID, privateKey)
+	} else {
+		err = appendSignature(req, &body, KeyID, privateKey)
+	}
+	if err != nil {
+		// Signing fails again on retry, so it is not temporary
+		return nil, &deliveryError{inboxURL + ": failed to sign request: " + err.Error(), -1}
+	}
 	resp, err := HttpClient.Do(req)
 	if err != nil {
 		urlErr := err.(*url.Error)
@@ -53,14 +188,14 @@ func sendActivity(inboxURL string, KeyID string, body []byte, privateKey *rsa.Pr
 		} else {
 			errMsg = urlErr.Unwrap().Error()
 		}
-		return errors.New(inboxURL + ": " + errMsg)
+		return nil, &deliveryError{inboxURL + ": " + errMsg, 0}
 	}
 	defer resp.Body.Close()
 
 	logrus.Debug(inboxURL, " ", resp.StatusCode)
 	if resp.StatusCode/100 != 2 {
-		return errors.New(inboxURL + ": " + resp.Status)
+		return resp, &deliveryError{inboxURL + ": " + resp.Status, resp.StatusCode}
 	}
 
-	return nil
+	return resp, nil
 }
@@ -2,10 +2,13 @@ package deliver
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"regexp"
 	"testing"
@@ -13,6 +16,8 @@ import (
 
 	"github.com/Songmu/go-httpdate"
 	"github.com/go-fed/httpsig"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/yukimochi/Activity-Relay/models"
 )
 
 func TestAppendSignature(t *testing.T) {
@@ -51,3 +56,175 @@ func TestAppendSignature(t *testing.T) {
 		t.Fatalf("Expected Digest header to be '%s', but got '%s'", calculatedDigest, givenDigest)
 	}
 }
+
+func TestAppendSignatureEd25519(t *testing.T) {
+	privateKey, _ := models.GeneratePrivateKey(models.Ed25519Key)
+	body := []byte("data")
+	req, _ := http.NewRequest("POST", "https://localhost", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/activity+json")
+	req.Header.Set("Date", httpdate.Time2Str(time.Now()))
+	appendSignature(req, &body, "https://relay.example.com/actor#main-key", privateKey)
+
+	verifier, err := httpsig.NewVerifier(req)
+	if err != nil {
+		t.Fatalf("Failed to create HTTPSignature verifier: %v", err)
+	}
+	err = verifier.Verify(privateKey.Public(), httpsig.ED25519)
+	if err != nil {
+		t.Fatalf("HTTPSignature verification failed: %v", err)
+	}
+}
+
+func TestAppendSignatureRFC9421(t *testing.T) {
+	file, _ := os.Open("../misc/test/create.json")
+	body, _ := io.ReadAll(file)
+	req, _ := http.NewRequest("POST", "https://localhost/inbox", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/activity+json")
+	appendSignatureRFC9421(req, &body, "https://toot.yukimochi.jp/users/YUKIMOCHI#main-key", GlobalConfig.ActorKey())
+
+	verifier, err := models.NewRFC9421Verifier(req)
+	if err != nil {
+		t.Fatalf("Failed to create RFC 9421 verifier: %v", err)
+	}
+	err = verifier.Verify(GlobalConfig.ActorKey().Public())
+	if err != nil {
+		t.Fatalf("RFC 9421 signature verification failed: %v", err)
+	}
+	err = models.VerifyContentDigest(req.Header.Get("Content-Digest"), body)
+	if err != nil {
+		t.Fatalf("Content-Digest verification failed: %v", err)
+	}
+}
+
+func TestSendActivityCavageByDefault(t *testing.T) {
+	RedisClient.FlushAll(context.TODO()).Result()
+
+	var signatureInputs []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signatureInputs = append(signatureInputs, r.Header.Get("Signature-Input"))
+		w.WriteHeader(202)
+		w.Write(nil)
+	}))
+	defer s.Close()
+
+	err := sendActivity(s.URL, "https://relay.example.com/actor#main-key", []byte("data"), GlobalConfig.ActorKey())
+	if err != nil {
+		t.Fatalf("Expected sendActivity to succeed, but got error: %v", err)
+	}
+	if len(signatureInputs) != 1 || signatureInputs[0] != "" {
+		t.Fatalf("Expected single draft-cavage signed request to unknown host, but got %v", signatureInputs)
+	}
+	host, _ := url.Parse(s.URL)
+	scheme, _ := RedisClient.Get(context.TODO(), "relay:signature:"+host.Host).Result()
+	if scheme != "" {
+		t.Fatalf("Expected signature scheme not to be remembered, but got '%s'", scheme)
+	}
+}
+
+func TestSendActivityAcceptSignature(t *testing.T) {
+	RedisClient.FlushAll(context.TODO()).Result()
+
+	var signatureInputs []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signatureInputs = append(signatureInputs, r.Header.Get("Signature-Input"))
+		w.Header().Set("Accept-Signature", `sig1=("@method" "@target-uri" "content-digest")`)
+		w.WriteHeader(202)
+		w.Write(nil)
+	}))
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		err := sendActivity(s.URL, "https://relay.example.com/actor#main-key", []byte("data"), GlobalConfig.ActorKey())
+		if err != nil {
+			t.Fatalf("Expected sendActivity to succeed, but got error: %v", err)
+		}
+	}
+	if len(signatureInputs) != 2 || signatureInputs[0] != "" || signatureInputs[1] == "" {
+		t.Fatalf("Expected RFC 9421 after inbox advertises Accept-Signature, but got %v", signatureInputs)
+	}
+}
+
+func TestSendActivityRFC9421(t *testing.T) {
+	RedisClient.FlushAll(context.TODO()).Result()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Signature-Input") == "" {
+			w.WriteHeader(401)
+			w.Write(nil)
+		} else {
+			w.WriteHeader(202)
+			w.Write(nil)
+		}
+	}))
+	defer s.Close()
+
+	host, _ := url.Parse(s.URL)
+	err := sendActivity(s.URL, "https://relay.example.com/actor#main-key", []byte("data"), GlobalConfig.ActorKey())
+	if err != nil {
+		t.Fatalf("Expected sendActivity to succeed with fallback, but got error: %v", err)
+	}
+	scheme, _ := RedisClient.Get(context.TODO(), "relay:signature:"+host.Host).Result()
+	if scheme != "rfc9421" {
+		t.Fatalf("Expected signature scheme to be remembered as rfc9421, but got '%s'", scheme)
+	}
+}
+
+func TestSendActivityFallbackCavage(t *testing.T) {
+	RedisClient.FlushAll(context.TODO()).Result()
+
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Signature-Input") != "" {
+			w.WriteHeader(401)
+			w.Write(nil)
+		} else {
+			w.WriteHeader(202)
+			w.Write(nil)
+		}
+	}))
+	defer s.Close()
+
+	host, _ := url.Parse(s.URL)
+	rememberSignatureScheme(host.Host, "rfc9421")
+	delivered := testutil.ToFloat64(models.Deliveries.WithLabelValues(host.Host, "2xx"))
+	rejected := testutil.ToFloat64(models.Deliveries.WithLabelValues(host.Host, "4xx"))
+	err := sendActivity(s.URL, "https://relay.example.com/actor#main-key", []byte("data"), GlobalConfig.ActorKey())
+	if err != nil {
+		t.Fatalf("Expected sendActivity to succeed with fallback, but got error: %v", err)
+	}
+	scheme, _ := RedisClient.Get(context.TODO(), "relay:signature:"+host.Host).Result()
+	if scheme != "cavage" {
+		t.Fatalf("Expected signature scheme to be remembered as cavage, but got '%s'", scheme)
+	}
+	if testutil.ToFloat64(models.Deliveries.WithLabelValues(host.Host, "2xx")) != delivered+1 || testutil.ToFloat64(models.Deliveries.WithLabelValues(host.Host, "4xx")) != rejected {
+		t.Fatalf("Expected fallback to be counted as one successful delivery")
+	}
+
+	// Remembered scheme is used without knocking twice
+	requests = 0
+	err = sendActivity(s.URL, "https://relay.example.com/actor#main-key", []byte("data"), GlobalConfig.ActorKey())
+	if err != nil || requests != 1 {
+		t.Fatalf("Expected single request with remembered scheme, but got %d requests (%v)", requests, err)
+	}
+}
+
+func TestSendActivitySigningFailure(t *testing.T) {
+	RedisClient.FlushAll(context.TODO()).Result()
+
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(202)
+		w.Write(nil)
+	}))
+	defer s.Close()
+
+	err := sendActivity(s.URL, "https://relay.example.com/actor#main-key", []byte("data"), "unsupported key")
+	if err == nil || isTemporaryError(err) {
+		t.Fatalf("Expected non-temporary error for signing failure, but got %v", err)
+	}
+	if requests != 0 {
+		t.Fatalf("Expected unsigned request not to be sent, but got %d requests", requests)
+	}
+}
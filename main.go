@@ -11,6 +11,10 @@ Job Worker
 
 	./Activity-Relay --config /path/to/config.yml worker
 
+Standalone (API Server and Job Worker in single process)
+
+	./Activity-Relay --config /path/to/config.yml standalone
+
 CLI Management Utility
 
 	./Activity-Relay --config /path/to/config.yml control
@@ -25,29 +29,53 @@ YAML Format
 	RELAY_DOMAIN: relay.toot.yukimochi.jp
 	RELAY_SERVICENAME: YUKIMOCHI Toot Relay Service
 	JOB_CONCURRENCY: 50
+	JOB_RETRY_COUNT: 5
+	JOB_RETRY_INTERVAL: 30
+	JOB_RETRY_MAX_INTERVAL: 3600
 	RELAY_SUMMARY: |
 		YUKIMOCHI Toot Relay Service is Running by Activity-Relay
 	RELAY_ICON: https://example.com/example_icon.png
 	RELAY_IMAGE: https://example.com/example_image.png
+	METRICS_BIND: 127.0.0.1:9090
+	SIGNATURE_CLOCK_SKEW: 300
+	ACTIVITY_DEDUPE_TTL: 86400
+	SHUTDOWN_TIMEOUT: 30
+	STATE_STORAGE: redis
+	SQLITE_PATH: relay.db
+	BLOCKLIST_SYNC_INTERVAL: 3600
 
 # Environment Variable
 
 This is Optional : When config file not exist, use environment variables.
   - ACTOR_PEM
+  - ACTOR_KEY
   - REDIS_URL
   - RELAY_BIND
   - RELAY_DOMAIN
   - RELAY_SERVICENAME
   - JOB_CONCURRENCY
+  - JOB_RETRY_COUNT
+  - JOB_RETRY_INTERVAL
+  - JOB_RETRY_MAX_INTERVAL
   - RELAY_SUMMARY
   - RELAY_ICON
   - RELAY_IMAGE
+  - METRICS_BIND
+  - SIGNATURE_CLOCK_SKEW
+  - ACTIVITY_DEDUPE_TTL
+  - SHUTDOWN_TIMEOUT
+  - STATE_STORAGE
+  - SQLITE_PATH
+  - BLOCKLIST_SYNC_INTERVAL
 */
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -108,6 +136,21 @@ func buildCommand() *cobra.Command {
 		},
 	}
 
+	var standalone = &cobra.Command{
+		Use:   "standalone",
+		Short: "Activity-Relay API Server and Job Worker in single process",
+		Long:  "Activity-Relay Standalone is providing API Server and Job Worker with in-process job queue. Redis is optional when STATE_STORAGE is sqlite or memory; Redis-only features are disabled without it.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			initConfig(cmd)
+			fmt.Println(GlobalConfig.DumpWelcomeMessage("Standalone", version))
+			err := runStandalone()
+			if err != nil {
+				logrus.Fatal(err.Error())
+			}
+			return nil
+		},
+	}
+
 	var command = &cobra.Command{
 		Use:   "control",
 		Short: "Activity-Relay CLI",
@@ -121,11 +164,32 @@ func buildCommand() *cobra.Command {
 	}
 	app.AddCommand(server)
 	app.AddCommand(worker)
+	app.AddCommand(standalone)
 	app.AddCommand(command)
 
 	return app
 }
 
+// runStandalone : Run API Server and Job Worker sharing in-process queue. Job Worker stops after API Server finishes pending enqueues.
+func runStandalone() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	queue := models.NewMemoryQueue()
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	workerErr := make(chan error, 1)
+	go func() {
+		workerErr <- deliver.StandaloneEntrypoint(workerCtx, GlobalConfig, version, queue)
+	}()
+
+	err := api.StandaloneEntrypoint(ctx, GlobalConfig, version, queue)
+	stopWorker()
+	if workerErr := <-workerErr; workerErr != nil {
+		logrus.Error(workerErr)
+	}
+	return err
+}
+
 func initConfig(cmd *cobra.Command) {
 	if verbose {
 		logrus.SetLevel(logrus.DebugLevel)
@@ -142,6 +206,7 @@ func initConfig(cmd *cobra.Command) {
 		logrus.Warn("Config file not exist. Use environment variables.")
 
 		viper.BindEnv("ACTOR_PEM")
+		viper.BindEnv("ACTOR_KEY")
 		viper.BindEnv("REDIS_URL")
 		viper.BindEnv("RELAY_BIND")
 		viper.BindEnv("RELAY_DOMAIN")
@@ -150,6 +215,16 @@ func initConfig(cmd *cobra.Command) {
 		viper.BindEnv("RELAY_SUMMARY")
 		viper.BindEnv("RELAY_ICON")
 		viper.BindEnv("RELAY_IMAGE")
+		viper.BindEnv("JOB_RETRY_COUNT")
+		viper.BindEnv("JOB_RETRY_INTERVAL")
+		viper.BindEnv("JOB_RETRY_MAX_INTERVAL")
+		viper.BindEnv("METRICS_BIND")
+		viper.BindEnv("SIGNATURE_CLOCK_SKEW")
+		viper.BindEnv("ACTIVITY_DEDUPE_TTL")
+		viper.BindEnv("SHUTDOWN_TIMEOUT")
+		viper.BindEnv("STATE_STORAGE")
+		viper.BindEnv("SQLITE_PATH")
+		viper.BindEnv("BLOCKLIST_SYNC_INTERVAL")
 	}
 
 	GlobalConfig, err = models.NewRelayConfig()
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var errActivityExpired = errors.New("activity ttl expired")
+
+// ActivityStore : Bodies of relayed activities shared by deliveries to inboxes. Body is deleted when deliveries to all inboxes are done or ttl expires.
+type ActivityStore interface {
+	// Push : Store body delivered to count inboxes for ttl
+	Push(id string, body []byte, count int, ttl time.Duration) error
+	// Body : Body of activity and whether delivery to inbox is already done. Error when body is expired.
+	Body(id string, inboxURL string) ([]byte, bool, error)
+	// Done : Mark delivery to inbox done. Remaining count is reduced once per inbox, and body is deleted when it reaches zero.
+	Done(id string, inboxURL string) error
+	// Extend : Keep body at least for ttl, until pending retry is processed
+	Extend(id string, ttl time.Duration) error
+}
+
+// RedisActivityStore : ActivityStore shared by API Server and Job Workers through Redis
+type RedisActivityStore struct {
+	redisClient *redis.Client
+}
+
+// NewRedisActivityStore : Create RedisActivityStore
+func NewRedisActivityStore(redisClient *redis.Client) *RedisActivityStore {
+	return &RedisActivityStore{redisClient}
+}
+
+func (store *RedisActivityStore) Push(id string, body []byte, count int, ttl time.Duration) error {
+	pushActivityScript := "redis.call('HSET',KEYS[1], 'body', ARGV[1], 'remain_count', ARGV[2]); redis.call('EXPIRE', KEYS[1], ARGV[3]);"
+	return evalScript(store.redisClient, pushActivityScript, []string{"relay:activity:" + id}, body, count, int(ttl.Seconds()))
+}
+
+func (store *RedisActivityStore) Body(id string, inboxURL string) ([]byte, bool, error) {
+	fields, err := store.redisClient.HMGet(context.TODO(), "relay:activity:"+id, "body", "done:"+inboxURL).Result()
+	if err != nil || fields[0] == nil {
+		return nil, false, errActivityExpired
+	}
+	return []byte(fields[0].(string)), fields[1] != nil, nil
+}
+
+func (store *RedisActivityStore) Done(id string, inboxURL string) error {
+	reductionRemainCountScript := "if redis.call('EXISTS', KEYS[1]) == 1 and redis.call('HSETNX', KEYS[1], 'done:' .. ARGV[1], 1) == 1 then local remain_count = redis.call('HINCRBY', KEYS[1], 'remain_count', -1); if remain_count < 1 then redis.call('DEL', KEYS[1]) end end;"
+	return evalScript(store.redisClient, reductionRemainCountScript, []string{"relay:activity:" + id}, inboxURL)
+}
+
+func (store *RedisActivityStore) Extend(id string, ttl time.Duration) error {
+	extendTTLScript := "local ttl = redis.call('TTL', KEYS[1]); if ttl >= 0 and ttl < tonumber(ARGV[1]) then redis.call('EXPIRE', KEYS[1], ARGV[1]) end;"
+	return evalScript(store.redisClient, extendTTLScript, []string{"relay:activity:" + id}, strconv.Itoa(int(ttl.Seconds())))
+}
+
+// evalScript : Run Lua script returning nothing
+func evalScript(redisClient *redis.Client, script string, keys []string, args ...interface{}) error {
+	err := redisClient.Eval(context.TODO(), script, keys, args...).Err()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
+
+// MemoryActivityStore : ActivityStore kept in process memory, for standalone mode without Redis
+type MemoryActivityStore struct {
+	mutex      sync.Mutex
+	activities map[string]*memoryActivity
+}
+
+type memoryActivity struct {
+	body    []byte
+	remain  int
+	done    map[string]bool
+	expires time.Time
+}
+
+// NewMemoryActivityStore : Create empty MemoryActivityStore
+func NewMemoryActivityStore() *MemoryActivityStore {
+	return &MemoryActivityStore{activities: make(map[string]*memoryActivity)}
+}
+
+func (store *MemoryActivityStore) Push(id string, body []byte, count int, ttl time.Duration) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	now := time.Now()
+	for key, activity := range store.activities {
+		if !now.Before(activity.expires) {
+			delete(store.activities, key)
+		}
+	}
+	store.activities[id] = &memoryActivity{body, count, make(map[string]bool), now.Add(ttl)}
+	return nil
+}
+
+// activity : Activity not expired yet, or nil. Caller must hold mutex.
+func (store *MemoryActivityStore) activity(id string) *memoryActivity {
+	activity, ok := store.activities[id]
+	if !ok {
+		return nil
+	}
+	if !time.Now().Before(activity.expires) {
+		delete(store.activities, id)
+		return nil
+	}
+	return activity
+}
+
+func (store *MemoryActivityStore) Body(id string, inboxURL string) ([]byte, bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	activity := store.activity(id)
+	if activity == nil {
+		return nil, false, errActivityExpired
+	}
+	return activity.body, activity.done[inboxURL], nil
+}
+
+func (store *MemoryActivityStore) Done(id string, inboxURL string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	activity := store.activity(id)
+	if activity == nil || activity.done[inboxURL] {
+		return nil
+	}
+	activity.done[inboxURL] = true
+	activity.remain--
+	if activity.remain < 1 {
+		delete(store.activities, id)
+	}
+	return nil
+}
+
+func (store *MemoryActivityStore) Extend(id string, ttl time.Duration) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	activity := store.activity(id)
+	if activity != nil && activity.expires.Before(time.Now().Add(ttl)) {
+		activity.expires = time.Now().Add(ttl)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestActivityStore(t *testing.T) {
+	t.Run("MemoryActivityStore", func(t *testing.T) {
+		testActivityStore(t, NewMemoryActivityStore())
+	})
+	t.Run("RedisActivityStore", func(t *testing.T) {
+		relayState.RedisClient.FlushAll(context.TODO()).Result()
+		defer relayState.RedisClient.FlushAll(context.TODO()).Result()
+		testActivityStore(t, NewRedisActivityStore(relayState.RedisClient))
+	})
+}
+
+func testActivityStore(t *testing.T, store ActivityStore) {
+	err := store.Push("activity", []byte("ExampleData"), 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, done, err := store.Body("activity", "https://example.com/inbox")
+	if err != nil || string(body) != "ExampleData" || done {
+		t.Fatalf("Expected body 'ExampleData' not done, but got '%s' %v %v", body, done, err)
+	}
+
+	store.Done("activity", "https://example.com/inbox")
+	store.Done("activity", "https://example.com/inbox")
+	_, done, err = store.Body("activity", "https://example.com/inbox")
+	if err != nil || !done {
+		t.Fatalf("Expected body to be kept and marked done once, but got %v %v", done, err)
+	}
+
+	err = store.Extend("activity", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Done("activity", "https://example.org/inbox")
+	_, _, err = store.Body("activity", "https://example.org/inbox")
+	if err == nil {
+		t.Fatal("Expected body to be deleted after all deliveries, but found")
+	}
+
+	_, _, err = store.Body("unknown", "https://example.com/inbox")
+	if err == nil {
+		t.Fatal("Expected error for unknown activity, but got nil")
+	}
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// ActorKeyGracePeriod : Default period to keep serving previous actor key after rotation
+const ActorKeyGracePeriod = 7 * 24 * time.Hour
+
+const (
+	actorKeyHash         = "relay:actorKey"
+	previousActorKeyHash = "relay:actorKey:previous"
+	actorKeyChannel      = "relay_actor_key"
+	defaultActorKeyName  = "main-key"
+	actorKeyBits         = 2048
+)
+
+// PreviousActorKey : Rotated actor key, served with current key until Expires
+type PreviousActorKey struct {
+	ID        string
+	PublicKey crypto.PublicKey
+	Expires   time.Time
+}
+
+// ActorKeyID is key ID of current HTTPSignature private key.
+func (relayConfig *RelayConfig) ActorKeyID() string {
+	keyID, _ := relayConfig.ActorSigningKey()
+	return keyID
+}
+
+// ActorSigningKey is key ID and private key of relay actor, read together to sign requests during rotation.
+func (relayConfig *RelayConfig) ActorSigningKey() (string, crypto.Signer) {
+	relayConfig.actorKeyMutex.RLock()
+	defer relayConfig.actorKeyMutex.RUnlock()
+	return relayConfig.actorKeyURL(relayConfig.actorKeyName), relayConfig.actorKey
+}
+
+// PreviousActorKey is rotated actor key within grace period, or nil.
+func (relayConfig *RelayConfig) PreviousActorKey() *PreviousActorKey {
+	relayConfig.actorKeyMutex.RLock()
+	defer relayConfig.actorKeyMutex.RUnlock()
+	previous := relayConfig.previousActorKey
+	if previous == nil || !time.Now().Before(previous.Expires) {
+		return nil
+	}
+	return previous
+}
+
+func (relayConfig *RelayConfig) actorKeyURL(name string) string {
+	return relayConfig.domain.String() + "/actor#" + name
+}
+
+// ActorKeyOverridden : Rotated actor key in Redis is used instead of key of ACTOR_KEY, ACTOR_PEM or Docker secret.
+func (relayConfig *RelayConfig) ActorKeyOverridden() bool {
+	relayConfig.actorKeyMutex.RLock()
+	defer relayConfig.actorKeyMutex.RUnlock()
+	if relayConfig.actorKeyName == defaultActorKeyName {
+		return false
+	}
+	publicKey, ok := relayConfig.actorKey.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return !ok || !publicKey.Equal(relayConfig.configuredKey.Public())
+}
+
+// LoadActorKey : Load rotated actor key from Redis. Rotated key takes precedence over key of ACTOR_KEY, ACTOR_PEM or Docker secret, which is used until first rotation.
+func (relayConfig *RelayConfig) LoadActorKey() error {
+	if relayConfig.redisClient == nil {
+		return nil
+	}
+	current, err := relayConfig.redisClient.HGetAll(context.TODO(), actorKeyHash).Result()
+	if err != nil {
+		return err
+	}
+	previous, err := relayConfig.redisClient.HGetAll(context.TODO(), previousActorKeyHash).Result()
+	if err != nil {
+		return err
+	}
+
+	relayConfig.actorKeyMutex.Lock()
+	defer relayConfig.actorKeyMutex.Unlock()
+	if current["privateKeyPem"] != "" {
+		privateKey, err := ReadPrivateKeyFromString(current["privateKeyPem"])
+		if err != nil {
+			return errors.New("rotated actor key is invalid: " + err.Error())
+		}
+		relayConfig.actorKey = privateKey
+		relayConfig.actorKeyName = current["id"]
+	}
+	relayConfig.previousActorKey = nil
+	if previous["publicKeyPem"] != "" {
+		publicKey, err := ReadPublicKeyFromString(previous["publicKeyPem"])
+		if err != nil {
+			return err
+		}
+		expires, _ := strconv.ParseInt(previous["expires"], 10, 64)
+		relayConfig.previousActorKey = &PreviousActorKey{
+			ID:        relayConfig.actorKeyURL(previous["id"]),
+			PublicKey: publicKey,
+			Expires:   time.Unix(expires, 0),
+		}
+	}
+	return nil
+}
+
+// RotateActorKey : Generate new actor key of same type, keep current one as previous key for grace period and notify other processes. Return new key ID.
+func (relayConfig *RelayConfig) RotateActorKey(grace time.Duration) (string, error) {
+	if relayConfig.redisClient == nil {
+		return "", errRedisRequired
+	}
+	relayConfig.actorKeyMutex.RLock()
+	previousName := relayConfig.actorKeyName
+	previousKey := relayConfig.actorKey
+	relayConfig.actorKeyMutex.RUnlock()
+
+	name := "key-" + strconv.FormatInt(time.Now().Unix(), 10)
+	if name == previousName {
+		return "", errors.New("actor key was rotated just now")
+	}
+	privateKey, err := GeneratePrivateKey(PrivateKeyType(previousKey))
+	if err != nil {
+		return "", err
+	}
+	privateKeyPem, err := GeneratePrivateKeyPEMString(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	expires := time.Now().Add(grace)
+	_, err = relayConfig.redisClient.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.Del(context.TODO(), previousActorKeyHash)
+		if grace > 0 {
+			pipe.HSet(context.TODO(), previousActorKeyHash, map[string]interface{}{
+				"id":           previousName,
+				"publicKeyPem": generatePublicKeyPEMString(previousKey.Public()),
+				"expires":      expires.Unix(),
+			})
+			pipe.ExpireAt(context.TODO(), previousActorKeyHash, expires)
+		}
+		pipe.HSet(context.TODO(), actorKeyHash, map[string]interface{}{
+			"id":            name,
+			"privateKeyPem": privateKeyPem,
+		})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = relayConfig.LoadActorKey()
+	if err != nil {
+		return "", err
+	}
+	relayConfig.redisClient.Publish(context.TODO(), actorKeyChannel, name)
+	return relayConfig.actorKeyURL(name), nil
+}
+
+// ListenActorKey : Reload actor key when rotated by other process or previous key expires, then call onChange.
+func (relayConfig *RelayConfig) ListenActorKey(onChange func()) {
+	if relayConfig.redisClient == nil {
+		return
+	}
+	subscription := relayConfig.redisClient.Subscribe(context.TODO(), actorKeyChannel)
+	_, err := subscription.Receive(context.TODO())
+	if err != nil {
+		panic(err)
+	}
+
+	reload := func() {
+		err := relayConfig.LoadActorKey()
+		if err != nil {
+			logrus.Error("Failed to reload actor key : ", err)
+			return
+		}
+		onChange()
+	}
+	ch := subscription.Channel()
+	go func() {
+		var expiry *time.Timer
+		for {
+			if expiry != nil {
+				expiry.Stop()
+			}
+			if previous := relayConfig.PreviousActorKey(); previous != nil {
+				expiry = time.AfterFunc(time.Until(previous.Expires), reload)
+			}
+			if _, ok := <-ch; !ok {
+				return
+			}
+			reload()
+		}
+	}()
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestRotateActorKey(t *testing.T) {
+	relayState.RedisClient.FlushAll(context.TODO()).Result()
+	defer relayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	relayConfig, _ := NewRelayConfig()
+	listener, _ := NewRelayConfig()
+	changed := make(chan bool, 1)
+	listener.ListenActorKey(func() { changed <- true })
+
+	previousKeyID, previousKey := relayConfig.ActorSigningKey()
+	if previousKeyID != "https://relay.toot.yukimochi.jp/actor#main-key" {
+		t.Fatalf("Expected key ID of ACTOR_PEM to be main-key, but got %s", previousKeyID)
+	}
+	if relayConfig.ActorKeyOverridden() {
+		t.Fatal("Expected key of ACTOR_PEM not to be overridden before rotation, but overridden")
+	}
+	keyID, err := relayConfig.RotateActorKey(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Switch signing key", func(t *testing.T) {
+		currentKeyID, currentKey := relayConfig.ActorSigningKey()
+		if currentKeyID != keyID || currentKeyID == previousKeyID || currentKey.(*rsa.PrivateKey).Equal(previousKey) {
+			t.Fatalf("Expected signing key to be switched, but got %s", currentKeyID)
+		}
+	})
+
+	t.Run("Serve previous key in actor", func(t *testing.T) {
+		actor := NewActivityPubActorFromRelayConfig(relayConfig)
+		if actor.PublicKey.ID != keyID {
+			t.Fatalf("Expected publicKey to be new key, but got %s", actor.PublicKey.ID)
+		}
+		publicKey, err := actor.PublicKeyByID(previousKeyID)
+		if err != nil || !previousKey.(*rsa.PrivateKey).PublicKey.Equal(publicKey) {
+			t.Fatalf("Expected previous key to be served in assertionMethod, but got %v", err)
+		}
+	})
+
+	t.Run("Notify other processes", func(t *testing.T) {
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+			t.Fatal("Expected listener to be notified, but not notified")
+		}
+		if listener.ActorKeyID() != keyID {
+			t.Fatalf("Expected listener to switch key to %s, but got %s", keyID, listener.ActorKeyID())
+		}
+	})
+
+	t.Run("Take precedence over configured key", func(t *testing.T) {
+		restarted, err := NewRelayConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restarted.ActorKeyID() != keyID || !restarted.ActorKeyOverridden() {
+			t.Fatalf("Expected rotated key to override configured key, but got %s", restarted.ActorKeyID())
+		}
+	})
+
+	t.Run("Stop serving previous key without grace period", func(t *testing.T) {
+		time.Sleep(time.Second)
+		_, err := relayConfig.RotateActorKey(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actor := NewActivityPubActorFromRelayConfig(relayConfig)
+		if relayConfig.PreviousActorKey() != nil || actor.AssertionMethod != nil {
+			t.Fatalf("Expected previous key not to be served, but got %v", actor.AssertionMethod)
+		}
+	})
+}
+
+func TestReadActorKey(t *testing.T) {
+	defer viper.Set("ACTOR_PEM", "../misc/test/testKey.pem")
+
+	t.Run("Read PKCS#8 key from ACTOR_KEY", func(t *testing.T) {
+		privateKey, _ := GeneratePrivateKey(RSAKey)
+		privateKeyPem, _ := GeneratePrivateKeyPEMString(privateKey)
+		viper.Set("ACTOR_KEY", privateKeyPem)
+		defer viper.Set("ACTOR_KEY", "")
+
+		actorKey, err := readActorKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !privateKey.(*rsa.PrivateKey).Equal(actorKey) {
+			t.Fatal("Expected key of ACTOR_KEY to be read, but got other key")
+		}
+	})
+
+	t.Run("Read Ed25519 key from Docker secret", func(t *testing.T) {
+		privateKey, _ := GeneratePrivateKey(Ed25519Key)
+		privateKeyPem, _ := GeneratePrivateKeyPEMString(privateKey)
+		secretPath := filepath.Join(t.TempDir(), "actor_key")
+		os.WriteFile(secretPath, []byte(privateKeyPem), 0600)
+		defaultSecretPath := actorKeySecretPath
+		actorKeySecretPath = secretPath
+		defer func() { actorKeySecretPath = defaultSecretPath }()
+		viper.Set("ACTOR_PEM", "")
+
+		actorKey, err := readActorKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !privateKey.(ed25519.PrivateKey).Equal(actorKey) {
+			t.Fatal("Expected key of Docker secret to be read, but got other key")
+		}
+	})
+
+	t.Run("Refuse missing key", func(t *testing.T) {
+		viper.Set("ACTOR_PEM", "../misc/test/notExist.pem")
+		_, err := readActorKey()
+		if err == nil {
+			t.Fatal("Expected error for missing key, but got nil")
+		}
+	})
+}
+
+func TestActorWithEd25519Key(t *testing.T) {
+	privateKey, _ := GeneratePrivateKey(Ed25519Key)
+	relayConfig := &RelayConfig{
+		actorKey:     privateKey,
+		actorKeyName: defaultActorKeyName,
+		domain:       globalConfig.domain,
+	}
+
+	actor := NewActivityPubActorFromRelayConfig(relayConfig)
+	if !strings.HasPrefix(actor.PublicKey.PublicKeyPem, "-----BEGIN PUBLIC KEY-----") {
+		t.Fatalf("Expected publicKeyPem to be SubjectPublicKeyInfo, but got %s", actor.PublicKey.PublicKeyPem)
+	}
+	publicKey, err := actor.PublicKeyByID(relayConfig.ActorKeyID())
+	if err != nil || !privateKey.Public().(ed25519.PublicKey).Equal(publicKey) {
+		t.Fatalf("Expected Ed25519 key to be published as Multikey, but got %v", actor.AssertionMethod)
+	}
+}
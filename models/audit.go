@@ -0,0 +1,197 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// Audit actions recorded by RelayState
+const (
+	AuditConfigEnable     = "config.enable"
+	AuditConfigDisable    = "config.disable"
+	AuditSubscriberAdd    = "subscriber.add"
+	AuditSubscriberDelete = "subscriber.delete"
+	AuditFollowerAdd      = "follower.add"
+	AuditFollowerUpdate   = "follower.update"
+	AuditFollowerDelete   = "follower.delete"
+	AuditFollowPending    = "follow.pending"
+	AuditFollowAccept     = "follow.accept"
+	AuditFollowReject     = "follow.reject"
+	AuditDomainSet        = "domain.set"
+	AuditDomainUnset      = "domain.unset"
+	AuditFilterAdd        = "filter.add"
+	AuditFilterDelete     = "filter.delete"
+	AuditRateLimitSet     = "ratelimit.set"
+	AuditRateLimitDelete  = "ratelimit.delete"
+	AuditBlocklistAdd     = "blocklist.add"
+	AuditBlocklistDelete  = "blocklist.delete"
+	AuditSnapshotImport   = "snapshot.import"
+	AuditTokenCreate      = "token.create"
+	AuditTokenRevoke      = "token.revoke"
+	AuditKeyRotate        = "key.rotate"
+)
+
+const (
+	auditStream = "relay:audit"
+	// auditStreamMaxLen : Approximate number of events kept in audit stream
+	auditStreamMaxLen    = 10000
+	auditStreamReadCount = 100
+	auditSystemActor     = "system"
+)
+
+// AuditEvent : Record of state mutation. Time is taken from stream entry ID.
+type AuditEvent struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+	Target string    `json:"target"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// String : Human readable event
+func (event AuditEvent) String() string {
+	line := event.Time.UTC().Format(time.RFC3339) + " [" + event.Actor + "] " + event.Action + " " + event.Target
+	if event.Reason != "" {
+		line += " (" + event.Reason + ")"
+	}
+	return line
+}
+
+// AuditFilter : Conditions for audit events. Empty condition matches every event.
+type AuditFilter struct {
+	// Actor : Exact actor
+	Actor string
+	// Action : Action or its prefix ending with "." (e.g. "domain.")
+	Action string
+	// Target : Substring of target
+	Target string
+	// Since : Oldest time of events
+	Since time.Time
+}
+
+// Match : Event satisfies all conditions
+func (filter AuditFilter) Match(event AuditEvent) bool {
+	if filter.Actor != "" && event.Actor != filter.Actor {
+		return false
+	}
+	if filter.Action != "" && event.Action != filter.Action && !(strings.HasSuffix(filter.Action, ".") && strings.HasPrefix(event.Action, filter.Action)) {
+		return false
+	}
+	if filter.Target != "" && !strings.Contains(event.Target, filter.Target) {
+		return false
+	}
+	return true
+}
+
+// WithAudit : RelayState recording changes made through it with actor and reason. Use it for changes only; its content is not refreshed.
+func (config *RelayState) WithAudit(actor string, reason string) *RelayState {
+	audited := *config
+	if config.origin != nil {
+		audited.origin = config.origin
+	} else {
+		audited.origin = config
+	}
+	audited.AuditActor = actor
+	audited.AuditReason = reason
+	return &audited
+}
+
+// RecordAudit : Append event to capped audit stream with actor and reason of RelayState
+func (config *RelayState) RecordAudit(action string, target string) {
+	if config.RedisClient == nil {
+		return
+	}
+	actor := config.AuditActor
+	if actor == "" {
+		actor = auditSystemActor
+	}
+	err := config.RedisClient.XAdd(context.TODO(), &redis.XAddArgs{
+		Stream: auditStream,
+		MaxLen: auditStreamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"actor":  actor,
+			"action": action,
+			"target": target,
+			"reason": config.AuditReason,
+		},
+	}).Err()
+	if err != nil {
+		logrus.Error("Failed to record audit event : ", err)
+	}
+}
+
+// AuditEvents : Latest events matching filter in chronological order, at most limit (0 for all)
+func (config *RelayState) AuditEvents(filter AuditFilter, limit int) ([]AuditEvent, error) {
+	if config.RedisClient == nil {
+		return nil, errors.New("audit log requires redis")
+	}
+	start := "-"
+	if !filter.Since.IsZero() {
+		start = strconv.FormatInt(filter.Since.UnixMilli(), 10)
+	}
+	messages, err := config.RedisClient.XRevRange(context.TODO(), auditStream, "+", start).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var events []AuditEvent
+	for _, message := range messages {
+		event := newAuditEvent(message)
+		if !filter.Match(event) {
+			continue
+		}
+		events = append(events, event)
+		if limit > 0 && len(events) >= limit {
+			break
+		}
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+// ReadAuditEvents : Wait up to block for events recorded after lastID ("$" for events recorded from now)
+func (config *RelayState) ReadAuditEvents(ctx context.Context, lastID string, block time.Duration) ([]AuditEvent, error) {
+	if config.RedisClient == nil {
+		return nil, errors.New("audit log requires redis")
+	}
+	streams, err := config.RedisClient.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{auditStream, lastID},
+		Count:   auditStreamReadCount,
+		Block:   block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var events []AuditEvent
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			events = append(events, newAuditEvent(message))
+		}
+	}
+	return events, nil
+}
+
+func newAuditEvent(message redis.XMessage) AuditEvent {
+	event := AuditEvent{ID: message.ID}
+	milliseconds, _ := strconv.ParseInt(strings.SplitN(message.ID, "-", 2)[0], 10, 64)
+	event.Time = time.UnixMilli(milliseconds)
+	event.Actor, _ = message.Values["actor"].(string)
+	event.Action, _ = message.Values["action"].(string)
+	event.Target, _ = message.Values["target"].(string)
+	event.Reason, _ = message.Values["reason"].(string)
+	return event
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	relayState.RedisClient.FlushAll(context.TODO()).Result()
+	state := NewState(relayState.RedisClient, false)
+	state.AuditActor = "cli:root"
+
+	state.SetBlockedDomain("spam.example.com", true)
+	state.WithAudit("token:dashboard", "spam reports").SetLimitedDomain("noisy.example.com", true)
+	state.AddSubscriber(Subscriber{"example.com", "https://example.com/inbox", "https://example.com/follow", "https://example.com/actor"})
+
+	t.Run("Record events with actor and reason", func(t *testing.T) {
+		events, err := state.AuditEvents(AuditFilter{}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 3 {
+			t.Fatalf("Expected 3 events, but got %v", events)
+		}
+		if events[0].Actor != "cli:root" || events[0].Action != AuditDomainSet || events[0].Target != "blocked spam.example.com" {
+			t.Fatalf("Expected first event to be blocked domain by cli:root, but got %+v", events[0])
+		}
+		if events[1].Actor != "token:dashboard" || events[1].Reason != "spam reports" {
+			t.Fatalf("Expected second event to be recorded with token and reason, but got %+v", events[1])
+		}
+		if time.Since(events[2].Time) > time.Minute {
+			t.Fatalf("Expected event time to be now, but got %v", events[2].Time)
+		}
+	})
+
+	t.Run("Apply changes made through WithAudit", func(t *testing.T) {
+		if !state.IsLimitedDomain("noisy.example.com") {
+			t.Fatalf("Expected limited domain to be applied to state, but not applied")
+		}
+	})
+
+	t.Run("Filter events", func(t *testing.T) {
+		events, _ := state.AuditEvents(AuditFilter{Action: "domain."}, 0)
+		if len(events) != 2 {
+			t.Fatalf("Expected 2 domain events, but got %v", events)
+		}
+		events, _ = state.AuditEvents(AuditFilter{Actor: "cli:root", Target: "example.com"}, 1)
+		if len(events) != 1 || events[0].Action != AuditSubscriberAdd {
+			t.Fatalf("Expected latest event by cli:root to be subscriber.add, but got %v", events)
+		}
+		events, _ = state.AuditEvents(AuditFilter{Since: time.Now().Add(time.Hour)}, 0)
+		if len(events) != 0 {
+			t.Fatalf("Expected no events in future, but got %v", events)
+		}
+	})
+
+	t.Run("Read events after ID", func(t *testing.T) {
+		events, _ := state.AuditEvents(AuditFilter{}, 0)
+		lastID := events[len(events)-1].ID
+		state.DelSubscriber("example.com")
+
+		events, err := state.ReadAuditEvents(context.TODO(), lastID, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 || events[0].Action != AuditSubscriberDelete {
+			t.Fatalf("Expected subscriber.delete event, but got %v", events)
+		}
+	})
+}
@@ -0,0 +1,447 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Blocklist formats
+const (
+	// MastodonCSVFormat : domain_blocks.csv exported by Mastodon. suspend is blocked, silence is limited and noop is skipped.
+	MastodonCSVFormat = "mastodon-csv"
+	// PlainFormat : One blocked domain per line. Lines starting with # are comments.
+	PlainFormat = "plain"
+)
+
+// BlocklistEntry : Domain and list it belongs to
+type BlocklistEntry struct {
+	Domain string     `json:"domain"`
+	List   DomainList `json:"list"`
+}
+
+func (entry BlocklistEntry) key() string {
+	return string(entry.List) + ":" + entry.Domain
+}
+
+func newBlocklistEntryFromKey(key string) BlocklistEntry {
+	list, domain, _ := strings.Cut(key, ":")
+	return BlocklistEntry{domain, DomainList(list)}
+}
+
+// ParseBlocklist : Parse blocklist in format. Invalid domains are skipped.
+func ParseBlocklist(format string, reader io.Reader) ([]BlocklistEntry, error) {
+	switch format {
+	case MastodonCSVFormat:
+		return parseMastodonCSV(reader)
+	case PlainFormat:
+		return parsePlainBlocklist(reader)
+	default:
+		return nil, errors.New("unknown blocklist format: " + format)
+	}
+}
+
+func parseMastodonCSV(reader io.Reader) ([]BlocklistEntry, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	// Exports without header have domain in first column and are suspended
+	domainColumn, severityColumn := 0, -1
+	if len(records) > 0 && strings.HasPrefix(records[0][0], "#") {
+		for i, column := range records[0] {
+			switch strings.TrimPrefix(column, "#") {
+			case "domain":
+				domainColumn = i
+			case "severity":
+				severityColumn = i
+			}
+		}
+		records = records[1:]
+	}
+
+	var entries []BlocklistEntry
+	for _, record := range records {
+		if domainColumn >= len(record) {
+			continue
+		}
+		list := BlockedDomainList
+		if severityColumn >= 0 && severityColumn < len(record) {
+			switch record[severityColumn] {
+			case "suspend", "":
+			case "silence":
+				list = LimitedDomainList
+			default:
+				continue
+			}
+		}
+		entries = appendBlocklistEntry(entries, record[domainColumn], list)
+	}
+	return entries, nil
+}
+
+func parsePlainBlocklist(reader io.Reader) ([]BlocklistEntry, error) {
+	var entries []BlocklistEntry
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = appendBlocklistEntry(entries, line, BlockedDomainList)
+	}
+	return entries, scanner.Err()
+}
+
+func appendBlocklistEntry(entries []BlocklistEntry, domain string, list DomainList) []BlocklistEntry {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if ValidateDomainPattern(domain) != nil {
+		return entries
+	}
+	return append(entries, BlocklistEntry{domain, list})
+}
+
+// DiffBlocklist : Entries not yet in blocked or limited domains
+func (config *RelayState) DiffBlocklist(entries []BlocklistEntry) []BlocklistEntry {
+	current := map[DomainList][]string{
+		BlockedDomainList: config.BlockedDomains,
+		LimitedDomainList: config.LimitedDomains,
+	}
+	seen := make(map[string]bool)
+	var added []BlocklistEntry
+	for _, entry := range entries {
+		if seen[entry.key()] || containsFold(current[entry.List], entry.Domain) {
+			continue
+		}
+		seen[entry.key()] = true
+		added = append(added, entry)
+	}
+	return added
+}
+
+func containsFold(domains []string, domain string) bool {
+	for _, entry := range domains {
+		if strings.EqualFold(entry, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportBlocklist : Add entries to blocked or limited domains and unfollow members of newly blocked domains. Return added entries and unfollowed domains.
+func (config *RelayState) ImportBlocklist(entries []BlocklistEntry, relayActor *Actor, enqueue ActivityEnqueuer) ([]BlocklistEntry, []string, error) {
+	added := config.DiffBlocklist(entries)
+	err := config.setBlocklistEntries(added, true)
+	if err != nil {
+		return added, nil, err
+	}
+	return added, config.unfollowBlockedEntries(added, relayActor, enqueue), nil
+}
+
+// unfollowBlockedEntries : Unfollow members matching blocked entries. Return unfollowed domains.
+func (config *RelayState) unfollowBlockedEntries(entries []BlocklistEntry, relayActor *Actor, enqueue ActivityEnqueuer) []string {
+	var unfollowed []string
+	for _, entry := range entries {
+		if entry.List == BlockedDomainList {
+			unfollowed = append(unfollowed, config.UnfollowMembersByDomainPattern(relayActor, entry.Domain, enqueue)...)
+		}
+	}
+	return unfollowed
+}
+
+func (config *RelayState) setBlocklistEntries(entries []BlocklistEntry, value bool) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	defer config.refresh(changeConfig)
+	for _, entry := range entries {
+		err := config.Storage.SetDomain(entry.List, entry.Domain, value)
+		if err != nil {
+			return err
+		}
+		config.recordDomainAudit(entry.List, entry.Domain, value)
+	}
+	return nil
+}
+
+// BlocklistSource : Blocklist file or URL synced periodically by Job Worker
+type BlocklistSource struct {
+	Name      string    `json:"name"`
+	Location  string    `json:"location"`
+	Format    string    `json:"format"`
+	SyncedAt  time.Time `json:"syncedAt,omitempty"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+func (source *BlocklistSource) validate() error {
+	if source.Name == "" || strings.ContainsAny(source.Name, ", :") {
+		return errors.New("name must be non-empty and not contain comma, colon or space")
+	}
+	if source.Location == "" {
+		return errors.New("location is empty")
+	}
+	if source.Format != MastodonCSVFormat && source.Format != PlainFormat {
+		return errors.New("unknown blocklist format: " + source.Format)
+	}
+	return nil
+}
+
+// maxBlocklistSize : Maximum size of blocklist read from source, to protect Job Worker from huge response
+const maxBlocklistSize = 8 << 20
+
+// fetch : Read blocklist from URL or local file
+func (source *BlocklistSource) fetch(client *http.Client) ([]BlocklistEntry, error) {
+	var reader io.Reader
+	if strings.HasPrefix(source.Location, "https://") || strings.HasPrefix(source.Location, "http://") {
+		resp, err := client.Get(source.Location)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s returned %d", source.Location, resp.StatusCode)
+		}
+		reader = resp.Body
+	} else {
+		file, err := os.Open(source.Location)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, maxBlocklistSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxBlocklistSize {
+		return nil, fmt.Errorf("%s exceeds %d bytes", source.Location, maxBlocklistSize)
+	}
+	return ParseBlocklist(source.Format, bytes.NewReader(data))
+}
+
+// BlocklistSources : Registered blocklist sources in order of name
+func (config *RelayState) BlocklistSources() []BlocklistSource {
+	var sources []BlocklistSource
+	if config.RedisClient == nil {
+		return sources
+	}
+	values, _ := config.RedisClient.HGetAll(context.TODO(), "relay:config:blocklistSource").Result()
+	for _, value := range values {
+		var source BlocklistSource
+		if json.Unmarshal([]byte(value), &source) == nil {
+			sources = append(sources, source)
+		}
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+	return sources
+}
+
+// SelectBlocklistSource : Select blocklist source by name (nil if not found)
+func (config *RelayState) SelectBlocklistSource(name string) *BlocklistSource {
+	if config.RedisClient == nil {
+		return nil
+	}
+	value, err := config.RedisClient.HGet(context.TODO(), "relay:config:blocklistSource", name).Result()
+	if err != nil {
+		return nil
+	}
+	var source BlocklistSource
+	if json.Unmarshal([]byte(value), &source) != nil {
+		return nil
+	}
+	return &source
+}
+
+// SetBlocklistSource : Add or update blocklist source
+func (config *RelayState) SetBlocklistSource(source BlocklistSource) error {
+	err := config.saveBlocklistSource(source)
+	if err != nil {
+		return err
+	}
+	config.RecordAudit(AuditBlocklistAdd, source.Name+" "+source.Location)
+	return nil
+}
+
+func (config *RelayState) saveBlocklistSource(source BlocklistSource) error {
+	if config.RedisClient == nil {
+		return errRedisRequired
+	}
+	err := source.validate()
+	if err != nil {
+		return err
+	}
+	data, _ := json.Marshal(&source)
+	_, err = config.RedisClient.HSet(context.TODO(), "relay:config:blocklistSource", source.Name, data).Result()
+	return err
+}
+
+// DelBlocklistSource : Delete blocklist source and entries only it provides. Return removed entries.
+func (config *RelayState) DelBlocklistSource(name string) ([]BlocklistEntry, error) {
+	if config.RedisClient == nil {
+		return nil, errRedisRequired
+	}
+	deleted, err := config.RedisClient.HDel(context.TODO(), "relay:config:blocklistSource", name).Result()
+	if err != nil {
+		return nil, err
+	}
+	if deleted == 0 {
+		return nil, errors.New("blocklist source not found: " + name)
+	}
+	config.RecordAudit(AuditBlocklistDelete, name)
+	return config.releaseBlocklistEntries(name, nil)
+}
+
+// SyncBlocklistSource : Fetch blocklist source, apply its additions and removals and unfollow members of newly blocked domains. Return added and removed entries and unfollowed domains.
+func (config *RelayState) SyncBlocklistSource(name string, client *http.Client, relayActor *Actor, enqueue ActivityEnqueuer) ([]BlocklistEntry, []BlocklistEntry, []string, error) {
+	source := config.SelectBlocklistSource(name)
+	if source == nil {
+		return nil, nil, nil, errors.New("blocklist source not found: " + name)
+	}
+
+	state := config
+	if config.AuditReason == "" {
+		state = config.WithAudit(config.AuditActor, "blocklist source "+name)
+	}
+	added, removed, err := state.syncBlocklistEntries(source, client)
+	if err != nil {
+		source.LastError = err.Error()
+	} else {
+		source.SyncedAt = time.Now().UTC()
+		source.LastError = ""
+	}
+	config.saveBlocklistSource(*source)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return added, removed, state.unfollowBlockedEntries(added, relayActor, enqueue), nil
+}
+
+func (config *RelayState) syncBlocklistEntries(source *BlocklistSource, client *http.Client) ([]BlocklistEntry, []BlocklistEntry, error) {
+	entries, err := source.fetch(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	config.loadConfig()
+
+	added, err := config.claimBlocklistEntries(source.Name, entries)
+	if err != nil {
+		return nil, nil, err
+	}
+	desired := make(map[string]bool)
+	for _, entry := range entries {
+		desired[entry.key()] = true
+	}
+	removed, err := config.releaseBlocklistEntries(source.Name, desired)
+	if err != nil {
+		return nil, nil, err
+	}
+	return added, removed, nil
+}
+
+// BlocklistOwners : Sources providing entry (empty for manually added entry)
+func (config *RelayState) BlocklistOwners(entry BlocklistEntry) []string {
+	if config.RedisClient == nil {
+		return nil
+	}
+	value, _ := config.RedisClient.HGet(context.TODO(), "relay:config:blocklistOwner", entry.key()).Result()
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+func (config *RelayState) setBlocklistOwners(entry BlocklistEntry, owners []string) error {
+	if len(owners) == 0 {
+		_, err := config.RedisClient.HDel(context.TODO(), "relay:config:blocklistOwner", entry.key()).Result()
+		return err
+	}
+	sort.Strings(owners)
+	_, err := config.RedisClient.HSet(context.TODO(), "relay:config:blocklistOwner", entry.key(), strings.Join(owners, ",")).Result()
+	return err
+}
+
+// claimBlocklistEntries : Record source as owner of entries and add new entries. Return added entries.
+func (config *RelayState) claimBlocklistEntries(name string, entries []BlocklistEntry) ([]BlocklistEntry, error) {
+	newEntries := config.DiffBlocklist(entries)
+	isNew := make(map[string]bool)
+	for _, entry := range newEntries {
+		isNew[entry.key()] = true
+	}
+
+	var added []BlocklistEntry
+	for _, entry := range entries {
+		owners := config.BlocklistOwners(entry)
+		if !isNew[entry.key()] && len(owners) == 0 {
+			// Added manually, keep it independent of source
+			continue
+		}
+		if !containsFold(owners, name) {
+			err := config.setBlocklistOwners(entry, append(owners, name))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if isNew[entry.key()] {
+			added = append(added, entry)
+			delete(isNew, entry.key())
+		}
+	}
+	return added, config.setBlocklistEntries(added, true)
+}
+
+// releaseBlocklistEntries : Remove source from owners of entries not desired. Entries without owners are removed. Return removed entries.
+func (config *RelayState) releaseBlocklistEntries(name string, desired map[string]bool) ([]BlocklistEntry, error) {
+	values, err := config.RedisClient.HGetAll(context.TODO(), "relay:config:blocklistOwner").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []BlocklistEntry
+	for key, value := range values {
+		owners := strings.Split(value, ",")
+		if desired[key] || !containsFold(owners, name) {
+			continue
+		}
+		var remainOwners []string
+		for _, owner := range owners {
+			if owner != name {
+				remainOwners = append(remainOwners, owner)
+			}
+		}
+		entry := newBlocklistEntryFromKey(key)
+		err = config.setBlocklistOwners(entry, remainOwners)
+		if err != nil {
+			return nil, err
+		}
+		if len(remainOwners) == 0 {
+			removed = append(removed, entry)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].key() < removed[j].key() })
+	return removed, config.setBlocklistEntries(removed, false)
+}
+
+// SyncBlocklistSources : Sync all blocklist sources. Failures are recorded in each source.
+func (config *RelayState) SyncBlocklistSources(client *http.Client, relayActor *Actor, enqueue ActivityEnqueuer) map[string]error {
+	results := make(map[string]error)
+	for _, source := range config.BlocklistSources() {
+		_, _, _, err := config.SyncBlocklistSource(source.Name, client, relayActor, enqueue)
+		results[source.Name] = err
+	}
+	return results
+}
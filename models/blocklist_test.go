@@ -0,0 +1,185 @@
+package models
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	t.Run("Mastodon CSV with header", func(t *testing.T) {
+		csv := `#domain,#severity,#reject_media,#reject_reports,#public_comment,#obfuscate
+spam.example.com,suspend,true,true,Spam,false
+Noisy.example.com,silence,false,false,,false
+media.example.com,noop,true,false,,false
+not a domain,suspend,false,false,,false
+`
+		entries, err := ParseBlocklist(MastodonCSVFormat, strings.NewReader(csv))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []BlocklistEntry{{"spam.example.com", BlockedDomainList}, {"noisy.example.com", LimitedDomainList}}
+		if len(entries) != len(expected) || entries[0] != expected[0] || entries[1] != expected[1] {
+			t.Fatalf("Expected entries to be %v, but got %v", expected, entries)
+		}
+	})
+
+	t.Run("Mastodon CSV without header", func(t *testing.T) {
+		entries, err := ParseBlocklist(MastodonCSVFormat, strings.NewReader("spam.example.com\nspam.example.net\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 || entries[1] != (BlocklistEntry{"spam.example.net", BlockedDomainList}) {
+			t.Fatalf("Expected 2 blocked entries, but got %v", entries)
+		}
+	})
+
+	t.Run("Plain", func(t *testing.T) {
+		entries, err := ParseBlocklist(PlainFormat, strings.NewReader("# comment\n\n*.spam.example.com\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0] != (BlocklistEntry{"*.spam.example.com", BlockedDomainList}) {
+			t.Fatalf("Expected 1 wildcard entry, but got %v", entries)
+		}
+	})
+
+	t.Run("Unknown format", func(t *testing.T) {
+		_, err := ParseBlocklist("unknown", strings.NewReader(""))
+		if err == nil {
+			t.Fatal("Expected error for unknown format, but got nil")
+		}
+	})
+}
+
+func TestSyncBlocklistSource(t *testing.T) {
+	relayState.RedisClient.FlushAll(context.TODO()).Result()
+	defer relayState.RedisClient.FlushAll(context.TODO()).Result()
+	state := NewState(relayState.RedisClient, false)
+
+	dir := t.TempDir()
+	writeBlocklist := func(name string, content string) string {
+		path := dir + "/" + name
+		os.WriteFile(path, []byte(content), 0644)
+		return path
+	}
+	client := new(http.Client)
+	actor := NewActivityPubActorFromRelayConfig(globalConfig)
+	var enqueued []enqueuedActivity
+
+	state.SetBlockedDomain("manual.example.com", true)
+	state.SetBlocklistSource(BlocklistSource{Name: "first", Location: writeBlocklist("first.txt", "manual.example.com\nshared.example.com\nfirst.example.com\n"), Format: PlainFormat})
+	state.SetBlocklistSource(BlocklistSource{Name: "second", Location: writeBlocklist("second.txt", "shared.example.com\n"), Format: PlainFormat})
+
+	added, _, _, err := state.SyncBlocklistSource("first", client, &actor, recordEnqueued(&enqueued))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 2 {
+		t.Fatalf("Expected 2 added entries, but got %v", added)
+	}
+	state.SyncBlocklistSource("second", client, &actor, recordEnqueued(&enqueued))
+
+	t.Run("Keep manual entry independent of source", func(t *testing.T) {
+		owners := state.BlocklistOwners(BlocklistEntry{"manual.example.com", BlockedDomainList})
+		if len(owners) != 0 {
+			t.Fatalf("Expected manual entry to have no owners, but got %v", owners)
+		}
+		owners = state.BlocklistOwners(BlocklistEntry{"shared.example.com", BlockedDomainList})
+		if len(owners) != 2 {
+			t.Fatalf("Expected shared entry to have 2 owners, but got %v", owners)
+		}
+	})
+
+	t.Run("Remove entries dropped by source", func(t *testing.T) {
+		writeBlocklist("first.txt", "shared.example.com\n")
+		_, removed, _, err := state.SyncBlocklistSource("first", client, &actor, recordEnqueued(&enqueued))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(removed) != 1 || removed[0].Domain != "first.example.com" {
+			t.Fatalf("Expected first.example.com to be removed, but got %v", removed)
+		}
+		state.Load()
+		if !state.IsBlockedDomain("manual.example.com") || !state.IsBlockedDomain("shared.example.com") || state.IsBlockedDomain("first.example.com") {
+			t.Fatalf("Expected blocked domains to be manual and shared, but got %v", state.BlockedDomains)
+		}
+	})
+
+	t.Run("Remove source releases its entries", func(t *testing.T) {
+		state.DelBlocklistSource("first")
+		removed, err := state.DelBlocklistSource("second")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(removed) != 1 || removed[0].Domain != "shared.example.com" {
+			t.Fatalf("Expected shared.example.com to be removed, but got %v", removed)
+		}
+		state.Load()
+		if len(state.BlockedDomains) != 1 || state.BlockedDomains[0] != "manual.example.com" {
+			t.Fatalf("Expected blocked domains to be [manual.example.com], but got %v", state.BlockedDomains)
+		}
+	})
+
+	t.Run("Refuse oversized blocklist", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(strings.Repeat("#", maxBlocklistSize+1)))
+		}))
+		defer s.Close()
+
+		state.SetBlocklistSource(BlocklistSource{Name: "huge", Location: s.URL, Format: PlainFormat})
+		_, _, _, err := state.SyncBlocklistSource("huge", client, &actor, recordEnqueued(&enqueued))
+		if err == nil || !strings.Contains(err.Error(), "exceeds") {
+			t.Fatalf("Expected error for oversized blocklist, but got %v", err)
+		}
+	})
+
+	t.Run("Record sync failure", func(t *testing.T) {
+		state.SetBlocklistSource(BlocklistSource{Name: "missing", Location: dir + "/missing.txt", Format: PlainFormat})
+		_, _, _, err := state.SyncBlocklistSource("missing", client, &actor, recordEnqueued(&enqueued))
+		if err == nil {
+			t.Fatal("Expected error for missing file, but got nil")
+		}
+		source := state.SelectBlocklistSource("missing")
+		if source == nil || source.LastError == "" {
+			t.Fatalf("Expected last error to be recorded, but got %v", source)
+		}
+	})
+}
+
+func TestSyncBlocklistSourcesUnfollowMembers(t *testing.T) {
+	relayState.RedisClient.FlushAll(context.TODO()).Result()
+	defer relayState.RedisClient.FlushAll(context.TODO()).Result()
+	state := NewState(relayState.RedisClient, false)
+	actor := NewActivityPubActorFromRelayConfig(globalConfig)
+
+	state.AddSubscriber(Subscriber{
+		Domain:     "suspended.example.com",
+		InboxURL:   "https://suspended.example.com/inbox",
+		ActivityID: "https://suspended.example.com/UUID",
+		ActorID:    "https://suspended.example.com/actor",
+	})
+	state.AddSubscriber(Subscriber{
+		Domain:   "example.com",
+		InboxURL: "https://example.com/inbox",
+	})
+	path := t.TempDir() + "/blocklist.txt"
+	os.WriteFile(path, []byte("suspended.example.com\n"), 0644)
+	state.SetBlocklistSource(BlocklistSource{Name: "source", Location: path, Format: PlainFormat})
+
+	var enqueued []enqueuedActivity
+	results := state.SyncBlocklistSources(new(http.Client), &actor, recordEnqueued(&enqueued))
+	if results["source"] != nil {
+		t.Fatal(results["source"])
+	}
+	state.Load()
+	if state.SelectSubscriber("suspended.example.com") != nil || state.SelectSubscriber("example.com") == nil {
+		t.Fatalf("Expected only suspended.example.com to be unfollowed, but got %v", state.Subscribers)
+	}
+	if len(enqueued) != 1 || enqueued[0].inboxURL != "https://suspended.example.com/inbox" || enqueued[0].activity.Type != "Reject" {
+		t.Fatalf("Expected Reject to be enqueued to suspended.example.com, but got %+v", enqueued)
+	}
+}
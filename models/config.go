@@ -2,11 +2,15 @@ package models
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net/url"
+	"os"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
@@ -17,16 +21,59 @@ import (
 
 // RelayConfig contains valid configuration.
 type RelayConfig struct {
-	actorKey        *rsa.PrivateKey
-	domain          *url.URL
-	redisClient     *redis.Client
-	redisURL        string
-	serverBind      string
-	serviceName     string
-	serviceSummary  string
-	serviceIconURL  *url.URL
-	serviceImageURL *url.URL
-	jobConcurrency  int
+	actorKey         crypto.Signer
+	actorKeyName     string
+	configuredKey    crypto.Signer
+	previousActorKey *PreviousActorKey
+	actorKeyMutex    sync.RWMutex
+	domain           *url.URL
+	redisClient      *redis.Client
+	redisURL         string
+	serverBind       string
+	serviceName      string
+	serviceSummary   string
+	serviceIconURL   *url.URL
+	serviceImageURL  *url.URL
+	jobConcurrency   int
+	retryPolicy      RetryPolicy
+	metricsBind      string
+	clockSkew        time.Duration
+	dedupeTTL        time.Duration
+	shutdownTimeout  time.Duration
+	stateStorage     string
+	sqlitePath       string
+	memoryStorage    *MemoryStorage
+	memoryActivities *MemoryActivityStore
+	memoryMutex      sync.Mutex
+	standalone       bool
+	blocklistSync    time.Duration
+}
+
+// RetryPolicy is exponential backoff policy for relay deliveries.
+type RetryPolicy struct {
+	Count       int
+	Interval    time.Duration
+	MaxInterval time.Duration
+}
+
+// Backoff returns delay before given retry attempt (1-origin) with jitter.
+func (policy RetryPolicy) Backoff(attempt int) time.Duration {
+	ceiling := policy.ceiling(attempt)
+	if ceiling <= 0 {
+		return 0
+	}
+	return ceiling/2 + time.Duration(rand.Int63n(int64(ceiling/2)+1))
+}
+
+func (policy RetryPolicy) ceiling(attempt int) time.Duration {
+	ceiling := policy.Interval
+	for i := 1; i < attempt && ceiling < policy.MaxInterval; i++ {
+		ceiling *= 2
+	}
+	if ceiling > policy.MaxInterval {
+		ceiling = policy.MaxInterval
+	}
+	return ceiling
 }
 
 // NewRelayConfig create valid RelayConfig from viper configuration.
@@ -53,26 +100,76 @@ func NewRelayConfig() (*RelayConfig, error) {
 		return nil, errors.New("JOB_CONCURRENCY IS 0 OR EMPTY. SHOULD BE SET MORE THAN 1")
 	}
 
-	privateKey, err := readPrivateKeyRSA(viper.GetString("ACTOR_PEM"))
+	privateKey, err := readActorKey()
 	if err != nil {
-		return nil, errors.New("ACTOR_PEM: " + err.Error())
+		return nil, err
 	}
 
-	redisURL := viper.GetString("REDIS_URL")
-	redisOption, err := redis.ParseURL(redisURL)
-	if err != nil {
-		return nil, errors.New("REDIS_URL: " + err.Error())
+	stateStorage := viper.GetString("STATE_STORAGE")
+	if stateStorage == "" {
+		stateStorage = "redis"
 	}
-	redisClient := redis.NewClient(redisOption)
-	err = redisClient.Ping(context.TODO()).Err()
-	if err != nil {
-		return nil, errors.New("REDIS_URL: " + err.Error())
+	if stateStorage != "redis" && stateStorage != "sqlite" && stateStorage != "memory" {
+		return nil, errors.New("STATE_STORAGE IS INVALID. SHOULD BE redis, sqlite OR memory")
+	}
+
+	// Redis is optional unless relay state is stored in it
+	redisURL := viper.GetString("REDIS_URL")
+	var redisClient *redis.Client
+	if redisURL != "" || stateStorage == "redis" {
+		redisOption, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return nil, errors.New("REDIS_URL: " + err.Error())
+		}
+		redisClient = redis.NewClient(redisOption)
+		err = redisClient.Ping(context.TODO()).Err()
+		if err != nil {
+			return nil, errors.New("REDIS_URL: " + err.Error())
+		}
+	} else {
+		logrus.Warn("REDIS_URL: EMPTY. RUNNING WITHOUT REDIS, ONLY STANDALONE IS AVAILABLE AND REDIS-ONLY FEATURES ARE DISABLED.")
 	}
 
 	serverBind := viper.GetString("RELAY_BIND")
 
-	return &RelayConfig{
+	retryPolicy := RetryPolicy{
+		Count:       getIntOrDefault("JOB_RETRY_COUNT", 5),
+		Interval:    time.Duration(getIntOrDefault("JOB_RETRY_INTERVAL", 30)) * time.Second,
+		MaxInterval: time.Duration(getIntOrDefault("JOB_RETRY_MAX_INTERVAL", 3600)) * time.Second,
+	}
+	if retryPolicy.Count < 0 || retryPolicy.Interval < time.Second || retryPolicy.MaxInterval < retryPolicy.Interval {
+		return nil, errors.New("JOB_RETRY_COUNT, JOB_RETRY_INTERVAL OR JOB_RETRY_MAX_INTERVAL IS INVALID")
+	}
+
+	clockSkew := time.Duration(getIntOrDefault("SIGNATURE_CLOCK_SKEW", 300)) * time.Second
+	if clockSkew < time.Second {
+		return nil, errors.New("SIGNATURE_CLOCK_SKEW IS INVALID")
+	}
+
+	dedupeTTL := time.Duration(getIntOrDefault("ACTIVITY_DEDUPE_TTL", 86400)) * time.Second
+	if dedupeTTL < time.Second {
+		return nil, errors.New("ACTIVITY_DEDUPE_TTL IS INVALID")
+	}
+
+	shutdownTimeout := time.Duration(getIntOrDefault("SHUTDOWN_TIMEOUT", 30)) * time.Second
+	if shutdownTimeout < 0 {
+		return nil, errors.New("SHUTDOWN_TIMEOUT IS INVALID")
+	}
+
+	blocklistSync := time.Duration(getIntOrDefault("BLOCKLIST_SYNC_INTERVAL", 3600)) * time.Second
+	if blocklistSync < 0 {
+		return nil, errors.New("BLOCKLIST_SYNC_INTERVAL IS INVALID")
+	}
+
+	sqlitePath := viper.GetString("SQLITE_PATH")
+	if sqlitePath == "" {
+		sqlitePath = "relay.db"
+	}
+
+	relayConfig := &RelayConfig{
 		actorKey:        privateKey,
+		actorKeyName:    defaultActorKeyName,
+		configuredKey:   privateKey,
 		domain:          domain,
 		redisClient:     redisClient,
 		redisURL:        redisURL,
@@ -82,7 +179,58 @@ func NewRelayConfig() (*RelayConfig, error) {
 		serviceIconURL:  iconURL,
 		serviceImageURL: imageURL,
 		jobConcurrency:  jobConcurrency,
-	}, nil
+		retryPolicy:     retryPolicy,
+		metricsBind:     viper.GetString("METRICS_BIND"),
+		clockSkew:       clockSkew,
+		dedupeTTL:       dedupeTTL,
+		shutdownTimeout: shutdownTimeout,
+		stateStorage:    stateStorage,
+		sqlitePath:      sqlitePath,
+		blocklistSync:   blocklistSync,
+	}
+	err = relayConfig.LoadActorKey()
+	if err != nil {
+		return nil, errors.New("ACTOR_PEM: " + err.Error())
+	}
+	if relayConfig.ActorKeyOverridden() {
+		logrus.Warn("ACTOR_KEY: ROTATED KEY [", relayConfig.ActorKeyID(), "] IN REDIS TAKES PRECEDENCE OVER ACTOR_KEY, ACTOR_PEM AND DOCKER SECRET.")
+	}
+	return relayConfig, nil
+}
+
+// actorKeySecretPath : Docker secret of actor key, read when neither ACTOR_KEY nor ACTOR_PEM is set
+var actorKeySecretPath = "/run/secrets/actor_key"
+
+// readActorKey : Read actor key from PEM string of ACTOR_KEY, file at ACTOR_PEM or Docker secret, in this order.
+func readActorKey() (crypto.Signer, error) {
+	if pemString := viper.GetString("ACTOR_KEY"); pemString != "" {
+		privateKey, err := ReadPrivateKeyFromString(pemString)
+		if err != nil {
+			return nil, errors.New("ACTOR_KEY: " + err.Error())
+		}
+		return privateKey, nil
+	}
+
+	source, keyPath := "ACTOR_PEM", viper.GetString("ACTOR_PEM")
+	if keyPath == "" {
+		source, keyPath = "ACTOR_KEY, ACTOR_PEM OR "+actorKeySecretPath, actorKeySecretPath
+	}
+	file, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, errors.New(source + ": " + err.Error())
+	}
+	privateKey, err := ReadPrivateKeyFromString(string(file))
+	if err != nil {
+		return nil, errors.New(source + ": " + err.Error())
+	}
+	return privateKey, nil
+}
+
+func getIntOrDefault(key string, defaultValue int) int {
+	if !viper.IsSet(key) {
+		return defaultValue
+	}
+	return viper.GetInt(key)
 }
 
 // ServerBind is API Server's bind interface definition.
@@ -105,12 +253,93 @@ func (relayConfig *RelayConfig) JobConcurrency() int {
 	return relayConfig.jobConcurrency
 }
 
+// RetryPolicy is API Worker's retry policy for relay deliveries.
+func (relayConfig *RelayConfig) RetryPolicy() RetryPolicy {
+	return relayConfig.retryPolicy
+}
+
+// MetricsBind is API Worker's metrics listener bind interface definition.
+func (relayConfig *RelayConfig) MetricsBind() string {
+	return relayConfig.metricsBind
+}
+
+// SignatureClockSkew is API Server's allowed clock skew for Date header and signature created time.
+func (relayConfig *RelayConfig) SignatureClockSkew() time.Duration {
+	return relayConfig.clockSkew
+}
+
+// DedupeTTL is API Server's period to remember relayed activities.
+func (relayConfig *RelayConfig) DedupeTTL() time.Duration {
+	return relayConfig.dedupeTTL
+}
+
+// ShutdownTimeout is API Server's and Job Worker's deadline to drain in-flight work on shutdown.
+func (relayConfig *RelayConfig) ShutdownTimeout() time.Duration {
+	return relayConfig.shutdownTimeout
+}
+
+// BlocklistSyncInterval is Job Worker's interval to sync blocklist sources. Zero disables sync.
+func (relayConfig *RelayConfig) BlocklistSyncInterval() time.Duration {
+	return relayConfig.blocklistSync
+}
+
+// StateStorage is storage backend name of RelayState.
+func (relayConfig *RelayConfig) StateStorage() string {
+	return relayConfig.stateStorage
+}
+
+// SetStandalone mark API Server and Job Worker run in single process, which is required to share state in memory.
+func (relayConfig *RelayConfig) SetStandalone() {
+	relayConfig.memoryMutex.Lock()
+	defer relayConfig.memoryMutex.Unlock()
+	relayConfig.standalone = true
+}
+
+// NewStorage create Storage of RelayState from RelayConfig. Memory storage is available only in standalone.
+func (relayConfig *RelayConfig) NewStorage() (Storage, error) {
+	switch relayConfig.stateStorage {
+	case "sqlite":
+		storage, err := NewSQLiteStorage(relayConfig.sqlitePath)
+		if err != nil {
+			return nil, errors.New("SQLITE_PATH: " + err.Error())
+		}
+		return storage, nil
+	case "memory":
+		// API Server and Job Worker of standalone share same state
+		relayConfig.memoryMutex.Lock()
+		defer relayConfig.memoryMutex.Unlock()
+		if !relayConfig.standalone {
+			return nil, errors.New("STATE_STORAGE: memory IS AVAILABLE ONLY IN STANDALONE. USE redis OR sqlite TO SHARE STATE AMONG PROCESSES")
+		}
+		if relayConfig.memoryStorage == nil {
+			relayConfig.memoryStorage = NewMemoryStorage()
+		}
+		return relayConfig.memoryStorage, nil
+	default:
+		return NewRedisStorage(relayConfig.redisClient), nil
+	}
+}
+
 // ActorKey is API Worker's HTTPSignature private key.
-func (relayConfig *RelayConfig) ActorKey() *rsa.PrivateKey {
-	return relayConfig.actorKey
+func (relayConfig *RelayConfig) ActorKey() crypto.Signer {
+	_, privateKey := relayConfig.ActorSigningKey()
+	return privateKey
+}
+
+// ActivityStore create ActivityStore of activity bodies shared by deliveries. Without Redis, bodies are kept in process.
+func (relayConfig *RelayConfig) ActivityStore() ActivityStore {
+	if relayConfig.redisClient != nil {
+		return NewRedisActivityStore(relayConfig.redisClient)
+	}
+	relayConfig.memoryMutex.Lock()
+	defer relayConfig.memoryMutex.Unlock()
+	if relayConfig.memoryActivities == nil {
+		relayConfig.memoryActivities = NewMemoryActivityStore()
+	}
+	return relayConfig.memoryActivities
 }
 
-// RedisClient is return redis client from RelayConfig.
+// RedisClient is return redis client from RelayConfig, or nil when REDIS_URL is empty.
 func (relayConfig *RelayConfig) RedisClient() *redis.Client {
 	return relayConfig.redisClient
 }
@@ -122,18 +351,24 @@ func (relayConfig *RelayConfig) DumpWelcomeMessage(moduleName string, version st
 RELAY NAME      : %s
 RELAY DOMAIN    : %s
 REDIS URL       : %s
+STATE STORAGE   : %s
 BIND ADDRESS    : %s
 JOB_CONCURRENCY : %s
-`, version, moduleName, relayConfig.serviceName, relayConfig.domain.Host, relayConfig.redisURL, relayConfig.serverBind, strconv.Itoa(relayConfig.jobConcurrency))
+`, version, moduleName, relayConfig.serviceName, relayConfig.domain.Host, relayConfig.redisURL, relayConfig.stateStorage, relayConfig.serverBind, strconv.Itoa(relayConfig.jobConcurrency))
 }
 
 // NewMachineryServer create Redis backed Machinery Server from RelayConfig.
 func NewMachineryServer(globalConfig *RelayConfig) (*machinery.Server, error) {
+	if globalConfig.redisClient == nil {
+		return nil, errors.New("REDIS_URL IS EMPTY. JOB QUEUE REQUIRES REDIS")
+	}
 	cnf := &config.Config{
 		Broker:          globalConfig.redisURL,
 		DefaultQueue:    "relay",
 		ResultBackend:   globalConfig.redisURL,
 		ResultsExpireIn: 1,
+		// Signals are handled by API Server and Job Worker entrypoints
+		NoUnixSignals: true,
 	}
 	newServer, err := machinery.NewServer(cnf)
 
@@ -4,6 +4,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -33,6 +34,18 @@ func TestNewRelayConfig(t *testing.T) {
 		if relayConfig.serviceImageURL.String() != "https://example.com/example_image.png" {
 			t.Errorf("Expected RelayConfig.serviceImageURL to be 'https://example.com/example_image.png', but got '%s'", relayConfig.serviceImageURL.String())
 		}
+		if relayConfig.clockSkew != 5*time.Minute {
+			t.Errorf("Expected RelayConfig.clockSkew to be 5m0s by default, but got '%s'", relayConfig.clockSkew)
+		}
+		if relayConfig.shutdownTimeout != 30*time.Second {
+			t.Errorf("Expected RelayConfig.shutdownTimeout to be 30s by default, but got '%s'", relayConfig.shutdownTimeout)
+		}
+		if relayConfig.blocklistSync != time.Hour {
+			t.Errorf("Expected RelayConfig.blocklistSync to be 1h0m0s by default, but got '%s'", relayConfig.blocklistSync)
+		}
+		if relayConfig.stateStorage != "redis" {
+			t.Errorf("Expected RelayConfig.stateStorage to be 'redis' by default, but got '%s'", relayConfig.stateStorage)
+		}
 	})
 
 	t.Run("Fail to load invalid configuration", func(t *testing.T) {
@@ -41,6 +54,7 @@ func TestNewRelayConfig(t *testing.T) {
 			"ACTOR_PEM@invalidKey":      "../misc/test/actor.dh.pem",
 			"REDIS_URL@invalidURL":      "",
 			"REDIS_URL@unreachableHost": "redis://localhost:6380",
+			"STATE_STORAGE@unknown":     "mysql",
 		}
 
 		for key, value := range invalidConfig {
@@ -58,6 +72,68 @@ func TestNewRelayConfig(t *testing.T) {
 	})
 }
 
+func TestNewRelayConfigWithoutRedis(t *testing.T) {
+	redisURL, stateStorage := viper.GetString("REDIS_URL"), viper.GetString("STATE_STORAGE")
+	defer func() {
+		viper.Set("REDIS_URL", redisURL)
+		viper.Set("STATE_STORAGE", stateStorage)
+	}()
+	viper.Set("REDIS_URL", "")
+	viper.Set("STATE_STORAGE", "memory")
+
+	relayConfig, err := NewRelayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if relayConfig.RedisClient() != nil {
+		t.Fatalf("Expected no Redis client, but got %v", relayConfig.RedisClient())
+	}
+
+	t.Run("Refuse memory storage outside standalone", func(t *testing.T) {
+		_, err := relayConfig.NewStorage()
+		if err == nil {
+			t.Fatal("Expected NewStorage to fail for memory storage outside standalone, but got nil")
+		}
+	})
+
+	t.Run("Share memory storage in process", func(t *testing.T) {
+		relayConfig.SetStandalone()
+		storage, err := relayConfig.NewStorage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		another, _ := relayConfig.NewStorage()
+		if _, ok := storage.(*MemoryStorage); !ok || storage != another {
+			t.Fatalf("Expected NewStorage() to return same *MemoryStorage, but got %T and %T", storage, another)
+		}
+		if _, ok := relayConfig.ActivityStore().(*MemoryActivityStore); !ok || relayConfig.ActivityStore() != relayConfig.ActivityStore() {
+			t.Fatalf("Expected ActivityStore() to return same *MemoryActivityStore, but got %T", relayConfig.ActivityStore())
+		}
+	})
+
+	t.Run("Fail to create job queue", func(t *testing.T) {
+		_, err := NewMachineryServer(relayConfig)
+		if err == nil {
+			t.Fatal("Expected NewMachineryServer to fail without Redis, but got nil")
+		}
+	})
+
+	t.Run("Keep configured actor key", func(t *testing.T) {
+		_, err := relayConfig.RotateActorKey(time.Hour)
+		if err == nil || relayConfig.ActorKeyID() != "https://relay.toot.yukimochi.jp/actor#main-key" {
+			t.Fatalf("Expected rotation to fail without Redis, but got %v", err)
+		}
+	})
+
+	t.Run("Require Redis for redis storage", func(t *testing.T) {
+		viper.Set("STATE_STORAGE", "redis")
+		_, err := NewRelayConfig()
+		if err == nil {
+			t.Fatal("Expected error for redis storage without REDIS_URL, but got nil")
+		}
+	})
+}
+
 func createRelayConfig(t *testing.T) *RelayConfig {
 	relayConfig, err := NewRelayConfig()
 	if err != nil {
@@ -90,6 +166,7 @@ func TestRelayConfig_DumpWelcomeMessage(t *testing.T) {
 		"RELAY NAME":      relayConfig.serviceName,
 		"RELAY DOMAIN":    relayConfig.domain.Host,
 		"REDIS URL":       relayConfig.redisURL,
+		"STATE STORAGE":   relayConfig.stateStorage,
 		"BIND ADDRESS":    relayConfig.serverBind,
 		"JOB_CONCURRENCY": strconv.Itoa(relayConfig.jobConcurrency),
 	}
@@ -101,6 +178,29 @@ func TestRelayConfig_DumpWelcomeMessage(t *testing.T) {
 	}
 }
 
+func TestRelayConfig_NewStorage(t *testing.T) {
+	relayConfig := createRelayConfig(t)
+
+	storage, err := relayConfig.NewStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := storage.(*RedisStorage); !ok {
+		t.Errorf("Expected NewStorage() to return *RedisStorage, but got %T", storage)
+	}
+
+	relayConfig.stateStorage = "sqlite"
+	relayConfig.sqlitePath = t.TempDir() + "/relay.db"
+	storage, err = relayConfig.NewStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if _, ok := storage.(*SQLiteStorage); !ok {
+		t.Errorf("Expected NewStorage() to return *SQLiteStorage, but got %T", storage)
+	}
+}
+
 func TestNewMachineryServer(t *testing.T) {
 	relayConfig := createRelayConfig(t)
 
@@ -109,3 +209,21 @@ func TestNewMachineryServer(t *testing.T) {
 		t.Errorf("Expected NewMachineryServer to succeed, but got error: %v", err)
 	}
 }
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{
+		Count:       5,
+		Interval:    30 * time.Second,
+		MaxInterval: 100 * time.Second,
+	}
+
+	t.Run("Backoff grows exponentially within jitter range", func(t *testing.T) {
+		ceilings := []time.Duration{30 * time.Second, 60 * time.Second, 100 * time.Second, 100 * time.Second}
+		for i, ceiling := range ceilings {
+			backoff := policy.Backoff(i + 1)
+			if backoff < ceiling/2 || backoff > ceiling {
+				t.Errorf("Expected backoff of attempt %d to be between %v and %v, but got %v", i+1, ceiling/2, ceiling, backoff)
+			}
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// Domain pattern forms in blocked and limited domain lists:
+//
+//	example.com     matches example.com only
+//	*.example.com   matches subdomains of example.com, but not example.com itself
+//	.example.com    matches example.com and its subdomains
+const (
+	wildcardDomainPrefix = "*."
+	suffixDomainPrefix   = "."
+)
+
+// ValidateDomainPattern : Check domain pattern is exact domain, wildcard or suffix
+func ValidateDomainPattern(pattern string) error {
+	// Strip only one prefix, so "*..example.com" keeps its empty label
+	domain := pattern
+	switch {
+	case strings.HasPrefix(pattern, wildcardDomainPrefix):
+		domain = strings.TrimPrefix(pattern, wildcardDomainPrefix)
+	case strings.HasPrefix(pattern, suffixDomainPrefix):
+		domain = strings.TrimPrefix(pattern, suffixDomainPrefix)
+	}
+	if domain == "" {
+		return errors.New("domain is empty")
+	}
+	if strings.Contains(domain, "*") {
+		return errors.New("wildcard is allowed only as leading label")
+	}
+	if strings.ContainsAny(domain, "/ ") {
+		return errors.New("domain is invalid")
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" {
+			return errors.New("domain has empty label")
+		}
+	}
+	return nil
+}
+
+// DomainPatternKind : Kind of domain pattern ("exact", "wildcard" or "suffix")
+func DomainPatternKind(pattern string) string {
+	switch {
+	case strings.HasPrefix(pattern, wildcardDomainPrefix):
+		return "wildcard"
+	case strings.HasPrefix(pattern, suffixDomainPrefix):
+		return "suffix"
+	default:
+		return "exact"
+	}
+}
+
+// DomainMatcher : Match hosts against domain patterns by looking up each parent domain
+type DomainMatcher struct {
+	exact    map[string]bool
+	wildcard map[string]bool
+	suffix   map[string]bool
+}
+
+// NewDomainMatcher : Create DomainMatcher from domain patterns. Patterns are matched case-insensitively.
+func NewDomainMatcher(patterns []string) DomainMatcher {
+	matcher := DomainMatcher{
+		exact:    make(map[string]bool),
+		wildcard: make(map[string]bool),
+		suffix:   make(map[string]bool),
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		switch DomainPatternKind(pattern) {
+		case "wildcard":
+			matcher.wildcard[strings.TrimPrefix(pattern, wildcardDomainPrefix)] = true
+		case "suffix":
+			matcher.suffix[strings.TrimPrefix(pattern, suffixDomainPrefix)] = true
+		default:
+			matcher.exact[pattern] = true
+		}
+	}
+	return matcher
+}
+
+// Match : Host (optionally with port) matches any pattern
+func (matcher DomainMatcher) Match(host string) bool {
+	host = strings.ToLower(host)
+	if matcher.exact[host] {
+		return true
+	}
+	if index := strings.LastIndex(host, ":"); index >= 0 && !strings.Contains(host[index:], "]") {
+		host = host[:index]
+	}
+	host = strings.TrimSuffix(host, ".")
+	if matcher.exact[host] || matcher.suffix[host] {
+		return true
+	}
+	for index := strings.Index(host, "."); index >= 0; index = strings.Index(host, ".") {
+		host = host[index+1:]
+		if matcher.wildcard[host] || matcher.suffix[host] {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchDomainPattern : Host matches single domain pattern
+func MatchDomainPattern(pattern string, host string) bool {
+	return NewDomainMatcher([]string{pattern}).Match(host)
+}
@@ -0,0 +1,43 @@
+package models
+
+import "testing"
+
+func TestDomainMatcher(t *testing.T) {
+	matcher := NewDomainMatcher([]string{"Exact.example.com", "*.wildcard.example.com", ".suffix.example.com"})
+
+	tests := map[string]bool{
+		"exact.example.com":          true,
+		"exact.example.com:8443":     true,
+		"sub.exact.example.com":      false,
+		"wildcard.example.com":       false,
+		"a.wildcard.example.com":     true,
+		"a.b.Wildcard.example.com":   true,
+		"suffix.example.com":         true,
+		"a.suffix.example.com":       true,
+		"notsuffix.example.com":      false,
+		"example.com":                false,
+		"suffix.example.com.":        true,
+		"a.suffix.example.com.other": false,
+	}
+	for host, expected := range tests {
+		if matcher.Match(host) != expected {
+			t.Errorf("Expected Match(%s) to be %v, but got %v", host, expected, !expected)
+		}
+	}
+}
+
+func TestValidateDomainPattern(t *testing.T) {
+	valid := []string{"example.com", "*.example.com", ".example.com", "localhost"}
+	for _, pattern := range valid {
+		if err := ValidateDomainPattern(pattern); err != nil {
+			t.Errorf("Expected pattern '%s' to be valid, but got error: %v", pattern, err)
+		}
+	}
+
+	invalid := []string{"", "*", "*.", ".", "a.*.example.com", "*example.com", "example..com", "https://example.com", "example.com.", "*..example.com", ".*.example.com", "..example.com", "*.example..com", ".example.com.", "*.."}
+	for _, pattern := range invalid {
+		if err := ValidateDomainPattern(pattern); err == nil {
+			t.Errorf("Expected pattern '%s' to be invalid, but got nil", pattern)
+		}
+	}
+}
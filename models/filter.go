@@ -0,0 +1,266 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FilterAction : Action for matched FilterRule
+type FilterAction string
+
+const (
+	// DropAction : Drop activity for all subscribers and followers
+	DropAction FilterAction = "drop"
+	// DropForFollowersAction : Drop activity for LitePub followers only
+	DropForFollowersAction FilterAction = "drop-followers"
+)
+
+// FilterRule : Content filtering rule evaluated before relaying. All provided conditions must match.
+type FilterRule struct {
+	ID             string       `json:"id,omitempty"`
+	Action         FilterAction `json:"action"`
+	Keyword        string       `json:"keyword,omitempty"`
+	Regex          string       `json:"regex,omitempty"`
+	Hashtag        string       `json:"hashtag,omitempty"`
+	Sensitive      bool         `json:"sensitive,omitempty"`
+	ContentWarning bool         `json:"content_warning,omitempty"`
+	MinAttachments int          `json:"min_attachments,omitempty"`
+	regex          *regexp.Regexp
+}
+
+func (rule *FilterRule) compile() error {
+	switch rule.Action {
+	case DropAction, DropForFollowersAction:
+	default:
+		return errors.New("invalid filter action: " + string(rule.Action))
+	}
+	if rule.Keyword == "" && rule.Regex == "" && rule.Hashtag == "" && !rule.Sensitive && !rule.ContentWarning && rule.MinAttachments < 1 {
+		return errors.New("filter has no condition")
+	}
+	rule.Hashtag = strings.TrimPrefix(rule.Hashtag, "#")
+	rule.regex = nil
+	if rule.Regex != "" {
+		regex, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return err
+		}
+		rule.regex = regex
+	}
+	return nil
+}
+
+// String : Human readable filter conditions
+func (rule *FilterRule) String() string {
+	var conditions []string
+	if rule.Keyword != "" {
+		conditions = append(conditions, "keyword="+strconv.Quote(rule.Keyword))
+	}
+	if rule.Regex != "" {
+		conditions = append(conditions, "regex="+strconv.Quote(rule.Regex))
+	}
+	if rule.Hashtag != "" {
+		conditions = append(conditions, "hashtag=#"+rule.Hashtag)
+	}
+	if rule.Sensitive {
+		conditions = append(conditions, "sensitive")
+	}
+	if rule.ContentWarning {
+		conditions = append(conditions, "content-warning")
+	}
+	if rule.MinAttachments > 0 {
+		conditions = append(conditions, fmt.Sprintf("attachments>=%d", rule.MinAttachments))
+	}
+	return string(rule.Action) + " " + strings.Join(conditions, " ")
+}
+
+func (rule *FilterRule) match(target *filterTarget) bool {
+	if rule.Keyword != "" && !strings.Contains(strings.ToLower(target.content), strings.ToLower(rule.Keyword)) {
+		return false
+	}
+	if rule.regex != nil && !rule.regex.MatchString(target.content) {
+		return false
+	}
+	if rule.Hashtag != "" {
+		found := false
+		for _, hashtag := range target.hashtags {
+			if strings.EqualFold(hashtag, rule.Hashtag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.Sensitive && !target.sensitive {
+		return false
+	}
+	if rule.ContentWarning && !target.contentWarning {
+		return false
+	}
+	if rule.MinAttachments > 0 && target.attachments < rule.MinAttachments {
+		return false
+	}
+	return true
+}
+
+// filterTarget : Properties of inner object evaluated by FilterRule
+type filterTarget struct {
+	content        string
+	hashtags       []string
+	sensitive      bool
+	contentWarning bool
+	attachments    int
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+func newFilterTarget(activity *Activity) *filterTarget {
+	object, ok := activity.Object.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	target := new(filterTarget)
+
+	var contents []string
+	if summary, ok := object["summary"].(string); ok && summary != "" {
+		contents = append(contents, summary)
+		target.contentWarning = true
+	}
+	if content, ok := object["content"].(string); ok {
+		contents = append(contents, content)
+	}
+	if contentMap, ok := object["contentMap"].(map[string]interface{}); ok {
+		for _, content := range contentMap {
+			if content, ok := content.(string); ok {
+				contents = append(contents, content)
+			}
+		}
+	}
+	target.content = htmlTag.ReplaceAllString(strings.Join(contents, "\n"), " ")
+
+	if sensitive, ok := object["sensitive"].(bool); ok {
+		target.sensitive = sensitive
+	}
+
+	tags, ok := object["tag"].([]interface{})
+	if !ok && object["tag"] != nil {
+		tags = []interface{}{object["tag"]}
+	}
+	for _, tag := range tags {
+		tag, ok := tag.(map[string]interface{})
+		if !ok || tag["type"] != "Hashtag" {
+			continue
+		}
+		if name, ok := tag["name"].(string); ok {
+			target.hashtags = append(target.hashtags, strings.TrimPrefix(name, "#"))
+		}
+	}
+
+	switch attachment := object["attachment"].(type) {
+	case []interface{}:
+		target.attachments = len(attachment)
+	case nil:
+	default:
+		target.attachments = 1
+	}
+	return target
+}
+
+// FilterActivity : Evaluate filters for activity. Return strongest action of matched rules ("" if none).
+func (config *RelayState) FilterActivity(activity *Activity) (FilterAction, *FilterRule) {
+	target := newFilterTarget(activity)
+	if target == nil {
+		return "", nil
+	}
+	var action FilterAction
+	var matched *FilterRule
+	for i := range config.Filters {
+		rule := &config.Filters[i]
+		if !rule.match(target) {
+			continue
+		}
+		if rule.Action == DropAction {
+			return DropAction, rule
+		}
+		action, matched = rule.Action, rule
+	}
+	return action, matched
+}
+
+// FilterAnnouncedObject : Apply filter rules to document announced by LitePub relay, which is either activity wrapping object or object itself.
+func (config *RelayState) FilterAnnouncedObject(document map[string]interface{}) (FilterAction, *FilterRule) {
+	if object, ok := document["object"].(map[string]interface{}); ok {
+		document = object
+	}
+	return config.FilterActivity(&Activity{Type: "Announce", Object: document})
+}
+
+// AddFilter : Add new filter rule
+func (config *RelayState) AddFilter(rule FilterRule) (string, error) {
+	if config.RedisClient == nil {
+		return "", errRedisRequired
+	}
+	err := rule.compile()
+	if err != nil {
+		return "", err
+	}
+	id, err := config.RedisClient.Incr(context.TODO(), "relay:config:filterSequence").Result()
+	if err != nil {
+		return "", err
+	}
+	rule.ID = strconv.FormatInt(id, 10)
+	data, _ := json.Marshal(&rule)
+	_, err = config.RedisClient.HSet(context.TODO(), "relay:config:filter", rule.ID, data).Result()
+	if err != nil {
+		return "", err
+	}
+	config.RecordAudit(AuditFilterAdd, rule.ID+" "+rule.String())
+
+	config.refresh(changeConfig)
+	return rule.ID, nil
+}
+
+// DelFilter : Delete filter rule
+func (config *RelayState) DelFilter(id string) bool {
+	if config.RedisClient == nil {
+		return false
+	}
+	deleted, _ := config.RedisClient.HDel(context.TODO(), "relay:config:filter", id).Result()
+	if deleted == 0 {
+		return false
+	}
+	config.RecordAudit(AuditFilterDelete, id)
+
+	config.refresh(changeConfig)
+	return true
+}
+
+func (config *RelayState) loadFilters() []FilterRule {
+	var filters []FilterRule
+	values, _ := config.RedisClient.HGetAll(context.TODO(), "relay:config:filter").Result()
+	for id, value := range values {
+		var rule FilterRule
+		err := json.Unmarshal([]byte(value), &rule)
+		if err != nil {
+			continue
+		}
+		rule.ID = id
+		if rule.compile() != nil {
+			continue
+		}
+		filters = append(filters, rule)
+	}
+	sort.Slice(filters, func(i, j int) bool {
+		left, _ := strconv.Atoi(filters[i].ID)
+		right, _ := strconv.Atoi(filters[j].ID)
+		return left < right
+	})
+	return filters
+}
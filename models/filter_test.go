@@ -0,0 +1,96 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func mockFilterActivity(object string) *Activity {
+	var activity Activity
+	json.Unmarshal([]byte(`{"id":"https://example.com/activity","type":"Create","actor":"https://example.com/users/alice","object":`+object+`}`), &activity)
+	return &activity
+}
+
+func TestFilterRuleMatch(t *testing.T) {
+	object := `{
+		"type": "Note",
+		"summary": "Spoiler",
+		"content": "<p>Buy <span>CHEAP</span> watches <a href=\"https://example.com/tags/spam\">#<span>spam</span></a></p>",
+		"sensitive": true,
+		"attachment": [{"type": "Document"}, {"type": "Document"}],
+		"tag": [{"type": "Hashtag", "name": "#Spam"}, {"type": "Mention", "name": "@bob"}]
+	}`
+	target := newFilterTarget(mockFilterActivity(object))
+
+	rules := map[string]struct {
+		rule    FilterRule
+		matched bool
+	}{
+		"keyword":                {FilterRule{Action: DropAction, Keyword: "cheap"}, true},
+		"keyword in html":        {FilterRule{Action: DropAction, Keyword: "span"}, false},
+		"regex":                  {FilterRule{Action: DropAction, Regex: `(?i)buy\s+cheap`}, true},
+		"hashtag":                {FilterRule{Action: DropAction, Hashtag: "#spam"}, true},
+		"mention is not hashtag": {FilterRule{Action: DropAction, Hashtag: "bob"}, false},
+		"sensitive":              {FilterRule{Action: DropAction, Sensitive: true}, true},
+		"content warning":        {FilterRule{Action: DropAction, ContentWarning: true, Keyword: "spoiler"}, true},
+		"attachments":            {FilterRule{Action: DropAction, MinAttachments: 2}, true},
+		"too few attachments":    {FilterRule{Action: DropAction, MinAttachments: 3}, false},
+		"all conditions":         {FilterRule{Action: DropAction, Keyword: "watches", Hashtag: "ham"}, false},
+	}
+	for name, testCase := range rules {
+		rule := testCase.rule
+		err := rule.compile()
+		if err != nil {
+			t.Fatalf("Expected rule '%s' to be valid, but got error: %v", name, err)
+		}
+		if rule.match(target) != testCase.matched {
+			t.Fatalf("Expected rule '%s' match to be %v, but got %v", name, testCase.matched, !testCase.matched)
+		}
+	}
+}
+
+func TestFilterRuleInvalid(t *testing.T) {
+	invalidRules := map[string]FilterRule{
+		"invalid filter action: pass": {Action: "pass", Keyword: "spam"},
+		"filter has no condition":     {Action: DropAction},
+	}
+	for message, rule := range invalidRules {
+		err := rule.compile()
+		if err == nil || err.Error() != message {
+			t.Fatalf("Expected error '%s', but got '%v'", message, err)
+		}
+	}
+	rule := FilterRule{Action: DropAction, Regex: "("}
+	if rule.compile() == nil {
+		t.Fatalf("Expected invalid regex to be rejected, but it was accepted")
+	}
+}
+
+func TestFilterActivity(t *testing.T) {
+	relayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	relayState.AddFilter(FilterRule{Action: DropForFollowersAction, Sensitive: true})
+	<-ch
+	relayState.AddFilter(FilterRule{Action: DropAction, Keyword: "spam"})
+	<-ch
+
+	action, _ := relayState.FilterActivity(mockFilterActivity(`{"type":"Note","content":"hello","sensitive":true}`))
+	if action != DropForFollowersAction {
+		t.Fatalf("Expected action to be drop-followers, but got '%s'", action)
+	}
+	action, rule := relayState.FilterActivity(mockFilterActivity(`{"type":"Note","content":"spam","sensitive":true}`))
+	if action != DropAction || rule.ID != "2" {
+		t.Fatalf("Expected action to be drop by filter [2], but got '%s'", action)
+	}
+	action, _ = relayState.FilterActivity(mockFilterActivity(`"https://example.com/note"`))
+	if action != "" {
+		t.Fatalf("Expected object reference not to be filtered, but got '%s'", action)
+	}
+
+	relayState.DelFilter("2")
+	<-ch
+	if len(relayState.Filters) != 1 || relayState.Filters[0].ID != "1" {
+		t.Fatalf("Expected filter [2] to be removed on reload, but got %v", relayState.Filters)
+	}
+}
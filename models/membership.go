@@ -0,0 +1,161 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ActivityEnqueuer : Enqueue activity body for delivery to inbox. State is not changed when enqueue fails.
+type ActivityEnqueuer func(inboxURL string, body []byte) error
+
+// UnfollowSubscriber : Send Reject of Follow to subscriber and delete it
+func (config *RelayState) UnfollowSubscriber(relayActor *Actor, subscriber Subscriber, enqueue ActivityEnqueuer) error {
+	activity := Activity{
+		Context: []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
+		ID:      subscriber.ActivityID,
+		Actor:   subscriber.ActorID,
+		Type:    "Follow",
+		Object:  "https://www.w3.org/ns/activitystreams#Public",
+	}
+	resp := activity.GenerateReply(*relayActor, activity, "Reject")
+	jsonData, err := json.Marshal(&resp)
+	if err != nil {
+		return err
+	}
+	err = enqueue(subscriber.InboxURL, jsonData)
+	if err != nil {
+		return err
+	}
+	config.DelSubscriber(subscriber.Domain)
+	return nil
+}
+
+// UnfollowFollower : Send Reject of Follow to follower and delete it
+func (config *RelayState) UnfollowFollower(relayActor *Actor, follower Follower, enqueue ActivityEnqueuer) error {
+	activity := Activity{
+		Context: []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
+		ID:      follower.ActivityID,
+		Actor:   follower.ActorID,
+		Type:    "Follow",
+		Object:  relayActor.ID,
+	}
+	resp := activity.GenerateReply(*relayActor, activity, "Reject")
+	jsonData, err := json.Marshal(&resp)
+	if err != nil {
+		return err
+	}
+	err = enqueue(follower.InboxURL, jsonData)
+	if err != nil {
+		return err
+	}
+	config.DelFollower(follower.Domain)
+	return nil
+}
+
+// UnfollowMembersByDomainPattern : Unfollow subscribers and followers in storage matching domain pattern. Return unfollowed domains.
+func (config *RelayState) UnfollowMembersByDomainPattern(relayActor *Actor, pattern string, enqueue ActivityEnqueuer) []string {
+	var unfollowed []string
+	subscribers, err := config.Storage.Subscribers()
+	if err != nil {
+		logrus.Error("Failed to list subscribers : ", err)
+	}
+	for _, subscriber := range subscribers {
+		if !MatchDomainPattern(pattern, subscriber.Domain) {
+			continue
+		}
+		err = config.UnfollowSubscriber(relayActor, subscriber, enqueue)
+		if err != nil {
+			logrus.Error("Failed to unfollow subscriber : ", err)
+			continue
+		}
+		unfollowed = append(unfollowed, subscriber.Domain)
+	}
+	followers, err := config.Storage.Followers()
+	if err != nil {
+		logrus.Error("Failed to list followers : ", err)
+	}
+	for _, follower := range followers {
+		if !MatchDomainPattern(pattern, follower.Domain) {
+			continue
+		}
+		err = config.UnfollowFollower(relayActor, follower, enqueue)
+		if err != nil {
+			logrus.Error("Failed to unfollow follower : ", err)
+			continue
+		}
+		unfollowed = append(unfollowed, follower.Domain)
+	}
+	return unfollowed
+}
+
+// BlockDomain : Set domain pattern as blocked and unfollow matching subscribers and followers. Return unfollowed domains.
+func (config *RelayState) BlockDomain(relayActor *Actor, pattern string, enqueue ActivityEnqueuer) []string {
+	config.SetBlockedDomain(pattern, true)
+	return config.UnfollowMembersByDomainPattern(relayActor, pattern, enqueue)
+}
+
+// RespondFollowRequest : Send Accept or Reject of pending follow request. Accepted actor is added as subscriber or follower, and followed back unless limited.
+func (config *RelayState) RespondFollowRequest(relayActor *Actor, domain string, response string, enqueue ActivityEnqueuer) error {
+	data, err := config.SelectPendingRequest(domain)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return errors.New("follow request from " + domain + " not found")
+	}
+	activity := Activity{
+		Context: []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
+		ID:      data.ActivityID,
+		Actor:   data.Actor,
+		Type:    data.Type,
+		Object:  data.Object,
+	}
+
+	resp := activity.GenerateReply(*relayActor, activity, response)
+	jsonData, err := json.Marshal(&resp)
+	if err != nil {
+		return err
+	}
+	err = enqueue(data.InboxURL, jsonData)
+	if err != nil {
+		return err
+	}
+	config.DelPendingRequest(domain)
+	if response != "Accept" {
+		config.RecordAudit(AuditFollowReject, domain)
+		return nil
+	}
+	config.RecordAudit(AuditFollowAccept, domain)
+
+	switch data.Object {
+	case "https://www.w3.org/ns/activitystreams#Public":
+		config.AddSubscriber(Subscriber{
+			Domain:     domain,
+			InboxURL:   data.InboxURL,
+			ActivityID: data.ActivityID,
+			ActorID:    data.Actor,
+		})
+	case relayActor.ID:
+		config.AddFollower(Follower{
+			Domain:     domain,
+			InboxURL:   data.InboxURL,
+			ActivityID: data.ActivityID,
+			ActorID:    data.Actor,
+		})
+		actorID, err := url.Parse(data.Actor)
+		if err == nil && !config.IsLimitedDomain(actorID.Host) {
+			followRequest := NewActivityPubActivity(*relayActor, []string{data.Actor}, data.Actor, "Follow")
+			jsonData, _ := json.Marshal(&followRequest)
+			err = enqueue(data.InboxURL, jsonData)
+			if err != nil {
+				logrus.Error("Failed to send MutuallyFollow Request : ", err)
+			} else {
+				logrus.Info("Sent MutuallyFollow Request : ", data.Actor)
+			}
+		}
+	}
+	return nil
+}
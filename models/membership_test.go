@@ -0,0 +1,144 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type enqueuedActivity struct {
+	inboxURL string
+	activity Activity
+}
+
+func recordEnqueued(enqueued *[]enqueuedActivity) ActivityEnqueuer {
+	return func(inboxURL string, body []byte) error {
+		var activity Activity
+		json.Unmarshal(body, &activity)
+		*enqueued = append(*enqueued, enqueuedActivity{inboxURL, activity})
+		return nil
+	}
+}
+
+func refuseEnqueue(string, []byte) error {
+	return errors.New("job queue is disabled")
+}
+
+func TestRespondFollowRequest(t *testing.T) {
+	state := NewStateWithStorage(NewMemoryStorage(), nil, false)
+	actor := NewActivityPubActorFromRelayConfig(globalConfig)
+	state.AddPendingRequest(PendingRequest{
+		Domain:     "subscription.example.jp",
+		InboxURL:   "https://subscription.example.jp/inbox",
+		ActivityID: "https://subscription.example.jp/UUID",
+		Type:       "Follow",
+		Actor:      "https://subscription.example.jp/actor",
+		Object:     "https://www.w3.org/ns/activitystreams#Public",
+	})
+	state.AddPendingRequest(PendingRequest{
+		Domain:     "follower.example.jp",
+		InboxURL:   "https://follower.example.jp/inbox",
+		ActivityID: "https://follower.example.jp/UUID",
+		Type:       "Follow",
+		Actor:      "https://follower.example.jp/actor",
+		Object:     actor.ID,
+	})
+
+	var enqueued []enqueuedActivity
+	err := state.RespondFollowRequest(&actor, "subscription.example.jp", "Accept", recordEnqueued(&enqueued))
+	if err != nil {
+		t.Fatalf("Expected follow request to be accepted, but got error: %v", err)
+	}
+	if state.SelectSubscriber("subscription.example.jp") == nil || len(enqueued) != 1 || enqueued[0].activity.Type != "Accept" {
+		t.Fatalf("Expected subscriber added with Accept, but got %+v", enqueued)
+	}
+
+	enqueued = nil
+	state.RespondFollowRequest(&actor, "follower.example.jp", "Accept", recordEnqueued(&enqueued))
+	if state.SelectFollower("follower.example.jp") == nil || len(enqueued) != 2 || enqueued[1].activity.Type != "Follow" {
+		t.Fatalf("Expected follower added with Accept and Follow back, but got %+v", enqueued)
+	}
+
+	err = state.RespondFollowRequest(&actor, "follower.example.jp", "Reject", recordEnqueued(&enqueued))
+	if err == nil {
+		t.Fatalf("Expected error for responded follow request")
+	}
+}
+
+func TestKeepStateWhenEnqueueFails(t *testing.T) {
+	state := NewStateWithStorage(NewMemoryStorage(), nil, false)
+	actor := NewActivityPubActorFromRelayConfig(globalConfig)
+	state.AddPendingRequest(PendingRequest{
+		Domain:     "subscription.example.jp",
+		InboxURL:   "https://subscription.example.jp/inbox",
+		ActivityID: "https://subscription.example.jp/UUID",
+		Type:       "Follow",
+		Actor:      "https://subscription.example.jp/actor",
+		Object:     "https://www.w3.org/ns/activitystreams#Public",
+	})
+	state.AddSubscriber(Subscriber{
+		Domain:     "a.example.jp",
+		InboxURL:   "https://a.example.jp/inbox",
+		ActivityID: "https://a.example.jp/UUID",
+		ActorID:    "https://a.example.jp/actor",
+	})
+	state.AddFollower(Follower{
+		Domain:     "b.example.jp",
+		InboxURL:   "https://b.example.jp/inbox",
+		ActivityID: "https://b.example.jp/UUID",
+		ActorID:    "https://b.example.jp/actor",
+	})
+
+	t.Run("Keep pending follow request", func(t *testing.T) {
+		err := state.RespondFollowRequest(&actor, "subscription.example.jp", "Accept", refuseEnqueue)
+		if err == nil {
+			t.Fatal("Expected error when Accept is not enqueued, but got nil")
+		}
+		pending, _ := state.SelectPendingRequest("subscription.example.jp")
+		if pending == nil || state.SelectSubscriber("subscription.example.jp") != nil {
+			t.Fatalf("Expected follow request to stay pending, but got pending %v", pending)
+		}
+	})
+	t.Run("Keep subscriber and follower", func(t *testing.T) {
+		err := state.UnfollowSubscriber(&actor, *state.SelectSubscriber("a.example.jp"), refuseEnqueue)
+		if err == nil || state.SelectSubscriber("a.example.jp") == nil {
+			t.Fatalf("Expected subscriber to be kept when Reject is not enqueued, but got %v", err)
+		}
+		err = state.UnfollowFollower(&actor, *state.SelectFollower("b.example.jp"), refuseEnqueue)
+		if err == nil || state.SelectFollower("b.example.jp") == nil {
+			t.Fatalf("Expected follower to be kept when Reject is not enqueued, but got %v", err)
+		}
+	})
+}
+
+func TestUnfollowMembersByDomainPattern(t *testing.T) {
+	state := NewStateWithStorage(NewMemoryStorage(), nil, false)
+	actor := NewActivityPubActorFromRelayConfig(globalConfig)
+	state.AddSubscriber(Subscriber{
+		Domain:     "a.example.jp",
+		InboxURL:   "https://a.example.jp/inbox",
+		ActivityID: "https://a.example.jp/UUID",
+		ActorID:    "https://a.example.jp/actor",
+	})
+	state.AddFollower(Follower{
+		Domain:     "b.example.jp",
+		InboxURL:   "https://b.example.jp/inbox",
+		ActivityID: "https://b.example.jp/UUID",
+		ActorID:    "https://b.example.jp/actor",
+	})
+	state.AddSubscriber(Subscriber{
+		Domain:   "example.com",
+		InboxURL: "https://example.com/inbox",
+	})
+
+	var enqueued []enqueuedActivity
+	unfollowed := state.UnfollowMembersByDomainPattern(&actor, "*.example.jp", recordEnqueued(&enqueued))
+	if len(unfollowed) != 2 || len(state.Subscribers) != 1 || len(state.Followers) != 0 {
+		t.Fatalf("Expected members of example.jp to be unfollowed, but got %v", unfollowed)
+	}
+	for _, activity := range enqueued {
+		if activity.activity.Type != "Reject" {
+			t.Fatalf("Expected Reject to be enqueued, but got %s", activity.activity.Type)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"context"
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// InboxRequests : Inbox requests by activity type and response code
+	InboxRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "inbox_requests_total",
+		Help:      "Number of inbox requests by activity type and response code.",
+	}, []string{"type", "code"})
+	// SignatureFailures : Failed signature verifications by reason
+	SignatureFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "signature_verification_failures_total",
+		Help:      "Number of failed signature verifications by reason.",
+	}, []string{"reason"})
+	// ActorCacheRequests : Actor cache lookups by result
+	ActorCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "actor_cache_requests_total",
+		Help:      "Number of actor cache lookups by result.",
+	}, []string{"result"})
+	// EnqueuedJobs : Enqueued jobs by task name
+	EnqueuedJobs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "enqueued_jobs_total",
+		Help:      "Number of jobs enqueued by task name.",
+	}, []string{"task"})
+	// Deliveries : Deliveries by destination host and status
+	Deliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "deliveries_total",
+		Help:      "Number of deliveries by destination host and status.",
+	}, []string{"host", "status"})
+	// RateLimitedRequests : Inbox requests rejected by rate limit by source domain
+	RateLimitedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "rate_limited_requests_total",
+		Help:      "Number of inbox requests rejected by rate limit by source domain.",
+	}, []string{"domain"})
+	// DuplicateActivities : Duplicated activities skipped by kind
+	DuplicateActivities = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "duplicate_activities_total",
+		Help:      "Number of duplicated activities skipped by kind.",
+	}, []string{"kind"})
+	// DeliveryDuration : Delivery latency by destination host
+	DeliveryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "relay",
+		Name:      "delivery_duration_seconds",
+		Help:      "Delivery latency by destination host.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"host"})
+)
+
+func init() {
+	prometheus.MustRegister(InboxRequests, SignatureFailures, ActorCacheRequests, EnqueuedJobs, Deliveries, RateLimitedRequests, DuplicateActivities, DeliveryDuration)
+}
+
+func registerCollector(collector prometheus.Collector) error {
+	err := prometheus.Register(collector)
+	var alreadyRegistered prometheus.AlreadyRegisteredError
+	if errors.As(err, &alreadyRegistered) {
+		return nil
+	}
+	return err
+}
+
+// RegisterQueueMetrics : Register Machinery queue depth metrics. Nothing is registered without Redis.
+func RegisterQueueMetrics(redisClient *redis.Client) error {
+	if redisClient == nil {
+		return nil
+	}
+	err := registerCollector(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   "relay",
+		Name:        "queue_depth",
+		Help:        "Number of jobs waiting in Machinery queue.",
+		ConstLabels: prometheus.Labels{"queue": "relay"},
+	}, func() float64 {
+		depth, _ := redisClient.LLen(context.TODO(), "relay").Result()
+		return float64(depth)
+	}))
+	if err != nil {
+		return err
+	}
+	return registerCollector(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   "relay",
+		Name:        "queue_depth",
+		Help:        "Number of jobs waiting in Machinery queue.",
+		ConstLabels: prometheus.Labels{"queue": "delayed_tasks"},
+	}, func() float64 {
+		depth, _ := redisClient.ZCard(context.TODO(), "delayed_tasks").Result()
+		return float64(depth)
+	}))
+}
+
+// RegisterMemoryQueueMetrics : Register in-process queue depth metrics
+func RegisterMemoryQueueMetrics(queue *MemoryQueue) error {
+	return registerCollector(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   "relay",
+		Name:        "queue_depth",
+		Help:        "Number of jobs waiting in Machinery queue.",
+		ConstLabels: prometheus.Labels{"queue": "memory"},
+	}, func() float64 {
+		return float64(queue.Len())
+	}))
+}
+
+// RegisterStateMetrics : Register subscriber and follower count metrics
+func RegisterStateMetrics(state *RelayState) error {
+	err := registerCollector(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "relay",
+		Name:      "subscribers",
+		Help:      "Number of subscribers.",
+	}, func() float64 {
+		return float64(len(state.Subscribers))
+	}))
+	if err != nil {
+		return err
+	}
+	return registerCollector(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "relay",
+		Name:      "followers",
+		Help:      "Number of followers.",
+	}, func() float64 {
+		return float64(len(state.Followers))
+	}))
+}
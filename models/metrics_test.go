@@ -0,0 +1,27 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRegisterStateMetrics(t *testing.T) {
+	err := RegisterStateMetrics(&relayState)
+	if err != nil {
+		t.Fatalf("Expected RegisterStateMetrics to succeed, but got error: %v", err)
+	}
+	err = RegisterStateMetrics(&relayState)
+	if err != nil {
+		t.Fatalf("Expected RegisterStateMetrics to be idempotent, but got error: %v", err)
+	}
+
+	count, err := testutil.GatherAndCount(prometheus.DefaultGatherer, "relay_subscribers", "relay_followers")
+	if err != nil {
+		t.Fatalf("Expected metrics to be gathered, but got error: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("Expected 2 metrics to be gathered, but got %d", count)
+	}
+}
@@ -1,12 +1,19 @@
 package models
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
 	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-fed/httpsig"
@@ -14,11 +21,19 @@ import (
 	"github.com/patrickmn/go-cache"
 )
 
-func signGETRequest(req *http.Request, keyID string, privateKey *rsa.PrivateKey) error {
+// HTTPSignatureAlgorithm : draft-cavage HTTP Signatures algorithm for private key.
+func HTTPSignatureAlgorithm(privateKey crypto.PrivateKey) httpsig.Algorithm {
+	if _, ok := privateKey.(ed25519.PrivateKey); ok {
+		return httpsig.ED25519
+	}
+	return httpsig.RSA_SHA256
+}
+
+func signGETRequest(req *http.Request, keyID string, privateKey crypto.PrivateKey) error {
 	req.Header.Set("Host", req.URL.Host)
 	req.Header.Set("Date", time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05")+" GMT")
 	signer, _, err := httpsig.NewSigner(
-		[]httpsig.Algorithm{httpsig.RSA_SHA256},
+		[]httpsig.Algorithm{HTTPSignatureAlgorithm(privateKey)},
 		httpsig.DigestSha256,
 		[]string{httpsig.RequestTarget, "Host", "Date"},
 		httpsig.Signature,
@@ -37,6 +52,75 @@ type PublicKey struct {
 	PublicKeyPem string `json:"publicKeyPem,omitempty"`
 }
 
+// Multikey : Controlled Identifiers Multikey verification method.
+type Multikey struct {
+	ID                 string `json:"id,omitempty"`
+	Type               string `json:"type,omitempty"`
+	Controller         string `json:"controller,omitempty"`
+	PublicKeyMultibase string `json:"publicKeyMultibase,omitempty"`
+}
+
+// Multicodec prefixes of Multikey
+var (
+	multicodecEd25519Pub = []byte{0xed, 0x01}
+	multicodecRSAPub     = []byte{0x85, 0x24}
+)
+
+// NewMultikey : Create Multikey of RSA or Ed25519 public key.
+func NewMultikey(id string, controller string, publicKey crypto.PublicKey) Multikey {
+	var encoded []byte
+	switch key := publicKey.(type) {
+	case ed25519.PublicKey:
+		encoded = append(append([]byte{}, multicodecEd25519Pub...), key...)
+	case *rsa.PublicKey:
+		encoded = append(append([]byte{}, multicodecRSAPub...), x509.MarshalPKCS1PublicKey(key)...)
+	}
+	return Multikey{
+		ID:                 id,
+		Type:               "Multikey",
+		Controller:         controller,
+		PublicKeyMultibase: "z" + encodeBase58(encoded),
+	}
+}
+
+// PublicKey : Decode publicKeyMultibase (base58btc encoded Ed25519 or RSA key).
+func (multikey *Multikey) PublicKey() (crypto.PublicKey, error) {
+	if !strings.HasPrefix(multikey.PublicKeyMultibase, "z") {
+		return nil, errors.New("unsupported multibase encoding")
+	}
+	decoded, err := decodeBase58(multikey.PublicKeyMultibase[1:])
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(decoded) == 2+ed25519.PublicKeySize && bytes.HasPrefix(decoded, multicodecEd25519Pub):
+		return ed25519.PublicKey(decoded[2:]), nil
+	case bytes.HasPrefix(decoded, multicodecRSAPub):
+		return x509.ParsePKCS1PublicKey(decoded[2:])
+	default:
+		return nil, errors.New("unsupported multikey type")
+	}
+}
+
+// Multikeys : List of Multikey, accepting single object and references.
+type Multikeys []Multikey
+
+// UnmarshalJSON decodes both single-object and array forms, skipping keys given only by reference.
+func (multikeys *Multikeys) UnmarshalJSON(data []byte) error {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		raws = []json.RawMessage{data}
+	}
+	*multikeys = nil
+	for _, raw := range raws {
+		var multikey Multikey
+		if err := json.Unmarshal(raw, &multikey); err == nil {
+			*multikeys = append(*multikeys, multikey)
+		}
+	}
+	return nil
+}
+
 // Endpoints : Contains SharedInbox address.
 type Endpoints struct {
 	SharedInbox string `json:"sharedInbox,omitempty"`
@@ -57,7 +141,10 @@ type Actor struct {
 	Summary           string      `json:"summary,omitempty"`
 	Inbox             string      `json:"inbox,omitempty"`
 	Endpoints         *Endpoints  `json:"endpoints,omitempty"`
+	FollowersURL      string      `json:"followers,omitempty"`
+	FollowingURL      string      `json:"following,omitempty"`
 	PublicKey         PublicKey   `json:"publicKey,omitempty"`
+	AssertionMethod   Multikeys   `json:"assertionMethod,omitempty"`
 	Icon              *Image      `json:"icon,omitempty"`
 	Image             *Image      `json:"image,omitempty"`
 }
@@ -67,11 +154,24 @@ func (actor *Actor) Followers() string {
 	return actor.ID + "/followers"
 }
 
+// PublicKeyByID : Lookup public key of Actor by keyId.
+func (actor *Actor) PublicKeyByID(keyID string) (crypto.PublicKey, error) {
+	for _, multikey := range actor.AssertionMethod {
+		if multikey.ID == keyID {
+			return multikey.PublicKey()
+		}
+	}
+	if actor.PublicKey.PublicKeyPem == "" {
+		return nil, errors.New("public key is not found: " + keyID)
+	}
+	return ReadPublicKeyFromString(actor.PublicKey.PublicKeyPem)
+}
+
 // NewActivityPubActorFromRelayConfig : Create Actor from relay config.
 func NewActivityPubActorFromRelayConfig(globalConfig *RelayConfig) Actor {
 	hostname := globalConfig.domain.String()
-	publicKey := &globalConfig.actorKey.PublicKey
-	publicKeyPemString := generatePublicKeyPEMString(publicKey)
+	keyID, privateKey := globalConfig.ActorSigningKey()
+	publicKeyPemString := generatePublicKeyPEMString(privateKey.Public())
 
 	newActor := Actor{
 		Context:           []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
@@ -81,13 +181,25 @@ func NewActivityPubActorFromRelayConfig(globalConfig *RelayConfig) Actor {
 		PreferredUsername: "relay",
 		Summary:           globalConfig.serviceSummary,
 		Inbox:             hostname + "/inbox",
+		FollowersURL:      hostname + "/actor/followers",
+		FollowingURL:      hostname + "/actor/following",
 		PublicKey: PublicKey{
-			ID:           hostname + "/actor#main-key",
+			ID:           keyID,
 			Owner:        hostname + "/actor",
 			PublicKeyPem: publicKeyPemString,
 		},
 	}
 
+	// Ed25519 key is verified by Multikey. Previous key is served until remote servers expire their cache.
+	previous := globalConfig.PreviousActorKey()
+	if PrivateKeyType(privateKey) == Ed25519Key || previous != nil {
+		newActor.Context = []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1", "https://w3id.org/security/multikey/v1"}
+		newActor.AssertionMethod = Multikeys{NewMultikey(keyID, newActor.ID, privateKey.Public())}
+		if previous != nil {
+			newActor.AssertionMethod = append(newActor.AssertionMethod, NewMultikey(previous.ID, newActor.ID, previous.PublicKey))
+		}
+	}
+
 	if globalConfig.serviceIconURL != nil {
 		newActor.Icon = &Image{
 			URL: globalConfig.serviceIconURL.String(),
@@ -103,7 +215,7 @@ func NewActivityPubActorFromRelayConfig(globalConfig *RelayConfig) Actor {
 }
 
 // NewActivityPubActorFromRemoteActor : Retrieve Actor from remote instance.
-func NewActivityPubActorFromRemoteActor(url string, uaString string, cache *cache.Cache, keyID string, privateKey *rsa.PrivateKey) (Actor, error) {
+func NewActivityPubActorFromRemoteActor(url string, uaString string, cache *cache.Cache, keyID string, privateKey crypto.PrivateKey) (Actor, error) {
 	var actor = new(Actor)
 	var err error
 	cacheData, found := cache.Get(url)
@@ -112,9 +224,11 @@ func NewActivityPubActorFromRemoteActor(url string, uaString string, cache *cach
 		if err != nil {
 			cache.Delete(url)
 		} else {
+			ActorCacheRequests.WithLabelValues("hit").Inc()
 			return *actor, nil
 		}
 	}
+	ActorCacheRequests.WithLabelValues("miss").Inc()
 	req, _ := http.NewRequest("GET", url, nil)
 	req.Header.Set("Accept", "application/activity+json")
 	req.Header.Set("User-Agent", uaString)
@@ -259,9 +373,10 @@ func NewActivityPubActivity(actor Actor, to []string, object interface{}, activi
 	}
 }
 
-// NewActivityPubActivityFromRemoteActivity : Retrieve Activity from remote instance.
-func NewActivityPubActivityFromRemoteActivity(url string, uaString string) (Activity, error) {
+// NewActivityPubActivityFromRemoteActivity : Retrieve Activity from remote instance. Fetched document is also returned as decoded JSON for content filters.
+func NewActivityPubActivityFromRemoteActivity(url string, uaString string) (Activity, map[string]interface{}, error) {
 	var activity = new(Activity)
+	var document map[string]interface{}
 	var err error
 	req, _ := http.NewRequest("GET", url, nil)
 	req.Header.Set("Accept", "application/activity+json")
@@ -269,20 +384,87 @@ func NewActivityPubActivityFromRemoteActivity(url string, uaString string) (Acti
 	client := new(http.Client)
 	resp, err := client.Do(req)
 	if err != nil {
-		return *activity, err
+		return *activity, nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return *activity, errors.New(resp.Status)
+		return *activity, nil, errors.New(resp.Status)
 	}
 
 	data, _ := io.ReadAll(resp.Body)
 	err = json.Unmarshal(data, &activity)
 	if err != nil {
-		return *activity, err
+		return *activity, nil, err
 	}
-	return *activity, nil
+	err = json.Unmarshal(data, &document)
+	if err != nil {
+		return *activity, nil, err
+	}
+	return *activity, document, nil
+}
+
+// collectionPageSize : Number of items in OrderedCollectionPage
+const collectionPageSize = 100
+
+// OrderedCollection : ActivityPub OrderedCollection, linking first page when items are listed.
+type OrderedCollection struct {
+	Context    interface{} `json:"@context,omitempty"`
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	TotalItems int         `json:"totalItems"`
+	First      string      `json:"first,omitempty"`
+}
+
+// OrderedCollectionPage : ActivityPub OrderedCollectionPage.
+type OrderedCollectionPage struct {
+	Context      interface{} `json:"@context,omitempty"`
+	ID           string      `json:"id"`
+	Type         string      `json:"type"`
+	TotalItems   int         `json:"totalItems"`
+	PartOf       string      `json:"partOf"`
+	Next         string      `json:"next,omitempty"`
+	Prev         string      `json:"prev,omitempty"`
+	OrderedItems []string    `json:"orderedItems"`
+}
+
+// NewOrderedCollection : Create OrderedCollection of totalItems. First page is linked only when withItems.
+func NewOrderedCollection(id string, totalItems int, withItems bool) OrderedCollection {
+	collection := OrderedCollection{
+		Context:    "https://www.w3.org/ns/activitystreams",
+		ID:         id,
+		Type:       "OrderedCollection",
+		TotalItems: totalItems,
+	}
+	if withItems {
+		collection.First = id + "?page=1"
+	}
+	return collection
+}
+
+// NewOrderedCollectionPage : Create page (1-origin) of OrderedCollection. Return false when page is out of range.
+func NewOrderedCollectionPage(id string, items []string, page int) (OrderedCollectionPage, bool) {
+	pages := max(1, (len(items)+collectionPageSize-1)/collectionPageSize)
+	if page < 1 || page > pages {
+		return OrderedCollectionPage{}, false
+	}
+	start := (page - 1) * collectionPageSize
+	end := min(len(items), start+collectionPageSize)
+	collectionPage := OrderedCollectionPage{
+		Context:      "https://www.w3.org/ns/activitystreams",
+		ID:           id + "?page=" + strconv.Itoa(page),
+		Type:         "OrderedCollectionPage",
+		TotalItems:   len(items),
+		PartOf:       id,
+		OrderedItems: append([]string{}, items[start:end]...),
+	}
+	if page < pages {
+		collectionPage.Next = id + "?page=" + strconv.Itoa(page+1)
+	}
+	if page > 1 {
+		collectionPage.Prev = id + "?page=" + strconv.Itoa(page-1)
+	}
+	return collectionPage, true
 }
 
 // Signature : ActivityPub Header Signature.
@@ -303,6 +485,7 @@ type WebfingerLink struct {
 // WebfingerResource : Webfinger Resource.
 type WebfingerResource struct {
 	Subject string          `json:"subject,omitempty"`
+	Aliases []string        `json:"aliases,omitempty"`
 	Links   []WebfingerLink `json:"links,omitempty"`
 }
 
@@ -311,16 +494,102 @@ func (actor *Actor) GenerateWebfingerResource(hostname *url.URL) WebfingerResour
 	resource := new(WebfingerResource)
 
 	resource.Subject = "acct:" + actor.PreferredUsername + "@" + hostname.Host
+	resource.Aliases = []string{actor.ID}
 	resource.Links = []WebfingerLink{
 		{
 			"self",
 			"application/activity+json",
 			actor.ID,
 		},
+		{
+			"http://webfinger.net/rel/profile-page",
+			"text/html",
+			"https://" + hostname.Host + "/",
+		},
 	}
 	return *resource
 }
 
+// Matches : Check queried resource is subject or alias of resource. Scheme and host are compared case-insensitively, and acct without scheme is accepted.
+func (resource WebfingerResource) Matches(queried string) bool {
+	queried = normalizeWebfingerURI(queried)
+	if queried == normalizeWebfingerURI(resource.Subject) {
+		return true
+	}
+	for _, alias := range resource.Aliases {
+		if queried == normalizeWebfingerURI(alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterLinks : Copy of resource with only links of given rels. All links are kept when rels is empty.
+func (resource WebfingerResource) FilterLinks(rels []string) WebfingerResource {
+	if len(rels) == 0 {
+		return resource
+	}
+	var links []WebfingerLink
+	for _, link := range resource.Links {
+		for _, rel := range rels {
+			// Registered relation types are case-insensitive, URI relation types are not
+			if link.Rel == rel || (!strings.Contains(rel, ":") && strings.EqualFold(link.Rel, rel)) {
+				links = append(links, link)
+				break
+			}
+		}
+	}
+	resource.Links = links
+	return resource
+}
+
+func normalizeWebfingerURI(uri string) string {
+	scheme, rest, found := strings.Cut(uri, ":")
+	if !found || (!strings.EqualFold(scheme, "acct") && strings.Contains(uri, "@") && !strings.Contains(uri, "/")) {
+		scheme, rest = "acct", uri
+	}
+	if strings.EqualFold(scheme, "acct") {
+		index := strings.LastIndex(rest, "@")
+		if index < 0 {
+			return "acct:" + rest
+		}
+		return "acct:" + rest[:index+1] + strings.ToLower(rest[index+1:])
+	}
+	parsedURI, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	parsedURI.Scheme = strings.ToLower(parsedURI.Scheme)
+	parsedURI.Host = strings.ToLower(parsedURI.Host)
+	return parsedURI.String()
+}
+
+// HostMeta : host-meta Resource, served as XRD and JSON.
+type HostMeta struct {
+	XMLName xml.Name       `xml:"http://docs.oasis-open.org/ns/xri/xrd-1.0 XRD" json:"-"`
+	Links   []HostMetaLink `xml:"Link" json:"links"`
+}
+
+// HostMetaLink : host-meta Link Resource.
+type HostMetaLink struct {
+	Rel      string `xml:"rel,attr" json:"rel"`
+	Type     string `xml:"type,attr,omitempty" json:"type,omitempty"`
+	Template string `xml:"template,attr" json:"template"`
+}
+
+// GenerateHostMeta : Generate host-meta resource pointing to Webfinger endpoint.
+func GenerateHostMeta(hostname *url.URL) HostMeta {
+	return HostMeta{
+		Links: []HostMetaLink{
+			{
+				"lrdd",
+				"application/jrd+json",
+				"https://" + hostname.Host + "/.well-known/webfinger?resource={uri}",
+			},
+		},
+	}
+}
+
 // NodeinfoResources : Nodeinfo Resources.
 type NodeinfoResources struct {
 	NodeinfoLinks NodeinfoLinks
@@ -106,3 +106,77 @@ func sliceEqual(a, b []string) bool {
 	}
 	return true
 }
+
+func TestNewOrderedCollectionPage(t *testing.T) {
+	var items []string
+	for i := 0; i < collectionPageSize+1; i++ {
+		items = append(items, fmt.Sprintf("https://example.com/users/%d", i))
+	}
+	id := "https://relay.example.com/actor/followers"
+
+	page, ok := NewOrderedCollectionPage(id, items, 1)
+	if !ok || len(page.OrderedItems) != collectionPageSize || page.Next != id+"?page=2" || page.Prev != "" {
+		t.Fatalf("Expected full first page linking next page, but got %+v", page)
+	}
+	page, ok = NewOrderedCollectionPage(id, items, 2)
+	if !ok || len(page.OrderedItems) != 1 || page.Next != "" || page.Prev != id+"?page=1" {
+		t.Fatalf("Expected last page linking previous page, but got %+v", page)
+	}
+	if _, ok = NewOrderedCollectionPage(id, items, 3); ok {
+		t.Fatal("Expected page out of range to be refused, but accepted")
+	}
+	if page, ok = NewOrderedCollectionPage(id, nil, 1); !ok || page.OrderedItems == nil {
+		t.Fatalf("Expected empty first page, but got %+v", page)
+	}
+}
+
+func TestWebfingerResourceMatches(t *testing.T) {
+	resource := WebfingerResource{
+		Subject: "acct:relay@relay.example.com",
+		Aliases: []string{"https://relay.example.com/actor"},
+	}
+	testCases := []struct {
+		queried  string
+		expected bool
+	}{
+		{"acct:relay@relay.example.com", true},
+		{"ACCT:relay@Relay.Example.Com", true},
+		{"relay@relay.example.com", true},
+		{"acct:Relay@relay.example.com", false},
+		{"https://relay.example.com/actor", true},
+		{"HTTPS://RELAY.EXAMPLE.COM/actor", true},
+		{"https://relay.example.com/Actor", false},
+		{"acct:yukimochi@relay.example.com", false},
+	}
+	for _, testCase := range testCases {
+		if resource.Matches(testCase.queried) != testCase.expected {
+			t.Fatalf("Expected match of '%s' to be %t, but got %t", testCase.queried, testCase.expected, !testCase.expected)
+		}
+	}
+}
+
+func TestWebfingerResourceFilterLinks(t *testing.T) {
+	resource := WebfingerResource{
+		Subject: "acct:relay@relay.example.com",
+		Links: []WebfingerLink{
+			{"self", "application/activity+json", "https://relay.example.com/actor"},
+			{"http://webfinger.net/rel/profile-page", "text/html", "https://relay.example.com/"},
+		},
+	}
+
+	filtered := resource.FilterLinks([]string{"SELF"})
+	if len(filtered.Links) != 1 || filtered.Links[0].Rel != "self" {
+		t.Fatalf("Expected only self link, but got %+v", filtered.Links)
+	}
+	filtered = resource.FilterLinks([]string{"http://webfinger.net/rel/profile-page", "self"})
+	if len(filtered.Links) != 2 {
+		t.Fatalf("Expected 2 links, but got %+v", filtered.Links)
+	}
+	filtered = resource.FilterLinks([]string{"HTTP://WEBFINGER.NET/rel/profile-page"})
+	if len(filtered.Links) != 0 {
+		t.Fatalf("Expected no links, but got %+v", filtered.Links)
+	}
+	if len(resource.FilterLinks(nil).Links) != 2 {
+		t.Fatalf("Expected all links without rel")
+	}
+}
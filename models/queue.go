@@ -0,0 +1,205 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yukimochi/machinery-v1/v1"
+	"github.com/yukimochi/machinery-v1/v1/retry"
+	"github.com/yukimochi/machinery-v1/v1/tasks"
+)
+
+// TaskHandler : Function processing task with string arguments
+type TaskHandler func(args ...string) error
+
+// JobQueue : Queue of delivery tasks
+type JobQueue interface {
+	// SendTask : Enqueue task. Task with ETA is delayed until ETA.
+	SendTask(signature *tasks.Signature) error
+}
+
+// MachineryQueue : JobQueue backed by Machinery on Redis, processed by Job Worker
+type MachineryQueue struct {
+	server *machinery.Server
+}
+
+// NewMachineryQueue : Create MachineryQueue sending tasks to server
+func NewMachineryQueue(server *machinery.Server) *MachineryQueue {
+	return &MachineryQueue{server}
+}
+
+func (queue *MachineryQueue) SendTask(signature *tasks.Signature) error {
+	_, err := queue.server.SendTask(signature)
+	return err
+}
+
+// DisabledQueue : JobQueue of CLI running without Redis, where no Job Worker receives tasks. Tasks are refused.
+type DisabledQueue struct{}
+
+func (DisabledQueue) SendTask(signature *tasks.Signature) error {
+	return errors.New("job queue requires Redis, " + signature.Name + " task is not sent")
+}
+
+// MemoryQueue : JobQueue processed in process, for standalone mode. Queued tasks are lost on exit.
+type MemoryQueue struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	handlers map[string]TaskHandler
+	pending  []*tasks.Signature
+	delayed  map[*time.Timer]struct{}
+	closed   bool
+	workers  sync.WaitGroup
+}
+
+// NewMemoryQueue : Create empty MemoryQueue
+func NewMemoryQueue() *MemoryQueue {
+	queue := &MemoryQueue{
+		handlers: make(map[string]TaskHandler),
+		delayed:  make(map[*time.Timer]struct{}),
+	}
+	queue.cond = sync.NewCond(&queue.mutex)
+	return queue
+}
+
+// RegisterTask : Register handler for task name
+func (queue *MemoryQueue) RegisterTask(name string, handler TaskHandler) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	queue.handlers[name] = handler
+}
+
+func (queue *MemoryQueue) SendTask(signature *tasks.Signature) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if queue.closed {
+		return errors.New("queue is closed")
+	}
+
+	if signature.ETA != nil {
+		delay := time.Until(*signature.ETA)
+		if delay > 0 {
+			var timer *time.Timer
+			timer = time.AfterFunc(delay, func() {
+				queue.mutex.Lock()
+				defer queue.mutex.Unlock()
+				if _, ok := queue.delayed[timer]; !ok {
+					return
+				}
+				delete(queue.delayed, timer)
+				queue.push(signature)
+			})
+			queue.delayed[timer] = struct{}{}
+			return nil
+		}
+	}
+	queue.push(signature)
+	return nil
+}
+
+// push : Append task to pending tasks. Caller must hold mutex.
+func (queue *MemoryQueue) push(signature *tasks.Signature) {
+	queue.pending = append(queue.pending, signature)
+	queue.cond.Signal()
+}
+
+// Len : Number of waiting and delayed tasks
+func (queue *MemoryQueue) Len() int {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return len(queue.pending) + len(queue.delayed)
+}
+
+// Launch : Start workers processing tasks concurrently
+func (queue *MemoryQueue) Launch(concurrency int) {
+	for i := 0; i < concurrency; i++ {
+		queue.workers.Add(1)
+		go queue.work()
+	}
+}
+
+func (queue *MemoryQueue) work() {
+	defer queue.workers.Done()
+	for {
+		signature := queue.pop()
+		if signature == nil {
+			return
+		}
+		queue.process(signature)
+	}
+}
+
+// pop : Wait for next task. Return nil when queue is closed.
+func (queue *MemoryQueue) pop() *tasks.Signature {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	for len(queue.pending) == 0 && !queue.closed {
+		queue.cond.Wait()
+	}
+	if queue.closed {
+		return nil
+	}
+	signature := queue.pending[0]
+	queue.pending = queue.pending[1:]
+	return signature
+}
+
+func (queue *MemoryQueue) process(signature *tasks.Signature) {
+	queue.mutex.Lock()
+	handler, ok := queue.handlers[signature.Name]
+	queue.mutex.Unlock()
+	if !ok {
+		logrus.Error("Task not registered : ", signature.Name)
+		return
+	}
+
+	args := make([]string, len(signature.Args))
+	for i, arg := range signature.Args {
+		args[i] = fmt.Sprint(arg.Value)
+	}
+	err := handler(args...)
+	if err == nil || signature.RetryCount < 1 {
+		return
+	}
+
+	// Retry in same manner as Machinery worker
+	retryTimeout := retry.FibonacciNext(signature.RetryTimeout)
+	eta := time.Now().Add(time.Duration(retryTimeout) * time.Second)
+	retrySignature := *signature
+	retrySignature.RetryCount--
+	retrySignature.RetryTimeout = retryTimeout
+	retrySignature.ETA = &eta
+	err = queue.SendTask(&retrySignature)
+	if err != nil {
+		logrus.Error("Failed to retry task ", signature.Name, " : ", err)
+	}
+}
+
+// Shutdown : Stop accepting tasks, drop waiting and delayed tasks, and wait for running tasks within ctx. Return number of dropped tasks.
+func (queue *MemoryQueue) Shutdown(ctx context.Context) (int, error) {
+	queue.mutex.Lock()
+	queue.closed = true
+	dropped := len(queue.pending) + len(queue.delayed)
+	queue.pending = nil
+	for timer := range queue.delayed {
+		timer.Stop()
+	}
+	queue.delayed = make(map[*time.Timer]struct{})
+	queue.cond.Broadcast()
+	queue.mutex.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		queue.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return dropped, nil
+	case <-ctx.Done():
+		return dropped, errors.New("shutdown deadline exceeded with running tasks")
+	}
+}
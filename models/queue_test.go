@@ -0,0 +1,137 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yukimochi/machinery-v1/v1/tasks"
+)
+
+func newTestSignature(name string, retryCount int, eta *time.Time) *tasks.Signature {
+	return &tasks.Signature{
+		Name:       name,
+		RetryCount: retryCount,
+		ETA:        eta,
+		Args: []tasks.Arg{
+			{
+				Name:  "inboxURL",
+				Type:  "string",
+				Value: "https://example.com/inbox",
+			},
+		},
+	}
+}
+
+func TestMemoryQueue(t *testing.T) {
+	t.Run("Process task with registered handler", func(t *testing.T) {
+		queue := NewMemoryQueue()
+		received := make(chan []string, 1)
+		queue.RegisterTask("register", func(args ...string) error {
+			received <- args
+			return nil
+		})
+		queue.Launch(2)
+		defer queue.Shutdown(context.Background())
+
+		queue.SendTask(newTestSignature("register", 0, nil))
+		select {
+		case args := <-received:
+			if len(args) != 1 || args[0] != "https://example.com/inbox" {
+				t.Fatalf("Expected args to be [https://example.com/inbox], but got %v", args)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected task to be processed, but timed out")
+		}
+	})
+
+	t.Run("Delay task until ETA", func(t *testing.T) {
+		queue := NewMemoryQueue()
+		received := make(chan time.Time, 1)
+		queue.RegisterTask("register", func(args ...string) error {
+			received <- time.Now()
+			return nil
+		})
+		queue.Launch(1)
+		defer queue.Shutdown(context.Background())
+
+		eta := time.Now().Add(200 * time.Millisecond)
+		queue.SendTask(newTestSignature("register", 0, &eta))
+		if queue.Len() != 1 {
+			t.Fatalf("Expected 1 delayed task, but got %d", queue.Len())
+		}
+		select {
+		case processed := <-received:
+			if processed.Before(eta) {
+				t.Fatalf("Expected task to be processed after %v, but processed at %v", eta, processed)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected delayed task to be processed, but timed out")
+		}
+	})
+
+	t.Run("Retry failed task", func(t *testing.T) {
+		queue := NewMemoryQueue()
+		var attempts int32
+		done := make(chan struct{})
+		queue.RegisterTask("register", func(args ...string) error {
+			if atomic.AddInt32(&attempts, 1) < 2 {
+				return errors.New("temporary error")
+			}
+			close(done)
+			return nil
+		})
+		queue.Launch(1)
+		defer queue.Shutdown(context.Background())
+
+		queue.SendTask(newTestSignature("register", 1, nil))
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("Expected task to be retried, but got %d attempts", atomic.LoadInt32(&attempts))
+		}
+	})
+
+	t.Run("Drop queued tasks on shutdown", func(t *testing.T) {
+		queue := NewMemoryQueue()
+		eta := time.Now().Add(time.Hour)
+		queue.SendTask(newTestSignature("register", 0, nil))
+		queue.SendTask(newTestSignature("register", 0, &eta))
+
+		dropped, err := queue.Shutdown(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dropped != 2 {
+			t.Fatalf("Expected 2 dropped tasks, but got %d", dropped)
+		}
+		err = queue.SendTask(newTestSignature("register", 0, nil))
+		if err == nil {
+			t.Fatal("Expected error for closed queue, but got nil")
+		}
+	})
+
+	t.Run("Wait for running tasks on shutdown", func(t *testing.T) {
+		queue := NewMemoryQueue()
+		started := make(chan struct{})
+		release := make(chan struct{})
+		queue.RegisterTask("register", func(args ...string) error {
+			close(started)
+			<-release
+			return nil
+		})
+		queue.Launch(1)
+		queue.SendTask(newTestSignature("register", 0, nil))
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := queue.Shutdown(ctx)
+		if err == nil {
+			t.Fatal("Expected shutdown deadline error, but got nil")
+		}
+		close(release)
+	})
+}
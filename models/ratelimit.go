@@ -0,0 +1,236 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// RateLimit : Token bucket for inbound requests per source domain. Zero Rate means unlimited.
+type RateLimit struct {
+	// Rate : Refilled tokens per second
+	Rate float64 `json:"rate"`
+	// Burst : Capacity of bucket
+	Burst int `json:"burst"`
+}
+
+// Unlimited : RateLimit does not limit requests
+func (limit RateLimit) Unlimited() bool {
+	return limit.Rate <= 0
+}
+
+// String : Human readable rate limit
+func (limit RateLimit) String() string {
+	if limit.Unlimited() {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%s req/s (burst %d)", strconv.FormatFloat(limit.Rate, 'f', -1, 64), limit.Burst)
+}
+
+func (limit *RateLimit) validate() error {
+	if limit.Rate < 0 || math.IsNaN(limit.Rate) || math.IsInf(limit.Rate, 0) {
+		return errors.New("rate must be zero or positive number")
+	}
+	if limit.Burst < 0 {
+		return errors.New("burst must be zero or positive number")
+	}
+	if limit.Unlimited() {
+		limit.Rate, limit.Burst = 0, 0
+	} else if limit.Burst == 0 {
+		limit.Burst = int(math.Ceil(limit.Rate))
+	}
+	return nil
+}
+
+// takeTokenScript : Refill bucket by elapsed time and take one token. Return {allowed, milliseconds to wait}.
+const takeTokenScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+local updated = tonumber(redis.call('HGET', KEYS[1], 'updated'))
+if tokens == nil or updated == nil then
+	tokens = burst
+	updated = now
+end
+if now > updated then
+	tokens = math.min(burst, tokens + (now - updated) * rate / 1000)
+	updated = now
+end
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(updated))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return {allowed, wait}
+`
+
+// SelectRateLimit : Rate limit applied to domain
+func (config *RelayState) SelectRateLimit(domain string) RateLimit {
+	if limit, ok := config.RateLimits[domain]; ok {
+		return limit
+	}
+	if config.DefaultRateLimit != nil {
+		return *config.DefaultRateLimit
+	}
+	return RateLimit{}
+}
+
+// addressRateLimit : Rate limit applied to remote address before signature is verified. Override of the address, or the most permissive limit of default and domain overrides so that verified domain limits stay effective.
+func (config *RelayState) addressRateLimit(address string) RateLimit {
+	if limit, ok := config.RateLimits[address]; ok {
+		return limit
+	}
+	if config.DefaultRateLimit == nil {
+		return RateLimit{}
+	}
+	permissive := *config.DefaultRateLimit
+	for _, limit := range config.RateLimits {
+		if limit.Unlimited() {
+			return limit
+		}
+		if limit.Rate > permissive.Rate {
+			permissive.Rate = limit.Rate
+		}
+		if limit.Burst > permissive.Burst {
+			permissive.Burst = limit.Burst
+		}
+	}
+	return permissive
+}
+
+// takeToken : Take token from bucket of key. Return false and duration to wait when bucket is empty.
+func (config *RelayState) takeToken(key string, limit RateLimit) (bool, time.Duration) {
+	if limit.Unlimited() || config.RedisClient == nil {
+		return true, 0
+	}
+	result, err := config.RedisClient.Eval(context.TODO(), takeTokenScript, []string{key}, limit.Rate, limit.Burst, time.Now().UnixMilli()).Int64Slice()
+	if err != nil || len(result) != 2 {
+		// Accept request rather than rejecting all requests on Redis failure
+		return true, 0
+	}
+	if result[0] == 1 {
+		return true, 0
+	}
+	return false, time.Duration(result[1]) * time.Millisecond
+}
+
+// TakeAddressRateLimitToken : Take token from bucket of remote address, before signature is verified. Rejections are not recorded in statistics.
+func (config *RelayState) TakeAddressRateLimitToken(address string) (bool, time.Duration) {
+	return config.takeToken("relay:ratelimit:address:"+address, config.addressRateLimit(address))
+}
+
+// TakeRateLimitToken : Take token from bucket of domain, verified by signature. Return false and duration to wait when bucket is empty.
+func (config *RelayState) TakeRateLimitToken(domain string) (bool, time.Duration) {
+	allowed, retryAfter := config.takeToken("relay:ratelimit:"+domain, config.SelectRateLimit(domain))
+	if !allowed {
+		config.RedisClient.HIncrBy(context.TODO(), "relay:statistics:ratelimited", domain, 1).Result()
+		RateLimitedRequests.WithLabelValues(domain).Inc()
+	}
+	return allowed, retryAfter
+}
+
+// RateLimitStatistics : Number of rate limited requests by domain
+func (config *RelayState) RateLimitStatistics() map[string]int64 {
+	statistics := make(map[string]int64)
+	if config.RedisClient == nil {
+		return statistics
+	}
+	values, _ := config.RedisClient.HGetAll(context.TODO(), "relay:statistics:ratelimited").Result()
+	for domain, value := range values {
+		count, err := strconv.ParseInt(value, 10, 64)
+		if err == nil {
+			statistics[domain] = count
+		}
+	}
+	return statistics
+}
+
+// SetDefaultRateLimit : Set rate limit applied to domains without override
+func (config *RelayState) SetDefaultRateLimit(limit RateLimit) error {
+	if config.RedisClient == nil {
+		return errRedisRequired
+	}
+	err := limit.validate()
+	if err != nil {
+		return err
+	}
+	data, _ := json.Marshal(&limit)
+	_, err = config.RedisClient.Set(context.TODO(), "relay:config:defaultRateLimit", data, 0).Result()
+	if err != nil {
+		return err
+	}
+	config.RecordAudit(AuditRateLimitSet, "default "+limit.String())
+
+	config.refresh(changeConfig)
+	return nil
+}
+
+// SetRateLimit : Set rate limit override for domain
+func (config *RelayState) SetRateLimit(domain string, limit RateLimit) error {
+	if config.RedisClient == nil {
+		return errRedisRequired
+	}
+	err := limit.validate()
+	if err != nil {
+		return err
+	}
+	data, _ := json.Marshal(&limit)
+	_, err = config.RedisClient.HSet(context.TODO(), "relay:config:rateLimit", domain, data).Result()
+	if err != nil {
+		return err
+	}
+	config.RecordAudit(AuditRateLimitSet, domain+" "+limit.String())
+
+	config.refresh(changeConfig)
+	return nil
+}
+
+// DelRateLimit : Delete rate limit override for domain
+func (config *RelayState) DelRateLimit(domain string) bool {
+	if config.RedisClient == nil {
+		return false
+	}
+	deleted, _ := config.RedisClient.HDel(context.TODO(), "relay:config:rateLimit", domain).Result()
+	if deleted == 0 {
+		return false
+	}
+	config.RecordAudit(AuditRateLimitDelete, domain)
+
+	config.refresh(changeConfig)
+	return true
+}
+
+func (config *RelayState) loadRateLimits() (*RateLimit, map[string]RateLimit) {
+	var defaultLimit *RateLimit
+	value, err := config.RedisClient.Get(context.TODO(), "relay:config:defaultRateLimit").Result()
+	if err == nil {
+		var limit RateLimit
+		if json.Unmarshal([]byte(value), &limit) == nil {
+			defaultLimit = &limit
+		}
+	}
+
+	var limits map[string]RateLimit
+	values, _ := config.RedisClient.HGetAll(context.TODO(), "relay:config:rateLimit").Result()
+	for domain, value := range values {
+		var limit RateLimit
+		if json.Unmarshal([]byte(value), &limit) != nil {
+			continue
+		}
+		if limits == nil {
+			limits = make(map[string]RateLimit)
+		}
+		limits[domain] = limit
+	}
+	return defaultLimit, limits
+}
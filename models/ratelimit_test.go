@@ -0,0 +1,96 @@
+package models
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTakeRateLimitToken(t *testing.T) {
+	relayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	relayState.SetRateLimit("example.com", RateLimit{Rate: 0.01, Burst: 2})
+	<-ch
+
+	for i := 0; i < 2; i++ {
+		allowed, _ := relayState.TakeRateLimitToken("example.com")
+		if !allowed {
+			t.Fatalf("Expected request %d to be allowed within burst, but it was rejected", i+1)
+		}
+	}
+	allowed, retryAfter := relayState.TakeRateLimitToken("example.com")
+	if allowed {
+		t.Fatalf("Expected request over burst to be rejected, but it was allowed")
+	}
+	if retryAfter <= 0 {
+		t.Fatalf("Expected retry after to be positive, but got %s", retryAfter)
+	}
+	allowed, _ = relayState.TakeRateLimitToken("unlimited.example.com")
+	if !allowed {
+		t.Fatalf("Expected request from domain without limit to be allowed, but it was rejected")
+	}
+
+	statistics := relayState.RateLimitStatistics()
+	if statistics["example.com"] != 1 || len(statistics) != 1 {
+		t.Fatalf("Expected 1 rate limited request for example.com, but got %v", statistics)
+	}
+}
+
+func TestTakeAddressRateLimitToken(t *testing.T) {
+	relayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	relayState.SetDefaultRateLimit(RateLimit{Rate: 0.01, Burst: 1})
+	<-ch
+	relayState.SetRateLimit("example.com", RateLimit{Rate: 0.01, Burst: 2})
+	<-ch
+
+	for i := 0; i < 2; i++ {
+		allowed, _ := relayState.TakeAddressRateLimitToken("192.0.2.1")
+		if !allowed {
+			t.Fatalf("Expected request %d to be allowed within most permissive burst, but it was rejected", i+1)
+		}
+	}
+	allowed, _ := relayState.TakeAddressRateLimitToken("192.0.2.1")
+	if allowed {
+		t.Fatalf("Expected request over burst to be rejected, but it was allowed")
+	}
+	if len(relayState.RateLimitStatistics()) != 0 {
+		t.Fatalf("Expected address rate limit not to be recorded, but got %v", relayState.RateLimitStatistics())
+	}
+
+	relayState.SetRateLimit("example.org", RateLimit{})
+	<-ch
+	allowed, _ = relayState.TakeAddressRateLimitToken("192.0.2.2")
+	if !allowed {
+		t.Fatalf("Expected address to be unlimited while any domain is unlimited, but it was rejected")
+	}
+}
+
+func TestSelectRateLimit(t *testing.T) {
+	relayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	relayState.SetDefaultRateLimit(RateLimit{Rate: 2.5})
+	<-ch
+	relayState.SetRateLimit("example.com", RateLimit{Rate: 10, Burst: 50})
+	<-ch
+
+	limit := relayState.SelectRateLimit("other.example.com")
+	if limit.Rate != 2.5 || limit.Burst != 3 {
+		t.Fatalf("Expected default rate limit with rounded up burst, but got %v", limit)
+	}
+	limit = relayState.SelectRateLimit("example.com")
+	if limit.Rate != 10 || limit.Burst != 50 {
+		t.Fatalf("Expected rate limit override, but got %v", limit)
+	}
+
+	relayState.DelRateLimit("example.com")
+	<-ch
+	limit = relayState.SelectRateLimit("example.com")
+	if limit.Rate != 2.5 {
+		t.Fatalf("Expected default rate limit after unset, but got %v", limit)
+	}
+
+	err := relayState.SetRateLimit("example.com", RateLimit{Rate: -1})
+	if err == nil {
+		t.Fatalf("Expected negative rate to be rejected, but it was accepted")
+	}
+}
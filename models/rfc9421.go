@@ -0,0 +1,338 @@
+package models
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RFC9421SignatureLabel : Label of signature produced by relay
+const RFC9421SignatureLabel = "sig1"
+
+// rfc9421CoveredComponents : Components covered by outgoing signatures
+var rfc9421CoveredComponents = []string{"@method", "@target-uri", "content-digest", "content-type"}
+
+// GenerateContentDigest : Generate RFC 9530 Content-Digest header value.
+func GenerateContentDigest(body []byte) string {
+	hash := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(hash[:]) + ":"
+}
+
+// VerifyContentDigest : Verify RFC 9530 Content-Digest header value against body.
+func VerifyContentDigest(header string, body []byte) error {
+	members, err := parseSFDictionary(header)
+	if err != nil {
+		return err
+	}
+	verified := false
+	for _, member := range members {
+		item, ok := member.value.(sfItem)
+		if !ok {
+			continue
+		}
+		given, ok := item.value.([]byte)
+		if !ok {
+			continue
+		}
+		var calculated []byte
+		switch member.key {
+		case "sha-256":
+			hash := sha256.Sum256(body)
+			calculated = hash[:]
+		case "sha-512":
+			hash := sha512.Sum512(body)
+			calculated = hash[:]
+		default:
+			continue
+		}
+		if subtle.ConstantTimeCompare(given, calculated) != 1 {
+			return errors.New("content-digest header is mismatch")
+		}
+		verified = true
+	}
+	if !verified {
+		return errors.New("content-digest header has no supported digest")
+	}
+	return nil
+}
+
+// SignRequestRFC9421 : Sign request with RFC 9421 HTTP Message Signatures.
+func SignRequestRFC9421(request *http.Request, body []byte, keyID string, privateKey crypto.PrivateKey, lifetime time.Duration) error {
+	request.Header.Set("Content-Digest", GenerateContentDigest(body))
+
+	created := time.Now()
+	identifiers := make([]string, len(rfc9421CoveredComponents))
+	for i, component := range rfc9421CoveredComponents {
+		identifiers[i] = serializeSFString(component)
+	}
+	signatureParams := fmt.Sprintf("(%s);created=%d;expires=%d;keyid=%s", strings.Join(identifiers, " "), created.Unix(), created.Add(lifetime).Unix(), serializeSFString(keyID))
+
+	base, err := rfc9421SignatureBase(request, rfc9421CoveredComponents, signatureParams)
+	if err != nil {
+		return err
+	}
+
+	var signature []byte
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		hash := sha256.Sum256([]byte(base))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(base))
+	default:
+		err = errors.New("unsupported private key type")
+	}
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Signature-Input", RFC9421SignatureLabel+"="+signatureParams)
+	request.Header.Set("Signature", RFC9421SignatureLabel+"=:"+base64.StdEncoding.EncodeToString(signature)+":")
+	return nil
+}
+
+// RFC9421Verifier : Verifier of RFC 9421 HTTP Message Signatures.
+type RFC9421Verifier struct {
+	request         *http.Request
+	components      []string
+	signatureParams string
+	signature       []byte
+	keyID           string
+	algorithm       string
+	created         time.Time
+	expires         time.Time
+}
+
+// NewRFC9421Verifier : Create verifier from Signature-Input and Signature headers.
+func NewRFC9421Verifier(request *http.Request) (*RFC9421Verifier, error) {
+	inputs, err := parseSFDictionary(strings.Join(request.Header.Values("Signature-Input"), ", "))
+	if err != nil {
+		return nil, err
+	}
+	signatures, err := parseSFDictionary(strings.Join(request.Header.Values("Signature"), ", "))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, input := range inputs {
+		list, ok := input.value.(sfInnerList)
+		if !ok {
+			continue
+		}
+		for _, signature := range signatures {
+			if signature.key != input.key {
+				continue
+			}
+			item, ok := signature.value.(sfItem)
+			if !ok {
+				return nil, errors.New("signature is not a byte sequence")
+			}
+			signatureBytes, ok := item.value.([]byte)
+			if !ok {
+				return nil, errors.New("signature is not a byte sequence")
+			}
+			return newRFC9421Verifier(request, list, input.raw, signatureBytes)
+		}
+	}
+	return nil, errors.New("no matching signature found in \"Signature-Input\" and \"Signature\"")
+}
+
+func newRFC9421Verifier(request *http.Request, list sfInnerList, signatureParams string, signature []byte) (*RFC9421Verifier, error) {
+	verifier := &RFC9421Verifier{
+		request:         request,
+		signatureParams: signatureParams,
+		signature:       signature,
+	}
+	for _, item := range list.items {
+		component, ok := item.value.(string)
+		if !ok {
+			return nil, errors.New("component identifier is not a string")
+		}
+		if len(item.params) > 0 {
+			return nil, errors.New("unsupported component parameter: " + component)
+		}
+		verifier.components = append(verifier.components, component)
+	}
+	for _, param := range list.params {
+		switch param.key {
+		case "keyid":
+			value, ok := param.value.(string)
+			if !ok {
+				return nil, errors.New("keyid is not a string")
+			}
+			verifier.keyID = value
+		case "alg":
+			value, ok := param.value.(string)
+			if !ok {
+				return nil, errors.New("alg is not a string")
+			}
+			verifier.algorithm = value
+		case "created", "expires":
+			value, ok := param.value.(int64)
+			if !ok {
+				return nil, errors.New(param.key + " is not an integer")
+			}
+			if param.key == "created" {
+				verifier.created = time.Unix(value, 0)
+			} else {
+				verifier.expires = time.Unix(value, 0)
+			}
+		}
+	}
+	if verifier.keyID == "" {
+		return nil, errors.New("signature has no keyid")
+	}
+	return verifier, nil
+}
+
+// KeyId : keyid parameter of signature
+func (verifier *RFC9421Verifier) KeyId() string {
+	return verifier.keyID
+}
+
+// Created : created parameter of signature (zero if absent)
+func (verifier *RFC9421Verifier) Created() time.Time {
+	return verifier.created
+}
+
+// Expires : expires parameter of signature (zero if absent)
+func (verifier *RFC9421Verifier) Expires() time.Time {
+	return verifier.expires
+}
+
+// Covers : Component is covered by signature
+func (verifier *RFC9421Verifier) Covers(component string) bool {
+	for _, covered := range verifier.components {
+		if covered == component {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify : Verify signature with public key.
+func (verifier *RFC9421Verifier) Verify(publicKey crypto.PublicKey) error {
+	base, err := rfc9421SignatureBase(verifier.request, verifier.components, verifier.signatureParams)
+	if err != nil {
+		return err
+	}
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		switch verifier.algorithm {
+		case "", "rsa-v1_5-sha256":
+			hash := sha256.Sum256([]byte(base))
+			return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], verifier.signature)
+		case "rsa-pss-sha512":
+			hash := sha512.Sum512([]byte(base))
+			return rsa.VerifyPSS(key, crypto.SHA512, hash[:], verifier.signature, &rsa.PSSOptions{SaltLength: 64})
+		}
+	case ed25519.PublicKey:
+		if verifier.algorithm == "" || verifier.algorithm == "ed25519" {
+			if !ed25519.Verify(key, []byte(base), verifier.signature) {
+				return errors.New("ed25519: verification error")
+			}
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		var hash []byte
+		switch {
+		case key.Curve == elliptic.P256() && (verifier.algorithm == "" || verifier.algorithm == "ecdsa-p256-sha256"):
+			sum := sha256.Sum256([]byte(base))
+			hash = sum[:]
+		case key.Curve == elliptic.P384() && (verifier.algorithm == "" || verifier.algorithm == "ecdsa-p384-sha384"):
+			sum := sha512.Sum384([]byte(base))
+			hash = sum[:]
+		default:
+			return errors.New("unsupported signature algorithm: " + verifier.algorithm)
+		}
+		size := len(verifier.signature) / 2
+		if size == 0 || len(verifier.signature)%2 != 0 {
+			return errors.New("ecdsa: invalid signature length")
+		}
+		r := new(big.Int).SetBytes(verifier.signature[:size])
+		s := new(big.Int).SetBytes(verifier.signature[size:])
+		if !ecdsa.Verify(key, hash, r, s) {
+			return errors.New("ecdsa: verification error")
+		}
+		return nil
+	default:
+		return errors.New("unsupported public key type")
+	}
+	return errors.New("unsupported signature algorithm: " + verifier.algorithm)
+}
+
+func rfc9421SignatureBase(request *http.Request, components []string, signatureParams string) (string, error) {
+	var builder strings.Builder
+	for _, component := range components {
+		value, err := rfc9421ComponentValue(request, component)
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString(serializeSFString(component) + ": " + value + "\n")
+	}
+	builder.WriteString("\"@signature-params\": " + signatureParams)
+	return builder.String(), nil
+}
+
+func rfc9421ComponentValue(request *http.Request, component string) (string, error) {
+	scheme := request.URL.Scheme
+	if scheme == "" {
+		// Relay is served over HTTPS behind reverse proxy
+		scheme = "https"
+	}
+	authority := strings.ToLower(request.Host)
+	if authority == "" {
+		authority = strings.ToLower(request.URL.Host)
+	}
+
+	switch component {
+	case "@method":
+		return request.Method, nil
+	case "@target-uri":
+		return scheme + "://" + authority + request.URL.RequestURI(), nil
+	case "@authority":
+		return authority, nil
+	case "@scheme":
+		return scheme, nil
+	case "@request-target":
+		return request.URL.RequestURI(), nil
+	case "@path":
+		path := request.URL.EscapedPath()
+		if path == "" {
+			path = "/"
+		}
+		return path, nil
+	case "@query":
+		return "?" + request.URL.RawQuery, nil
+	case "host":
+		return authority, nil
+	}
+	if strings.HasPrefix(component, "@") {
+		return "", errors.New("unsupported derived component: " + component)
+	}
+
+	values := request.Header.Values(component)
+	if len(values) == 0 {
+		return "", errors.New("covered header is missing: " + component)
+	}
+	// Values aliases header map of request, so trim into new slice
+	trimmed := make([]string, len(values))
+	for i, value := range values {
+		trimmed[i] = strings.TrimSpace(value)
+	}
+	return strings.Join(trimmed, ", "), nil
+}
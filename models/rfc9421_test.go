@@ -0,0 +1,165 @@
+package models
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newRFC9421TestRequest(body []byte) *http.Request {
+	req, _ := http.NewRequest("POST", "https://relay.example.com/inbox", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/activity+json")
+	return req
+}
+
+func TestSignRequestRFC9421(t *testing.T) {
+	body := []byte(`{"type":"Create"}`)
+	req := newRFC9421TestRequest(body)
+	err := SignRequestRFC9421(req, body, "https://relay.example.com/actor#main-key", globalConfig.ActorKey(), time.Hour)
+	if err != nil {
+		t.Fatalf("Expected SignRequestRFC9421 to succeed, but got error: %v", err)
+	}
+
+	verifier, err := NewRFC9421Verifier(req)
+	if err != nil {
+		t.Fatalf("Expected NewRFC9421Verifier to succeed, but got error: %v", err)
+	}
+	if verifier.KeyId() != "https://relay.example.com/actor#main-key" {
+		t.Fatalf("Expected keyid to be 'https://relay.example.com/actor#main-key', but got '%s'", verifier.KeyId())
+	}
+	if verifier.Expires().Sub(verifier.Created()) != time.Hour {
+		t.Fatalf("Expected signature lifetime to be 1h, but got %s", verifier.Expires().Sub(verifier.Created()))
+	}
+	if !verifier.Covers("content-digest") {
+		t.Fatalf("Expected signature to cover content-digest, but it does not")
+	}
+	err = verifier.Verify(globalConfig.ActorKey().Public())
+	if err != nil {
+		t.Fatalf("Expected signature to be verified, but got error: %v", err)
+	}
+	err = VerifyContentDigest(req.Header.Get("Content-Digest"), body)
+	if err != nil {
+		t.Fatalf("Expected Content-Digest to be verified, but got error: %v", err)
+	}
+}
+
+func TestSignRequestRFC9421Ed25519(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	body := []byte(`{"type":"Create"}`)
+	req := newRFC9421TestRequest(body)
+	SignRequestRFC9421(req, body, "https://relay.example.com/actor#ed25519-key", privateKey, time.Hour)
+
+	verifier, err := NewRFC9421Verifier(req)
+	if err != nil {
+		t.Fatalf("Expected NewRFC9421Verifier to succeed, but got error: %v", err)
+	}
+	err = verifier.Verify(publicKey)
+	if err != nil {
+		t.Fatalf("Expected signature to be verified, but got error: %v", err)
+	}
+}
+
+func TestRFC9421VerifyTamperedRequest(t *testing.T) {
+	body := []byte(`{"type":"Create"}`)
+	req := newRFC9421TestRequest(body)
+	SignRequestRFC9421(req, body, "https://relay.example.com/actor#main-key", globalConfig.ActorKey(), time.Hour)
+	req.Header.Set("Content-Type", "text/plain")
+
+	verifier, _ := NewRFC9421Verifier(req)
+	err := verifier.Verify(globalConfig.ActorKey().Public())
+	if err == nil {
+		t.Fatalf("Expected verification of tampered request to fail, but it succeeded")
+	}
+	err = VerifyContentDigest(req.Header.Get("Content-Digest"), []byte(`{"type":"Delete"}`))
+	if err == nil || err.Error() != "content-digest header is mismatch" {
+		t.Fatalf("Expected error 'content-digest header is mismatch', but got '%v'", err)
+	}
+}
+
+func TestNewRFC9421VerifierRejectsComponentParameters(t *testing.T) {
+	req := newRFC9421TestRequest(nil)
+	req.Header.Set("Signature-Input", `sig1=("@method" "content-type";sf);keyid="https://relay.example.com/actor#main-key"`)
+	req.Header.Set("Signature", `sig1=:AAAA:`)
+
+	_, err := NewRFC9421Verifier(req)
+	if err == nil || err.Error() != "unsupported component parameter: content-type" {
+		t.Fatalf("Expected error 'unsupported component parameter: content-type', but got '%v'", err)
+	}
+}
+
+func TestParseSFDictionary(t *testing.T) {
+	members, err := parseSFDictionary(`sig1=("@method" "@target-uri");created=1618884473;keyid="test-key", sig2=:AAAA:, flag`)
+	if err != nil {
+		t.Fatalf("Expected parseSFDictionary to succeed, but got error: %v", err)
+	}
+	if len(members) != 3 {
+		t.Fatalf("Expected 3 members, but got %d", len(members))
+	}
+	if members[0].raw != `("@method" "@target-uri");created=1618884473;keyid="test-key"` {
+		t.Fatalf("Expected raw value to keep serialization, but got '%s'", members[0].raw)
+	}
+	list := members[0].value.(sfInnerList)
+	if len(list.params) != 2 || list.params[0].key != "created" || list.params[0].value != int64(1618884473) {
+		t.Fatalf("Expected created to be 1618884473, but got %v", list.params)
+	}
+	if members[2].value.(sfItem).value != true {
+		t.Fatalf("Expected bare key to be true, but got %v", members[2].value)
+	}
+}
+
+func TestActorPublicKeyByIDMultikey(t *testing.T) {
+	publicKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	multibase := "z" + encodeBase58(append([]byte{0xed, 0x01}, publicKey...))
+	data, _ := json.Marshal(map[string]interface{}{
+		"id": "https://example.com/actor",
+		"publicKey": map[string]string{
+			"id":           "https://example.com/actor#main-key",
+			"publicKeyPem": generatePublicKeyPEMString(globalConfig.ActorKey().Public()),
+		},
+		// Single object form of assertionMethod
+		"assertionMethod": map[string]string{
+			"id":                 "https://example.com/actor#ed25519-key",
+			"type":               "Multikey",
+			"publicKeyMultibase": multibase,
+		},
+	})
+
+	var actor Actor
+	err := json.Unmarshal(data, &actor)
+	if err != nil {
+		t.Fatalf("Expected actor to be decoded, but got error: %v", err)
+	}
+	key, err := actor.PublicKeyByID("https://example.com/actor#ed25519-key")
+	if err != nil {
+		t.Fatalf("Expected Multikey to be found, but got error: %v", err)
+	}
+	if !publicKey.Equal(key) {
+		t.Fatalf("Expected Multikey to be decoded to Ed25519 public key, but got %v", key)
+	}
+	key, err = actor.PublicKeyByID("https://example.com/actor#main-key")
+	if err != nil || !globalConfig.ActorKey().(*rsa.PrivateKey).PublicKey.Equal(key) {
+		t.Fatalf("Expected publicKeyPem to be used for main-key, but got %v (%v)", key, err)
+	}
+}
+
+func TestRFC9421ComponentValueKeepsHeader(t *testing.T) {
+	req := newRFC9421TestRequest([]byte("{}"))
+	req.Header.Add("X-Example", " first ")
+	req.Header.Add("X-Example", "second ")
+
+	value, err := rfc9421ComponentValue(req, "x-example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "first, second" {
+		t.Fatalf("Expected 'first, second', but got '%s'", value)
+	}
+	if req.Header.Values("X-Example")[0] != " first " {
+		t.Fatalf("Expected request header to be unchanged, but got '%s'", req.Header.Values("X-Example")[0])
+	}
+}
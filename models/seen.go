@@ -0,0 +1,63 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+const (
+	// SeenActivity : Activity relayed to subscribers, keyed by activity ID
+	SeenActivity = "activity"
+	// SeenObject : Object announced to followers, keyed by activity type and inner object ID
+	SeenObject = "object"
+)
+
+// MarkSeen : Mark ID as relayed for ttl. Return false when it was already relayed.
+func (config *RelayState) MarkSeen(kind string, id string, ttl time.Duration) bool {
+	marked, err := config.markOnce("relay:seen:"+kind+":", id, ttl)
+	if err != nil {
+		// Relay anyway rather than dropping activity
+		return true
+	}
+	if !marked {
+		if config.RedisClient != nil {
+			config.RedisClient.HIncrBy(context.TODO(), "relay:statistics:duplicate", kind, 1).Result()
+		}
+		DuplicateActivities.WithLabelValues(kind).Inc()
+	}
+	return marked
+}
+
+// MarkNonce : Mark signature as used for ttl. Return false when it was already used.
+func (config *RelayState) MarkNonce(signature string, ttl time.Duration) (bool, error) {
+	return config.markOnce("relay:nonce:", signature, ttl)
+}
+
+// markOnce : Set key of hashed ID for ttl in Redis, or in process without Redis. Return false when it is already set.
+func (config *RelayState) markOnce(prefix string, id string, ttl time.Duration) (bool, error) {
+	hash := sha256.Sum256([]byte(id))
+	key := prefix + hex.EncodeToString(hash[:])
+	if config.RedisClient == nil {
+		return config.localSeen.Add(key, true, ttl) == nil, nil
+	}
+	return config.RedisClient.SetNX(context.TODO(), key, 1, ttl).Result()
+}
+
+// DuplicateStatistics : Number of duplicated activities by kind
+func (config *RelayState) DuplicateStatistics() map[string]int64 {
+	statistics := map[string]int64{SeenActivity: 0, SeenObject: 0}
+	if config.RedisClient == nil {
+		return statistics
+	}
+	values, _ := config.RedisClient.HGetAll(context.TODO(), "relay:statistics:duplicate").Result()
+	for kind, value := range values {
+		count, err := strconv.ParseInt(value, 10, 64)
+		if err == nil {
+			statistics[kind] = count
+		}
+	}
+	return statistics
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMarkSeen(t *testing.T) {
+	relayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	if !relayState.MarkSeen(SeenActivity, "https://example.com/activity/1", time.Minute) {
+		t.Fatalf("Expected first activity to be marked, but it was already seen")
+	}
+	if relayState.MarkSeen(SeenActivity, "https://example.com/activity/1", time.Minute) {
+		t.Fatalf("Expected duplicated activity to be already seen, but it was marked")
+	}
+	if !relayState.MarkSeen(SeenObject, "https://example.com/activity/1", time.Minute) {
+		t.Fatalf("Expected object to be tracked separately from activity, but it was already seen")
+	}
+
+	statistics := relayState.DuplicateStatistics()
+	if statistics[SeenActivity] != 1 || statistics[SeenObject] != 0 {
+		t.Fatalf("Expected duplicate statistics to be activity=1 object=0, but got %v", statistics)
+	}
+}
@@ -0,0 +1,453 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// SnapshotVersion : Version of Snapshot format written by Export
+const SnapshotVersion = 1
+
+// ImportMode : How Snapshot is applied to current state
+type ImportMode string
+
+const (
+	// MergeImport : Add and update entries in snapshot, keep other entries
+	MergeImport ImportMode = "merge"
+	// ReplaceImport : Make state identical to snapshot
+	ReplaceImport ImportMode = "replace"
+)
+
+// Snapshot : Versioned full export of relay state. Config holds every flag by stored name.
+type Snapshot struct {
+	Version          int                  `json:"version"`
+	Config           map[string]bool      `json:"config"`
+	LimitedDomains   []string             `json:"limitedDomains,omitempty"`
+	BlockedDomains   []string             `json:"blockedDomains,omitempty"`
+	AllowedDomains   []string             `json:"allowedDomains,omitempty"`
+	Filters          []FilterRule         `json:"filters,omitempty"`
+	DefaultRateLimit *RateLimit           `json:"defaultRateLimit,omitempty"`
+	RateLimits       map[string]RateLimit `json:"rateLimits,omitempty"`
+	Subscribers      []Subscriber         `json:"subscriptions,omitempty"`
+	Followers        []Follower           `json:"followers,omitempty"`
+	PendingRequests  []PendingRequest     `json:"pendingRequests,omitempty"`
+}
+
+// SnapshotChange : Change made by importing Snapshot
+type SnapshotChange struct {
+	// Action : "+" for added, "-" for removed and "~" for updated entry
+	Action string
+	// Kind : config, limited, blocked, allowed, filter, ratelimit, subscriber, follower or pending
+	Kind   string
+	Target string
+}
+
+// String : Human readable change
+func (change SnapshotChange) String() string {
+	return change.Action + " " + change.Kind + " " + change.Target
+}
+
+type snapshotOperation struct {
+	change SnapshotChange
+	apply  func() error
+}
+
+// ParseSnapshot : Parse and validate Snapshot. RelayState JSON exported by older versions is accepted, with enabled flags only.
+func ParseSnapshot(reader io.Reader) (*Snapshot, error) {
+	var data struct {
+		Snapshot
+		RelayConfig relayConfig `json:"relayConfig"`
+	}
+	err := json.NewDecoder(reader).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := data.Snapshot
+
+	switch snapshot.Version {
+	case 0:
+		snapshot.Config = make(map[string]bool)
+		for key, value := range data.RelayConfig.values() {
+			if value {
+				snapshot.Config[configKeys[key]] = true
+			}
+		}
+	case SnapshotVersion:
+	default:
+		return nil, errors.New("unsupported snapshot version: " + strconv.Itoa(snapshot.Version))
+	}
+
+	for name := range snapshot.Config {
+		if _, ok := configByName(name); !ok {
+			return nil, errors.New("unknown config: " + name)
+		}
+	}
+	for _, domains := range [][]string{snapshot.LimitedDomains, snapshot.BlockedDomains, snapshot.AllowedDomains} {
+		for _, domain := range domains {
+			err = ValidateDomainPattern(domain)
+			if err != nil {
+				return nil, errors.New("invalid domain " + domain + ": " + err.Error())
+			}
+		}
+	}
+	for i := range snapshot.Filters {
+		err = snapshot.Filters[i].compile()
+		if err != nil {
+			return nil, errors.New("invalid filter: " + err.Error())
+		}
+	}
+	if snapshot.DefaultRateLimit != nil {
+		err = snapshot.DefaultRateLimit.validate()
+		if err != nil {
+			return nil, errors.New("invalid default rate limit: " + err.Error())
+		}
+	}
+	for domain, limit := range snapshot.RateLimits {
+		err = limit.validate()
+		if err != nil {
+			return nil, errors.New("invalid rate limit for " + domain + ": " + err.Error())
+		}
+	}
+	return &snapshot, nil
+}
+
+func configByName(name string) (Config, bool) {
+	for key, keyName := range configKeys {
+		if keyName == name {
+			return key, true
+		}
+	}
+	return 0, false
+}
+
+// Export : Take Snapshot of current state
+func (config *RelayState) Export() (*Snapshot, error) {
+	snapshot := Snapshot{
+		Version: SnapshotVersion,
+		Config:  make(map[string]bool),
+	}
+	for key, name := range configKeys {
+		value, err := config.Storage.ConfigValue(key)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Config[name] = value
+	}
+
+	var err error
+	snapshot.LimitedDomains, err = config.Storage.Domains(LimitedDomainList)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.BlockedDomains, err = config.Storage.Domains(BlockedDomainList)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.AllowedDomains, err = config.Storage.Domains(AllowedDomainList)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Subscribers, err = config.Storage.Subscribers()
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Followers, err = config.Storage.Followers()
+	if err != nil {
+		return nil, err
+	}
+	snapshot.PendingRequests, err = config.Storage.PendingRequests()
+	if err != nil {
+		return nil, err
+	}
+	if config.RedisClient != nil {
+		snapshot.Filters = config.loadFilters()
+		snapshot.DefaultRateLimit, snapshot.RateLimits = config.loadRateLimits()
+	}
+	return &snapshot, nil
+}
+
+// DiffSnapshot : Changes to be made by importing Snapshot, without applying
+func (config *RelayState) DiffSnapshot(snapshot *Snapshot, mode ImportMode) ([]SnapshotChange, error) {
+	operations, err := config.planSnapshot(snapshot, mode)
+	if err != nil {
+		return nil, err
+	}
+	var changes []SnapshotChange
+	for _, operation := range operations {
+		changes = append(changes, operation.change)
+	}
+	return changes, nil
+}
+
+// ImportSnapshot : Apply Snapshot to state and unfollow members of newly blocked domains, as BlockDomain does. Return changes applied until error and unfollowed domains.
+func (config *RelayState) ImportSnapshot(snapshot *Snapshot, mode ImportMode, relayActor *Actor, enqueue ActivityEnqueuer) ([]SnapshotChange, []string, error) {
+	operations, err := config.planSnapshot(snapshot, mode)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(operations) == 0 {
+		return nil, nil, nil
+	}
+	defer config.refresh("")
+
+	var changes []SnapshotChange
+	for _, operation := range operations {
+		err = operation.apply()
+		if err != nil {
+			err = errors.New(operation.change.String() + ": " + err.Error())
+			break
+		}
+		config.RecordAudit(AuditSnapshotImport, operation.change.String())
+		changes = append(changes, operation.change)
+	}
+
+	var unfollowed []string
+	for _, change := range changes {
+		if change.Action == "+" && change.Kind == string(BlockedDomainList) {
+			unfollowed = append(unfollowed, config.UnfollowMembersByDomainPattern(relayActor, change.Target, enqueue)...)
+		}
+	}
+	return changes, unfollowed, err
+}
+
+// planSnapshot : Operations turning current state into snapshot. Removals of members precede additions.
+func (config *RelayState) planSnapshot(snapshot *Snapshot, mode ImportMode) ([]snapshotOperation, error) {
+	if mode != MergeImport && mode != ReplaceImport {
+		return nil, errors.New("invalid import mode: " + string(mode))
+	}
+	current, err := config.Export()
+	if err != nil {
+		return nil, err
+	}
+	replace := mode == ReplaceImport
+
+	var operations []snapshotOperation
+	add := func(action string, kind string, target string, apply func() error) {
+		operations = append(operations, snapshotOperation{SnapshotChange{action, kind, target}, apply})
+	}
+
+	names := make([]string, 0, len(configKeys))
+	for _, name := range configKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key, _ := configByName(name)
+		value, ok := snapshot.Config[name]
+		if !ok && !replace {
+			continue
+		}
+		if current.Config[name] != value {
+			add("~", "config", name+"="+strconv.FormatBool(value), func() error {
+				return config.Storage.SetConfigValue(key, value)
+			})
+		}
+	}
+
+	domainLists := []struct {
+		list     DomainList
+		snapshot []string
+		current  []string
+	}{
+		{LimitedDomainList, snapshot.LimitedDomains, current.LimitedDomains},
+		{BlockedDomainList, snapshot.BlockedDomains, current.BlockedDomains},
+		{AllowedDomainList, snapshot.AllowedDomains, current.AllowedDomains},
+	}
+	for _, domainList := range domainLists {
+		list := domainList.list
+		for _, domain := range domainList.snapshot {
+			if !slices.Contains(domainList.current, domain) {
+				add("+", string(list), domain, func() error { return config.Storage.SetDomain(list, domain, true) })
+			}
+		}
+		if replace {
+			for _, domain := range domainList.current {
+				if !slices.Contains(domainList.snapshot, domain) {
+					add("-", string(list), domain, func() error { return config.Storage.SetDomain(list, domain, false) })
+				}
+			}
+		}
+	}
+
+	if config.RedisClient != nil {
+		operations = append(operations, config.planFilters(snapshot.Filters, current.Filters, replace)...)
+		operations = append(operations, config.planRateLimits(snapshot, current, replace)...)
+	}
+
+	removedMembers := make(map[string]bool)
+	if replace {
+		for _, subscriber := range current.Subscribers {
+			if !containsSubscriber(snapshot.Subscribers, subscriber.Domain) {
+				domain := subscriber.Domain
+				removedMembers[domain] = true
+				add("-", "subscriber", domain, func() error { return config.Storage.DelSubscriber(domain) })
+			}
+		}
+		for _, follower := range current.Followers {
+			if !containsFollower(snapshot.Followers, follower.Domain) {
+				domain := follower.Domain
+				removedMembers[domain] = true
+				add("-", "follower", domain, func() error { return config.Storage.DelFollower(domain) })
+			}
+		}
+	}
+
+	currentSubscribers := make(map[string]Subscriber)
+	for _, subscriber := range current.Subscribers {
+		currentSubscribers[subscriber.Domain] = subscriber
+	}
+	for _, subscriber := range snapshot.Subscribers {
+		existing, ok := currentSubscribers[subscriber.Domain]
+		if ok && existing == subscriber {
+			continue
+		}
+		add(changeAction(ok), "subscriber", subscriber.Domain, func() error { return config.Storage.AddSubscriber(subscriber) })
+	}
+
+	currentFollowers := make(map[string]Follower)
+	for _, follower := range current.Followers {
+		currentFollowers[follower.Domain] = follower
+	}
+	for _, follower := range snapshot.Followers {
+		existing, ok := currentFollowers[follower.Domain]
+		if ok && existing == follower {
+			continue
+		}
+		add(changeAction(ok), "follower", follower.Domain, func() error { return config.Storage.AddFollower(follower) })
+	}
+
+	// Pending requests are deleted together with removed members
+	currentRequests := make(map[string]PendingRequest)
+	for _, request := range current.PendingRequests {
+		if !removedMembers[request.Domain] {
+			currentRequests[request.Domain] = request
+		}
+	}
+	snapshotRequests := make(map[string]bool)
+	for _, request := range snapshot.PendingRequests {
+		snapshotRequests[request.Domain] = true
+		existing, ok := currentRequests[request.Domain]
+		if ok && existing == request {
+			continue
+		}
+		add(changeAction(ok), "pending", request.Domain, func() error { return config.Storage.AddPendingRequest(request) })
+	}
+	if replace {
+		for _, domain := range sortedKeys(currentRequests) {
+			if !snapshotRequests[domain] {
+				add("-", "pending", domain, func() error { return config.Storage.DelPendingRequest(domain) })
+			}
+		}
+	}
+
+	return operations, nil
+}
+
+// planFilters : Filters are compared by conditions. Added filters get new IDs.
+func (config *RelayState) planFilters(snapshotFilters []FilterRule, currentFilters []FilterRule, replace bool) []snapshotOperation {
+	var operations []snapshotOperation
+	currentConditions := make(map[string]bool)
+	for _, rule := range currentFilters {
+		currentConditions[filterConditions(rule)] = true
+	}
+	snapshotConditions := make(map[string]bool)
+	for _, rule := range snapshotFilters {
+		conditions := filterConditions(rule)
+		if snapshotConditions[conditions] {
+			continue
+		}
+		snapshotConditions[conditions] = true
+		if !currentConditions[conditions] {
+			operations = append(operations, snapshotOperation{SnapshotChange{"+", "filter", rule.String()}, func() error {
+				_, err := config.AddFilter(rule)
+				return err
+			}})
+		}
+	}
+	if replace {
+		for _, rule := range currentFilters {
+			if !snapshotConditions[filterConditions(rule)] {
+				id := rule.ID
+				operations = append(operations, snapshotOperation{SnapshotChange{"-", "filter", rule.String()}, func() error {
+					config.DelFilter(id)
+					return nil
+				}})
+			}
+		}
+	}
+	return operations
+}
+
+func filterConditions(rule FilterRule) string {
+	rule.ID = ""
+	data, _ := json.Marshal(&rule)
+	return string(data)
+}
+
+func (config *RelayState) planRateLimits(snapshot *Snapshot, current *Snapshot, replace bool) []snapshotOperation {
+	var operations []snapshotOperation
+	if snapshot.DefaultRateLimit != nil {
+		limit := *snapshot.DefaultRateLimit
+		if current.DefaultRateLimit == nil || *current.DefaultRateLimit != limit {
+			operations = append(operations, snapshotOperation{SnapshotChange{changeAction(current.DefaultRateLimit != nil), "ratelimit", "default " + limit.String()}, func() error {
+				return config.SetDefaultRateLimit(limit)
+			}})
+		}
+	} else if replace && current.DefaultRateLimit != nil {
+		operations = append(operations, snapshotOperation{SnapshotChange{"-", "ratelimit", "default"}, func() error {
+			return config.RedisClient.Del(context.TODO(), "relay:config:defaultRateLimit").Err()
+		}})
+	}
+
+	for _, domain := range sortedKeys(snapshot.RateLimits) {
+		limit := snapshot.RateLimits[domain]
+		existing, ok := current.RateLimits[domain]
+		if ok && existing == limit {
+			continue
+		}
+		operations = append(operations, snapshotOperation{SnapshotChange{changeAction(ok), "ratelimit", domain + " " + limit.String()}, func() error {
+			return config.SetRateLimit(domain, limit)
+		}})
+	}
+	if replace {
+		for _, domain := range sortedKeys(current.RateLimits) {
+			if _, ok := snapshot.RateLimits[domain]; !ok {
+				operations = append(operations, snapshotOperation{SnapshotChange{"-", "ratelimit", domain}, func() error {
+					config.DelRateLimit(domain)
+					return nil
+				}})
+			}
+		}
+	}
+	return operations
+}
+
+func changeAction(exists bool) string {
+	if exists {
+		return "~"
+	}
+	return "+"
+}
+
+func containsSubscriber(subscribers []Subscriber, domain string) bool {
+	for _, subscriber := range subscribers {
+		if subscriber.Domain == domain {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFollower(followers []Follower, domain string) bool {
+	for _, follower := range followers {
+		if follower.Domain == domain {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSnapshot(t *testing.T) {
+	t.Run("Parse legacy export", func(t *testing.T) {
+		snapshot, err := ParseSnapshot(strings.NewReader(`{"relayConfig":{"manuallyAccept":true},"blockedDomains":["example.com"]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshot.Config) != 1 || !snapshot.Config["manually_accept"] {
+			t.Fatalf("Expected only enabled flag to be listed, but got %v", snapshot.Config)
+		}
+		if len(snapshot.BlockedDomains) != 1 {
+			t.Fatalf("Expected blocked domains to be [example.com], but got %v", snapshot.BlockedDomains)
+		}
+	})
+
+	t.Run("Refuse unsupported version", func(t *testing.T) {
+		_, err := ParseSnapshot(strings.NewReader(`{"version":99}`))
+		if err == nil {
+			t.Fatal("Expected error for unsupported version, but got nil")
+		}
+	})
+
+	t.Run("Refuse unknown config", func(t *testing.T) {
+		_, err := ParseSnapshot(strings.NewReader(`{"version":1,"config":{"hoge":true}}`))
+		if err == nil {
+			t.Fatal("Expected error for unknown config, but got nil")
+		}
+	})
+}
+
+func TestImportSnapshot(t *testing.T) {
+	state := NewStateWithStorage(NewMemoryStorage(), nil, false)
+	state.AddSubscriber(Subscriber{"a.example.com", "https://a.example.com/inbox", "https://a.example.com/follow", "https://a.example.com/actor"})
+	state.SetConfig(PersonOnly, true)
+	actor := NewActivityPubActorFromRelayConfig(globalConfig)
+	var enqueued []enqueuedActivity
+
+	snapshot := &Snapshot{
+		Version:     SnapshotVersion,
+		Config:      map[string]bool{"manually_accept": true},
+		Subscribers: []Subscriber{{"b.example.com", "https://b.example.com/inbox", "https://b.example.com/follow", "https://b.example.com/actor"}},
+	}
+
+	t.Run("Merge keeps other entries", func(t *testing.T) {
+		changes, _, err := state.ImportSnapshot(snapshot, MergeImport, &actor, recordEnqueued(&enqueued))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 2 {
+			t.Fatalf("Expected 2 changes, but got %v", changes)
+		}
+		if len(state.Subscribers) != 2 || !state.RelayConfig.PersonOnly || !state.RelayConfig.ManuallyAccept {
+			t.Fatalf("Expected subscribers and flags to be merged, but got %v and %+v", state.Subscribers, state.RelayConfig)
+		}
+	})
+
+	t.Run("Replace removes other entries", func(t *testing.T) {
+		changes, _, err := state.ImportSnapshot(snapshot, ReplaceImport, &actor, recordEnqueued(&enqueued))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 2 || changes[0].String() != "~ config block_service=false" || changes[1].String() != "- subscriber a.example.com" {
+			t.Fatalf("Expected flag and subscriber to be removed, but got %v", changes)
+		}
+		if len(state.Subscribers) != 1 || state.Subscribers[0].Domain != "b.example.com" || state.RelayConfig.PersonOnly {
+			t.Fatalf("Expected state to be identical to snapshot, but got %v and %+v", state.Subscribers, state.RelayConfig)
+		}
+	})
+
+	t.Run("Import same snapshot again makes no change", func(t *testing.T) {
+		changes, _ := state.DiffSnapshot(snapshot, ReplaceImport)
+		if len(changes) != 0 {
+			t.Fatalf("Expected no changes, but got %v", changes)
+		}
+	})
+
+	t.Run("Unfollow members of imported blocked domains", func(t *testing.T) {
+		blocked := &Snapshot{Version: SnapshotVersion, BlockedDomains: []string{"*.example.com"}}
+		_, unfollowed, err := state.ImportSnapshot(blocked, MergeImport, &actor, recordEnqueued(&enqueued))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(unfollowed) != 1 || unfollowed[0] != "b.example.com" || len(state.Subscribers) != 0 {
+			t.Fatalf("Expected b.example.com to be unfollowed, but got %v", unfollowed)
+		}
+		if len(enqueued) != 1 || enqueued[0].activity.Type != "Reject" {
+			t.Fatalf("Expected Reject to be enqueued, but got %+v", enqueued)
+		}
+	})
+}
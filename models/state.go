@@ -2,8 +2,14 @@ package models
 
 import (
 	"context"
+	"errors"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/patrickmn/go-cache"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 )
@@ -16,32 +22,78 @@ const (
 	PersonOnly Config = iota
 	// ManuallyAccept : Manually Accept Follow-Request
 	ManuallyAccept
+	// InstanceActorSigning : Accept activities signed by another actor on the same host
+	InstanceActorSigning
+	// AllowlistOnly : Accept Follow-Request and activities only from allowed domains
+	AllowlistOnly
+	// CollectionItems : List items of followers and following collections of relay actor
+	CollectionItems
+	// StatusDomains : List participating domains on public status page
+	StatusDomains
+	// StatusConfig : Show config flags on public status page
+	StatusConfig
 )
 
 // RelayState : Store Subscribers, Followers And Relay Configurations
 type RelayState struct {
 	RedisClient *redis.Client `json:"-"`
+	Storage     Storage       `json:"-"`
+	AuditActor  string        `json:"-"`
+	AuditReason string        `json:"-"`
 	notifiable  bool
-
-	RelayConfig             relayConfig  `json:"relayConfig,omitempty"`
-	LimitedDomains          []string     `json:"limitedDomains,omitempty"`
-	BlockedDomains          []string     `json:"blockedDomains,omitempty"`
-	Subscribers             []Subscriber `json:"subscriptions,omitempty"`
-	Followers               []Follower   `json:"followers,omitempty"`
-	SubscribersAndFollowers []Subscriber `json:"-"`
+	origin      *RelayState
+
+	RelayConfig             relayConfig          `json:"relayConfig,omitempty"`
+	LimitedDomains          []string             `json:"limitedDomains,omitempty"`
+	BlockedDomains          []string             `json:"blockedDomains,omitempty"`
+	AllowedDomains          []string             `json:"allowedDomains,omitempty"`
+	Filters                 []FilterRule         `json:"filters,omitempty"`
+	DefaultRateLimit        *RateLimit           `json:"defaultRateLimit,omitempty"`
+	RateLimits              map[string]RateLimit `json:"rateLimits,omitempty"`
+	Subscribers             []Subscriber         `json:"subscriptions,omitempty"`
+	Followers               []Follower           `json:"followers,omitempty"`
+	SubscribersAndFollowers []Subscriber         `json:"-"`
+
+	localSeen      *cache.Cache
+	limitedMatcher DomainMatcher
+	blockedMatcher DomainMatcher
+	allowedMatcher DomainMatcher
 }
 
-// NewState : Create new RelayState instance with redis client
+// Change notified through relay_refresh channel. Empty change reloads everything.
+const (
+	changeConfig     = "config"
+	changeSubscriber = "subscriber:"
+	changeFollower   = "follower:"
+)
+
+// errRedisRequired : Returned by features kept only in Redis when REDIS_URL is empty
+var errRedisRequired = errors.New("this feature requires Redis, set REDIS_URL")
+
+// NewState : Create new RelayState instance stored in redis
 func NewState(redisClient *redis.Client, notifiable bool) RelayState {
+	return NewStateWithStorage(NewRedisStorage(redisClient), redisClient, notifiable)
+}
+
+// NewStateWithStorage : Create new RelayState instance with storage. Redis client may be nil, then Redis-only features (notifications, admin tokens, filters, rate limits,
+// audit log, blocklist sources) are disabled and seen activities are remembered in process.
+func NewStateWithStorage(storage Storage, redisClient *redis.Client, notifiable bool) RelayState {
 	var config RelayState
+	config.Storage = storage
 	config.RedisClient = redisClient
-	config.notifiable = notifiable
+	config.notifiable = notifiable && redisClient != nil
+	if redisClient == nil {
+		config.localSeen = cache.New(time.Hour, 10*time.Minute)
+	}
 
 	config.Load()
 	return config
 }
 
 func (config *RelayState) ListenNotify(c chan<- bool) {
+	if config.RedisClient == nil {
+		return
+	}
 	_, err := config.RedisClient.Subscribe(context.TODO(), "relay_refresh").Receive(context.TODO())
 	if err != nil {
 		panic(err)
@@ -50,9 +102,8 @@ func (config *RelayState) ListenNotify(c chan<- bool) {
 
 	cNotify := c != nil
 	go func() {
-		for range ch {
-			logrus.Info("RelayState reloaded")
-			config.Load()
+		for message := range ch {
+			config.apply(message.Payload)
 			if cNotify {
 				c <- true
 			}
@@ -60,100 +111,163 @@ func (config *RelayState) ListenNotify(c chan<- bool) {
 	}()
 }
 
-// Load : Refrash content from redis
+// Load : Refrash content from storage
 func (config *RelayState) Load() {
-	config.RelayConfig.load(config.RedisClient)
-	var limitedDomains []string
-	var blockedDomains []string
-	var subscribers []Subscriber
-	var followers []Follower
-	var subscribersAndFollowers []Subscriber
+	config.loadConfig()
+	config.loadMembers()
+}
 
-	domains, _ := config.RedisClient.HKeys(context.TODO(), "relay:config:limitedDomain").Result()
-	for _, domain := range domains {
-		limitedDomains = append(limitedDomains, domain)
+func (config *RelayState) loadConfig() {
+	config.RelayConfig.load(config.Storage)
+	limitedDomains, err := config.Storage.Domains(LimitedDomainList)
+	if err != nil {
+		logrus.Error("Failed to load limited domains : ", err)
 	}
-	domains, _ = config.RedisClient.HKeys(context.TODO(), "relay:config:blockedDomain").Result()
-	for _, domain := range domains {
-		blockedDomains = append(blockedDomains, domain)
+	blockedDomains, err := config.Storage.Domains(BlockedDomainList)
+	if err != nil {
+		logrus.Error("Failed to load blocked domains : ", err)
+	}
+	allowedDomains, err := config.Storage.Domains(AllowedDomainList)
+	if err != nil {
+		logrus.Error("Failed to load allowed domains : ", err)
 	}
 
-	domains, _ = config.RedisClient.Keys(context.TODO(), "relay:subscription:*").Result()
-	for _, domain := range domains {
-		domainName := strings.Replace(domain, "relay:subscription:", "", 1)
-		inboxURL, _ := config.RedisClient.HGet(context.TODO(), domain, "inbox_url").Result()
-		activityID, err := config.RedisClient.HGet(context.TODO(), domain, "activity_id").Result()
-		if err != nil {
-			activityID = ""
-		}
-		actorID, err := config.RedisClient.HGet(context.TODO(), domain, "actor_id").Result()
-		if err != nil {
-			actorID = ""
-		}
-		subscribers = append(subscribers, Subscriber{domainName, inboxURL, activityID, actorID})
-		subscribersAndFollowers = append(subscribersAndFollowers, Subscriber{domainName, inboxURL, activityID, actorID})
+	config.LimitedDomains = limitedDomains
+	config.BlockedDomains = blockedDomains
+	config.limitedMatcher = NewDomainMatcher(limitedDomains)
+	config.blockedMatcher = NewDomainMatcher(blockedDomains)
+	config.AllowedDomains = allowedDomains
+	config.allowedMatcher = NewDomainMatcher(allowedDomains)
+	if config.RedisClient != nil {
+		config.Filters = config.loadFilters()
+		config.DefaultRateLimit, config.RateLimits = config.loadRateLimits()
 	}
+}
 
-	domains, _ = config.RedisClient.Keys(context.TODO(), "relay:follower:*").Result()
-	for _, domain := range domains {
-		domainName := strings.Replace(domain, "relay:follower:", "", 1)
-		inboxURL, _ := config.RedisClient.HGet(context.TODO(), domain, "inbox_url").Result()
-		activityID, err := config.RedisClient.HGet(context.TODO(), domain, "activity_id").Result()
-		if err != nil {
-			activityID = ""
-		}
-		actorID, err := config.RedisClient.HGet(context.TODO(), domain, "actor_id").Result()
-		if err != nil {
-			actorID = ""
-		}
-		mutuallyFollow, err := config.RedisClient.HGet(context.TODO(), domain, "mutually_follow").Result()
-		if err != nil {
-			mutuallyFollow = "0"
+func (config *RelayState) loadMembers() {
+	subscribers, err := config.Storage.Subscribers()
+	if err != nil {
+		logrus.Error("Failed to load subscribers : ", err)
+	}
+	followers, err := config.Storage.Followers()
+	if err != nil {
+		logrus.Error("Failed to load followers : ", err)
+	}
+
+	config.Subscribers = subscribers
+	config.Followers = followers
+	config.updateSubscribersAndFollowers()
+}
+
+// apply : Apply notified change to in-memory state
+func (config *RelayState) apply(change string) {
+	switch {
+	case change == changeConfig:
+		config.loadConfig()
+		logrus.Debug("RelayState config reloaded")
+	case strings.HasPrefix(change, changeSubscriber):
+		config.patchSubscriber(strings.TrimPrefix(change, changeSubscriber))
+		logrus.Debug("RelayState patched : ", change)
+	case strings.HasPrefix(change, changeFollower):
+		config.patchFollower(strings.TrimPrefix(change, changeFollower))
+		logrus.Debug("RelayState patched : ", change)
+	default:
+		config.Load()
+		logrus.Info("RelayState reloaded")
+	}
+}
+
+func (config *RelayState) patchSubscriber(domain string) {
+	patched, err := config.Storage.Subscriber(domain)
+	if err != nil {
+		logrus.Error("Failed to load subscriber : ", err)
+		return
+	}
+
+	var subscribers []Subscriber
+	for _, subscriber := range config.Subscribers {
+		if subscriber.Domain != domain {
+			subscribers = append(subscribers, subscriber)
 		}
-		followers = append(followers, Follower{domainName, inboxURL, activityID, actorID, mutuallyFollow == "1"})
-		subscribersAndFollowers = append(subscribersAndFollowers, Subscriber{domainName, inboxURL, activityID, actorID})
+	}
+	if patched != nil {
+		subscribers = append(subscribers, *patched)
+		sort.Slice(subscribers, func(i, j int) bool { return subscribers[i].Domain < subscribers[j].Domain })
 	}
 
-	config.LimitedDomains = limitedDomains
-	config.BlockedDomains = blockedDomains
 	config.Subscribers = subscribers
+	config.updateSubscribersAndFollowers()
+}
+
+func (config *RelayState) patchFollower(domain string) {
+	patched, err := config.Storage.Follower(domain)
+	if err != nil {
+		logrus.Error("Failed to load follower : ", err)
+		return
+	}
+
+	var followers []Follower
+	for _, follower := range config.Followers {
+		if follower.Domain != domain {
+			followers = append(followers, follower)
+		}
+	}
+	if patched != nil {
+		followers = append(followers, *patched)
+		sort.Slice(followers, func(i, j int) bool { return followers[i].Domain < followers[j].Domain })
+	}
+
 	config.Followers = followers
+	config.updateSubscribersAndFollowers()
+}
+
+func (config *RelayState) updateSubscribersAndFollowers() {
+	var subscribersAndFollowers []Subscriber
+	for _, subscriber := range config.Subscribers {
+		subscribersAndFollowers = append(subscribersAndFollowers, subscriber)
+	}
+	for _, follower := range config.Followers {
+		subscribersAndFollowers = append(subscribersAndFollowers, Subscriber{follower.Domain, follower.InboxURL, follower.ActivityID, follower.ActorID})
+	}
 	config.SubscribersAndFollowers = subscribersAndFollowers
 }
 
 // SetConfig : Set relay configuration
 func (config *RelayState) SetConfig(key Config, value bool) {
-	strValue := 0
-	if value {
-		strValue = 1
-	}
-	switch key {
-	case PersonOnly:
-		config.RedisClient.HSet(context.TODO(), "relay:config", "block_service", strValue).Result()
-	case ManuallyAccept:
-		config.RedisClient.HSet(context.TODO(), "relay:config", "manually_accept", strValue).Result()
+	err := config.Storage.SetConfigValue(key, value)
+	if err != nil {
+		logrus.Error("Failed to set config : ", err)
+	} else if value {
+		config.RecordAudit(AuditConfigEnable, configKeys[key])
+	} else {
+		config.RecordAudit(AuditConfigDisable, configKeys[key])
 	}
 
-	config.refresh()
+	config.refresh(changeConfig)
 }
 
 // AddSubscriber : Add new instance for subscriber list
 func (config *RelayState) AddSubscriber(domain Subscriber) {
-	config.RedisClient.HMSet(context.TODO(), "relay:subscription:"+domain.Domain, map[string]interface{}{
-		"inbox_url":   domain.InboxURL,
-		"activity_id": domain.ActivityID,
-		"actor_id":    domain.ActorID,
-	})
+	err := config.Storage.AddSubscriber(domain)
+	if err != nil {
+		logrus.Error("Failed to add subscriber : ", err)
+	} else {
+		config.RecordAudit(AuditSubscriberAdd, domain.Domain)
+	}
 
-	config.refresh()
+	config.refresh(changeSubscriber + domain.Domain)
 }
 
 // DelSubscriber : Delete instance from subscriber list
 func (config *RelayState) DelSubscriber(domain string) {
-	config.RedisClient.Del(context.TODO(), "relay:subscription:"+domain).Result()
-	config.RedisClient.Del(context.TODO(), "relay:pending:"+domain).Result()
+	err := config.Storage.DelSubscriber(domain)
+	if err != nil {
+		logrus.Error("Failed to delete subscriber : ", err)
+	} else {
+		config.RecordAudit(AuditSubscriberDelete, domain)
+	}
 
-	config.refresh()
+	config.refresh(changeSubscriber + domain)
 }
 
 // SelectSubscriber : Select instance from subscriber list
@@ -168,33 +282,38 @@ func (config *RelayState) SelectSubscriber(domain string) *Subscriber {
 
 // AddFollower : Add new instance for follower list
 func (config *RelayState) AddFollower(domain Follower) {
-	config.RedisClient.HMSet(context.TODO(), "relay:follower:"+domain.Domain, map[string]interface{}{
-		"inbox_url":       domain.InboxURL,
-		"activity_id":     domain.ActivityID,
-		"actor_id":        domain.ActorID,
-		"mutually_follow": domain.MutuallyFollow,
-	})
+	err := config.Storage.AddFollower(domain)
+	if err != nil {
+		logrus.Error("Failed to add follower : ", err)
+	} else {
+		config.RecordAudit(AuditFollowerAdd, domain.Domain)
+	}
 
-	config.refresh()
+	config.refresh(changeFollower + domain.Domain)
 }
 
 // UpdateFollowerStatus : Update MutuallyFollow Status
 func (config *RelayState) UpdateFollowerStatus(domain string, mutuallyFollow bool) {
-	if mutuallyFollow {
-		config.RedisClient.HSet(context.TODO(), "relay:follower:"+domain, "mutually_follow", "1")
+	err := config.Storage.UpdateFollowerStatus(domain, mutuallyFollow)
+	if err != nil {
+		logrus.Error("Failed to update follower : ", err)
 	} else {
-		config.RedisClient.HSet(context.TODO(), "relay:follower:"+domain, "mutually_follow", "0")
+		config.RecordAudit(AuditFollowerUpdate, domain+" mutually_follow="+strconv.FormatBool(mutuallyFollow))
 	}
 
-	config.refresh()
+	config.refresh(changeFollower + domain)
 }
 
 // DelFollower : Delete instance from follower list
 func (config *RelayState) DelFollower(domain string) {
-	config.RedisClient.Del(context.TODO(), "relay:follower:"+domain).Result()
-	config.RedisClient.Del(context.TODO(), "relay:pending:"+domain).Result()
+	err := config.Storage.DelFollower(domain)
+	if err != nil {
+		logrus.Error("Failed to delete follower : ", err)
+	} else {
+		config.RecordAudit(AuditFollowerDelete, domain)
+	}
 
-	config.refresh()
+	config.refresh(changeFollower + domain)
 }
 
 // SelectFollower : Select instance from follower list
@@ -207,33 +326,161 @@ func (config *RelayState) SelectFollower(domain string) *Follower {
 	return nil
 }
 
+// ConfigFlags : Config flags by stored name
+func (config *RelayState) ConfigFlags() map[string]bool {
+	flags := make(map[string]bool)
+	for key, value := range config.RelayConfig.values() {
+		flags[configKeys[key]] = value
+	}
+	return flags
+}
+
+// MemberDomains : Domains of subscribers and followers, sorted
+func (config *RelayState) MemberDomains() []string {
+	var domains []string
+	for _, subscriber := range config.SubscribersAndFollowers {
+		domains = append(domains, subscriber.Domain)
+	}
+	slices.Sort(domains)
+	return slices.Compact(domains)
+}
+
+// FollowersOfRelay : Actors of subscribers and followers following relay, sorted
+func (config *RelayState) FollowersOfRelay() []string {
+	var actors []string
+	for _, subscriber := range config.SubscribersAndFollowers {
+		if subscriber.ActorID != "" {
+			actors = append(actors, subscriber.ActorID)
+		}
+	}
+	slices.Sort(actors)
+	return slices.Compact(actors)
+}
+
+// FollowingOfRelay : Actors of followers followed back by relay, sorted
+func (config *RelayState) FollowingOfRelay() []string {
+	var actors []string
+	for _, follower := range config.Followers {
+		if follower.MutuallyFollow && follower.ActorID != "" {
+			actors = append(actors, follower.ActorID)
+		}
+	}
+	slices.Sort(actors)
+	return slices.Compact(actors)
+}
+
+// AddPendingRequest : Add follow request waiting for manual acceptance
+func (config *RelayState) AddPendingRequest(request PendingRequest) error {
+	err := config.Storage.AddPendingRequest(request)
+	if err == nil {
+		config.RecordAudit(AuditFollowPending, request.Domain)
+	}
+	return err
+}
+
+// SelectPendingRequest : Select follow request waiting for manual acceptance (nil if not found)
+func (config *RelayState) SelectPendingRequest(domain string) (*PendingRequest, error) {
+	return config.Storage.PendingRequest(domain)
+}
+
+// PendingRequests : List follow requests waiting for manual acceptance
+func (config *RelayState) PendingRequests() ([]PendingRequest, error) {
+	return config.Storage.PendingRequests()
+}
+
+// DelPendingRequest : Delete follow request waiting for manual acceptance
+func (config *RelayState) DelPendingRequest(domain string) error {
+	return config.Storage.DelPendingRequest(domain)
+}
+
+// IsLimitedDomain : Host matches limited domain patterns
+func (config *RelayState) IsLimitedDomain(host string) bool {
+	return config.limitedMatcher.Match(host)
+}
+
+// IsBlockedDomain : Host matches blocked domain patterns
+func (config *RelayState) IsBlockedDomain(host string) bool {
+	return config.blockedMatcher.Match(host)
+}
+
+// IsAllowedDomain : Host matches allowed domain patterns
+func (config *RelayState) IsAllowedDomain(host string) bool {
+	return config.allowedMatcher.Match(host)
+}
+
+// IsFederationAllowed : Host may follow and relay activities (always true unless allowlist-only mode is enabled)
+func (config *RelayState) IsFederationAllowed(host string) bool {
+	return !config.RelayConfig.AllowlistOnly || config.IsAllowedDomain(host)
+}
+
+// SelectMembersByDomainPattern : Select subscribers and followers whose domain matches domain pattern
+func (config *RelayState) SelectMembersByDomainPattern(pattern string) ([]Subscriber, []Follower) {
+	var subscribers []Subscriber
+	for _, subscriber := range config.Subscribers {
+		if MatchDomainPattern(pattern, subscriber.Domain) {
+			subscribers = append(subscribers, subscriber)
+		}
+	}
+	var followers []Follower
+	for _, follower := range config.Followers {
+		if MatchDomainPattern(pattern, follower.Domain) {
+			followers = append(followers, follower)
+		}
+	}
+	return subscribers, followers
+}
+
 // SetBlockedDomain : Set/Unset instance for blocked domain
 func (config *RelayState) SetBlockedDomain(domain string, value bool) {
-	if value {
-		config.RedisClient.HSet(context.TODO(), "relay:config:blockedDomain", domain, "1").Result()
+	err := config.Storage.SetDomain(BlockedDomainList, domain, value)
+	if err != nil {
+		logrus.Error("Failed to set blocked domain : ", err)
 	} else {
-		config.RedisClient.HDel(context.TODO(), "relay:config:blockedDomain", domain).Result()
+		config.recordDomainAudit(BlockedDomainList, domain, value)
 	}
 
-	config.refresh()
+	config.refresh(changeConfig)
 }
 
 // SetLimitedDomain : Set/Unset instance for limited domain
 func (config *RelayState) SetLimitedDomain(domain string, value bool) {
-	if value {
-		config.RedisClient.HSet(context.TODO(), "relay:config:limitedDomain", domain, "1").Result()
+	err := config.Storage.SetDomain(LimitedDomainList, domain, value)
+	if err != nil {
+		logrus.Error("Failed to set limited domain : ", err)
 	} else {
-		config.RedisClient.HDel(context.TODO(), "relay:config:limitedDomain", domain).Result()
+		config.recordDomainAudit(LimitedDomainList, domain, value)
 	}
 
-	config.refresh()
+	config.refresh(changeConfig)
 }
 
-func (config *RelayState) refresh() {
-	if config.notifiable {
-		config.RedisClient.Publish(context.TODO(), "relay_refresh", nil)
+// SetAllowedDomain : Set/Unset instance for allowed domain
+func (config *RelayState) SetAllowedDomain(domain string, value bool) {
+	err := config.Storage.SetDomain(AllowedDomainList, domain, value)
+	if err != nil {
+		logrus.Error("Failed to set allowed domain : ", err)
 	} else {
-		config.Load()
+		config.recordDomainAudit(AllowedDomainList, domain, value)
+	}
+
+	config.refresh(changeConfig)
+}
+
+func (config *RelayState) recordDomainAudit(list DomainList, domain string, value bool) {
+	if value {
+		config.RecordAudit(AuditDomainSet, string(list)+" "+domain)
+	} else {
+		config.RecordAudit(AuditDomainUnset, string(list)+" "+domain)
+	}
+}
+
+func (config *RelayState) refresh(change string) {
+	if config.origin != nil {
+		config.origin.refresh(change)
+	} else if config.notifiable {
+		config.RedisClient.Publish(context.TODO(), "relay_refresh", change)
+	} else {
+		config.apply(change)
 	}
 }
 
@@ -255,19 +502,34 @@ type Follower struct {
 }
 
 type relayConfig struct {
-	PersonOnly     bool `json:"blockService,omitempty"`
-	ManuallyAccept bool `json:"manuallyAccept,omitempty"`
+	PersonOnly           bool `json:"blockService,omitempty"`
+	ManuallyAccept       bool `json:"manuallyAccept,omitempty"`
+	InstanceActorSigning bool `json:"instanceActorSigning,omitempty"`
+	AllowlistOnly        bool `json:"allowlistOnly,omitempty"`
+	CollectionItems      bool `json:"collectionItems,omitempty"`
+	StatusDomains        bool `json:"statusDomains,omitempty"`
+	StatusConfig         bool `json:"statusConfig,omitempty"`
 }
 
-func (config *relayConfig) load(redisClient *redis.Client) {
-	personOnly, err := redisClient.HGet(context.TODO(), "relay:config", "block_service").Result()
-	if err != nil {
-		personOnly = "0"
-	}
-	manuallyAccept, err := redisClient.HGet(context.TODO(), "relay:config", "manually_accept").Result()
-	if err != nil {
-		manuallyAccept = "0"
+func (config *relayConfig) load(storage Storage) {
+	config.PersonOnly, _ = storage.ConfigValue(PersonOnly)
+	config.ManuallyAccept, _ = storage.ConfigValue(ManuallyAccept)
+	config.InstanceActorSigning, _ = storage.ConfigValue(InstanceActorSigning)
+	config.AllowlistOnly, _ = storage.ConfigValue(AllowlistOnly)
+	config.CollectionItems, _ = storage.ConfigValue(CollectionItems)
+	config.StatusDomains, _ = storage.ConfigValue(StatusDomains)
+	config.StatusConfig, _ = storage.ConfigValue(StatusConfig)
+}
+
+// values : Flags by Config
+func (config relayConfig) values() map[Config]bool {
+	return map[Config]bool{
+		PersonOnly:           config.PersonOnly,
+		ManuallyAccept:       config.ManuallyAccept,
+		InstanceActorSigning: config.InstanceActorSigning,
+		AllowlistOnly:        config.AllowlistOnly,
+		CollectionItems:      config.CollectionItems,
+		StatusDomains:        config.StatusDomains,
+		StatusConfig:         config.StatusConfig,
 	}
-	config.PersonOnly = personOnly == "1"
-	config.ManuallyAccept = manuallyAccept == "1"
 }
@@ -184,6 +184,24 @@ func TestLimitedDomain(t *testing.T) {
 	})
 }
 
+func TestFederationAllowed(t *testing.T) {
+	relayState.RedisClient.FlushAll(context.TODO()).Result()
+	state := NewState(relayState.RedisClient, false)
+
+	state.SetAllowedDomain("*.example.com", true)
+	if !state.IsFederationAllowed("other.example.org") {
+		t.Fatalf("Expected every domain to be allowed when allowlist-only is disabled, but not allowed")
+	}
+
+	state.SetConfig(AllowlistOnly, true)
+	if !state.IsFederationAllowed("sub.example.com") {
+		t.Fatalf("Expected 'sub.example.com' to be allowed, but not allowed")
+	}
+	if state.IsFederationAllowed("example.com") || state.IsFederationAllowed("other.example.org") {
+		t.Fatalf("Expected domains out of allowlist to be refused, but allowed")
+	}
+}
+
 func TestLoadCompatibleSubscription(t *testing.T) {
 	relayState.RedisClient.FlushAll(context.TODO()).Result()
 
@@ -191,6 +209,7 @@ func TestLoadCompatibleSubscription(t *testing.T) {
 		Domain:   "example.com",
 		InboxURL: "https://example.com/inbox",
 	})
+	<-ch
 
 	relayState.RedisClient.HDel(context.TODO(), "relay:subscription:example.com", "activity_id", "actor_id")
 	relayState.Load()
@@ -205,3 +224,54 @@ func TestLoadCompatibleSubscription(t *testing.T) {
 		t.Fatalf("Expected compatible subscriber 'example.com' with inbox 'https://example.com/inbox' to be present, but not found")
 	}
 }
+
+func TestMigrateMemberIndex(t *testing.T) {
+	relayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	// Subscriber and follower stored by older versions without index
+	relayState.RedisClient.HSet(context.TODO(), "relay:subscription:example.com", "inbox_url", "https://example.com/inbox").Result()
+	relayState.RedisClient.HSet(context.TODO(), "relay:follower:example.org", "inbox_url", "https://example.org/inbox", "mutually_follow", "1").Result()
+
+	migrated := NewState(relayState.RedisClient, false)
+	if len(migrated.Subscribers) != 1 || migrated.Subscribers[0].Domain != "example.com" {
+		t.Fatalf("Expected legacy subscriber 'example.com' to be indexed, but got %v", migrated.Subscribers)
+	}
+	if len(migrated.Followers) != 1 || !migrated.Followers[0].MutuallyFollow {
+		t.Fatalf("Expected legacy follower 'example.org' to be indexed, but got %v", migrated.Followers)
+	}
+	if len(migrated.SubscribersAndFollowers) != 2 {
+		t.Fatalf("Expected 2 subscribers and followers, but got %d", len(migrated.SubscribersAndFollowers))
+	}
+}
+
+func TestTreatFollowerNotify(t *testing.T) {
+	relayState.RedisClient.FlushAll(context.TODO()).Result()
+	relayState.Load()
+
+	relayState.AddFollower(Follower{
+		Domain:   "example.com",
+		InboxURL: "https://example.com/inbox",
+	})
+	<-ch
+	relayState.AddSubscriber(Subscriber{
+		Domain:   "example.org",
+		InboxURL: "https://example.org/inbox",
+	})
+	<-ch
+	relayState.UpdateFollowerStatus("example.com", true)
+	<-ch
+
+	follower := relayState.SelectFollower("example.com")
+	if follower == nil || !follower.MutuallyFollow {
+		t.Fatalf("Expected follower 'example.com' to be patched as mutually followed, but got %v", follower)
+	}
+	if len(relayState.SubscribersAndFollowers) != 2 {
+		t.Fatalf("Expected 2 subscribers and followers, but got %d", len(relayState.SubscribersAndFollowers))
+	}
+
+	relayState.DelFollower("example.com")
+	<-ch
+	if relayState.SelectFollower("example.com") != nil || len(relayState.SubscribersAndFollowers) != 1 {
+		t.Fatalf("Expected follower 'example.com' to be removed, but still found")
+	}
+}
@@ -0,0 +1,255 @@
+package models
+
+import (
+	"sort"
+	"sync"
+)
+
+// DomainList : Enum for domain lists stored in Storage
+type DomainList string
+
+const (
+	// LimitedDomainList : Domains whose actors are not followed back
+	LimitedDomainList DomainList = "limited"
+	// BlockedDomainList : Domains refused by relay
+	BlockedDomainList DomainList = "blocked"
+	// AllowedDomainList : Domains accepted in allowlist-only mode
+	AllowedDomainList DomainList = "allowed"
+)
+
+// configKeys : Stored name by Config
+var configKeys = map[Config]string{
+	PersonOnly:           "block_service",
+	ManuallyAccept:       "manually_accept",
+	InstanceActorSigning: "instance_actor_signing",
+	AllowlistOnly:        "allowlist_only",
+	CollectionItems:      "collection_items",
+	StatusDomains:        "status_domains",
+	StatusConfig:         "status_config",
+}
+
+// PendingRequest : Follow request waiting for manual acceptance
+type PendingRequest struct {
+	Domain     string `json:"domain,omitempty"`
+	InboxURL   string `json:"inbox_url,omitempty"`
+	ActivityID string `json:"activity_id,omitempty"`
+	Type       string `json:"type,omitempty"`
+	Actor      string `json:"actor,omitempty"`
+	Object     string `json:"object,omitempty"`
+}
+
+// Storage : Persistent store of subscribers, followers, pending requests, domain lists and config flags.
+// Lists are returned in order of domain. Missing entries are returned as nil without error.
+type Storage interface {
+	Subscribers() ([]Subscriber, error)
+	Subscriber(domain string) (*Subscriber, error)
+	AddSubscriber(subscriber Subscriber) error
+	// DelSubscriber : Delete subscriber and its pending request
+	DelSubscriber(domain string) error
+
+	Followers() ([]Follower, error)
+	Follower(domain string) (*Follower, error)
+	AddFollower(follower Follower) error
+	UpdateFollowerStatus(domain string, mutuallyFollow bool) error
+	// DelFollower : Delete follower and its pending request
+	DelFollower(domain string) error
+
+	PendingRequests() ([]PendingRequest, error)
+	PendingRequest(domain string) (*PendingRequest, error)
+	AddPendingRequest(request PendingRequest) error
+	DelPendingRequest(domain string) error
+
+	Domains(list DomainList) ([]string, error)
+	SetDomain(list DomainList, domain string, value bool) error
+
+	ConfigValue(key Config) (bool, error)
+	SetConfigValue(key Config, value bool) error
+
+	Close() error
+}
+
+// MemoryStorage : Storage kept in process memory, for tests and ephemeral relays
+type MemoryStorage struct {
+	mutex       sync.RWMutex
+	subscribers map[string]Subscriber
+	followers   map[string]Follower
+	pending     map[string]PendingRequest
+	domains     map[DomainList]map[string]bool
+	config      map[Config]bool
+}
+
+// NewMemoryStorage : Create empty MemoryStorage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		subscribers: make(map[string]Subscriber),
+		followers:   make(map[string]Follower),
+		pending:     make(map[string]PendingRequest),
+		domains:     make(map[DomainList]map[string]bool),
+		config:      make(map[Config]bool),
+	}
+}
+
+func sortedKeys[T any](values map[string]T) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (storage *MemoryStorage) Subscribers() ([]Subscriber, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	var subscribers []Subscriber
+	for _, domain := range sortedKeys(storage.subscribers) {
+		subscribers = append(subscribers, storage.subscribers[domain])
+	}
+	return subscribers, nil
+}
+
+func (storage *MemoryStorage) Subscriber(domain string) (*Subscriber, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	subscriber, ok := storage.subscribers[domain]
+	if !ok {
+		return nil, nil
+	}
+	return &subscriber, nil
+}
+
+func (storage *MemoryStorage) AddSubscriber(subscriber Subscriber) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.subscribers[subscriber.Domain] = subscriber
+	return nil
+}
+
+func (storage *MemoryStorage) DelSubscriber(domain string) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	delete(storage.subscribers, domain)
+	delete(storage.pending, domain)
+	return nil
+}
+
+func (storage *MemoryStorage) Followers() ([]Follower, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	var followers []Follower
+	for _, domain := range sortedKeys(storage.followers) {
+		followers = append(followers, storage.followers[domain])
+	}
+	return followers, nil
+}
+
+func (storage *MemoryStorage) Follower(domain string) (*Follower, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	follower, ok := storage.followers[domain]
+	if !ok {
+		return nil, nil
+	}
+	return &follower, nil
+}
+
+func (storage *MemoryStorage) AddFollower(follower Follower) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.followers[follower.Domain] = follower
+	return nil
+}
+
+func (storage *MemoryStorage) UpdateFollowerStatus(domain string, mutuallyFollow bool) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	follower, ok := storage.followers[domain]
+	if ok {
+		follower.MutuallyFollow = mutuallyFollow
+		storage.followers[domain] = follower
+	}
+	return nil
+}
+
+func (storage *MemoryStorage) DelFollower(domain string) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	delete(storage.followers, domain)
+	delete(storage.pending, domain)
+	return nil
+}
+
+func (storage *MemoryStorage) PendingRequests() ([]PendingRequest, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	var requests []PendingRequest
+	for _, domain := range sortedKeys(storage.pending) {
+		requests = append(requests, storage.pending[domain])
+	}
+	return requests, nil
+}
+
+func (storage *MemoryStorage) PendingRequest(domain string) (*PendingRequest, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	request, ok := storage.pending[domain]
+	if !ok {
+		return nil, nil
+	}
+	return &request, nil
+}
+
+func (storage *MemoryStorage) AddPendingRequest(request PendingRequest) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.pending[request.Domain] = request
+	return nil
+}
+
+func (storage *MemoryStorage) DelPendingRequest(domain string) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	delete(storage.pending, domain)
+	return nil
+}
+
+func (storage *MemoryStorage) Domains(list DomainList) ([]string, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	domains := sortedKeys(storage.domains[list])
+	if len(domains) == 0 {
+		return nil, nil
+	}
+	return domains, nil
+}
+
+func (storage *MemoryStorage) SetDomain(list DomainList, domain string, value bool) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	if storage.domains[list] == nil {
+		storage.domains[list] = make(map[string]bool)
+	}
+	if value {
+		storage.domains[list][domain] = true
+	} else {
+		delete(storage.domains[list], domain)
+	}
+	return nil
+}
+
+func (storage *MemoryStorage) ConfigValue(key Config) (bool, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	return storage.config[key], nil
+}
+
+func (storage *MemoryStorage) SetConfigValue(key Config, value bool) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.config[key] = value
+	return nil
+}
+
+func (storage *MemoryStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,269 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisDomainListKeys : Hash key by DomainList
+var redisDomainListKeys = map[DomainList]string{
+	LimitedDomainList: "relay:config:limitedDomain",
+	BlockedDomainList: "relay:config:blockedDomain",
+	AllowedDomainList: "relay:config:allowedDomain",
+}
+
+// RedisStorage : Storage backed by Redis
+type RedisStorage struct {
+	client *redis.Client
+}
+
+// NewRedisStorage : Create RedisStorage. Subscribers and followers stored by older versions are indexed on creation.
+func NewRedisStorage(client *redis.Client) *RedisStorage {
+	storage := &RedisStorage{client}
+	storage.migrateMemberIndex()
+	return storage
+}
+
+// migrateMemberIndex : Index subscribers and followers stored by older versions into sets
+func (storage *RedisStorage) migrateMemberIndex() {
+	indexed, err := storage.client.Exists(context.TODO(), "relay:index").Result()
+	if err != nil || indexed == 1 {
+		return
+	}
+	for _, kind := range []string{"subscription", "follower"} {
+		iter := storage.client.Scan(context.TODO(), 0, "relay:"+kind+":*", 1000).Iterator()
+		for iter.Next(context.TODO()) {
+			domain := strings.TrimPrefix(iter.Val(), "relay:"+kind+":")
+			storage.client.SAdd(context.TODO(), "relay:"+kind+"s", domain).Result()
+		}
+	}
+	storage.client.Set(context.TODO(), "relay:index", 1, 0).Result()
+}
+
+// hashes : Fetch hashes of indexed members in one round trip. Members without hash are skipped.
+func (storage *RedisStorage) hashes(index string, prefix string) ([]string, []map[string]string, error) {
+	domains, err := storage.client.SMembers(context.TODO(), index).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(domains)
+
+	pipeline := storage.client.Pipeline()
+	commands := make([]*redis.MapStringStringCmd, len(domains))
+	for i, domain := range domains {
+		commands[i] = pipeline.HGetAll(context.TODO(), prefix+domain)
+	}
+	_, err = pipeline.Exec(context.TODO())
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, nil, err
+	}
+
+	var existDomains []string
+	var hashes []map[string]string
+	for i, domain := range domains {
+		hash, _ := commands[i].Result()
+		if len(hash) == 0 {
+			continue
+		}
+		existDomains = append(existDomains, domain)
+		hashes = append(hashes, hash)
+	}
+	return existDomains, hashes, nil
+}
+
+func newSubscriberFromHash(domain string, hash map[string]string) Subscriber {
+	return Subscriber{domain, hash["inbox_url"], hash["activity_id"], hash["actor_id"]}
+}
+
+func newFollowerFromHash(domain string, hash map[string]string) Follower {
+	return Follower{domain, hash["inbox_url"], hash["activity_id"], hash["actor_id"], hash["mutually_follow"] == "1"}
+}
+
+func (storage *RedisStorage) Subscribers() ([]Subscriber, error) {
+	domains, hashes, err := storage.hashes("relay:subscriptions", "relay:subscription:")
+	if err != nil {
+		return nil, err
+	}
+	var subscribers []Subscriber
+	for i, domain := range domains {
+		subscribers = append(subscribers, newSubscriberFromHash(domain, hashes[i]))
+	}
+	return subscribers, nil
+}
+
+func (storage *RedisStorage) Subscriber(domain string) (*Subscriber, error) {
+	hash, err := storage.client.HGetAll(context.TODO(), "relay:subscription:"+domain).Result()
+	if err != nil || len(hash) == 0 {
+		return nil, err
+	}
+	subscriber := newSubscriberFromHash(domain, hash)
+	return &subscriber, nil
+}
+
+func (storage *RedisStorage) AddSubscriber(subscriber Subscriber) error {
+	_, err := storage.client.TxPipelined(context.TODO(), func(pipeline redis.Pipeliner) error {
+		pipeline.HMSet(context.TODO(), "relay:subscription:"+subscriber.Domain, map[string]interface{}{
+			"inbox_url":   subscriber.InboxURL,
+			"activity_id": subscriber.ActivityID,
+			"actor_id":    subscriber.ActorID,
+		})
+		pipeline.SAdd(context.TODO(), "relay:subscriptions", subscriber.Domain)
+		return nil
+	})
+	return err
+}
+
+func (storage *RedisStorage) DelSubscriber(domain string) error {
+	_, err := storage.client.TxPipelined(context.TODO(), func(pipeline redis.Pipeliner) error {
+		pipeline.Del(context.TODO(), "relay:subscription:"+domain, "relay:pending:"+domain)
+		pipeline.SRem(context.TODO(), "relay:subscriptions", domain)
+		return nil
+	})
+	return err
+}
+
+func (storage *RedisStorage) Followers() ([]Follower, error) {
+	domains, hashes, err := storage.hashes("relay:followers", "relay:follower:")
+	if err != nil {
+		return nil, err
+	}
+	var followers []Follower
+	for i, domain := range domains {
+		followers = append(followers, newFollowerFromHash(domain, hashes[i]))
+	}
+	return followers, nil
+}
+
+func (storage *RedisStorage) Follower(domain string) (*Follower, error) {
+	hash, err := storage.client.HGetAll(context.TODO(), "relay:follower:"+domain).Result()
+	if err != nil || len(hash) == 0 {
+		return nil, err
+	}
+	follower := newFollowerFromHash(domain, hash)
+	return &follower, nil
+}
+
+func (storage *RedisStorage) AddFollower(follower Follower) error {
+	_, err := storage.client.TxPipelined(context.TODO(), func(pipeline redis.Pipeliner) error {
+		pipeline.HMSet(context.TODO(), "relay:follower:"+follower.Domain, map[string]interface{}{
+			"inbox_url":       follower.InboxURL,
+			"activity_id":     follower.ActivityID,
+			"actor_id":        follower.ActorID,
+			"mutually_follow": follower.MutuallyFollow,
+		})
+		pipeline.SAdd(context.TODO(), "relay:followers", follower.Domain)
+		return nil
+	})
+	return err
+}
+
+func (storage *RedisStorage) UpdateFollowerStatus(domain string, mutuallyFollow bool) error {
+	value := "0"
+	if mutuallyFollow {
+		value = "1"
+	}
+	_, err := storage.client.HSet(context.TODO(), "relay:follower:"+domain, "mutually_follow", value).Result()
+	return err
+}
+
+func (storage *RedisStorage) DelFollower(domain string) error {
+	_, err := storage.client.TxPipelined(context.TODO(), func(pipeline redis.Pipeliner) error {
+		pipeline.Del(context.TODO(), "relay:follower:"+domain, "relay:pending:"+domain)
+		pipeline.SRem(context.TODO(), "relay:followers", domain)
+		return nil
+	})
+	return err
+}
+
+func newPendingRequestFromHash(domain string, hash map[string]string) PendingRequest {
+	return PendingRequest{domain, hash["inbox_url"], hash["activity_id"], hash["type"], hash["actor"], hash["object"]}
+}
+
+func (storage *RedisStorage) PendingRequests() ([]PendingRequest, error) {
+	var requests []PendingRequest
+	iter := storage.client.Scan(context.TODO(), 0, "relay:pending:*", 1000).Iterator()
+	for iter.Next(context.TODO()) {
+		domain := strings.TrimPrefix(iter.Val(), "relay:pending:")
+		request, err := storage.PendingRequest(domain)
+		if err != nil {
+			return nil, err
+		}
+		if request != nil {
+			requests = append(requests, *request)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Domain < requests[j].Domain })
+	return requests, nil
+}
+
+func (storage *RedisStorage) PendingRequest(domain string) (*PendingRequest, error) {
+	hash, err := storage.client.HGetAll(context.TODO(), "relay:pending:"+domain).Result()
+	if err != nil || len(hash) == 0 {
+		return nil, err
+	}
+	request := newPendingRequestFromHash(domain, hash)
+	return &request, nil
+}
+
+func (storage *RedisStorage) AddPendingRequest(request PendingRequest) error {
+	_, err := storage.client.HMSet(context.TODO(), "relay:pending:"+request.Domain, map[string]interface{}{
+		"inbox_url":   request.InboxURL,
+		"activity_id": request.ActivityID,
+		"type":        request.Type,
+		"actor":       request.Actor,
+		"object":      request.Object,
+	}).Result()
+	return err
+}
+
+func (storage *RedisStorage) DelPendingRequest(domain string) error {
+	_, err := storage.client.Del(context.TODO(), "relay:pending:"+domain).Result()
+	return err
+}
+
+func (storage *RedisStorage) Domains(list DomainList) ([]string, error) {
+	domains, err := storage.client.HKeys(context.TODO(), redisDomainListKeys[list]).Result()
+	if err != nil || len(domains) == 0 {
+		return nil, err
+	}
+	sort.Strings(domains)
+	return domains, nil
+}
+
+func (storage *RedisStorage) SetDomain(list DomainList, domain string, value bool) error {
+	var err error
+	if value {
+		_, err = storage.client.HSet(context.TODO(), redisDomainListKeys[list], domain, "1").Result()
+	} else {
+		_, err = storage.client.HDel(context.TODO(), redisDomainListKeys[list], domain).Result()
+	}
+	return err
+}
+
+func (storage *RedisStorage) ConfigValue(key Config) (bool, error) {
+	value, err := storage.client.HGet(context.TODO(), "relay:config", configKeys[key]).Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return value == "1", err
+}
+
+func (storage *RedisStorage) SetConfigValue(key Config, value bool) error {
+	strValue := 0
+	if value {
+		strValue = 1
+	}
+	_, err := storage.client.HSet(context.TODO(), "relay:config", configKeys[key], strValue).Result()
+	return err
+}
+
+func (storage *RedisStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,248 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS subscribers (
+	domain      TEXT PRIMARY KEY,
+	inbox_url   TEXT NOT NULL DEFAULT '',
+	activity_id TEXT NOT NULL DEFAULT '',
+	actor_id    TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS followers (
+	domain          TEXT PRIMARY KEY,
+	inbox_url       TEXT NOT NULL DEFAULT '',
+	activity_id     TEXT NOT NULL DEFAULT '',
+	actor_id        TEXT NOT NULL DEFAULT '',
+	mutually_follow INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS pending_requests (
+	domain      TEXT PRIMARY KEY,
+	inbox_url   TEXT NOT NULL DEFAULT '',
+	activity_id TEXT NOT NULL DEFAULT '',
+	type        TEXT NOT NULL DEFAULT '',
+	actor       TEXT NOT NULL DEFAULT '',
+	object      TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS domain_lists (
+	list   TEXT NOT NULL,
+	domain TEXT NOT NULL,
+	PRIMARY KEY (list, domain)
+);
+CREATE TABLE IF NOT EXISTS config (
+	key   TEXT PRIMARY KEY,
+	value INTEGER NOT NULL
+);
+`
+
+// SQLiteStorage : Storage backed by embedded SQLite database
+type SQLiteStorage struct {
+	db *sql.DB
+}
+
+// NewSQLiteStorage : Open SQLite database at path and create tables if needed
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows only one writer at a time
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStorage{db}, nil
+}
+
+func (storage *SQLiteStorage) Subscribers() ([]Subscriber, error) {
+	rows, err := storage.db.Query("SELECT domain, inbox_url, activity_id, actor_id FROM subscribers ORDER BY domain")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var subscribers []Subscriber
+	for rows.Next() {
+		var subscriber Subscriber
+		err = rows.Scan(&subscriber.Domain, &subscriber.InboxURL, &subscriber.ActivityID, &subscriber.ActorID)
+		if err != nil {
+			return nil, err
+		}
+		subscribers = append(subscribers, subscriber)
+	}
+	return subscribers, rows.Err()
+}
+
+func (storage *SQLiteStorage) Subscriber(domain string) (*Subscriber, error) {
+	var subscriber Subscriber
+	err := storage.db.QueryRow("SELECT domain, inbox_url, activity_id, actor_id FROM subscribers WHERE domain = ?", domain).Scan(&subscriber.Domain, &subscriber.InboxURL, &subscriber.ActivityID, &subscriber.ActorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &subscriber, nil
+}
+
+func (storage *SQLiteStorage) AddSubscriber(subscriber Subscriber) error {
+	_, err := storage.db.Exec("INSERT OR REPLACE INTO subscribers (domain, inbox_url, activity_id, actor_id) VALUES (?, ?, ?, ?)", subscriber.Domain, subscriber.InboxURL, subscriber.ActivityID, subscriber.ActorID)
+	return err
+}
+
+func (storage *SQLiteStorage) DelSubscriber(domain string) error {
+	return storage.deleteMember("subscribers", domain)
+}
+
+func (storage *SQLiteStorage) deleteMember(table string, domain string) error {
+	tx, err := storage.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM "+table+" WHERE domain = ?", domain)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM pending_requests WHERE domain = ?", domain)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (storage *SQLiteStorage) Followers() ([]Follower, error) {
+	rows, err := storage.db.Query("SELECT domain, inbox_url, activity_id, actor_id, mutually_follow FROM followers ORDER BY domain")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var followers []Follower
+	for rows.Next() {
+		var follower Follower
+		err = rows.Scan(&follower.Domain, &follower.InboxURL, &follower.ActivityID, &follower.ActorID, &follower.MutuallyFollow)
+		if err != nil {
+			return nil, err
+		}
+		followers = append(followers, follower)
+	}
+	return followers, rows.Err()
+}
+
+func (storage *SQLiteStorage) Follower(domain string) (*Follower, error) {
+	var follower Follower
+	err := storage.db.QueryRow("SELECT domain, inbox_url, activity_id, actor_id, mutually_follow FROM followers WHERE domain = ?", domain).Scan(&follower.Domain, &follower.InboxURL, &follower.ActivityID, &follower.ActorID, &follower.MutuallyFollow)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &follower, nil
+}
+
+func (storage *SQLiteStorage) AddFollower(follower Follower) error {
+	_, err := storage.db.Exec("INSERT OR REPLACE INTO followers (domain, inbox_url, activity_id, actor_id, mutually_follow) VALUES (?, ?, ?, ?, ?)", follower.Domain, follower.InboxURL, follower.ActivityID, follower.ActorID, follower.MutuallyFollow)
+	return err
+}
+
+func (storage *SQLiteStorage) UpdateFollowerStatus(domain string, mutuallyFollow bool) error {
+	_, err := storage.db.Exec("UPDATE followers SET mutually_follow = ? WHERE domain = ?", mutuallyFollow, domain)
+	return err
+}
+
+func (storage *SQLiteStorage) DelFollower(domain string) error {
+	return storage.deleteMember("followers", domain)
+}
+
+func (storage *SQLiteStorage) PendingRequests() ([]PendingRequest, error) {
+	rows, err := storage.db.Query("SELECT domain, inbox_url, activity_id, type, actor, object FROM pending_requests ORDER BY domain")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var requests []PendingRequest
+	for rows.Next() {
+		var request PendingRequest
+		err = rows.Scan(&request.Domain, &request.InboxURL, &request.ActivityID, &request.Type, &request.Actor, &request.Object)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, rows.Err()
+}
+
+func (storage *SQLiteStorage) PendingRequest(domain string) (*PendingRequest, error) {
+	var request PendingRequest
+	err := storage.db.QueryRow("SELECT domain, inbox_url, activity_id, type, actor, object FROM pending_requests WHERE domain = ?", domain).Scan(&request.Domain, &request.InboxURL, &request.ActivityID, &request.Type, &request.Actor, &request.Object)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+func (storage *SQLiteStorage) AddPendingRequest(request PendingRequest) error {
+	_, err := storage.db.Exec("INSERT OR REPLACE INTO pending_requests (domain, inbox_url, activity_id, type, actor, object) VALUES (?, ?, ?, ?, ?, ?)", request.Domain, request.InboxURL, request.ActivityID, request.Type, request.Actor, request.Object)
+	return err
+}
+
+func (storage *SQLiteStorage) DelPendingRequest(domain string) error {
+	_, err := storage.db.Exec("DELETE FROM pending_requests WHERE domain = ?", domain)
+	return err
+}
+
+func (storage *SQLiteStorage) Domains(list DomainList) ([]string, error) {
+	rows, err := storage.db.Query("SELECT domain FROM domain_lists WHERE list = ? ORDER BY domain", string(list))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var domains []string
+	for rows.Next() {
+		var domain string
+		err = rows.Scan(&domain)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, domain)
+	}
+	return domains, rows.Err()
+}
+
+func (storage *SQLiteStorage) SetDomain(list DomainList, domain string, value bool) error {
+	var err error
+	if value {
+		_, err = storage.db.Exec("INSERT OR IGNORE INTO domain_lists (list, domain) VALUES (?, ?)", string(list), domain)
+	} else {
+		_, err = storage.db.Exec("DELETE FROM domain_lists WHERE list = ? AND domain = ?", string(list), domain)
+	}
+	return err
+}
+
+func (storage *SQLiteStorage) ConfigValue(key Config) (bool, error) {
+	var value bool
+	err := storage.db.QueryRow("SELECT value FROM config WHERE key = ?", configKeys[key]).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return value, err
+}
+
+func (storage *SQLiteStorage) SetConfigValue(key Config, value bool) error {
+	_, err := storage.db.Exec("INSERT OR REPLACE INTO config (key, value) VALUES (?, ?)", configKeys[key], value)
+	return err
+}
+
+func (storage *SQLiteStorage) Close() error {
+	return storage.db.Close()
+}
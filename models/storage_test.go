@@ -0,0 +1,197 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStorage(t *testing.T) {
+	t.Run("MemoryStorage", func(t *testing.T) {
+		testStorage(t, NewMemoryStorage())
+	})
+	t.Run("RedisStorage", func(t *testing.T) {
+		relayState.RedisClient.FlushAll(context.TODO()).Result()
+		defer relayState.RedisClient.FlushAll(context.TODO()).Result()
+
+		testStorage(t, NewRedisStorage(relayState.RedisClient))
+	})
+	t.Run("SQLiteStorage", func(t *testing.T) {
+		storage, err := NewSQLiteStorage(t.TempDir() + "/relay.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer storage.Close()
+
+		testStorage(t, storage)
+	})
+}
+
+func testStorage(t *testing.T, storage Storage) {
+	t.Run("Subscribers", func(t *testing.T) {
+		storage.AddSubscriber(Subscriber{"b.example.com", "https://b.example.com/inbox", "https://b.example.com/follow", "https://b.example.com/actor"})
+		storage.AddSubscriber(Subscriber{"a.example.com", "https://a.example.com/inbox", "https://a.example.com/follow", "https://a.example.com/actor"})
+		storage.AddPendingRequest(PendingRequest{Domain: "a.example.com", Type: "Follow"})
+
+		subscribers, err := storage.Subscribers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(subscribers) != 2 || subscribers[0].Domain != "a.example.com" || subscribers[1].InboxURL != "https://b.example.com/inbox" {
+			t.Fatalf("Expected subscribers ordered by domain, but got %v", subscribers)
+		}
+
+		storage.DelSubscriber("a.example.com")
+		subscriber, err := storage.Subscriber("a.example.com")
+		if err != nil || subscriber != nil {
+			t.Fatalf("Expected deleted subscriber to be nil, but got %v (error: %v)", subscriber, err)
+		}
+		request, err := storage.PendingRequest("a.example.com")
+		if err != nil || request != nil {
+			t.Fatalf("Expected pending request of deleted subscriber to be nil, but got %v (error: %v)", request, err)
+		}
+		subscriber, _ = storage.Subscriber("b.example.com")
+		if subscriber == nil || subscriber.ActorID != "https://b.example.com/actor" {
+			t.Fatalf("Expected subscriber b.example.com to remain, but got %v", subscriber)
+		}
+		storage.DelSubscriber("b.example.com")
+	})
+
+	t.Run("Followers", func(t *testing.T) {
+		storage.AddFollower(Follower{"example.com", "https://example.com/inbox", "https://example.com/follow", "https://example.com/actor", false})
+		storage.UpdateFollowerStatus("example.com", true)
+
+		follower, err := storage.Follower("example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if follower == nil || !follower.MutuallyFollow || follower.InboxURL != "https://example.com/inbox" {
+			t.Fatalf("Expected follower to be mutually followed, but got %v", follower)
+		}
+
+		storage.DelFollower("example.com")
+		followers, err := storage.Followers()
+		if err != nil || len(followers) != 0 {
+			t.Fatalf("Expected no followers, but got %v (error: %v)", followers, err)
+		}
+	})
+
+	t.Run("PendingRequests", func(t *testing.T) {
+		request := PendingRequest{"example.com", "https://example.com/inbox", "https://example.com/follow", "Follow", "https://example.com/actor", "https://www.w3.org/ns/activitystreams#Public"}
+		storage.AddPendingRequest(request)
+
+		requests, err := storage.PendingRequests()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(requests) != 1 || requests[0] != request {
+			t.Fatalf("Expected pending requests to be [%v], but got %v", request, requests)
+		}
+
+		storage.DelPendingRequest("example.com")
+		requests, _ = storage.PendingRequests()
+		if len(requests) != 0 {
+			t.Fatalf("Expected no pending requests, but got %v", requests)
+		}
+	})
+
+	t.Run("Domains", func(t *testing.T) {
+		storage.SetDomain(BlockedDomainList, "b.example.com", true)
+		storage.SetDomain(BlockedDomainList, "a.example.com", true)
+		storage.SetDomain(LimitedDomainList, "c.example.com", true)
+		storage.SetDomain(BlockedDomainList, "b.example.com", false)
+
+		blocked, err := storage.Domains(BlockedDomainList)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blocked) != 1 || blocked[0] != "a.example.com" {
+			t.Fatalf("Expected blocked domains to be [a.example.com], but got %v", blocked)
+		}
+		limited, _ := storage.Domains(LimitedDomainList)
+		if len(limited) != 1 || limited[0] != "c.example.com" {
+			t.Fatalf("Expected limited domains to be [c.example.com], but got %v", limited)
+		}
+	})
+
+	t.Run("ConfigValue", func(t *testing.T) {
+		value, err := storage.ConfigValue(ManuallyAccept)
+		if err != nil || value {
+			t.Fatalf("Expected unset config to be false, but got %v (error: %v)", value, err)
+		}
+
+		storage.SetConfigValue(ManuallyAccept, true)
+		value, _ = storage.ConfigValue(ManuallyAccept)
+		if !value {
+			t.Fatalf("Expected config to be true, but got false")
+		}
+		value, _ = storage.ConfigValue(PersonOnly)
+		if value {
+			t.Fatalf("Expected other config to remain false, but got true")
+		}
+	})
+}
+
+func TestStateWithMemoryStorage(t *testing.T) {
+	state := NewStateWithStorage(NewMemoryStorage(), nil, true)
+
+	state.AddSubscriber(Subscriber{"example.com", "https://example.com/inbox", "https://example.com/follow", "https://example.com/actor"})
+	state.AddFollower(Follower{"follower.example.com", "https://follower.example.com/inbox", "https://follower.example.com/follow", "https://follower.example.com/actor", false})
+	state.SetBlockedDomain("blocked.example.com", true)
+	state.SetConfig(ManuallyAccept, true)
+
+	if state.SelectSubscriber("example.com") == nil {
+		t.Fatalf("Expected subscriber to be applied to state, but not found")
+	}
+	if len(state.SubscribersAndFollowers) != 2 {
+		t.Fatalf("Expected 2 subscribers and followers, but got %d", len(state.SubscribersAndFollowers))
+	}
+	if len(state.BlockedDomains) != 1 || state.BlockedDomains[0] != "blocked.example.com" {
+		t.Fatalf("Expected blocked domains to be [blocked.example.com], but got %v", state.BlockedDomains)
+	}
+	if !state.RelayConfig.ManuallyAccept {
+		t.Fatalf("Expected ManuallyAccept to be true, but got false")
+	}
+}
+
+func TestStateWithoutRedis(t *testing.T) {
+	state := NewStateWithStorage(NewMemoryStorage(), nil, true)
+
+	t.Run("Remember seen activities in process", func(t *testing.T) {
+		if !state.MarkSeen(SeenActivity, "https://example.com/activity", time.Minute) {
+			t.Fatal("Expected first activity to be marked, but not marked")
+		}
+		if state.MarkSeen(SeenActivity, "https://example.com/activity", time.Minute) {
+			t.Fatal("Expected duplicated activity not to be marked, but marked")
+		}
+		registered, err := state.MarkNonce("signature", time.Minute)
+		if err != nil || !registered {
+			t.Fatalf("Expected nonce to be registered, but got %v", err)
+		}
+		registered, _ = state.MarkNonce("signature", time.Minute)
+		if registered {
+			t.Fatal("Expected replayed nonce not to be registered, but registered")
+		}
+	})
+
+	t.Run("Disable Redis-only features", func(t *testing.T) {
+		if _, err := state.AddFilter(FilterRule{Action: DropAction, Keyword: "spam"}); err == nil {
+			t.Error("Expected AddFilter to fail without Redis, but got nil")
+		}
+		if err := state.SetRateLimit("example.com", RateLimit{Rate: 1, Burst: 1}); err == nil {
+			t.Error("Expected SetRateLimit to fail without Redis, but got nil")
+		}
+		if _, err := state.AddAdminToken("admin", AdminScope); err == nil {
+			t.Error("Expected AddAdminToken to fail without Redis, but got nil")
+		}
+		if err := state.SetBlocklistSource(BlocklistSource{Name: "source", Location: "https://example.com/blocklist.txt", Format: PlainFormat}); err == nil {
+			t.Error("Expected SetBlocklistSource to fail without Redis, but got nil")
+		}
+		if allowed, _ := state.TakeRateLimitToken("example.com"); !allowed {
+			t.Error("Expected request to be allowed without Redis, but limited")
+		}
+		if state.SelectAdminToken("token") != nil || len(state.AdminTokens()) != 0 {
+			t.Error("Expected no admin tokens without Redis, but found")
+		}
+	})
+}
@@ -0,0 +1,269 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Minimal parser of RFC 8941 Structured Field Values used by RFC 9421 and RFC 9530 headers.
+
+type sfToken string
+
+type sfParam struct {
+	key   string
+	value interface{}
+}
+
+type sfItem struct {
+	value  interface{}
+	params []sfParam
+}
+
+type sfInnerList struct {
+	items  []sfItem
+	params []sfParam
+}
+
+type sfMember struct {
+	key string
+	// value is sfItem or sfInnerList
+	value interface{}
+	// raw is the original serialization of value, including parameters
+	raw string
+}
+
+type sfParser struct {
+	input string
+	pos   int
+}
+
+func (p *sfParser) eof() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *sfParser) peek() byte {
+	return p.input[p.pos]
+}
+
+func (p *sfParser) skipSP() {
+	for !p.eof() && p.peek() == ' ' {
+		p.pos++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+func parseSFDictionary(input string) ([]sfMember, error) {
+	p := &sfParser{input: strings.TrimSpace(input)}
+	var members []sfMember
+	for !p.eof() {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		start := p.pos
+		var value interface{}
+		if !p.eof() && p.peek() == '=' {
+			p.pos++
+			start = p.pos
+			if !p.eof() && p.peek() == '(' {
+				value, err = p.parseInnerList()
+			} else {
+				value, err = p.parseItem()
+			}
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			params, err := p.parseParameters()
+			if err != nil {
+				return nil, err
+			}
+			value = sfItem{true, params}
+		}
+		members = append(members, sfMember{key, value, p.input[start:p.pos]})
+
+		p.skipOWS()
+		if p.eof() {
+			break
+		}
+		if p.peek() != ',' {
+			return nil, errors.New("structured field: expected comma")
+		}
+		p.pos++
+		p.skipOWS()
+		if p.eof() {
+			return nil, errors.New("structured field: trailing comma")
+		}
+	}
+	return members, nil
+}
+
+func (p *sfParser) parseInnerList() (sfInnerList, error) {
+	var list sfInnerList
+	p.pos++ // (
+	for !p.eof() {
+		p.skipSP()
+		if p.eof() {
+			break
+		}
+		if p.peek() == ')' {
+			p.pos++
+			params, err := p.parseParameters()
+			if err != nil {
+				return list, err
+			}
+			list.params = params
+			return list, nil
+		}
+		item, err := p.parseItem()
+		if err != nil {
+			return list, err
+		}
+		list.items = append(list.items, item)
+		if !p.eof() && p.peek() != ' ' && p.peek() != ')' {
+			return list, errors.New("structured field: invalid inner list")
+		}
+	}
+	return list, errors.New("structured field: unterminated inner list")
+}
+
+func (p *sfParser) parseItem() (sfItem, error) {
+	value, err := p.parseBareItem()
+	if err != nil {
+		return sfItem{}, err
+	}
+	params, err := p.parseParameters()
+	if err != nil {
+		return sfItem{}, err
+	}
+	return sfItem{value, params}, nil
+}
+
+func (p *sfParser) parseParameters() ([]sfParam, error) {
+	var params []sfParam
+	for !p.eof() && p.peek() == ';' {
+		p.pos++
+		p.skipSP()
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		var value interface{} = true
+		if !p.eof() && p.peek() == '=' {
+			p.pos++
+			value, err = p.parseBareItem()
+			if err != nil {
+				return nil, err
+			}
+		}
+		params = append(params, sfParam{key, value})
+	}
+	return params, nil
+}
+
+func (p *sfParser) parseKey() (string, error) {
+	start := p.pos
+	if p.eof() || !(p.peek() == '*' || (p.peek() >= 'a' && p.peek() <= 'z')) {
+		return "", errors.New("structured field: invalid key")
+	}
+	for !p.eof() {
+		c := p.peek()
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' || c == '-' || c == '.' || c == '*' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.input[start:p.pos], nil
+}
+
+func (p *sfParser) parseBareItem() (interface{}, error) {
+	if p.eof() {
+		return nil, errors.New("structured field: unexpected end")
+	}
+	c := p.peek()
+	switch {
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	case c == '"':
+		return p.parseString()
+	case c == ':':
+		return p.parseByteSequence()
+	case c == '?':
+		if p.pos+1 >= len(p.input) || (p.input[p.pos+1] != '0' && p.input[p.pos+1] != '1') {
+			return nil, errors.New("structured field: invalid boolean")
+		}
+		value := p.input[p.pos+1] == '1'
+		p.pos += 2
+		return value, nil
+	case c == '*' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		start := p.pos
+		for !p.eof() && strings.IndexByte(" ,;()=\"\t", p.peek()) < 0 {
+			p.pos++
+		}
+		return sfToken(p.input[start:p.pos]), nil
+	}
+	return nil, errors.New("structured field: invalid item")
+}
+
+func (p *sfParser) parseNumber() (interface{}, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	decimal := false
+	for !p.eof() && ((p.peek() >= '0' && p.peek() <= '9') || p.peek() == '.') {
+		if p.peek() == '.' {
+			decimal = true
+		}
+		p.pos++
+	}
+	if decimal {
+		return strconv.ParseFloat(p.input[start:p.pos], 64)
+	}
+	return strconv.ParseInt(p.input[start:p.pos], 10, 64)
+}
+
+func (p *sfParser) parseString() (string, error) {
+	var builder strings.Builder
+	p.pos++ // "
+	for !p.eof() {
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '\\':
+			if p.eof() {
+				return "", errors.New("structured field: invalid escape")
+			}
+			builder.WriteByte(p.peek())
+			p.pos++
+		case '"':
+			return builder.String(), nil
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	return "", errors.New("structured field: unterminated string")
+}
+
+func (p *sfParser) parseByteSequence() ([]byte, error) {
+	p.pos++ // :
+	end := strings.IndexByte(p.input[p.pos:], ':')
+	if end < 0 {
+		return nil, errors.New("structured field: unterminated byte sequence")
+	}
+	encoded := p.input[p.pos : p.pos+end]
+	p.pos += end + 1
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+func serializeSFString(value string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(value) + "\""
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TokenScope : Permission level of AdminToken
+type TokenScope string
+
+const (
+	// ReadOnlyScope : Allowed to list and export relay information
+	ReadOnlyScope TokenScope = "read-only"
+	// ModerationScope : Allowed to manage domains and follow requests
+	ModerationScope TokenScope = "moderation"
+	// AdminScope : Allowed to change relay configuration
+	AdminScope TokenScope = "admin"
+)
+
+func (scope TokenScope) level() int {
+	switch scope {
+	case ReadOnlyScope:
+		return 1
+	case ModerationScope:
+		return 2
+	case AdminScope:
+		return 3
+	}
+	return 0
+}
+
+// IsValid : Check scope is known
+func (scope TokenScope) IsValid() bool {
+	return scope.level() > 0
+}
+
+// Allows : Check scope covers required scope
+func (scope TokenScope) Allows(required TokenScope) bool {
+	return scope.IsValid() && scope.level() >= required.level()
+}
+
+// AdminToken : Credential for relay admin API
+type AdminToken struct {
+	Name      string     `json:"name"`
+	Scope     TokenScope `json:"scope"`
+	CreatedAt time.Time  `json:"created_at"`
+	hash      string
+}
+
+func hashAdminToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// AddAdminToken : Issue new admin token and return its secret
+func (config *RelayState) AddAdminToken(name string, scope TokenScope) (string, error) {
+	if config.RedisClient == nil {
+		return "", errRedisRequired
+	}
+	if name == "" {
+		return "", errors.New("token name is empty")
+	}
+	if !scope.IsValid() {
+		return "", errors.New("invalid token scope: " + string(scope))
+	}
+	if config.SelectAdminTokenByName(name) != nil {
+		return "", errors.New("token name is already used: " + name)
+	}
+
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	_, err = config.RedisClient.HMSet(context.TODO(), "relay:token:"+hashAdminToken(token), map[string]interface{}{
+		"name":       name,
+		"scope":      string(scope),
+		"created_at": time.Now().Unix(),
+	}).Result()
+	if err != nil {
+		return "", err
+	}
+	config.RecordAudit(AuditTokenCreate, name+" "+string(scope))
+	return token, nil
+}
+
+// SelectAdminToken : Select admin token by its secret
+func (config *RelayState) SelectAdminToken(token string) *AdminToken {
+	if token == "" || config.RedisClient == nil {
+		return nil
+	}
+	return config.selectAdminTokenByHash(hashAdminToken(token))
+}
+
+func (config *RelayState) selectAdminTokenByHash(hash string) *AdminToken {
+	data, err := config.RedisClient.HGetAll(context.TODO(), "relay:token:"+hash).Result()
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	createdAt, _ := strconv.ParseInt(data["created_at"], 10, 64)
+	return &AdminToken{
+		Name:      data["name"],
+		Scope:     TokenScope(data["scope"]),
+		CreatedAt: time.Unix(createdAt, 0),
+		hash:      hash,
+	}
+}
+
+// SelectAdminTokenByName : Select admin token by its name
+func (config *RelayState) SelectAdminTokenByName(name string) *AdminToken {
+	for _, token := range config.AdminTokens() {
+		if token.Name == name {
+			return &token
+		}
+	}
+	return nil
+}
+
+// AdminTokens : List all issued admin tokens
+func (config *RelayState) AdminTokens() []AdminToken {
+	var tokens []AdminToken
+	if config.RedisClient == nil {
+		return tokens
+	}
+	iter := config.RedisClient.Scan(context.TODO(), 0, "relay:token:*", 1000).Iterator()
+	for iter.Next(context.TODO()) {
+		token := config.selectAdminTokenByHash(strings.TrimPrefix(iter.Val(), "relay:token:"))
+		if token != nil {
+			tokens = append(tokens, *token)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		logrus.Error("Failed to list admin tokens : ", err)
+	}
+	return tokens
+}
+
+// DelAdminToken : Revoke admin token by its name
+func (config *RelayState) DelAdminToken(name string) bool {
+	token := config.SelectAdminTokenByName(name)
+	if token == nil {
+		return false
+	}
+	config.RedisClient.Del(context.TODO(), "relay:token:"+token.hash).Result()
+	config.RecordAudit(AuditTokenRevoke, name)
+	return true
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTokenScopeAllows(t *testing.T) {
+	if !AdminScope.Allows(ModerationScope) {
+		t.Fatalf("Expected admin scope to allow moderation scope, but it did not")
+	}
+	if !ModerationScope.Allows(ReadOnlyScope) {
+		t.Fatalf("Expected moderation scope to allow read-only scope, but it did not")
+	}
+	if ReadOnlyScope.Allows(ModerationScope) {
+		t.Fatalf("Expected read-only scope to not allow moderation scope, but it did")
+	}
+	if TokenScope("unknown").Allows(ReadOnlyScope) {
+		t.Fatalf("Expected unknown scope to not allow read-only scope, but it did")
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	relayState.RedisClient.FlushAll(context.TODO()).Result()
+
+	token, err := relayState.AddAdminToken("dashboard", ReadOnlyScope)
+	if err != nil {
+		t.Fatalf("Expected AddAdminToken to succeed, but got error: %v", err)
+	}
+
+	t.Run("Select token by secret", func(t *testing.T) {
+		adminToken := relayState.SelectAdminToken(token)
+		if adminToken == nil || adminToken.Name != "dashboard" || adminToken.Scope != ReadOnlyScope {
+			t.Fatalf("Expected token 'dashboard' with read-only scope, but got %+v", adminToken)
+		}
+		if relayState.SelectAdminToken("invalid") != nil {
+			t.Fatalf("Expected nil for invalid token, but got token")
+		}
+	})
+
+	t.Run("Reject duplicated name", func(t *testing.T) {
+		_, err := relayState.AddAdminToken("dashboard", AdminScope)
+		if err == nil {
+			t.Fatalf("Expected error for duplicated token name, but got nil")
+		}
+	})
+
+	t.Run("Reject invalid scope", func(t *testing.T) {
+		_, err := relayState.AddAdminToken("invalid", TokenScope("root"))
+		if err == nil {
+			t.Fatalf("Expected error for invalid token scope, but got nil")
+		}
+	})
+
+	t.Run("Revoke token", func(t *testing.T) {
+		if !relayState.DelAdminToken("dashboard") {
+			t.Fatalf("Expected DelAdminToken to succeed, but it did not")
+		}
+		if relayState.SelectAdminToken(token) != nil {
+			t.Fatalf("Expected revoked token to be invalid, but it was still valid")
+		}
+		if len(relayState.AdminTokens()) != 0 {
+			t.Fatalf("Expected no tokens remaining, but got %d", len(relayState.AdminTokens()))
+		}
+	})
+}
@@ -2,11 +2,15 @@ package models
 
 import (
 	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
-	"io/ioutil"
+	"math/big"
+	"strings"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
@@ -27,6 +31,18 @@ func ReadPublicKeyRSAFromString(pemString string) (*rsa.PublicKey, error) {
 	return pub, nil
 }
 
+// ReadPublicKeyFromString : Read RSA, Ed25519 or ECDSA public key from PEM string.
+func ReadPublicKeyFromString(pemString string) (crypto.PublicKey, error) {
+	decoded, _ := pem.Decode([]byte(pemString))
+	if decoded == nil {
+		return nil, errors.New("failed parse PublicKey from string")
+	}
+	if decoded.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(decoded.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(decoded.Bytes)
+}
+
 func redisHGetOrCreateWithDefault(redisClient *redis.Client, key string, field string, defaultValue string) (string, error) {
 	keyExist, err := redisClient.HExists(context.TODO(), key, field).Result()
 	if err != nil {
@@ -47,29 +63,123 @@ func redisHGetOrCreateWithDefault(redisClient *redis.Client, key string, field s
 	}
 }
 
-func readPrivateKeyRSA(keyPath string) (*rsa.PrivateKey, error) {
-	file, err := ioutil.ReadFile(keyPath)
-	if err != nil {
-		return nil, err
+// Actor key types
+const (
+	RSAKey     = "rsa"
+	Ed25519Key = "ed25519"
+)
+
+// GeneratePrivateKey : Generate private key of relay actor. keyType is rsa or ed25519.
+func GeneratePrivateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case RSAKey:
+		return rsa.GenerateKey(rand.Reader, actorKeyBits)
+	case Ed25519Key:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	default:
+		return nil, errors.New("unsupported key type: " + keyType)
 	}
-	decoded, _ := pem.Decode(file)
+}
+
+// PrivateKeyType : Key type of private key, rsa or ed25519
+func PrivateKeyType(privateKey crypto.Signer) string {
+	if _, ok := privateKey.(ed25519.PrivateKey); ok {
+		return Ed25519Key
+	}
+	return RSAKey
+}
+
+// ReadPrivateKeyFromString : Read RSA (PKCS#1 or PKCS#8) or Ed25519 (PKCS#8) private key from PEM string.
+func ReadPrivateKeyFromString(pemString string) (crypto.Signer, error) {
+	decoded, _ := pem.Decode([]byte(pemString))
 	if decoded == nil {
-		return nil, errors.New("ACTOR_PEM IS INVALID. FAILED TO READ")
+		return nil, errors.New("failed parse PrivateKey from string")
+	}
+	if decoded.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(decoded.Bytes)
 	}
-	privateKey, err := x509.ParsePKCS1PrivateKey(decoded.Bytes)
+	keyInterface, err := x509.ParsePKCS8PrivateKey(decoded.Bytes)
 	if err != nil {
 		return nil, err
 	}
-	return privateKey, nil
+	switch privateKey := keyInterface.(type) {
+	case *rsa.PrivateKey:
+		return privateKey, nil
+	case ed25519.PrivateKey:
+		return privateKey, nil
+	default:
+		return nil, errors.New("unsupported private key type, should be RSA or Ed25519")
+	}
+}
+
+// GeneratePrivateKeyPEMString : Encode private key in PKCS#8 PEM.
+func GeneratePrivateKeyPEMString(privateKey crypto.Signer) (string, error) {
+	privateKeyByte, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	privateKeyPem := pem.EncodeToMemory(
+		&pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: privateKeyByte,
+		},
+	)
+	return string(privateKeyPem), nil
 }
 
-func generatePublicKeyPEMString(publicKey *rsa.PublicKey) string {
-	publicKeyByte := x509.MarshalPKCS1PublicKey(publicKey)
+func generatePublicKeyPEMString(publicKey crypto.PublicKey) string {
+	publicKeyByte, _ := x509.MarshalPKIXPublicKey(publicKey)
 	publicKeyPem := pem.EncodeToMemory(
 		&pem.Block{
-			Type:  "RSA PUBLIC KEY",
+			Type:  "PUBLIC KEY",
 			Bytes: publicKeyByte,
 		},
 	)
 	return string(publicKeyPem)
 }
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func encodeBase58(data []byte) string {
+	value := new(big.Int).SetBytes(data)
+	var encoded []byte
+	for value.Sign() > 0 {
+		mod := new(big.Int)
+		value.DivMod(value, big.NewInt(58), mod)
+		encoded = append([]byte{base58Alphabet[mod.Int64()]}, encoded...)
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append([]byte{base58Alphabet[0]}, encoded...)
+	}
+	return string(encoded)
+}
+
+func decodeBase58(encoded string) ([]byte, error) {
+	var decoded []byte
+	for _, c := range []byte(encoded) {
+		carry := strings.IndexByte(base58Alphabet, c)
+		if carry < 0 {
+			return nil, errors.New("invalid base58 character")
+		}
+		for i := len(decoded) - 1; i >= 0; i-- {
+			carry += int(decoded[i]) * 58
+			decoded[i] = byte(carry)
+			carry >>= 8
+		}
+		for carry > 0 {
+			decoded = append([]byte{byte(carry)}, decoded...)
+			carry >>= 8
+		}
+	}
+	for _, c := range []byte(encoded) {
+		if c != base58Alphabet[0] {
+			break
+		}
+		decoded = append([]byte{0}, decoded...)
+	}
+	return decoded, nil
+}